@@ -34,6 +34,7 @@ func run(argv []string, stdout, stderr io.Writer) error {
 	project := flags.String("project", ".", "Project root")
 	claudeDir := flags.String("claude", "", "Optional path to .claude directory")
 	modelName := flags.String("model", "claude-3-5-sonnet-20241022", "Anthropic model name")
+	providerName := flags.String("provider", "anthropic", "Model provider (anthropic/bedrock/vertex)")
 	systemPrompt := flags.String("system-prompt", "", "System prompt override")
 	sessionID := flags.String("session", "", "Session identifier override")
 	promptFile := flags.String("prompt-file", "", "Read prompt from file (defaults to stdin/args)")
@@ -51,9 +52,9 @@ func run(argv []string, stdout, stderr io.Writer) error {
 		return err
 	}
 
-	provider := &modelpkg.AnthropicProvider{
-		ModelName: *modelName,
-		System:    *systemPrompt,
+	provider, err := newModelProvider(*providerName, *modelName, *systemPrompt)
+	if err != nil {
+		return err
 	}
 	settingsPath := ""
 	if strings.TrimSpace(*claudeDir) != "" {
@@ -108,6 +109,22 @@ func run(argv []string, stdout, stderr io.Writer) error {
 	return nil
 }
 
+// newModelProvider serves Claude from the Anthropic API, Amazon Bedrock or
+// Google Vertex AI; the cloud providers read their region, project and
+// credentials from the standard AWS and Google environment.
+func newModelProvider(name, modelName, system string) (modelpkg.Provider, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "anthropic":
+		return &modelpkg.AnthropicProvider{ModelName: modelName, System: system}, nil
+	case "bedrock":
+		return &modelpkg.BedrockProvider{ModelName: modelName, System: system}, nil
+	case "vertex":
+		return &modelpkg.VertexProvider{ModelName: modelName, System: system}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+}
+
 func resolvePrompt(literal, file string, tail []string) (string, error) {
 	if strings.TrimSpace(literal) != "" {
 		return literal, nil
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/cexll/agentsdk-go/pkg/api"
+	modelpkg "github.com/cexll/agentsdk-go/pkg/model"
 )
 
 func TestRunACPModeNoPrompt(t *testing.T) {
@@ -51,3 +52,33 @@ func TestRunNonACPModeWithoutPromptErrors(t *testing.T) {
 		t.Fatalf("expected prompt-related error, got: %v", err)
 	}
 }
+
+func TestRunSelectsModelProvider(t *testing.T) {
+	originalServe := serveACPStdio
+	t.Cleanup(func() {
+		serveACPStdio = originalServe
+	})
+
+	var factory api.ModelFactory
+	serveACPStdio = func(ctx context.Context, options api.Options, stdin io.Reader, stdout io.Writer) error {
+		factory = options.ModelFactory
+		return nil
+	}
+
+	if err := run([]string{"--acp", "--provider", "bedrock", "--model", "claude-sonnet-4-5"}, io.Discard, io.Discard); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	bedrock, ok := factory.(*modelpkg.BedrockProvider)
+	if !ok || bedrock.ModelName != "claude-sonnet-4-5" {
+		t.Fatalf("expected bedrock provider, got %#v", factory)
+	}
+	if err := run([]string{"--acp", "--provider", "vertex"}, io.Discard, io.Discard); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if _, ok := factory.(*modelpkg.VertexProvider); !ok {
+		t.Fatalf("expected vertex provider, got %#v", factory)
+	}
+	if err := run([]string{"--acp", "--provider", "azure"}, io.Discard, io.Discard); err == nil || !strings.Contains(err.Error(), "azure") {
+		t.Fatalf("expected unknown provider error, got %v", err)
+	}
+}
@@ -269,6 +269,11 @@ func (a *Adapter) Cancel(_ context.Context, params acpproto.CancelNotification)
 	if !ok {
 		return nil
 	}
+	// Cancel through the runtime first so the reason reaches history and the
+	// Stop hook before the turn context ends the run.
+	if rt := state.runtime(); rt != nil {
+		_ = rt.CancelSession(string(params.SessionId))
+	}
 	// NOTE: AgentSideConnection already cancels Prompt request contexts before
 	// invoking this method; this call keeps adapter-managed session state in sync.
 	state.cancelTurn()
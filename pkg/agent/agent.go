@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/cexll/agentsdk-go/pkg/middleware"
 	"github.com/cexll/agentsdk-go/pkg/model"
@@ -12,6 +13,11 @@ import (
 var (
 	ErrMaxIterations = errors.New("max iterations reached")
 	ErrNilModel      = errors.New("agent: model is nil")
+	// ErrSuspended is returned (possibly wrapped) by a ToolExecutor that
+	// cannot finish a call yet, for example while it awaits an out-of-band
+	// approval. Run stops after the current batch and returns the error so
+	// the caller can resume later via Context.PendingToolCalls.
+	ErrSuspended = errors.New("agent: run suspended")
 )
 
 // Model produces the next output for the agent given the current context.
@@ -24,6 +30,15 @@ type ToolExecutor interface {
 	Execute(ctx context.Context, call ToolCall, c *Context) (ToolResult, error)
 }
 
+// ConcurrentToolExecutor is optionally implemented by ToolExecutors whose
+// calls may overlap. ConcurrencySafe reports whether call can run alongside
+// other concurrency-safe calls emitted in the same model turn; Execute must be
+// safe for concurrent use for every call that reports true.
+type ConcurrentToolExecutor interface {
+	ToolExecutor
+	ConcurrencySafe(call ToolCall) bool
+}
+
 // ToolCall describes a discrete tool invocation request.
 type ToolCall struct {
 	ID    string
@@ -105,6 +120,13 @@ func (a *Agent) Run(ctx context.Context, c *Context) (*ModelOutput, error) {
 	var last *ModelOutput
 	iteration := 0
 
+	if pending := c.PendingToolCalls; len(pending) > 0 {
+		c.PendingToolCalls = nil
+		if err := a.runTools(ctx, pending, c, state); err != nil {
+			return c.LastModelOutput, err
+		}
+	}
+
 	for {
 		if err := ctx.Err(); err != nil {
 			return last, err
@@ -145,45 +167,120 @@ func (a *Agent) Run(ctx context.Context, c *Context) (*ModelOutput, error) {
 			return out, nil
 		}
 
-		var firstMiddlewareErr error
+		if err := a.runTools(ctx, out.ToolCalls, c, state); err != nil {
+			return last, err
+		}
 
-		for _, call := range out.ToolCalls {
+		iteration++
+	}
+}
+
+// runTools executes the tool calls of a single model turn. Consecutive
+// concurrency-safe calls are batched and run in parallel when
+// Options.MaxParallelToolCalls allows it; every other call runs on its own.
+// Middleware stages and result bookkeeping always happen on the calling
+// goroutine in the original call order. Middleware errors do not stop the
+// remaining calls; the first one is returned once every call has finished.
+// A call suspended by the executor records no result; the rest of its batch
+// completes, later batches are skipped and the suspension error is returned.
+func (a *Agent) runTools(ctx context.Context, calls []ToolCall, c *Context, state *middleware.State) error {
+	var firstMiddlewareErr error
+	record := func(err error) {
+		if err != nil && firstMiddlewareErr == nil {
+			firstMiddlewareErr = err
+		}
+	}
+
+	for start := 0; start < len(calls); {
+		end := a.batchEnd(calls, start)
+		batch := calls[start:end]
+		start = end
+
+		for _, call := range batch {
 			state.ToolCall = call
-			if err := a.mw.Execute(ctx, middleware.StageBeforeTool, state); err != nil && firstMiddlewareErr == nil {
-				firstMiddlewareErr = err
-			}
+			record(a.mw.Execute(ctx, middleware.StageBeforeTool, state))
+		}
+
+		if a.tools == nil {
+			return fmt.Errorf("tool executor is nil for call %s", batch[0].Name)
+		}
 
-			if a.tools == nil {
-				return last, fmt.Errorf("tool executor is nil for call %s", call.Name)
+		results := make([]ToolResult, len(batch))
+		suspended := make([]error, len(batch))
+		if len(batch) == 1 {
+			results[0], suspended[0] = a.executeTool(ctx, batch[0], c)
+		} else {
+			sem := make(chan struct{}, a.opts.MaxParallelToolCalls)
+			var wg sync.WaitGroup
+			for i, call := range batch {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(i int, call ToolCall) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					results[i], suspended[i] = a.executeTool(ctx, call, c)
+				}(i, call)
 			}
+			wg.Wait()
+		}
 
-			res, err := a.tools.Execute(ctx, call, c)
-			if err != nil {
-				if res.Name == "" {
-					res.Name = call.Name
-				}
-				if res.Metadata == nil {
-					res.Metadata = map[string]any{}
-				}
-				res.Metadata["is_error"] = true
-				res.Metadata["error"] = err.Error()
-				if res.Output == "" {
-					res.Output = fmt.Sprintf("Tool execution failed: %v", err)
+		var suspendErr error
+		for i, call := range batch {
+			if suspended[i] != nil {
+				if suspendErr == nil {
+					suspendErr = suspended[i]
 				}
+				continue
 			}
+			c.ToolResults = append(c.ToolResults, results[i])
+			state.ToolCall = call
+			state.ToolResult = results[i]
+			record(a.mw.Execute(ctx, middleware.StageAfterTool, state))
+		}
+		if suspendErr != nil {
+			return suspendErr
+		}
+	}
+	return firstMiddlewareErr
+}
 
-			c.ToolResults = append(c.ToolResults, res)
-			state.ToolResult = res
+// batchEnd returns the exclusive end index of the batch starting at start.
+// A batch holds a single call unless parallel execution is enabled and the
+// executor marks consecutive calls as concurrency safe.
+func (a *Agent) batchEnd(calls []ToolCall, start int) int {
+	end := start + 1
+	if a.opts.MaxParallelToolCalls <= 1 {
+		return end
+	}
+	exec, ok := a.tools.(ConcurrentToolExecutor)
+	if !ok || !exec.ConcurrencySafe(calls[start]) {
+		return end
+	}
+	for end < len(calls) && exec.ConcurrencySafe(calls[end]) {
+		end++
+	}
+	return end
+}
 
-			if err := a.mw.Execute(ctx, middleware.StageAfterTool, state); err != nil && firstMiddlewareErr == nil {
-				firstMiddlewareErr = err
-			}
+// executeTool runs a single call, folding execution errors into the result
+// metadata. Only suspension errors are returned to the caller.
+func (a *Agent) executeTool(ctx context.Context, call ToolCall, c *Context) (ToolResult, error) {
+	res, err := a.tools.Execute(ctx, call, c)
+	if errors.Is(err, ErrSuspended) {
+		return ToolResult{}, err
+	}
+	if err != nil {
+		if res.Name == "" {
+			res.Name = call.Name
 		}
-
-		if firstMiddlewareErr != nil {
-			return last, firstMiddlewareErr
+		if res.Metadata == nil {
+			res.Metadata = map[string]any{}
+		}
+		res.Metadata["is_error"] = true
+		res.Metadata["error"] = err.Error()
+		if res.Output == "" {
+			res.Output = fmt.Sprintf("Tool execution failed: %v", err)
 		}
-
-		iteration++
 	}
+	return res, nil
 }
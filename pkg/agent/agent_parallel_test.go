@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/middleware"
+)
+
+type parallelTools struct {
+	safe     map[string]bool
+	delays   map[string]time.Duration
+	inflight atomic.Int32
+	peak     atomic.Int32
+	mu       sync.Mutex
+	order    []string
+}
+
+func (t *parallelTools) Execute(ctx context.Context, call ToolCall, _ *Context) (ToolResult, error) {
+	cur := t.inflight.Add(1)
+	defer t.inflight.Add(-1)
+	for {
+		prev := t.peak.Load()
+		if cur <= prev || t.peak.CompareAndSwap(prev, cur) {
+			break
+		}
+	}
+	if d := t.delays[call.Name]; d > 0 {
+		select {
+		case <-ctx.Done():
+			return ToolResult{Name: call.Name}, ctx.Err()
+		case <-time.After(d):
+		}
+	}
+	t.mu.Lock()
+	t.order = append(t.order, call.ID)
+	t.mu.Unlock()
+	return ToolResult{Name: call.Name, Output: call.ID}, nil
+}
+
+func (t *parallelTools) ConcurrencySafe(call ToolCall) bool {
+	return t.safe[call.Name]
+}
+
+func TestAgentRunsSafeToolsInParallelPreservingOrder(t *testing.T) {
+	model := &scriptedModel{outputs: []*ModelOutput{
+		{ToolCalls: []ToolCall{
+			{ID: "c1", Name: "slow"},
+			{ID: "c2", Name: "fast"},
+			{ID: "c3", Name: "fast"},
+		}},
+		{Content: "done", Done: true},
+	}}
+	tools := &parallelTools{
+		safe:   map[string]bool{"slow": true, "fast": true},
+		delays: map[string]time.Duration{"slow": 50 * time.Millisecond, "fast": 5 * time.Millisecond},
+	}
+	var before, after []string
+	mw := middleware.Funcs{
+		OnBeforeTool: func(_ context.Context, st *middleware.State) error {
+			before = append(before, st.ToolCall.(ToolCall).ID)
+			return nil
+		},
+		OnAfterTool: func(_ context.Context, st *middleware.State) error {
+			after = append(after, st.ToolCall.(ToolCall).ID)
+			if res, ok := st.ToolResult.(ToolResult); !ok || res.Output != st.ToolCall.(ToolCall).ID {
+				t.Errorf("after tool saw mismatched result %+v for %+v", st.ToolResult, st.ToolCall)
+			}
+			return nil
+		},
+	}
+	ag, err := New(model, tools, Options{
+		MaxParallelToolCalls: 4,
+		Middleware:           middleware.NewChain([]middleware.Middleware{mw}),
+	})
+	if err != nil {
+		t.Fatalf("new agent: %v", err)
+	}
+	ctx := NewContext()
+	if _, err := ag.Run(context.Background(), ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if tools.peak.Load() < 2 {
+		t.Fatalf("expected concurrent execution, peak=%d", tools.peak.Load())
+	}
+	if tools.order[len(tools.order)-1] != "c1" {
+		t.Fatalf("expected slow call to finish last, got %v", tools.order)
+	}
+	want := []string{"c1", "c2", "c3"}
+	for i, id := range want {
+		if ctx.ToolResults[i].Output != id || before[i] != id || after[i] != id {
+			t.Fatalf("order mismatch: results=%+v before=%v after=%v", ctx.ToolResults, before, after)
+		}
+	}
+}
+
+func TestAgentKeepsUnsafeToolsSequential(t *testing.T) {
+	model := &scriptedModel{outputs: []*ModelOutput{
+		{ToolCalls: []ToolCall{
+			{ID: "c1", Name: "read"},
+			{ID: "c2", Name: "write"},
+			{ID: "c3", Name: "read"},
+		}},
+		{Content: "done", Done: true},
+	}}
+	tools := &parallelTools{
+		safe:   map[string]bool{"read": true},
+		delays: map[string]time.Duration{"read": 5 * time.Millisecond, "write": 5 * time.Millisecond},
+	}
+	ag, err := New(model, tools, Options{MaxParallelToolCalls: 4})
+	if err != nil {
+		t.Fatalf("new agent: %v", err)
+	}
+	if _, err := ag.Run(context.Background(), NewContext()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if tools.peak.Load() != 1 {
+		t.Fatalf("expected sequential execution around unsafe tool, peak=%d", tools.peak.Load())
+	}
+}
+
+func TestAgentDefaultsToSequentialTools(t *testing.T) {
+	model := &scriptedModel{outputs: []*ModelOutput{
+		{ToolCalls: []ToolCall{{ID: "c1", Name: "read"}, {ID: "c2", Name: "read"}}},
+		{Content: "done", Done: true},
+	}}
+	tools := &parallelTools{
+		safe:   map[string]bool{"read": true},
+		delays: map[string]time.Duration{"read": 5 * time.Millisecond},
+	}
+	ag, err := New(model, tools, Options{})
+	if err != nil {
+		t.Fatalf("new agent: %v", err)
+	}
+	if _, err := ag.Run(context.Background(), NewContext()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if tools.peak.Load() != 1 {
+		t.Fatalf("expected sequential execution without MaxParallelToolCalls, peak=%d", tools.peak.Load())
+	}
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cexll/agentsdk-go/pkg/middleware"
+)
+
+type suspendingTools struct {
+	suspend map[string]bool
+	calls   []string
+}
+
+func (t *suspendingTools) Execute(_ context.Context, call ToolCall, _ *Context) (ToolResult, error) {
+	t.calls = append(t.calls, call.ID)
+	if t.suspend[call.ID] {
+		return ToolResult{}, fmt.Errorf("awaiting approval: %w", ErrSuspended)
+	}
+	return ToolResult{Name: call.Name, Output: call.ID}, nil
+}
+
+func TestAgentStopsAtSuspendedToolCall(t *testing.T) {
+	model := &scriptedModel{outputs: []*ModelOutput{
+		{ToolCalls: []ToolCall{{ID: "c1", Name: "a"}, {ID: "c2", Name: "b"}, {ID: "c3", Name: "c"}}},
+		{Content: "unreachable", Done: true},
+	}}
+	tools := &suspendingTools{suspend: map[string]bool{"c2": true}}
+	var after []string
+	mw := middleware.Funcs{OnAfterTool: func(_ context.Context, st *middleware.State) error {
+		after = append(after, st.ToolCall.(ToolCall).ID)
+		return nil
+	}}
+	ag, err := New(model, tools, Options{Middleware: middleware.NewChain([]middleware.Middleware{mw})})
+	if err != nil {
+		t.Fatalf("new agent: %v", err)
+	}
+
+	c := NewContext()
+	out, err := ag.Run(context.Background(), c)
+	if !errors.Is(err, ErrSuspended) {
+		t.Fatalf("expected ErrSuspended, got %v", err)
+	}
+	if out == nil || len(out.ToolCalls) != 3 {
+		t.Fatalf("expected last model output, got %+v", out)
+	}
+	if fmt.Sprint(tools.calls) != "[c1 c2]" || fmt.Sprint(after) != "[c1]" {
+		t.Fatalf("unexpected execution calls=%v after=%v", tools.calls, after)
+	}
+	if len(c.ToolResults) != 1 {
+		t.Fatalf("suspended call must not record a result: %+v", c.ToolResults)
+	}
+	if model.idx != 1 {
+		t.Fatalf("model must not be called after suspension, got %d calls", model.idx)
+	}
+}
+
+func TestAgentRunsPendingToolCallsFirst(t *testing.T) {
+	model := &scriptedModel{outputs: []*ModelOutput{{Content: "done", Done: true}}}
+	tools := &suspendingTools{}
+	ag, err := New(model, tools, Options{})
+	if err != nil {
+		t.Fatalf("new agent: %v", err)
+	}
+
+	c := NewContext()
+	c.PendingToolCalls = []ToolCall{{ID: "c2", Name: "b"}, {ID: "c3", Name: "c"}}
+	out, err := ag.Run(context.Background(), c)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if out.Content != "done" || fmt.Sprint(tools.calls) != "[c2 c3]" {
+		t.Fatalf("unexpected out=%+v calls=%v", out, tools.calls)
+	}
+	if c.PendingToolCalls != nil || len(c.ToolResults) != 2 {
+		t.Fatalf("pending calls must be consumed: %+v", c)
+	}
+}
@@ -9,6 +9,9 @@ type Context struct {
 	Values          map[string]any
 	ToolResults     []ToolResult
 	LastModelOutput *ModelOutput
+	// PendingToolCalls are executed by Run before the first model call. They
+	// carry the unfinished tool calls of a suspended run being resumed.
+	PendingToolCalls []ToolCall
 }
 
 func NewContext() *Context {
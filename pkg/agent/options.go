@@ -13,6 +13,10 @@ type Options struct {
 	MaxIterations int
 	// Timeout bounds the entire Run invocation. Zero disables it.
 	Timeout time.Duration
+	// MaxParallelToolCalls bounds how many tool calls from a single model turn
+	// may run at once. Values <= 1 keep strictly sequential execution. Only
+	// calls reported as safe by a ConcurrentToolExecutor run in parallel.
+	MaxParallelToolCalls int
 	// Middleware chain. Defaults to an empty chain when nil.
 	Middleware *middleware.Chain
 }
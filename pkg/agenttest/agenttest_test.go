@@ -0,0 +1,167 @@
+package agenttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cexll/agentsdk-go/pkg/api"
+	coreevents "github.com/cexll/agentsdk-go/pkg/core/events"
+	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/tool"
+)
+
+type greetTool struct {
+	calls int
+}
+
+func (g *greetTool) Name() string             { return "greet" }
+func (g *greetTool) Description() string      { return "greets someone" }
+func (g *greetTool) Schema() *tool.JSONSchema { return &tool.JSONSchema{Type: "object"} }
+func (g *greetTool) Execute(_ context.Context, params map[string]interface{}) (*tool.ToolResult, error) {
+	g.calls++
+	return &tool.ToolResult{Success: true, Output: fmt.Sprintf("hello %v", params["name"])}, nil
+}
+
+func withGreet(g *greetTool) Option {
+	return WithOptions(func(opts *api.Options) { opts.Tools = []tool.Tool{g} })
+}
+
+func TestModelStreamsLikeProvider(t *testing.T) {
+	mdl := NewModel().
+		Turn("let me check", model.ToolCall{Name: "greet", Arguments: map[string]any{"name": "ada"}}).
+		Text("all done").WithUsage(10, 2)
+
+	var text strings.Builder
+	var fragments string
+	var calls []model.ToolCall
+	var final *model.Response
+	err := mdl.CompleteStream(context.Background(), model.Request{}, func(sr model.StreamResult) error {
+		text.WriteString(sr.Delta)
+		fragments += sr.ToolInputDelta
+		if sr.ToolInputDelta != "" && (sr.ToolCallID != "toolu_1" || sr.ToolCallName != "greet") {
+			t.Fatalf("fragment without call identity: %+v", sr)
+		}
+		if sr.ToolCall != nil {
+			calls = append(calls, *sr.ToolCall)
+		}
+		if sr.Final {
+			final = sr.Response
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if text.String() != "let me check" || fragments != `{"name":"ada"}` {
+		t.Fatalf("unexpected deltas text=%q fragments=%q", text.String(), fragments)
+	}
+	if len(calls) != 1 || final == nil || final.StopReason != "tool_use" || final.Model != ModelName {
+		t.Fatalf("unexpected stream calls=%+v final=%+v", calls, final)
+	}
+
+	resp, err := mdl.Complete(context.Background(), model.Request{})
+	if err != nil || resp.Message.Content != "all done" || resp.Usage.TotalTokens != 12 {
+		t.Fatalf("unexpected second turn %+v err=%v", resp, err)
+	}
+	if _, err := mdl.Complete(context.Background(), model.Request{}); !errors.Is(err, ErrScriptExhausted) {
+		t.Fatalf("expected exhausted script, got %v", err)
+	}
+	if mdl.Remaining() != 0 || len(mdl.Requests()) != 3 {
+		t.Fatalf("unexpected bookkeeping remaining=%d requests=%d", mdl.Remaining(), len(mdl.Requests()))
+	}
+}
+
+func TestHarnessRunsToolsThroughRuntime(t *testing.T) {
+	greet := &greetTool{}
+	mdl := NewModel().
+		ToolUse("greet", map[string]any{"name": "ada"}).
+		Text("done")
+	h := New(t, mdl, withGreet(greet))
+
+	res := h.Run("say hi")
+	res.AssertOutput("done")
+	res.AssertToolCalled("greet")
+	res.AssertToolCallCount("greet", 1)
+	res.AssertToolCalledWith("greet", map[string]any{"name": "ada"})
+	res.AssertToolSucceeded("greet")
+	res.AssertToolNotCalled("Bash")
+	res.AssertEvent(coreevents.PreToolUse)
+	res.AssertEvent(coreevents.PostToolUse)
+	res.AssertNoEvent(coreevents.PostToolUseFailure)
+	res.AssertNoPermissionPrompt()
+
+	if greet.calls != 1 {
+		t.Fatalf("expected the real tool to run, got %d calls", greet.calls)
+	}
+	if call := res.ToolCalls[0]; call.ID != "toolu_1" || call.Output != "hello ada" {
+		t.Fatalf("unexpected recorded call %+v", call)
+	}
+	// The tool result is fed back to the model on the next turn.
+	reqs := mdl.Requests()
+	last := reqs[len(reqs)-1].Messages
+	if tail := last[len(last)-1]; tail.Role != "tool" || tail.ToolCalls[0].Result != "hello ada" {
+		t.Fatalf("expected tool result in follow-up request, got %+v", tail)
+	}
+}
+
+func TestHarnessRecordsPermissionDecisions(t *testing.T) {
+	greet := &greetTool{}
+	mdl := NewModel().
+		ToolUse("greet", map[string]any{"name": "bob"}).
+		Text("denied")
+	h := New(t, mdl,
+		withGreet(greet),
+		WithSettings(`{"permissions":{"ask":["greet"]},"sandbox":{"enabled":true}}`),
+		WithPermissionDecision(coreevents.PermissionDeny),
+	)
+
+	res := h.Run("say hi")
+	res.AssertPermission("greet", coreevents.PermissionDeny)
+	res.AssertToolCalled("greet")
+	if greet.calls != 0 {
+		t.Fatalf("denied tool must not run, got %d calls", greet.calls)
+	}
+	if call := res.Calls("greet")[0]; call.Err == nil {
+		t.Fatalf("expected denial error on the recorded call, got %+v", call)
+	}
+}
+
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertionsReportFailures(t *testing.T) {
+	tb := &recordingTB{TB: t}
+	res := newResult(tb, &api.Response{
+		Result: &api.Result{Output: "hello"},
+		HookEvents: []coreevents.Event{
+			{Type: coreevents.PreToolUse, Payload: coreevents.ToolUsePayload{Name: "greet", ToolUseID: "a", Params: map[string]any{"n": float64(1)}}},
+		},
+	}, nil)
+
+	res.AssertToolCalledWith("greet", map[string]any{"n": 1})
+	if len(tb.errors) != 0 {
+		t.Fatalf("int params should match decoded numbers, got %v", tb.errors)
+	}
+
+	res.AssertToolCalled("edit")
+	res.AssertToolCalledWith("greet", map[string]any{"n": 2})
+	res.AssertToolSucceeded("greet")
+	res.AssertEvent(coreevents.Stop)
+	res.AssertPermission("greet", coreevents.PermissionAllow)
+	res.AssertOutput("bye")
+	res.AssertOutputContains("bye")
+	if len(tb.errors) != 7 {
+		t.Fatalf("expected every failing assertion to report, got %d: %v", len(tb.errors), tb.errors)
+	}
+}
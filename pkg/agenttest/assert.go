@@ -0,0 +1,206 @@
+package agenttest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	coreevents "github.com/cexll/agentsdk-go/pkg/core/events"
+)
+
+// Calls returns the recorded calls of the named tool in order.
+func (r *Result) Calls(name string) []ToolCall {
+	var out []ToolCall
+	for _, call := range r.ToolCalls {
+		if call.Name == name {
+			out = append(out, call)
+		}
+	}
+	return out
+}
+
+// AssertToolCalled fails the test unless the named tool was invoked.
+func (r *Result) AssertToolCalled(name string) {
+	r.t.Helper()
+	if len(r.Calls(name)) == 0 {
+		r.t.Errorf("agenttest: expected tool %q to be called; calls: %s", name, r.callNames())
+	}
+}
+
+// AssertToolNotCalled fails the test if the named tool was invoked.
+func (r *Result) AssertToolNotCalled(name string) {
+	r.t.Helper()
+	if n := len(r.Calls(name)); n > 0 {
+		r.t.Errorf("agenttest: expected tool %q not to be called, got %d calls", name, n)
+	}
+}
+
+// AssertToolCallCount fails the test unless the named tool was invoked want
+// times.
+func (r *Result) AssertToolCallCount(name string, want int) {
+	r.t.Helper()
+	if got := len(r.Calls(name)); got != want {
+		r.t.Errorf("agenttest: expected %d calls of %q, got %d", want, name, got)
+	}
+}
+
+// AssertToolCalledWith fails the test unless some call of the named tool had
+// every key of params with an equal value. Values compare after a JSON-style
+// normalisation, so 1 matches float64(1).
+func (r *Result) AssertToolCalledWith(name string, params map[string]any) {
+	r.t.Helper()
+	calls := r.Calls(name)
+	for _, call := range calls {
+		if paramsMatch(call.Params, params) {
+			return
+		}
+	}
+	seen := make([]string, 0, len(calls))
+	for _, call := range calls {
+		seen = append(seen, fmt.Sprintf("%v", call.Params))
+	}
+	r.t.Errorf("agenttest: no call of %q with %v; calls: [%s]", name, params, strings.Join(seen, ", "))
+}
+
+// AssertToolSucceeded fails the test unless every call of the named tool ran
+// without error.
+func (r *Result) AssertToolSucceeded(name string) {
+	r.t.Helper()
+	calls := r.Calls(name)
+	if len(calls) == 0 {
+		r.t.Errorf("agenttest: expected tool %q to be called", name)
+		return
+	}
+	for _, call := range calls {
+		if !call.Completed || call.Err != nil {
+			r.t.Errorf("agenttest: tool %q call %s did not succeed: completed=%v err=%v", name, call.ID, call.Completed, call.Err)
+		}
+	}
+}
+
+// AssertEvent fails the test unless a hook event of type typ fired.
+func (r *Result) AssertEvent(typ coreevents.EventType) {
+	r.t.Helper()
+	if r.countEvents(typ) == 0 {
+		r.t.Errorf("agenttest: expected %s event; fired: %s", typ, r.eventTypes())
+	}
+}
+
+// AssertNoEvent fails the test if a hook event of type typ fired.
+func (r *Result) AssertNoEvent(typ coreevents.EventType) {
+	r.t.Helper()
+	if n := r.countEvents(typ); n > 0 {
+		r.t.Errorf("agenttest: expected no %s event, got %d", typ, n)
+	}
+}
+
+// AssertPermission fails the test unless a permission prompt for the named
+// tool was answered with want.
+func (r *Result) AssertPermission(name string, want coreevents.PermissionDecisionType) {
+	r.t.Helper()
+	var got []string
+	for _, p := range r.Permissions {
+		if p.Request.ToolName != name {
+			continue
+		}
+		if p.Decision == want {
+			return
+		}
+		got = append(got, string(p.Decision))
+	}
+	if len(got) == 0 {
+		r.t.Errorf("agenttest: expected a permission prompt for %q", name)
+		return
+	}
+	r.t.Errorf("agenttest: expected %q permission for %q, got %v", want, name, got)
+}
+
+// AssertNoPermissionPrompt fails the test if any permission prompt was raised.
+func (r *Result) AssertNoPermissionPrompt() {
+	r.t.Helper()
+	if len(r.Permissions) > 0 {
+		r.t.Errorf("agenttest: expected no permission prompts, got %d", len(r.Permissions))
+	}
+}
+
+// AssertOutput fails the test unless the final output equals want.
+func (r *Result) AssertOutput(want string) {
+	r.t.Helper()
+	if got := r.Output(); got != want {
+		r.t.Errorf("agenttest: output = %q, want %q", got, want)
+	}
+}
+
+// AssertOutputContains fails the test unless the final output contains
+// substr.
+func (r *Result) AssertOutputContains(substr string) {
+	r.t.Helper()
+	if got := r.Output(); !strings.Contains(got, substr) {
+		r.t.Errorf("agenttest: output %q does not contain %q", got, substr)
+	}
+}
+
+func (r *Result) countEvents(typ coreevents.EventType) int {
+	n := 0
+	for _, evt := range r.Events() {
+		if evt.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Result) eventTypes() string {
+	names := make([]string, 0, len(r.Events()))
+	for _, evt := range r.Events() {
+		names = append(names, string(evt.Type))
+	}
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+func (r *Result) callNames() string {
+	names := make([]string, 0, len(r.ToolCalls))
+	for _, call := range r.ToolCalls {
+		names = append(names, call.Name)
+	}
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+func paramsMatch(got, want map[string]any) bool {
+	for key, value := range want {
+		actual, ok := got[key]
+		if !ok || !reflect.DeepEqual(normalize(actual), normalize(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize widens numeric types to float64 so scripted ints compare equal
+// to decoded JSON numbers.
+func normalize(v any) any {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	case map[string]any:
+		out := make(map[string]any, len(n))
+		for k, val := range n {
+			out[k] = normalize(val)
+		}
+		return out
+	case []any:
+		out := make([]any, len(n))
+		for i, val := range n {
+			out[i] = normalize(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
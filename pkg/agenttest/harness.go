@@ -0,0 +1,235 @@
+package agenttest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cexll/agentsdk-go/pkg/api"
+	coreevents "github.com/cexll/agentsdk-go/pkg/core/events"
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+const defaultSettings = `{"model":"claude-3-opus"}`
+
+// Option customises the runtime built by New.
+type Option func(*harnessConfig)
+
+type harnessConfig struct {
+	settings string
+	files    map[string]string
+	options  []func(*api.Options)
+	decide   api.PermissionRequestHandler
+}
+
+// WithSettings replaces the project's .claude/settings.json contents.
+func WithSettings(raw string) Option {
+	return func(cfg *harnessConfig) { cfg.settings = raw }
+}
+
+// WithFile writes a file relative to the project root before the runtime is
+// built, e.g. a skill under .claude/skills or a fixture the tools read.
+func WithFile(rel, content string) Option {
+	return func(cfg *harnessConfig) { cfg.files[rel] = content }
+}
+
+// WithOptions adjusts the api.Options passed to api.New. ProjectRoot and
+// Model are already set when fn runs.
+func WithOptions(fn func(*api.Options)) Option {
+	return func(cfg *harnessConfig) {
+		if fn != nil {
+			cfg.options = append(cfg.options, fn)
+		}
+	}
+}
+
+// WithPermissionDecision answers every permission prompt with decision.
+func WithPermissionDecision(decision coreevents.PermissionDecisionType) Option {
+	return WithPermissionHandler(func(context.Context, api.PermissionRequest) (coreevents.PermissionDecisionType, error) {
+		return decision, nil
+	})
+}
+
+// WithPermissionHandler answers permission prompts with fn.
+func WithPermissionHandler(fn api.PermissionRequestHandler) Option {
+	return func(cfg *harnessConfig) { cfg.decide = fn }
+}
+
+// PermissionDecision records one permission prompt and its answer.
+type PermissionDecision struct {
+	Request  api.PermissionRequest
+	Decision coreevents.PermissionDecisionType
+}
+
+// Harness runs prompts through a real api.Runtime rooted in a temporary
+// project directory.
+type Harness struct {
+	Runtime *api.Runtime
+	// Root is the temporary project root.
+	Root string
+
+	t           testing.TB
+	mu          sync.Mutex
+	permissions []PermissionDecision
+}
+
+// New builds a runtime around mdl inside t.TempDir(). The project gets a
+// minimal .claude/settings.json unless WithSettings is given, and the runtime
+// is closed when the test ends. Permission prompts are recorded and, without
+// a handler, left unanswered as the runtime would.
+func New(t testing.TB, mdl model.Model, opts ...Option) *Harness {
+	t.Helper()
+	cfg := harnessConfig{settings: defaultSettings, files: map[string]string{}}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&cfg)
+		}
+	}
+
+	root := t.TempDir()
+	cfg.files[filepath.Join(".claude", "settings.json")] = cfg.settings
+	for rel, content := range cfg.files {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("agenttest: create %s: %v", rel, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("agenttest: write %s: %v", rel, err)
+		}
+	}
+
+	h := &Harness{Root: root, t: t}
+	options := api.Options{ProjectRoot: root, Model: mdl}
+	for _, fn := range cfg.options {
+		fn(&options)
+	}
+	decide := cfg.decide
+	if decide == nil {
+		decide = options.PermissionRequestHandler
+	}
+	options.PermissionRequestHandler = h.recordPermission(decide)
+
+	rt, err := api.New(context.Background(), options)
+	if err != nil {
+		t.Fatalf("agenttest: runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	h.Runtime = rt
+	return h
+}
+
+func (h *Harness) recordPermission(next api.PermissionRequestHandler) api.PermissionRequestHandler {
+	return func(ctx context.Context, req api.PermissionRequest) (coreevents.PermissionDecisionType, error) {
+		decision := coreevents.PermissionAsk
+		if next != nil {
+			var err error
+			decision, err = next(ctx, req)
+			if err != nil {
+				return decision, err
+			}
+		}
+		h.mu.Lock()
+		h.permissions = append(h.permissions, PermissionDecision{Request: req, Decision: decision})
+		h.mu.Unlock()
+		return decision, nil
+	}
+}
+
+// Run sends prompt through the runtime and fails the test on error.
+func (h *Harness) Run(prompt string) *Result {
+	h.t.Helper()
+	res, err := h.RunRequest(api.Request{Prompt: prompt})
+	if err != nil {
+		h.t.Fatalf("agenttest: run: %v", err)
+	}
+	return res
+}
+
+// RunRequest sends req through the runtime. The returned Result is non-nil
+// whenever the runtime produced a response, including budget-exceeded
+// partial responses.
+func (h *Harness) RunRequest(req api.Request) (*Result, error) {
+	h.t.Helper()
+	h.mu.Lock()
+	h.permissions = nil
+	h.mu.Unlock()
+
+	resp, err := h.Runtime.Run(context.Background(), req)
+	var partial *api.BudgetExceededError
+	if resp == nil && err != nil && errors.As(err, &partial) {
+		resp = partial.Partial
+	}
+	if resp == nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	perms := append([]PermissionDecision(nil), h.permissions...)
+	h.mu.Unlock()
+	return newResult(h.t, resp, perms), err
+}
+
+// ToolCall is a tool invocation observed through the tool hook events.
+type ToolCall struct {
+	ID     string
+	Name   string
+	Params map[string]any
+	// Completed reports whether PostToolUse fired; calls rejected by a
+	// PreToolUse hook never get there.
+	Completed bool
+	Output    any
+	Err       error
+}
+
+// Result is the outcome of one harness run.
+type Result struct {
+	Response    *api.Response
+	ToolCalls   []ToolCall
+	Permissions []PermissionDecision
+
+	t testing.TB
+}
+
+func newResult(t testing.TB, resp *api.Response, perms []PermissionDecision) *Result {
+	res := &Result{Response: resp, Permissions: perms, t: t}
+	index := map[string]int{}
+	for _, evt := range resp.HookEvents {
+		switch payload := evt.Payload.(type) {
+		case coreevents.ToolUsePayload:
+			index[payload.ToolUseID] = len(res.ToolCalls)
+			res.ToolCalls = append(res.ToolCalls, ToolCall{ID: payload.ToolUseID, Name: payload.Name, Params: payload.Params})
+		case coreevents.ToolResultPayload:
+			i, ok := index[payload.ToolUseID]
+			if !ok {
+				i = len(res.ToolCalls)
+				res.ToolCalls = append(res.ToolCalls, ToolCall{ID: payload.ToolUseID, Name: payload.Name})
+			}
+			call := &res.ToolCalls[i]
+			call.Completed = true
+			call.Output = payload.Result
+			call.Err = payload.Err
+			if payload.Params != nil {
+				call.Params = payload.Params
+			}
+		}
+	}
+	return res
+}
+
+// Output returns the final assistant text.
+func (r *Result) Output() string {
+	if r == nil || r.Response == nil || r.Response.Result == nil {
+		return ""
+	}
+	return r.Response.Result.Output
+}
+
+// Events returns the hook events fired during the run.
+func (r *Result) Events() []coreevents.Event {
+	if r == nil || r.Response == nil {
+		return nil
+	}
+	return r.Response.HookEvents
+}
@@ -0,0 +1,199 @@
+// Package agenttest provides a scripted model, a runtime harness and
+// assertions for testing custom tools, skills and hooks against the real
+// agent pipeline without calling a provider.
+package agenttest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+// ModelName is reported as Response.Model by the scripted model.
+const ModelName = "agenttest"
+
+// ErrScriptExhausted is returned when the runtime asks for more turns than
+// were scripted.
+var ErrScriptExhausted = errors.New("agenttest: model script exhausted")
+
+// Model is a model.Model that replays scripted turns in order. Build the
+// script with the fluent methods before handing the model to a runtime:
+//
+//	mdl := agenttest.NewModel().
+//		ToolUse("Bash", map[string]any{"command": "ls"}).
+//		Text("done")
+//
+// CompleteStream emits text and tool argument fragments before the final
+// response, the way the provider adapters do.
+type Model struct {
+	mu       sync.Mutex
+	turns    []scriptedTurn
+	requests []model.Request
+	nextID   int
+}
+
+type scriptedTurn struct {
+	resp *model.Response
+	err  error
+}
+
+// NewModel returns an empty script.
+func NewModel() *Model {
+	return &Model{}
+}
+
+// Text scripts a turn that answers with text and no tool calls.
+func (m *Model) Text(text string) *Model {
+	return m.Turn(text)
+}
+
+// ToolUse scripts a turn with a single tool call. The call gets a generated
+// ID of the form "toolu_N".
+func (m *Model) ToolUse(name string, args map[string]any) *Model {
+	return m.Turn("", model.ToolCall{Name: name, Arguments: args})
+}
+
+// Turn scripts a turn with optional text followed by tool calls, which the
+// runtime executes in the same iteration. Calls without an ID get one.
+func (m *Model) Turn(text string, calls ...model.ToolCall) *Model {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg := model.Message{Role: "assistant", Content: text}
+	for _, call := range calls {
+		if call.ID == "" {
+			m.nextID++
+			call.ID = fmt.Sprintf("toolu_%d", m.nextID)
+		}
+		msg.ToolCalls = append(msg.ToolCalls, call)
+	}
+	stop := "end_turn"
+	if len(msg.ToolCalls) > 0 {
+		stop = "tool_use"
+	}
+	m.turns = append(m.turns, scriptedTurn{resp: &model.Response{Message: msg, StopReason: stop, Model: ModelName}})
+	return m
+}
+
+// Respond scripts a turn that returns resp as is.
+func (m *Model) Respond(resp *model.Response) *Model {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.turns = append(m.turns, scriptedTurn{resp: resp})
+	return m
+}
+
+// Error scripts a turn that fails with err.
+func (m *Model) Error(err error) *Model {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.turns = append(m.turns, scriptedTurn{err: err})
+	return m
+}
+
+// WithUsage sets the token usage reported by the most recently scripted turn.
+func (m *Model) WithUsage(input, output int) *Model {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n := len(m.turns); n > 0 && m.turns[n-1].resp != nil {
+		m.turns[n-1].resp.Usage = model.Usage{InputTokens: input, OutputTokens: output, TotalTokens: input + output}
+	}
+	return m
+}
+
+// Requests returns the requests the model received so far.
+func (m *Model) Requests() []model.Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]model.Request(nil), m.requests...)
+}
+
+// Remaining reports how many scripted turns have not been consumed.
+func (m *Model) Remaining() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.turns)
+}
+
+// Complete implements model.Model.
+func (m *Model) Complete(ctx context.Context, req model.Request) (*model.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.next(req)
+}
+
+// CompleteStream implements model.Model.
+func (m *Model) CompleteStream(ctx context.Context, req model.Request, cb model.StreamHandler) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	resp, err := m.next(req)
+	if err != nil {
+		return err
+	}
+	if cb == nil {
+		return nil
+	}
+	for _, chunk := range splitText(resp.Message.Content) {
+		if err := cb(model.StreamResult{Delta: chunk}); err != nil {
+			return err
+		}
+	}
+	for i := range resp.Message.ToolCalls {
+		call := resp.Message.ToolCalls[i]
+		index := i + 1
+		raw, err := json.Marshal(call.Arguments)
+		if err != nil {
+			return fmt.Errorf("agenttest: marshal %s arguments: %w", call.Name, err)
+		}
+		half := len(raw) / 2
+		for _, fragment := range []string{string(raw[:half]), string(raw[half:])} {
+			if err := cb(model.StreamResult{ToolInputDelta: fragment, ToolCallID: call.ID, ToolCallName: call.Name, Index: index}); err != nil {
+				return err
+			}
+		}
+		if err := cb(model.StreamResult{ToolCall: &call, Index: index}); err != nil {
+			return err
+		}
+	}
+	return cb(model.StreamResult{Final: true, Response: resp})
+}
+
+func (m *Model) next(req model.Request) (*model.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	req.Messages = append([]model.Message(nil), req.Messages...)
+	m.requests = append(m.requests, req)
+	if len(m.turns) == 0 {
+		return nil, ErrScriptExhausted
+	}
+	turn := m.turns[0]
+	m.turns = m.turns[1:]
+	if turn.err != nil {
+		return nil, turn.err
+	}
+	resp := *turn.resp
+	resp.Message.ToolCalls = append([]model.ToolCall(nil), turn.resp.Message.ToolCalls...)
+	return &resp, nil
+}
+
+// splitText breaks text into word-sized deltas that concatenate back to the
+// original.
+func splitText(text string) []string {
+	var chunks []string
+	for text != "" {
+		idx := strings.IndexByte(text, ' ')
+		if idx < 0 {
+			chunks = append(chunks, text)
+			break
+		}
+		chunks = append(chunks, text[:idx+1])
+		text = text[idx+1:]
+	}
+	return chunks
+}
@@ -2,12 +2,16 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"maps"
 	"net/url"
+	"os"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -71,14 +75,21 @@ type Runtime struct {
 	recorder         HookRecorder
 	hooks            *corehooks.Executor
 	histories        *historyStore
-	historyPersister *diskHistoryPersister
+	historyPersister HistoryStore
+	transcripts      *transcriptStore
 	sessionGate      *sessionGate
+	runs             *runRegistry
+	sessions         *sessionIndex
+	storedMu         sync.Mutex
+	stored           map[string]storedHistory
 
 	cmdExec   *commands.Executor
 	skReg     *skills.Registry
 	subMgr    *subagents.Manager
 	taskStore tasks.Store
 	tokens    *tokenTracker
+	pricing   *model.PricingRegistry
+	budgets   *budgetLedger
 	compactor *compactor
 	tracer    Tracer
 
@@ -96,6 +107,20 @@ type Runtime struct {
 func New(ctx context.Context, opts Options) (*Runtime, error) {
 	opts = opts.withDefaults()
 	opts = opts.frozen()
+	if err := opts.SessionConcurrency.validate(); err != nil {
+		return nil, err
+	}
+	if err := validatePromptSections(opts.SystemPromptSections, false); err != nil {
+		return nil, err
+	}
+	if err := opts.ModelParams.validate(); err != nil {
+		return nil, err
+	}
+	for name, params := range opts.SubagentModelParams {
+		if err := params.validate(); err != nil {
+			return nil, fmt.Errorf("%w (subagent %q)", err, name)
+		}
+	}
 	mode := opts.modeContext()
 
 	// 初始化文件系统抽象层
@@ -121,6 +146,7 @@ func New(ctx context.Context, opts Options) (*Runtime, error) {
 		return nil, err
 	}
 
+	applyCredentialSettings(opts, settings)
 	mdl, err := resolveModel(ctx, opts)
 	if err != nil {
 		return nil, err
@@ -171,6 +197,9 @@ func New(ctx context.Context, opts Options) (*Runtime, error) {
 	recorder := defaultHookRecorder()
 	hooks := newHookExecutor(opts, recorder, settings)
 	compactor := newCompactor(opts.ProjectRoot, opts.AutoCompact, opts.Model, opts.TokenLimit, hooks)
+	if compactor != nil {
+		compactor.counter = opts.TokenCounter
+	}
 
 	// Initialize tracer (noop without 'otel' build tag)
 	tracer, err := NewTracer(opts.OTEL)
@@ -190,18 +219,31 @@ func New(ctx context.Context, opts Options) (*Runtime, error) {
 	}
 
 	histories := newHistoryStore(opts.MaxSessions)
-	var historyPersister *diskHistoryPersister
+	histories.counter = opts.TokenCounter
+	historyPersister := opts.HistoryStore
 	retainDays := 0
 	if settings != nil && settings.CleanupPeriodDays != nil {
 		retainDays = *settings.CleanupPeriodDays
 	}
+	var transcripts *transcriptStore
 	if retainDays > 0 {
-		historyPersister = newDiskHistoryPersister(opts.ProjectRoot)
-		if historyPersister != nil {
-			histories.loader = historyPersister.Load
-			if err := historyPersister.Cleanup(retainDays); err != nil {
+		if disk := newDiskHistoryPersister(opts.ProjectRoot); disk != nil {
+			if err := disk.Cleanup(retainDays); err != nil {
 				log.Printf("history cleanup warning: %v", err)
 			}
+			if historyPersister == nil {
+				historyPersister = NewFileHistoryStore(disk.dir)
+			}
+		}
+		transcripts = newTranscriptStore(opts.ProjectRoot)
+		if err := transcripts.Cleanup(retainDays); err != nil {
+			log.Printf("transcript cleanup warning: %v", err)
+		}
+		if err := newSuspendedRunStore(opts.ProjectRoot).Cleanup(retainDays); err != nil {
+			log.Printf("suspended run cleanup warning: %v", err)
+		}
+		if err := pruneHistoryStore(ctx, opts.HistoryStore, retainDays); err != nil {
+			log.Printf("history store cleanup warning: %v", err)
 		}
 	}
 
@@ -220,16 +262,25 @@ func New(ctx context.Context, opts Options) (*Runtime, error) {
 		hooks:            hooks,
 		histories:        histories,
 		historyPersister: historyPersister,
+		transcripts:      transcripts,
 		cmdExec:          cmdExec,
 		skReg:            skReg,
 		subMgr:           subMgr,
 		taskStore:        opts.TaskStore,
 		tokens:           newTokenTracker(opts.TokenTracking, opts.TokenCallback),
+		pricing:          newPricingRegistry(opts, settings),
+		budgets:          newBudgetLedger(),
 		compactor:        compactor,
 		tracer:           tracer,
 		ownsTaskStore:    ownsTaskStore,
 	}
 	rt.sessionGate = newSessionGate()
+	rt.runs = newRunRegistry()
+	rt.sessions = newSessionIndex()
+	histories.onEvict = rt.evictSession
+	if historyPersister != nil || transcripts != nil {
+		histories.loader = rt.loadHistory
+	}
 
 	if taskTool != nil {
 		taskTool.SetRunner(rt.taskRunner())
@@ -266,22 +317,32 @@ func (rt *Runtime) Run(ctx context.Context, req Request) (*Response, error) {
 		sessionID = defaultSessionID(rt.mode.EntryPoint)
 	}
 	req.SessionID = sessionID
+	if strings.TrimSpace(req.RequestID) == "" {
+		req.RequestID = uuid.New().String()
+	}
 
-	if err := rt.sessionGate.Acquire(ctx, sessionID); err != nil {
-		return nil, ErrConcurrentExecution
+	ctx, release, err := rt.acquireSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
 	}
-	defer rt.sessionGate.Release(sessionID)
+	defer release()
+	ctx, run, untrack, err := rt.runs.track(ctx, req.RequestID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer untrack()
 
 	prep, err := rt.prepare(ctx, req)
 	if err != nil {
+		if stop := stopError(ctx); stop != nil {
+			return nil, stop
+		}
 		return nil, err
 	}
+	prep.run = run
 	defer rt.persistHistory(prep.normalized.SessionID, prep.history)
 	result, err := rt.runAgent(prep)
-	if err != nil {
-		return nil, err
-	}
-	return rt.buildResponse(prep, result), nil
+	return rt.finishRun(prep, result, err)
 }
 
 // RunStream executes the pipeline asynchronously and returns events over a channel.
@@ -297,6 +358,9 @@ func (rt *Runtime) RunStream(ctx context.Context, req Request) (<-chan StreamEve
 		sessionID = defaultSessionID(rt.mode.EntryPoint)
 	}
 	req.SessionID = sessionID
+	if strings.TrimSpace(req.RequestID) == "" {
+		req.RequestID = uuid.New().String()
+	}
 
 	if err := rt.beginRun(); err != nil {
 		return nil, err
@@ -314,19 +378,31 @@ func (rt *Runtime) RunStream(ctx context.Context, req Request) (<-chan StreamEve
 	go func() {
 		defer rt.endRun()
 		defer close(out)
-		if err := rt.sessionGate.Acquire(ctxWithEmit, sessionID); err != nil {
+		runCtx, release, err := rt.acquireSession(ctxWithEmit, sessionID)
+		if err != nil {
+			isErr := true
+			out <- StreamEvent{Type: EventError, Output: err.Error(), IsError: &isErr}
+			return
+		}
+		defer release()
+		ctxWithEmit, run, untrack, err := rt.runs.track(runCtx, req.RequestID, sessionID)
+		if err != nil {
 			isErr := true
-			out <- StreamEvent{Type: EventError, Output: ErrConcurrentExecution.Error(), IsError: &isErr}
+			out <- StreamEvent{Type: EventError, Output: err.Error(), IsError: &isErr}
 			return
 		}
-		defer rt.sessionGate.Release(sessionID)
+		defer untrack()
 
 		prep, err := rt.prepare(ctxWithEmit, req)
 		if err != nil {
+			if stop := stopError(ctxWithEmit); stop != nil {
+				err = stop
+			}
 			isErr := true
 			out <- StreamEvent{Type: EventError, Output: err.Error(), IsError: &isErr}
 			return
 		}
+		prep.run = run
 		defer rt.persistHistory(prep.normalized.SessionID, prep.history)
 
 		done := make(chan struct{})
@@ -350,8 +426,11 @@ func (rt *Runtime) RunStream(ctx context.Context, req Request) (<-chan StreamEve
 		defer func() {
 			if rt.hooks != nil {
 				reason := "completed"
-				if runErr != nil {
+				switch {
+				case runErr != nil:
 					reason = "error"
+				case result.suspended != nil:
+					reason = "suspended"
 				}
 				//nolint:errcheck // session end events are non-critical notifications
 				rt.hooks.Publish(coreevents.Event{
@@ -371,6 +450,9 @@ func (rt *Runtime) RunStream(ctx context.Context, req Request) (<-chan StreamEve
 			out <- StreamEvent{Type: EventError, Output: runErr.Error(), IsError: &isErr}
 			return
 		}
+		if result.suspended != nil {
+			out <- StreamEvent{Type: EventRunSuspended, SessionID: req.SessionID, ToolUseID: result.suspended.ToolUseID, Name: result.suspended.ToolName, Output: result.suspended}
+		}
 		rt.buildResponse(prep, result)
 	}()
 	return out, nil
@@ -475,12 +557,18 @@ type preparedRun struct {
 	subagentResult *subagents.Result
 	mode           ModeContext
 	toolWhitelist  map[string]struct{}
+	// pending holds the unfinished tool calls of a resumed run.
+	pending []agent.ToolCall
+	// run tracks the progress reported by ActiveRuns.
+	run *activeRun
 }
 
 type runResult struct {
-	output *agent.ModelOutput
-	usage  model.Usage
-	reason string
+	output     *agent.ModelOutput
+	usage      model.Usage
+	reason     string
+	structured map[string]any
+	suspended  *SuspendedRun
 }
 
 func (rt *Runtime) prepare(ctx context.Context, req Request) (preparedRun, error) {
@@ -493,6 +581,15 @@ func (rt *Runtime) prepare(ctx context.Context, req Request) (preparedRun, error
 	if prompt == "" && len(normalized.ContentBlocks) == 0 {
 		return preparedRun{}, errors.New("api: prompt is empty")
 	}
+	if err := validateOutputSchema(normalized.OutputSchema); err != nil {
+		return preparedRun{}, err
+	}
+	if err := validatePromptSections(normalized.PromptSections, true); err != nil {
+		return preparedRun{}, err
+	}
+	if err := normalized.ModelParams.validate(); err != nil {
+		return preparedRun{}, err
+	}
 
 	if normalized.SessionID == "" {
 		normalized.SessionID = fallbackSession
@@ -504,6 +601,13 @@ func (rt *Runtime) prepare(ctx context.Context, req Request) (preparedRun, error
 	}
 
 	history := rt.histories.Get(normalized.SessionID)
+	rt.refreshHistory(ctx, normalized.SessionID, history)
+	// A run suspended for approval leaves its tool calls unanswered; a new
+	// prompt abandons them, which providers only accept once they have a
+	// result. Resume then reports the run as stale.
+	if msgs := history.All(); len(unansweredToolCalls(msgs)) > 0 {
+		history.Replace(answerDanglingToolCalls(msgs, abandonedToolResult))
+	}
 	recorder := defaultHookRecorder()
 
 	if rt.compactor != nil {
@@ -576,32 +680,83 @@ func (rt *Runtime) runAgentWithMiddleware(prep preparedRun, extras ...middleware
 		enableCache = *prep.normalized.EnablePromptCache
 	}
 
+	systemBlocks, err := rt.renderSystemSections(prep.ctx, prep.normalized)
+	if err != nil {
+		return runResult{}, err
+	}
+
+	thinking := rt.opts.Thinking
+	if prep.normalized.Thinking != nil {
+		thinking = prep.normalized.Thinking
+	}
+	cachePolicy := rt.opts.CachePolicy
+	if prep.normalized.CachePolicy != nil {
+		cachePolicy = prep.normalized.CachePolicy
+	}
+
+	params := rt.modelParamsFor(prep.normalized)
+	tools := availableTools(rt.registry, prep.toolWhitelist)
+	output := newStructuredOutput(prep.normalized.OutputSchema, prep.normalized.OutputRetries)
+	offered := tools
+	if output != nil {
+		offered = append(append([]model.ToolDefinition(nil), tools...), output.toolDefinition())
+	}
+	if err := checkToolChoice(params.ToolChoice, offered); err != nil {
+		return runResult{}, err
+	}
+
 	hookAdapter := &runtimeHookAdapter{executor: rt.hooks, recorder: prep.recorder}
+	results := newToolResultSequencer(prep.history)
+	var streamObserver modelStreamObserver
+	calibrator, _ := rt.opts.TokenCounter.(message.Calibrator)
+	for _, mw := range extras {
+		if obs, ok := mw.(modelStreamObserver); ok {
+			streamObserver = obs
+		}
+	}
 	modelAdapter := &conversationModel{
 		base:          selectedModel,
 		history:       prep.history,
 		prompt:        prep.prompt,
 		contentBlocks: prep.contentBlocks,
 		trimmer:       rt.newTrimmer(),
-		tools:         availableTools(rt.registry, prep.toolWhitelist),
+		tools:         tools,
 		systemPrompt:  rt.opts.SystemPrompt,
+		systemBlocks:  systemBlocks,
 		rulesLoader:   rt.rulesLoader,
 		enableCache:   enableCache,
+		cachePolicy:   cachePolicy,
+		thinking:      thinking,
+		params:        params,
 		hooks:         hookAdapter,
 		recorder:      prep.recorder,
 		compactor:     rt.compactor,
 		sessionID:     prep.normalized.SessionID,
-	}
+		results:       results,
+		stream:        streamObserver,
+		output:        output,
+		budget:        rt.newRunBudget(prep.normalized),
+		run:           prep.run,
+		calibrator:    calibrator,
+		// A resumed run already made its first call.
+		toolChoiceSpent: len(prep.pending) > 0,
+		onUsage: func(modelName string, usage model.Usage) float64 {
+			return rt.recordUsage(prep, modelName, usage)
+		},
+	}
+	defer modelAdapter.budget.release()
 
 	toolExec := &runtimeToolExecutor{
 		executor:           rt.executor,
 		hooks:              hookAdapter,
 		history:            prep.history,
+		results:            results,
 		allow:              prep.toolWhitelist,
 		root:               rt.sbRoot,
 		host:               "localhost",
 		sessionID:          prep.normalized.SessionID,
-		permissionResolver: buildPermissionResolver(hookAdapter, rt.opts.PermissionRequestHandler, rt.opts.ApprovalQueue, rt.opts.ApprovalApprover, rt.opts.ApprovalWhitelistTTL, rt.opts.ApprovalWait),
+		run:                prep.run,
+		permissionResolver: buildPermissionResolver(hookAdapter, rt.opts.PermissionRequestHandler, rt.opts.ApprovalQueue, rt.opts.ApprovalApprover, rt.opts.ApprovalWhitelistTTL, rt.opts.ApprovalWait, rt.opts.ApprovalSuspend),
 	}
 
 	chainItems := make([]middleware.Middleware, 0, len(rt.opts.Middleware)+len(extras))
@@ -613,9 +768,10 @@ func (rt *Runtime) runAgentWithMiddleware(prep preparedRun, extras ...middleware
 	}
 	chain := middleware.NewChain(chainItems, middleware.WithTimeout(rt.opts.MiddlewareTimeout))
 	ag, err := agent.New(modelAdapter, toolExec, agent.Options{
-		MaxIterations: rt.opts.MaxIterations,
-		Timeout:       rt.opts.Timeout,
-		Middleware:    chain,
+		MaxIterations:        rt.opts.MaxIterations,
+		Timeout:              rt.opts.Timeout,
+		MaxParallelToolCalls: rt.opts.MaxParallelToolCalls,
+		Middleware:           chain,
 	})
 	if err != nil {
 		return runResult{}, err
@@ -635,44 +791,108 @@ func (rt *Runtime) runAgentWithMiddleware(prep preparedRun, extras ...middleware
 	if rt.skReg != nil {
 		agentCtx.Values["skills.registry"] = rt.skReg
 	}
+	if len(prep.pending) > 0 {
+		agentCtx.PendingToolCalls = prep.pending
+		calls := make([]message.ToolCall, len(prep.pending))
+		for i, call := range prep.pending {
+			calls[i] = message.ToolCall{ID: call.ID, Name: call.Name, Arguments: call.Input}
+		}
+		results.Expect(calls)
+	}
 	out, err := ag.Run(prep.ctx, agentCtx)
+	var (
+		suspended *SuspendedRun
+		exceeded  *BudgetExceededError
+	)
 	if err != nil {
-		return runResult{}, err
-	}
-	if rt.tokens != nil && rt.tokens.IsEnabled() {
-		stats := tokenStatsFromUsage(modelAdapter.usage, "", prep.normalized.SessionID, prep.normalized.RequestID)
-		rt.tokens.Record(stats)
-		payload := coreevents.TokenUsagePayload{
-			InputTokens:   stats.InputTokens,
-			OutputTokens:  stats.OutputTokens,
-			TotalTokens:   stats.TotalTokens,
-			CacheCreation: stats.CacheCreation,
-			CacheRead:     stats.CacheRead,
-			Model:         stats.Model,
-			SessionID:     stats.SessionID,
-			RequestID:     stats.RequestID,
-		}
-		if rt.hooks != nil {
-			//nolint:errcheck // token usage events are non-critical notifications
-			rt.hooks.Publish(coreevents.Event{
-				Type:      coreevents.TokenUsage,
-				SessionID: stats.SessionID,
-				RequestID: stats.RequestID,
-				Payload:   payload,
-			})
+		var pending *approvalPendingError
+		stop := stopError(prep.ctx)
+		switch {
+		case errors.As(err, &pending):
+			if suspended, err = rt.suspendRun(prep, pending); err != nil {
+				return runResult{}, err
+			}
+		case errors.As(err, &exceeded):
+			out = modelAdapter.partial
+		case stop != nil:
+			reason := modelAdapter.recordStopped(prep.ctx, stop)
+			return runResult{usage: modelAdapter.usage, reason: reason}, stop
+		default:
+			return runResult{}, err
 		}
-		if prep.recorder != nil {
-			prep.recorder.Record(coreevents.Event{
-				Type:      coreevents.TokenUsage,
-				SessionID: stats.SessionID,
-				RequestID: stats.RequestID,
-				Payload:   payload,
-			})
+	}
+	res := runResult{output: out, usage: modelAdapter.usage, reason: modelAdapter.stopReason, suspended: suspended}
+	if suspended != nil {
+		res.reason = StopReasonPendingApproval
+	}
+	if modelAdapter.output != nil {
+		res.structured = modelAdapter.output.value
+	}
+	if exceeded != nil {
+		res.reason = StopReasonBudgetExceeded
+		return res, exceeded
+	}
+	return res, nil
+}
+
+// finishRun builds the response of a completed run. A run stopped by a budget
+// returns its partial response on the BudgetExceededError.
+func (rt *Runtime) finishRun(prep preparedRun, result runResult, err error) (*Response, error) {
+	if err != nil {
+		var exceeded *BudgetExceededError
+		if errors.As(err, &exceeded) {
+			exceeded.Partial = rt.buildResponse(prep, result)
 		}
+		return nil, err
 	}
-	return runResult{output: out, usage: modelAdapter.usage, reason: modelAdapter.stopReason}, nil
+	return rt.buildResponse(prep, result), nil
 }
 
+// recordUsage adds the usage of a single model call to the session totals,
+// records it with its estimated cost and publishes it as a TokenUsage event.
+// It returns the estimated cost.
+func (rt *Runtime) recordUsage(prep preparedRun, modelName string, usage model.Usage) float64 {
+	var costUSD float64
+	if cost := rt.costFunc(); cost != nil {
+		costUSD = cost(modelName, usage)
+	}
+	rt.sessions.addUsage(prep.normalized.SessionID, usage, costUSD)
+	if rt.tokens == nil || !rt.tokens.IsEnabled() {
+		return costUSD
+	}
+	stats := tokenStatsFromUsage(usage, modelName, prep.normalized.SessionID, prep.normalized.RequestID)
+	stats.CostUSD = costUSD
+	rt.tokens.Record(stats)
+	payload := coreevents.TokenUsagePayload{
+		InputTokens:   stats.InputTokens,
+		OutputTokens:  stats.OutputTokens,
+		TotalTokens:   stats.TotalTokens,
+		CacheCreation: stats.CacheCreation,
+		CacheRead:     stats.CacheRead,
+		CostUSD:       stats.CostUSD,
+		Model:         stats.Model,
+		SessionID:     stats.SessionID,
+		RequestID:     stats.RequestID,
+	}
+	if rt.hooks != nil {
+		//nolint:errcheck // token usage events are non-critical notifications
+		rt.hooks.Publish(coreevents.Event{
+			Type:      coreevents.TokenUsage,
+			SessionID: stats.SessionID,
+			RequestID: stats.RequestID,
+			Payload:   payload,
+		})
+	}
+	if prep.recorder != nil {
+		prep.recorder.Record(coreevents.Event{
+			Type:      coreevents.TokenUsage,
+			SessionID: stats.SessionID,
+			RequestID: stats.RequestID,
+			Payload:   payload,
+		})
+	}
+	return costUSD
+}
 func (rt *Runtime) buildResponse(prep preparedRun, result runResult) *Response {
 	events := []coreevents.Event(nil)
 	if prep.recorder != nil {
@@ -690,6 +910,7 @@ func (rt *Runtime) buildResponse(prep preparedRun, result runResult) *Response {
 		Settings:        rt.Settings(),
 		SandboxSnapshot: rt.sandboxReport(),
 		Tags:            maps.Clone(prep.normalized.Tags),
+		Suspended:       result.suspended,
 	}
 	return resp
 }
@@ -743,6 +964,7 @@ func convertRunResult(res runResult) *Result {
 		ToolCalls:  toolCalls,
 		Usage:      res.usage,
 		StopReason: res.reason,
+		Structured: res.structured,
 	}
 }
 
@@ -978,7 +1200,12 @@ func (rt *Runtime) newTrimmer() *message.Trimmer {
 	if rt.opts.TokenLimit <= 0 {
 		return nil
 	}
-	return message.NewTrimmer(rt.opts.TokenLimit, nil)
+	trimmer := message.NewTrimmer(rt.opts.TokenLimit, rt.opts.TokenCounter)
+	trimmer.KeepToolPairs = true
+	// System messages carry compaction summaries; losing one loses every
+	// turn it replaced.
+	trimmer.Pinned = func(msg message.Message) bool { return msg.Role == "system" }
+	return trimmer
 }
 
 // ----------------- adapters -----------------
@@ -991,20 +1218,45 @@ type conversationModel struct {
 	trimmer       *message.Trimmer
 	tools         []model.ToolDefinition
 	systemPrompt  string
+	systemBlocks  []model.SystemBlock
 	rulesLoader   *config.RulesLoader
 	enableCache   bool // Enable prompt caching for this conversation
+	cachePolicy   *model.CachePolicy
+	thinking      *model.ThinkingConfig
+	params        ModelParams
 	usage         model.Usage
 	stopReason    string
 	hooks         *runtimeHookAdapter
 	recorder      *hookRecorder
 	compactor     *compactor
 	sessionID     string
+	results       *toolResultSequencer
+	stream        modelStreamObserver
+	output        *structuredOutput
+	budget        *runBudget
+	onUsage       func(modelName string, usage model.Usage) float64
+	// callCost is the cost onUsage estimated for the latest model call.
+	callCost float64
+	// partial is the output reported when a budget stops the run.
+	partial *agent.ModelOutput
+	// streamed collects the text of the model call in flight so an
+	// interrupted run can keep what was already shown.
+	streamed strings.Builder
+	run      *activeRun
+	// calibrator learns from the input tokens each call reports.
+	calibrator message.Calibrator
+	// toolChoiceSpent is set once a call ran with a forced tool choice.
+	toolChoiceSpent bool
 }
 
 func (m *conversationModel) Generate(ctx context.Context, _ *agent.Context) (*agent.ModelOutput, error) {
 	if m.base == nil {
 		return nil, errors.New("model is nil")
 	}
+	if err := m.budget.check(); err != nil {
+		return nil, err
+	}
+	m.run.nextIteration()
 
 	if strings.TrimSpace(m.prompt) != "" || len(m.contentBlocks) > 0 {
 		userMsg := message.Message{Role: "user", Content: strings.TrimSpace(m.prompt)}
@@ -1018,6 +1270,9 @@ func (m *conversationModel) Generate(ctx context.Context, _ *agent.Context) (*ag
 		m.prompt = ""
 		m.contentBlocks = nil
 	}
+	if err := m.applySteering(ctx); err != nil {
+		return nil, err
+	}
 
 	if m.compactor != nil {
 		if _, _, err := m.compactor.maybeCompact(ctx, m.history, m.sessionID, m.recorder); err != nil {
@@ -1025,9 +1280,119 @@ func (m *conversationModel) Generate(ctx context.Context, _ *agent.Context) (*ag
 		}
 	}
 
+	for {
+		// Steering and structured output feedback loop back here, so budgets
+		// spent meanwhile are checked before every call.
+		if err := m.budget.check(); err != nil {
+			return nil, err
+		}
+		resp, err := m.complete(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		assistant := message.Message{
+			Role:             resp.Message.Role,
+			Content:          strings.TrimSpace(resp.Message.Content),
+			ReasoningContent: resp.Message.ReasoningContent,
+			ThinkingBlocks:   convertThinkingBlocksFromModel(resp.Message.ThinkingBlocks),
+		}
+		if len(resp.Message.ToolCalls) > 0 {
+			assistant.ToolCalls = make([]message.ToolCall, len(resp.Message.ToolCalls))
+			for i, call := range resp.Message.ToolCalls {
+				assistant.ToolCalls[i] = message.ToolCall{ID: call.ID, Name: call.Name, Arguments: call.Arguments}
+			}
+		}
+		if err := m.chargeBudget(ctx, resp); err != nil {
+			// Keep the text of the last turn but drop tool calls that will
+			// never run so the history stays well formed.
+			assistant.ToolCalls = nil
+			if assistant.Content != "" || assistant.ReasoningContent != "" {
+				m.history.Append(assistant)
+			}
+			m.partial = &agent.ModelOutput{Content: assistant.Content, Done: true}
+			return nil, err
+		}
+
+		if m.output != nil {
+			accepted, call, feedback, err := m.output.resolve(assistant)
+			if err != nil {
+				return nil, err
+			}
+			if accepted || feedback != "" {
+				m.recordFinalAnswer(assistant, call, accepted, feedback)
+				if accepted {
+					return &agent.ModelOutput{Content: m.output.raw(), Done: true}, nil
+				}
+				continue
+			}
+		}
+
+		m.history.Append(assistant)
+		m.results.Expect(assistant.ToolCalls)
+		if len(assistant.ToolCalls) == 0 && m.run.hasSteering() {
+			// A steering message arrived during the final turn; answer it
+			// instead of finishing. The answer so far is the partial output
+			// if a budget stops the run first.
+			m.partial = &agent.ModelOutput{Content: assistant.Content, Done: true}
+			if err := m.applySteering(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		out := &agent.ModelOutput{Content: assistant.Content, Done: len(assistant.ToolCalls) == 0}
+		if len(assistant.ToolCalls) > 0 {
+			out.ToolCalls = make([]agent.ToolCall, len(assistant.ToolCalls))
+			for i, call := range assistant.ToolCalls {
+				out.ToolCalls[i] = agent.ToolCall{ID: call.ID, Name: call.Name, Input: call.Arguments}
+			}
+			for _, tc := range out.ToolCalls {
+				if len(tc.Input) == 0 {
+					log.Printf("WARNING: tool call %q (id=%s) has empty arguments — "+
+						"this usually means the API proxy stripped tool_use.input", tc.Name, tc.ID)
+				}
+			}
+		}
+		return out, nil
+	}
+}
+
+// applySteering appends the messages queued through Runtime.Steer as user
+// turns, firing UserPromptSubmit for each and confirming them on the stream.
+func (m *conversationModel) applySteering(ctx context.Context) error {
+	for _, msg := range m.run.takeSteering() {
+		m.history.Append(message.Message{Role: "user", Content: msg})
+		if err := m.hooks.UserPrompt(ctx, msg); err != nil {
+			return err
+		}
+		if emit := streamEmitFromContext(ctx); emit != nil {
+			emit(ctx, StreamEvent{Type: EventSteeringApplied, SessionID: m.sessionID, Output: msg})
+		}
+	}
+	return nil
+}
+
+// complete sends the current history to the model and returns its final
+// response, publishing request/response details on the middleware state.
+func (m *conversationModel) complete(ctx context.Context) (*model.Response, error) {
 	snapshot := m.history.All()
 	if m.trimmer != nil {
-		snapshot = m.trimmer.Trim(snapshot)
+		// Fetch exact counts with the run's ctx; the trimmer then only reads
+		// the counter's cache.
+		if primer, ok := m.trimmer.Counter.(message.Primer); ok {
+			primer.Prime(ctx, snapshot)
+		}
+		var report message.TrimReport
+		snapshot, report = m.trimmer.TrimWithReport(snapshot)
+		// A user turn keeps each note where its messages were dropped; a
+		// system message would be hoisted into the cached system prompt.
+		// Notes go in from the last gap so earlier indexes stay valid.
+		for i := len(report.Gaps) - 1; i >= 0; i-- {
+			gap := report.Gaps[i]
+			note := message.Message{Role: "user", Content: fmt.Sprintf("[%d earlier messages omitted]", gap.Dropped)}
+			snapshot = slices.Insert(snapshot, gap.Index, note)
+		}
 	}
 	systemPrompt := m.systemPrompt
 	if m.rulesLoader != nil {
@@ -1035,14 +1400,26 @@ func (m *conversationModel) Generate(ctx context.Context, _ *agent.Context) (*ag
 			systemPrompt = fmt.Sprintf("%s\n\n## Project Rules\n\n%s", systemPrompt, rules)
 		}
 	}
+	tools := m.tools
+	if m.output != nil {
+		systemPrompt = strings.TrimSpace(systemPrompt + "\n\n" + m.output.instructions())
+		tools = append(append([]model.ToolDefinition(nil), m.tools...), m.output.toolDefinition())
+	}
 	req := model.Request{
 		Messages:          convertMessages(snapshot),
-		Tools:             m.tools,
+		Tools:             tools,
 		System:            systemPrompt,
-		MaxTokens:         0,
-		Model:             "",
-		Temperature:       nil,
+		SystemBlocks:      m.systemBlocks,
+		MaxTokens:         m.params.MaxTokens,
+		Temperature:       m.params.Temperature,
 		EnablePromptCache: m.enableCache,
+		Cache:             m.cachePolicy,
+		Thinking:          m.thinking,
+		TopP:              m.params.TopP,
+		TopK:              m.params.TopK,
+		StopSequences:     m.params.StopSequences,
+		ToolChoice:        m.toolChoice(),
+		UserID:            m.params.UserID,
 	}
 
 	// Populate middleware state with model request if available
@@ -1057,10 +1434,25 @@ func (m *conversationModel) Generate(ctx context.Context, _ *agent.Context) (*ag
 	// Use streaming internally: some API proxies return empty tool_use.input
 	// in non-streaming mode but work correctly with streaming. Streaming is
 	// also the production-standard path for the Anthropic API.
+	ctx = model.WithFailoverObserver(ctx, func(sw model.FailoverSwitch) {
+		if err := m.hooks.ModelSelected(ctx, coreevents.ModelSelectedPayload{
+			Reason:        sw.Reason(),
+			Model:         sw.ToModel,
+			PreviousModel: sw.FromModel,
+		}); err != nil {
+			log.Printf("api: failed to emit ModelSelected event: %v", err)
+		}
+	})
 	var resp *model.Response
+	m.streamed.Reset()
 	if err := m.base.CompleteStream(ctx, req, func(sr model.StreamResult) error {
 		if sr.Final && sr.Response != nil {
 			resp = sr.Response
+			return nil
+		}
+		m.streamed.WriteString(sr.Delta)
+		if m.stream != nil {
+			m.stream.ObserveModelStream(ctx, sr)
 		}
 		return nil
 	}); err != nil {
@@ -1069,8 +1461,17 @@ func (m *conversationModel) Generate(ctx context.Context, _ *agent.Context) (*ag
 	if resp == nil {
 		return nil, errors.New("model returned no final response")
 	}
+	m.streamed.Reset()
+	if req.ToolChoice.Forces() {
+		m.toolChoiceSpent = true
+	}
+	m.calibrate(snapshot, req, resp.Usage)
 	m.usage = resp.Usage
 	m.stopReason = resp.StopReason
+	m.callCost = 0
+	if m.onUsage != nil {
+		m.callCost = m.onUsage(resp.Model, resp.Usage)
+	}
 
 	// Populate middleware state with model response and usage
 	if st, ok := ctx.Value(model.MiddlewareStateKey).(*middleware.State); ok && st != nil {
@@ -1082,40 +1483,116 @@ func (m *conversationModel) Generate(ctx context.Context, _ *agent.Context) (*ag
 		st.Values["model.usage"] = resp.Usage
 		st.Values["model.stop_reason"] = resp.StopReason
 	}
+	return resp, nil
+}
 
-	assistant := message.Message{Role: resp.Message.Role, Content: strings.TrimSpace(resp.Message.Content), ReasoningContent: resp.Message.ReasoningContent}
-	if len(resp.Message.ToolCalls) > 0 {
-		assistant.ToolCalls = make([]message.ToolCall, len(resp.Message.ToolCalls))
-		for i, call := range resp.Message.ToolCalls {
-			assistant.ToolCalls[i] = message.ToolCall{ID: call.ID, Name: call.Name, Arguments: call.Arguments}
+// calibrate reports the request just sent, with its system prompt and tool
+// definitions, together with the input tokens it cost. Cached prompt tokens
+// are not part of InputTokens but still fill the context window.
+func (m *conversationModel) calibrate(snapshot []message.Message, req model.Request, usage model.Usage) {
+	observed := usage.InputTokens + usage.CacheReadTokens + usage.CacheCreationTokens
+	if m.calibrator == nil || observed <= 0 {
+		return
+	}
+	sample := make([]message.Message, 0, len(snapshot)+len(req.SystemBlocks)+2)
+	if req.System != "" {
+		sample = append(sample, message.Message{Role: "system", Content: req.System})
+	}
+	for _, block := range req.SystemBlocks {
+		sample = append(sample, message.Message{Role: "system", Content: block.Text})
+	}
+	if len(req.Tools) > 0 {
+		if raw, err := json.Marshal(req.Tools); err == nil {
+			sample = append(sample, message.Message{Role: "system", Content: string(raw)})
 		}
 	}
-	m.history.Append(assistant)
+	m.calibrator.Observe(append(sample, snapshot...), observed)
+}
 
-	out := &agent.ModelOutput{Content: assistant.Content, Done: len(assistant.ToolCalls) == 0}
-	if len(assistant.ToolCalls) > 0 {
-		out.ToolCalls = make([]agent.ToolCall, len(assistant.ToolCalls))
-		for i, call := range assistant.ToolCalls {
-			out.ToolCalls[i] = agent.ToolCall{ID: call.ID, Name: call.Name, Input: call.Arguments}
+// recordStopped closes the history of a run stopped by the runtime with the
+// text streamed so far and a marker naming the cause, so the next turn sees
+// what the user saw, and reports the stop to the Stop hook. It returns the
+// run's stop reason.
+func (m *conversationModel) recordStopped(ctx context.Context, stop error) string {
+	marker, reason, hookReason := interruptedMarker, StopReasonInterrupted, StopReasonInterrupted
+	var cancelled *RunCancelledError
+	if errors.As(stop, &cancelled) {
+		marker, reason = cancelled.marker(), StopReasonCancelled
+		hookReason = fmt.Sprintf("%s: %s", StopReasonCancelled, cancelled.Reason)
+	}
+	m.results.Abandon(fmt.Sprintf(`{"error":%q}`, marker))
+	content := strings.TrimSpace(m.streamed.String())
+	m.streamed.Reset()
+	if content != "" {
+		content += "\n\n"
+	}
+	m.history.Append(message.Message{Role: "assistant", Content: content + marker})
+	if err := m.hooks.Stop(context.WithoutCancel(ctx), hookReason); err != nil {
+		log.Printf("api: failed to emit Stop event: %v", err)
+	}
+	return reason
+}
+
+// chargeBudget accounts the call against the run budget and announces soft
+// limit crossings as Notification events.
+func (m *conversationModel) chargeBudget(ctx context.Context, resp *model.Response) error {
+	warnings, exceeded := m.budget.charge(resp.Usage, m.callCost)
+	for _, w := range warnings {
+		if err := m.hooks.Notification(ctx, coreevents.NotificationPayload{
+			Title:            "Budget warning",
+			Message:          w.message(),
+			NotificationType: NotificationTypeBudgetWarning,
+			Meta: map[string]any{
+				"scope": w.Scope,
+				"limit": w.Limit,
+				"used":  w.Used,
+				"max":   w.Max,
+			},
+		}); err != nil {
+			log.Printf("api: failed to emit budget warning: %v", err)
 		}
-		for _, tc := range out.ToolCalls {
-			if len(tc.Input) == 0 {
-				log.Printf("WARNING: tool call %q (id=%s) has empty arguments — "+
-					"this usually means the API proxy stripped tool_use.input", tc.Name, tc.ID)
-			}
+	}
+	if exceeded != nil {
+		return exceeded
+	}
+	return nil
+}
+
+// recordFinalAnswer appends a structured-output attempt to history. A
+// final_answer call is paired with its tool result (acceptance or validation
+// feedback) and any other calls from the same turn are dropped; a plain text
+// attempt that was rejected is followed by the feedback as a user message.
+func (m *conversationModel) recordFinalAnswer(assistant message.Message, call *message.ToolCall, accepted bool, feedback string) {
+	if call == nil {
+		m.history.Append(assistant)
+		if !accepted {
+			m.history.Append(message.Message{Role: "user", Content: feedback})
 		}
+		return
 	}
-	return out, nil
+	kept := *call
+	assistant.ToolCalls = []message.ToolCall{kept}
+	m.history.Append(assistant)
+	result := "Final answer accepted."
+	if !accepted {
+		result = feedback
+	}
+	m.history.Append(message.Message{
+		Role:      "tool",
+		ToolCalls: []message.ToolCall{{ID: kept.ID, Name: kept.Name, Result: result}},
+	})
 }
 
 type runtimeToolExecutor struct {
 	executor  *tool.Executor
 	hooks     *runtimeHookAdapter
 	history   *message.History
+	results   *toolResultSequencer
 	allow     map[string]struct{}
 	root      string
 	host      string
 	sessionID string
+	run       *activeRun
 
 	permissionResolver tool.PermissionResolver
 }
@@ -1150,11 +1627,42 @@ func (t *runtimeToolExecutor) isAllowed(ctx context.Context, name string) bool {
 	return reqAllowed && subAllowed
 }
 
+// ConcurrencySafe implements agent.ConcurrentToolExecutor by consulting the
+// registered tool's tool.ConcurrencySafeTool declaration.
+func (t *runtimeToolExecutor) ConcurrencySafe(call agent.ToolCall) bool {
+	if t == nil || t.executor == nil {
+		return false
+	}
+	reg := t.executor.Registry()
+	if reg == nil {
+		return false
+	}
+	impl, err := reg.Get(call.Name)
+	if err != nil {
+		return false
+	}
+	return tool.IsConcurrencySafe(impl)
+}
+
+// appendHistory records a tool result message, keeping call order when a
+// sequencer is attached.
+func (t *runtimeToolExecutor) appendHistory(callID string, msg message.Message) {
+	if t.results != nil {
+		t.results.Append(callID, msg)
+		return
+	}
+	if t.history != nil {
+		t.history.Append(msg)
+	}
+}
+
 func (t *runtimeToolExecutor) Execute(ctx context.Context, call agent.ToolCall, _ *agent.Context) (agent.ToolResult, error) {
 	if t.executor == nil {
+		t.results.Skip(call.ID)
 		return agent.ToolResult{}, errors.New("tool executor not initialised")
 	}
 	if !t.isAllowed(ctx, call.Name) {
+		t.results.Skip(call.ID)
 		return agent.ToolResult{}, fmt.Errorf("tool %s is not whitelisted", call.Name)
 	}
 
@@ -1170,16 +1678,14 @@ func (t *runtimeToolExecutor) Execute(ctx context.Context, call agent.ToolCall,
 							"the API proxy likely stripped tool_use.input — check proxy configuration",
 						call.Name, schema.Required)
 					log.Printf("WARNING: %s (id=%s)", errMsg, call.ID)
-					if t.history != nil {
-						t.history.Append(message.Message{
-							Role: "tool",
-							ToolCalls: []message.ToolCall{{
-								ID:     call.ID,
-								Name:   call.Name,
-								Result: errMsg,
-							}},
-						})
-					}
+					t.appendHistory(call.ID, message.Message{
+						Role: "tool",
+						ToolCalls: []message.ToolCall{{
+							ID:     call.ID,
+							Name:   call.Name,
+							Result: errMsg,
+						}},
+					})
 					return agent.ToolResult{
 						Name:     call.Name,
 						Output:   errMsg,
@@ -1192,16 +1698,14 @@ func (t *runtimeToolExecutor) Execute(ctx context.Context, call agent.ToolCall,
 
 	// Helper to append tool result to history
 	appendToolResult := func(content string) {
-		if t.history != nil {
-			t.history.Append(message.Message{
-				Role: "tool",
-				ToolCalls: []message.ToolCall{{
-					ID:     call.ID,
-					Name:   call.Name,
-					Result: content,
-				}},
-			})
-		}
+		t.appendHistory(call.ID, message.Message{
+			Role: "tool",
+			ToolCalls: []message.ToolCall{{
+				ID:     call.ID,
+				Name:   call.Name,
+				Result: content,
+			}},
+		})
 	}
 
 	params, preErr := t.hooks.PreToolUse(ctx, coreToolUsePayload(call))
@@ -1234,6 +1738,9 @@ func (t *runtimeToolExecutor) Execute(ctx context.Context, call agent.ToolCall,
 			}
 		}
 	}
+	if errors.Is(preErr, agent.ErrSuspended) {
+		return agent.ToolResult{}, t.suspend(call, preErr)
+	}
 	if preErr != nil {
 		// Hook denied execution - still need to add tool_result to history
 		errContent := fmt.Sprintf(`{"error":%q}`, preErr.Error())
@@ -1271,7 +1778,12 @@ func (t *runtimeToolExecutor) Execute(ctx context.Context, call agent.ToolCall,
 	if t.permissionResolver != nil {
 		exec = exec.WithPermissionResolver(t.permissionResolver)
 	}
+	t.run.toolStarted(call.ID, call.Name)
 	result, err := exec.Execute(ctx, callSpec)
+	t.run.toolFinished(call.ID)
+	if errors.Is(err, agent.ErrSuspended) {
+		return agent.ToolResult{}, t.suspend(call, err)
+	}
 	toolResult := agent.ToolResult{Name: call.Name}
 	meta := map[string]any{}
 	content := ""
@@ -1301,12 +1813,23 @@ func (t *runtimeToolExecutor) Execute(ctx context.Context, call agent.ToolCall,
 	return toolResult, err
 }
 
+// suspend leaves call without a tool result so the run can stop at it and be
+// resumed later.
+func (t *runtimeToolExecutor) suspend(call agent.ToolCall, err error) error {
+	t.results.Skip(call.ID)
+	var pending *approvalPendingError
+	if errors.As(err, &pending) {
+		pending.call = call
+	}
+	return err
+}
+
 func coreToolUsePayload(call agent.ToolCall) coreevents.ToolUsePayload {
-	return coreevents.ToolUsePayload{Name: call.Name, Params: call.Input}
+	return coreevents.ToolUsePayload{Name: call.Name, Params: call.Input, ToolUseID: call.ID}
 }
 
 func coreToolResultPayload(call agent.ToolCall, res *tool.CallResult, err error) coreevents.ToolResultPayload {
-	payload := coreevents.ToolResultPayload{Name: call.Name}
+	payload := coreevents.ToolResultPayload{Name: call.Name, Params: call.Input, ToolUseID: call.ID}
 	if res != nil && res.Result != nil {
 		payload.Result = res.Result.Output
 		payload.Duration = res.Duration()
@@ -1315,7 +1838,7 @@ func coreToolResultPayload(call agent.ToolCall, res *tool.CallResult, err error)
 	return payload
 }
 
-func buildPermissionResolver(hooks *runtimeHookAdapter, handler PermissionRequestHandler, approvals *security.ApprovalQueue, approver string, whitelistTTL time.Duration, approvalWait bool) tool.PermissionResolver {
+func buildPermissionResolver(hooks *runtimeHookAdapter, handler PermissionRequestHandler, approvals *security.ApprovalQueue, approver string, whitelistTTL time.Duration, approvalWait, approvalSuspend bool) tool.PermissionResolver {
 	if hooks == nil && handler == nil && approvals == nil {
 		return nil
 	}
@@ -1323,6 +1846,9 @@ func buildPermissionResolver(hooks *runtimeHookAdapter, handler PermissionReques
 		if decision.Action != security.PermissionAsk {
 			return decision, nil
 		}
+		if action, ok := resumeApprovalFromContext(ctx).take(formatApprovalCommand(call.Name, decision.Target)); ok {
+			return decisionWithAction(decision, action), nil
+		}
 
 		req := PermissionRequest{
 			ToolName:   call.Name,
@@ -1397,6 +1923,10 @@ func buildPermissionResolver(hooks *runtimeHookAdapter, handler PermissionReques
 			}
 		}
 
+		if approvalSuspend && approvals != nil && record != nil && record.State == security.ApprovalPending {
+			return decision, &approvalPendingError{record: record}
+		}
+
 		if approvalWait && approvals != nil && record != nil {
 			resolved, err := approvals.Wait(ctx, record.ID)
 			if err != nil {
@@ -1809,6 +2339,42 @@ func resolveModel(ctx context.Context, opts Options) (model.Model, error) {
 	return nil, ErrMissingModel
 }
 
+// apiKeyHelperTTLEnv overrides how long a key from apiKeyHelper is reused.
+const apiKeyHelperTTLEnv = "CLAUDE_CODE_API_KEY_HELPER_TTL_MS"
+
+// applyCredentialSettings hands the settings.json credential helpers to a
+// model factory that does not set its own: the AWS scripts to a Bedrock
+// provider and apiKeyHelper to the API key based providers.
+func applyCredentialSettings(opts Options, settings *config.Settings) {
+	if settings == nil {
+		return
+	}
+	if provider, ok := opts.ModelFactory.(*model.BedrockProvider); ok {
+		provider.UseCredentialScripts(settings.AWSCredentialExport, settings.AWSAuthRefresh)
+	}
+	command := strings.TrimSpace(settings.APIKeyHelper)
+	if command == "" {
+		return
+	}
+	if provider, ok := opts.ModelFactory.(interface{ UseAPIKeyHelper(*model.APIKeyHelper) }); ok {
+		provider.UseAPIKeyHelper(&model.APIKeyHelper{Command: command, TTL: apiKeyHelperTTL(settings.Env)})
+	}
+}
+
+// apiKeyHelperTTL reads apiKeyHelperTTLEnv from the settings env, then the
+// process environment; zero keeps the helper default.
+func apiKeyHelperTTL(env map[string]string) time.Duration {
+	raw, ok := env[apiKeyHelperTTLEnv]
+	if !ok {
+		raw = os.Getenv(apiKeyHelperTTLEnv)
+	}
+	ms, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
 func defaultSessionID(entry EntryPoint) string {
 	prefix := strings.TrimSpace(string(entry))
 	if prefix == "" {
@@ -3,6 +3,8 @@ package api
 import (
 	"context"
 	"errors"
+	"slices"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -552,6 +554,65 @@ func TestNewTrimmerHelper(t *testing.T) {
 	}
 }
 
+func TestTrimmedContextNotesOmittedMessages(t *testing.T) {
+	mdl := &stubModel{responses: []*model.Response{{Message: model.Message{Role: "assistant", Content: "ok"}}}}
+	rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdl, TokenLimit: 60})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	long := strings.Repeat("x", 200)
+	hist := rt.histories.Get("s")
+	hist.Append(message.Message{Role: "user", Content: "original task"})
+	hist.Append(message.Message{Role: "assistant", ToolCalls: []message.ToolCall{{ID: "t1", Name: "read", Arguments: map[string]any{"path": long}}}})
+	hist.Append(message.Message{Role: "tool", Content: long, ToolCalls: []message.ToolCall{{ID: "t1", Name: "read"}}})
+	hist.Append(message.Message{Role: "assistant", Content: long + long})
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "continue", SessionID: "s"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	msgs := mdl.requests[0].Messages
+	if len(msgs) != 3 {
+		t.Fatalf("expected first prompt, omission note and new prompt, got %+v", msgs)
+	}
+	if msgs[0].Content != "original task" || msgs[1].Role != "user" || msgs[1].Content != "[3 earlier messages omitted]" || msgs[2].Content != "continue" {
+		t.Fatalf("unexpected trimmed context %+v", msgs)
+	}
+	if strings.Contains(mdl.requests[0].System, "omitted") {
+		t.Fatalf("the omission note must not reach the system prompt")
+	}
+}
+
+func TestTrimmedContextNotesEachGapAroundPinnedMessages(t *testing.T) {
+	mdl := &stubModel{responses: []*model.Response{{Message: model.Message{Role: "assistant", Content: "ok"}}}}
+	rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdl, TokenLimit: 60})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	long := strings.Repeat("x", 400)
+	hist := rt.histories.Get("s")
+	hist.Append(message.Message{Role: "user", Content: "original task"})
+	hist.Append(message.Message{Role: "assistant", Content: long})
+	hist.Append(message.Message{Role: "system", Content: "summary"})
+	hist.Append(message.Message{Role: "assistant", Content: long})
+	hist.Append(message.Message{Role: "assistant", Content: long})
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "continue", SessionID: "s"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var got []string
+	for _, msg := range mdl.requests[0].Messages {
+		got = append(got, msg.Content)
+	}
+	want := []string{"original task", "[1 earlier messages omitted]", "summary", "[2 earlier messages omitted]", "continue"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected one note per gap, got %q", got)
+	}
+}
+
 func TestResolveModelPrefersFactory(t *testing.T) {
 	mdl := &stubModel{}
 	called := false
@@ -86,3 +86,103 @@ func (s *streamingStubTool) StreamExecute(ctx context.Context, params map[string
 	}
 	return &tool.ToolResult{Success: true, Output: "chunk-1\nchunk-err", Data: params}, nil
 }
+
+// deltaStreamModel replays scripted fragments before each final response so
+// RunStream can be checked for live forwarding.
+type deltaStreamModel struct {
+	turns [][]model.StreamResult
+	idx   int
+}
+
+func (m *deltaStreamModel) Complete(context.Context, model.Request) (*model.Response, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (m *deltaStreamModel) CompleteStream(_ context.Context, _ model.Request, cb model.StreamHandler) error {
+	turn := m.turns[m.idx]
+	m.idx++
+	for _, sr := range turn {
+		if err := cb(sr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRunStreamForwardsLiveModelDeltas(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &deltaStreamModel{turns: [][]model.StreamResult{
+		{
+			{Thinking: "plan", Index: 0},
+			{Delta: "Hel", Index: 1},
+			{Delta: "lo", Index: 1},
+			{ToolInputDelta: `{"text":`, ToolCallID: "tool_1", ToolCallName: "echo", Index: 2},
+			{ToolInputDelta: `"hi"}`, ToolCallID: "tool_1", ToolCallName: "echo", Index: 2},
+			{Final: true, Response: &model.Response{Message: model.Message{
+				Role:      "assistant",
+				Content:   "Hello",
+				ToolCalls: []model.ToolCall{{ID: "tool_1", Name: "echo", Arguments: map[string]any{"text": "hi"}}},
+			}}},
+		},
+		{
+			{Final: true, Response: &model.Response{Message: model.Message{Role: "assistant", Content: "ok"}}},
+		},
+	}}
+
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: mdl, Tools: []tool.Tool{&echoTool{}}})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	stream, err := rt.RunStream(context.Background(), Request{Prompt: "go"})
+	if err != nil {
+		t.Fatalf("RunStream: %v", err)
+	}
+
+	var got []string
+	for evt := range stream {
+		switch evt.Type {
+		case EventContentBlockStart:
+			got = append(got, fmt.Sprintf("start:%d:%s", *evt.Index, evt.ContentBlock.Type))
+		case EventContentBlockDelta:
+			frag := evt.Delta.Text + evt.Delta.Thinking + string(evt.Delta.PartialJSON)
+			got = append(got, fmt.Sprintf("delta:%d:%s:%s", *evt.Index, evt.Delta.Type, frag))
+		case EventContentBlockStop:
+			got = append(got, fmt.Sprintf("stop:%d", *evt.Index))
+		case EventMessageStop:
+			got = append(got, "message_stop")
+		case EventError:
+			t.Fatalf("unexpected error event: %+v", evt)
+		}
+	}
+
+	want := []string{
+		"start:0:thinking",
+		"delta:0:thinking_delta:plan",
+		"stop:0",
+		"start:1:text",
+		"delta:1:text_delta:Hel",
+		"delta:1:text_delta:lo",
+		"stop:1",
+		"start:2:tool_use",
+		`delta:2:input_json_delta:"{\"text\":"`,
+		`delta:2:input_json_delta:"\"hi\"}"`,
+		"stop:2",
+		"message_stop",
+		// The second turn streams no fragments, so the text is synthesised.
+		"start:0:text",
+		"delta:0:text_delta:o",
+		"delta:0:text_delta:k",
+		"stop:0",
+		"message_stop",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("event count mismatch:\n got %q\nwant %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("event %d mismatch: got %q want %q\nall: %q", i, got[i], want[i], got)
+		}
+	}
+}
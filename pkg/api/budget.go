@@ -0,0 +1,281 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+// Budget scopes reported by BudgetExceededError and budget warnings.
+const (
+	BudgetScopeRequest = "request"
+	BudgetScopeSession = "session"
+	BudgetScopeRuntime = "runtime"
+)
+
+// Budget limits reported by BudgetExceededError and budget warnings.
+const (
+	BudgetLimitInputTokens  = "input_tokens"
+	BudgetLimitOutputTokens = "output_tokens"
+	BudgetLimitCostUSD      = "cost_usd"
+)
+
+// StopReasonBudgetExceeded is reported on the partial Result carried by a
+// BudgetExceededError.
+const StopReasonBudgetExceeded = "budget_exceeded"
+
+// NotificationTypeBudgetWarning tags the Notification event published when a
+// budget crosses its soft limit.
+const NotificationTypeBudgetWarning = "budget_warning"
+
+const defaultBudgetSoftLimit = 0.8
+
+// ErrBudgetExceeded is matched (via errors.Is) by every BudgetExceededError.
+var ErrBudgetExceeded = errors.New("api: budget exceeded")
+
+// Budget caps token usage and estimated spend. Zero fields are unlimited.
+type Budget struct {
+	MaxInputTokens  int64 `json:"max_input_tokens,omitempty"`
+	MaxOutputTokens int64 `json:"max_output_tokens,omitempty"`
+	// MaxCostUSD caps the spend estimated by Options.CostFunc.
+	MaxCostUSD float64 `json:"max_cost_usd,omitempty"`
+	// SoftLimit is the fraction of a cap at which a budget_warning
+	// Notification is published, once per scope and limit. Zero uses 0.8;
+	// values outside (0,1) disable warnings.
+	SoftLimit float64 `json:"soft_limit,omitempty"`
+}
+
+func (b Budget) isZero() bool {
+	return b.MaxInputTokens <= 0 && b.MaxOutputTokens <= 0 && b.MaxCostUSD <= 0
+}
+
+func (b Budget) softLimit() float64 {
+	if b.SoftLimit == 0 {
+		return defaultBudgetSoftLimit
+	}
+	return b.SoftLimit
+}
+
+// CostFunc estimates the USD cost of a single model call. modelName is the
+// model reported by the provider and may be empty.
+type CostFunc func(modelName string, usage model.Usage) float64
+
+// BudgetExceededError reports the cap that stopped a run. Partial holds the
+// response accumulated up to that point.
+type BudgetExceededError struct {
+	Scope   string
+	Limit   string
+	Max     float64
+	Used    float64
+	Partial *Response
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("api: %s %s budget exceeded (%s of %s)", e.Scope, e.Limit, formatBudgetAmount(e.Limit, e.Used), formatBudgetAmount(e.Limit, e.Max))
+}
+
+func (e *BudgetExceededError) Unwrap() error { return ErrBudgetExceeded }
+
+// budgetWarning describes a soft limit crossing.
+type budgetWarning struct {
+	Scope string
+	Limit string
+	Max   float64
+	Used  float64
+}
+
+func (w budgetWarning) message() string {
+	return fmt.Sprintf("%s %s budget at %.0f%% (%s of %s)", w.Scope, w.Limit, 100*w.Used/w.Max, formatBudgetAmount(w.Limit, w.Used), formatBudgetAmount(w.Limit, w.Max))
+}
+
+func formatBudgetAmount(limit string, v float64) string {
+	if limit == BudgetLimitCostUSD {
+		return fmt.Sprintf("$%.4f", v)
+	}
+	return fmt.Sprintf("%.0f", v)
+}
+
+type budgetUsage struct {
+	input  int64
+	output int64
+	cost   float64
+}
+
+func (u *budgetUsage) add(other budgetUsage) {
+	u.input += other.input
+	u.output += other.output
+	u.cost += other.cost
+}
+
+// budgetLedger accumulates usage per session and across the runtime so caps
+// hold across requests.
+type budgetLedger struct {
+	mu       sync.Mutex
+	total    budgetUsage
+	sessions map[string]*budgetUsage
+	warned   map[string]bool
+}
+
+func newBudgetLedger() *budgetLedger {
+	return &budgetLedger{sessions: map[string]*budgetUsage{}, warned: map[string]bool{}}
+}
+
+// seed restores the session usage recorded before sessionID was evicted,
+// leaving usage the ledger already tracks untouched.
+func (l *budgetLedger) seed(sessionID string, usage budgetUsage) {
+	if l == nil || usage == (budgetUsage{}) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.sessions[sessionID]; ok {
+		return
+	}
+	l.sessions[sessionID] = &usage
+}
+
+// forget drops the usage and warning markers of sessionID.
+func (l *budgetLedger) forget(sessionID string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.sessions, sessionID)
+	for _, limit := range []string{BudgetLimitInputTokens, BudgetLimitOutputTokens, BudgetLimitCostUSD} {
+		delete(l.warned, "session/"+sessionID+"/"+limit)
+	}
+}
+
+// runBudget enforces the request, session and runtime budgets of one run.
+type runBudget struct {
+	ledger    *budgetLedger
+	sessionID string
+	request   Budget
+	session   Budget
+	runtime   Budget
+	used      budgetUsage
+}
+
+func (rt *Runtime) newRunBudget(req Request) *runBudget {
+	request := rt.opts.Budget
+	if req.Budget != nil {
+		request = *req.Budget
+	}
+	if request.isZero() && rt.opts.SessionBudget.isZero() && rt.opts.RuntimeBudget.isZero() {
+		return nil
+	}
+	if info, ok := rt.sessions.info(req.SessionID); ok {
+		rt.budgets.seed(req.SessionID, budgetUsage{input: info.InputTokens, output: info.OutputTokens, cost: info.CostUSD})
+	}
+	return &runBudget{
+		ledger:    rt.budgets,
+		sessionID: req.SessionID,
+		request:   request,
+		session:   rt.opts.SessionBudget,
+		runtime:   rt.opts.RuntimeBudget,
+	}
+}
+
+// check reports an exhausted budget before another model call is made.
+func (b *runBudget) check() *BudgetExceededError {
+	if b == nil {
+		return nil
+	}
+	b.ledger.mu.Lock()
+	defer b.ledger.mu.Unlock()
+	err, _ := b.evaluateLocked(false)
+	return err
+}
+
+// charge accounts one model call and its estimated cost in every scope. It
+// returns the soft limit crossings to announce and an error once a cap is
+// reached.
+func (b *runBudget) charge(usage model.Usage, cost float64) ([]budgetWarning, *BudgetExceededError) {
+	if b == nil {
+		return nil, nil
+	}
+	delta := budgetUsage{input: int64(usage.InputTokens), output: int64(usage.OutputTokens), cost: cost}
+
+	b.ledger.mu.Lock()
+	defer b.ledger.mu.Unlock()
+	b.used.add(delta)
+	session := b.ledger.sessions[b.sessionID]
+	if session == nil {
+		session = &budgetUsage{}
+		b.ledger.sessions[b.sessionID] = session
+	}
+	session.add(delta)
+	b.ledger.total.add(delta)
+
+	err, warnings := b.evaluateLocked(true)
+	return warnings, err
+}
+
+func (b *runBudget) evaluateLocked(warn bool) (*BudgetExceededError, []budgetWarning) {
+	var session budgetUsage
+	if s := b.ledger.sessions[b.sessionID]; s != nil {
+		session = *s
+	}
+	scopes := []struct {
+		name   string
+		key    string
+		budget Budget
+		used   budgetUsage
+	}{
+		{BudgetScopeRequest, "", b.request, b.used},
+		{BudgetScopeSession, "session/" + b.sessionID, b.session, session},
+		{BudgetScopeRuntime, "runtime", b.runtime, b.ledger.total},
+	}
+
+	var warnings []budgetWarning
+	for _, scope := range scopes {
+		limits := []struct {
+			name string
+			max  float64
+			used float64
+		}{
+			{BudgetLimitInputTokens, float64(scope.budget.MaxInputTokens), float64(scope.used.input)},
+			{BudgetLimitOutputTokens, float64(scope.budget.MaxOutputTokens), float64(scope.used.output)},
+			{BudgetLimitCostUSD, scope.budget.MaxCostUSD, scope.used.cost},
+		}
+		for _, limit := range limits {
+			if limit.max <= 0 {
+				continue
+			}
+			if limit.used >= limit.max {
+				return &BudgetExceededError{Scope: scope.name, Limit: limit.name, Max: limit.max, Used: limit.used}, warnings
+			}
+			soft := scope.budget.softLimit()
+			if !warn || soft <= 0 || soft >= 1 || limit.used < soft*limit.max {
+				continue
+			}
+			// Request scope warnings are tracked per run, the others for the
+			// lifetime of the runtime.
+			warnedKey := scope.key + "/" + limit.name
+			if scope.key == "" {
+				warnedKey = fmt.Sprintf("request/%p/%s", b, limit.name)
+			}
+			if b.ledger.warned[warnedKey] {
+				continue
+			}
+			b.ledger.warned[warnedKey] = true
+			warnings = append(warnings, budgetWarning{Scope: scope.name, Limit: limit.name, Max: limit.max, Used: limit.used})
+		}
+	}
+	return nil, warnings
+}
+
+// release forgets the per-run warning markers once the run is over.
+func (b *runBudget) release() {
+	if b == nil {
+		return
+	}
+	b.ledger.mu.Lock()
+	defer b.ledger.mu.Unlock()
+	for _, limit := range []string{BudgetLimitInputTokens, BudgetLimitOutputTokens, BudgetLimitCostUSD} {
+		delete(b.ledger.warned, fmt.Sprintf("request/%p/%s", b, limit))
+	}
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	coreevents "github.com/cexll/agentsdk-go/pkg/core/events"
+	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/tool"
+)
+
+func usageResponse(content string, in, out int, calls ...model.ToolCall) *model.Response {
+	return &model.Response{
+		Message: model.Message{Role: "assistant", Content: content, ToolCalls: calls},
+		Usage:   model.Usage{InputTokens: in, OutputTokens: out, TotalTokens: in + out},
+		Model:   "test-model",
+	}
+}
+
+func TestRunStopsAtRequestTokenBudget(t *testing.T) {
+	root := newClaudeProject(t)
+	echo := &echoTool{}
+	mdl := &stubModel{responses: []*model.Response{
+		usageResponse("checking", 60, 10, model.ToolCall{ID: "c1", Name: "echo", Arguments: map[string]any{"text": "a"}}),
+		usageResponse("still going", 60, 10, model.ToolCall{ID: "c2", Name: "echo", Arguments: map[string]any{"text": "b"}}),
+		usageResponse("never", 60, 10),
+	}}
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: mdl, Tools: []tool.Tool{echo}})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	_, err = rt.Run(context.Background(), Request{Prompt: "go", SessionID: "s", Budget: &Budget{MaxInputTokens: 100}})
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got %v", err)
+	}
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("expected *BudgetExceededError, got %T", err)
+	}
+	if exceeded.Scope != BudgetScopeRequest || exceeded.Limit != BudgetLimitInputTokens || exceeded.Used != 120 || exceeded.Max != 100 {
+		t.Fatalf("unexpected budget error %+v", exceeded)
+	}
+	if len(mdl.requests) != 2 {
+		t.Fatalf("expected the run to stop after two model calls, got %d", len(mdl.requests))
+	}
+	if echo.calls != 1 {
+		t.Fatalf("tool calls of the exceeding turn must not run, got %d calls", echo.calls)
+	}
+	partial := exceeded.Partial
+	if partial == nil || partial.Result == nil {
+		t.Fatalf("expected partial response, got %+v", partial)
+	}
+	if partial.Result.Output != "still going" || partial.Result.StopReason != StopReasonBudgetExceeded {
+		t.Fatalf("unexpected partial result %+v", partial.Result)
+	}
+	last := rt.histories.Get("s").All()
+	if tail := last[len(last)-1]; tail.Content != "still going" || len(tail.ToolCalls) != 0 {
+		t.Fatalf("expected dangling tool calls dropped from history, got %+v", tail)
+	}
+}
+
+func TestSessionBudgetSpansRuns(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &stubModel{responses: []*model.Response{
+		usageResponse("one", 10, 30),
+		usageResponse("two", 10, 30),
+	}}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:   root,
+		Model:         mdl,
+		SessionBudget: Budget{MaxOutputTokens: 50},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	ctx := context.Background()
+	resp, err := rt.Run(ctx, Request{Prompt: "first", SessionID: "s"})
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if hasBudgetWarning(resp.HookEvents, BudgetScopeSession, BudgetLimitOutputTokens) {
+		t.Fatalf("expected no warning below the soft limit")
+	}
+	_, err = rt.Run(ctx, Request{Prompt: "second", SessionID: "s"})
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) || exceeded.Scope != BudgetScopeSession {
+		t.Fatalf("expected session budget error, got %v", err)
+	}
+
+	// The exhausted session refuses further model calls; other sessions are unaffected.
+	if _, err := rt.Run(ctx, Request{Prompt: "third", SessionID: "s"}); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected exhausted session to be rejected, got %v", err)
+	}
+	if len(mdl.requests) != 2 {
+		t.Fatalf("expected no model call for an exhausted session, got %d calls", len(mdl.requests))
+	}
+	mdl.responses = append(mdl.responses, usageResponse("fresh", 1, 1))
+	if _, err := rt.Run(ctx, Request{Prompt: "hello", SessionID: "other"}); err != nil {
+		t.Fatalf("other session: %v", err)
+	}
+
+	// A deleted session does not hand its spend to a new one with the same ID.
+	if err := rt.DeleteSession(ctx, "s"); err != nil {
+		t.Fatalf("delete session: %v", err)
+	}
+	mdl.responses = append(mdl.responses, usageResponse("reborn", 1, 1))
+	if _, err := rt.Run(ctx, Request{Prompt: "again", SessionID: "s"}); err != nil {
+		t.Fatalf("expected a fresh budget after delete, got %v", err)
+	}
+}
+
+func TestSessionBudgetLedgerPrunedOnEviction(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &stubModel{responses: []*model.Response{
+		usageResponse("one", 10, 45),
+		usageResponse("two", 1, 1),
+		usageResponse("three", 1, 1),
+	}}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:   root,
+		Model:         mdl,
+		MaxSessions:   1,
+		SessionBudget: Budget{MaxOutputTokens: 50},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := rt.Run(ctx, Request{Prompt: "hi", SessionID: id}); err != nil {
+			t.Fatalf("run %s: %v", id, err)
+		}
+	}
+	rt.budgets.mu.Lock()
+	defer rt.budgets.mu.Unlock()
+	if len(rt.budgets.sessions) != 1 || rt.budgets.sessions["c"] == nil {
+		t.Fatalf("expected only the resident session in the ledger, got %v", rt.budgets.sessions)
+	}
+	for key := range rt.budgets.warned {
+		if strings.HasPrefix(key, "session/a/") {
+			t.Fatalf("expected evicted session warnings pruned, got %v", rt.budgets.warned)
+		}
+	}
+}
+
+func TestSessionBudgetSurvivesEviction(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &stubModel{responses: []*model.Response{
+		usageResponse("one", 10, 45),
+		usageResponse("two", 1, 1),
+		usageResponse("three", 1, 10),
+	}}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:   root,
+		Model:         mdl,
+		MaxSessions:   1,
+		SessionBudget: Budget{MaxOutputTokens: 50},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	ctx := context.Background()
+	for _, id := range []string{"a", "b"} {
+		if _, err := rt.Run(ctx, Request{Prompt: "hi", SessionID: id}); err != nil {
+			t.Fatalf("run %s: %v", id, err)
+		}
+	}
+	_, err = rt.Run(ctx, Request{Prompt: "again", SessionID: "a"})
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected reloaded session to keep its budget usage, got %v", err)
+	}
+	if budgetErr.Scope != BudgetScopeSession || budgetErr.Used != 55 {
+		t.Fatalf("unexpected budget error: %+v", budgetErr)
+	}
+}
+
+func TestCostBudgetWarnsAtSoftLimit(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &stubModel{responses: []*model.Response{
+		usageResponse("one", 1000, 0),
+		usageResponse("two", 1000, 0),
+	}}
+	var models []string
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:   root,
+		Model:         mdl,
+		RuntimeBudget: Budget{MaxCostUSD: 2.5},
+		CostFunc: func(name string, usage model.Usage) float64 {
+			models = append(models, name)
+			return float64(usage.InputTokens) / 1000
+		},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	ctx := context.Background()
+	resp, err := rt.Run(ctx, Request{Prompt: "a", SessionID: "s1"})
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if hasBudgetWarning(resp.HookEvents, BudgetScopeRuntime, BudgetLimitCostUSD) {
+		t.Fatalf("unexpected warning at 40%% of the budget")
+	}
+	resp, err = rt.Run(ctx, Request{Prompt: "b", SessionID: "s2"})
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if !hasBudgetWarning(resp.HookEvents, BudgetScopeRuntime, BudgetLimitCostUSD) {
+		t.Fatalf("expected budget warning at 80%%, got %+v", resp.HookEvents)
+	}
+	if len(models) != 2 || models[0] != "test-model" {
+		t.Fatalf("expected cost func to see the served model, got %v", models)
+	}
+}
+
+func hasBudgetWarning(events []coreevents.Event, scope, limit string) bool {
+	for _, evt := range events {
+		payload, ok := evt.Payload.(coreevents.NotificationPayload)
+		if !ok || evt.Type != coreevents.Notification || payload.NotificationType != NotificationTypeBudgetWarning {
+			continue
+		}
+		if payload.Meta["scope"] == scope && payload.Meta["limit"] == limit {
+			return true
+		}
+	}
+	return false
+}
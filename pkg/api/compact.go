@@ -83,6 +83,7 @@ type compactor struct {
 	limit   int
 	hooks   *corehooks.Executor
 	rollout *RolloutWriter
+	counter message.TokenCounter
 	mu      sync.Mutex
 }
 
@@ -256,7 +257,10 @@ func (c *compactor) compact(ctx context.Context, hist *message.History, snapshot
 
 	var userText []message.Message
 	if c.cfg.PreserveUserText && c.cfg.UserTextTokens > 0 {
-		var counter message.NaiveCounter
+		counter := c.counter
+		if counter == nil {
+			counter = message.NaiveCounter{}
+		}
 		total := 0
 		indices := make([]int, 0)
 		for i := len(older) - 1; i >= 0; i-- {
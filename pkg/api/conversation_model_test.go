@@ -120,3 +120,75 @@ func TestConversationModelEnableCachePassthrough(t *testing.T) {
 		})
 	}
 }
+
+func TestRuntimeThinkingConfigAndSignedReasoning(t *testing.T) {
+	signed := &model.Response{Message: model.Message{
+		Role:             "assistant",
+		Content:          "first",
+		ReasoningContent: "plan",
+		ThinkingBlocks:   []model.ThinkingBlock{{Text: "plan", Signature: "sig"}, {Redacted: "opaque"}},
+	}}
+	stub := &stubModel{responses: []*model.Response{signed, {Message: model.Message{Role: "assistant", Content: "second"}}}}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot: newClaudeProject(t),
+		Model:       stub,
+		Thinking:    &model.ThinkingConfig{Enabled: true, BudgetTokens: 2048},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "one", SessionID: "think"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := stub.requests[0].Thinking; got == nil || !got.Enabled || got.BudgetTokens != 2048 {
+		t.Fatalf("expected runtime thinking default, got %+v", got)
+	}
+
+	override := &model.ThinkingConfig{Effort: model.ReasoningEffortLow}
+	if _, err := rt.Run(context.Background(), Request{Prompt: "two", SessionID: "think", Thinking: override}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	req := stub.requests[1]
+	if req.Thinking != override {
+		t.Fatalf("expected request thinking override, got %+v", req.Thinking)
+	}
+	replayed := req.Messages[1]
+	if replayed.Role != "assistant" || len(replayed.ThinkingBlocks) != 2 || replayed.ThinkingBlocks[0].Signature != "sig" || replayed.ThinkingBlocks[1].Redacted != "opaque" {
+		t.Fatalf("expected signed reasoning replayed, got %+v", replayed)
+	}
+}
+
+func TestRuntimeCachePolicyReachesModelRequest(t *testing.T) {
+	stub := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "first"}},
+		{Message: model.Message{Role: "assistant", Content: "second"}},
+	}}
+	policy := &model.CachePolicy{AfterSystem: true, AfterMessages: []int{-1}, TTL: model.CacheTTL1h}
+	rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: stub, CachePolicy: policy})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "one", SessionID: "cache"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got := stub.requests[0].Cache
+	if got == nil || !got.AfterSystem || got.TTL != model.CacheTTL1h || len(got.AfterMessages) != 1 || got.AfterMessages[0] != -1 {
+		t.Fatalf("expected runtime cache policy, got %+v", got)
+	}
+	policy.AfterMessages[0] = -2
+	if stub.requests[0].Cache.AfterMessages[0] != -1 {
+		t.Fatalf("runtime must keep its own copy of the cache policy")
+	}
+
+	override := &model.CachePolicy{AfterTools: true}
+	if _, err := rt.Run(context.Background(), Request{Prompt: "two", SessionID: "cache", CachePolicy: override}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if stub.requests[1].Cache != override {
+		t.Fatalf("expected request cache policy override, got %+v", stub.requests[1].Cache)
+	}
+}
@@ -1,9 +1,12 @@
 package api
 
 import (
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"os"
 	"path/filepath"
@@ -18,9 +21,14 @@ type diskHistoryPersister struct {
 }
 
 type persistedHistory struct {
-	Version   int               `json:"version"`
+	Version int `json:"version"`
+	// Revision is the HistoryStore version of the file; 0 for files written
+	// before revisions existed.
+	Revision  int64             `json:"revision,omitempty"`
 	SessionID string            `json:"session_id,omitempty"`
+	CreatedAt time.Time         `json:"created_at,omitempty"`
 	UpdatedAt time.Time         `json:"updated_at,omitempty"`
+	Metadata  *SessionMetadata  `json:"metadata,omitempty"`
 	Messages  []message.Message `json:"messages,omitempty"`
 }
 
@@ -91,17 +99,28 @@ func (p *diskHistoryPersister) Load(sessionID string) ([]message.Message, error)
 		}
 		return nil, fmt.Errorf("read history: %w", err)
 	}
+	decoded, err := decodePersistedHistory(data)
+	if err != nil {
+		return nil, err
+	}
+	return decoded.Messages, nil
+}
+
+// decodePersistedHistory accepts the versioned wrapper and the legacy bare
+// message array.
+func decodePersistedHistory(data []byte) (persistedHistory, error) {
 	var wrapper persistedHistory
 	if err := json.Unmarshal(data, &wrapper); err == nil {
 		if wrapper.Version != 0 || wrapper.SessionID != "" || !wrapper.UpdatedAt.IsZero() || wrapper.Messages != nil {
-			return message.CloneMessages(wrapper.Messages), nil
+			wrapper.Messages = message.CloneMessages(wrapper.Messages)
+			return wrapper, nil
 		}
 	}
 	var msgs []message.Message
 	if err := json.Unmarshal(data, &msgs); err != nil {
-		return nil, fmt.Errorf("decode history: %w", err)
+		return persistedHistory{}, fmt.Errorf("decode history: %w", err)
 	}
-	return message.CloneMessages(msgs), nil
+	return persistedHistory{Messages: message.CloneMessages(msgs)}, nil
 }
 
 func (p *diskHistoryPersister) Save(sessionID string, msgs []message.Message) error {
@@ -109,21 +128,25 @@ func (p *diskHistoryPersister) Save(sessionID string, msgs []message.Message) er
 	if path == "" {
 		return nil
 	}
-	if err := os.MkdirAll(p.dir, 0o700); err != nil {
-		return fmt.Errorf("mkdir history dir: %w", err)
-	}
-	payload := persistedHistory{
+	return writePersistedHistory(p.dir, path, persistedHistory{
 		Version:   1,
 		SessionID: sessionID,
 		UpdatedAt: time.Now().UTC(),
 		Messages:  message.CloneMessages(msgs),
+	})
+}
+
+// writePersistedHistory atomically replaces path with payload.
+func writePersistedHistory(dir, path string, payload persistedHistory) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("mkdir history dir: %w", err)
 	}
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("encode history: %w", err)
 	}
 
-	tmp, err := os.CreateTemp(p.dir, sanitizePathComponent(sessionID)+".*.tmp")
+	tmp, err := os.CreateTemp(dir, sanitizePathComponent(payload.SessionID)+".*.tmp")
 	if err != nil {
 		return fmt.Errorf("create temp history: %w", err)
 	}
@@ -218,7 +241,7 @@ func (p *diskHistoryPersister) filePath(sessionID string) string {
 }
 
 func (rt *Runtime) persistHistory(sessionID string, history *message.History) {
-	if rt == nil || rt.historyPersister == nil || history == nil {
+	if rt == nil || history == nil {
 		return
 	}
 	sessionID = strings.TrimSpace(sessionID)
@@ -229,7 +252,223 @@ func (rt *Runtime) persistHistory(sessionID string, history *message.History) {
 	if len(snapshot) == 0 {
 		return
 	}
-	if err := rt.historyPersister.Save(sessionID, snapshot); err != nil {
+	if rt.historyPersister == nil && rt.transcripts == nil {
+		rt.recordSession(sessionID, snapshot)
+		return
+	}
+	saved, err := rt.saveHistory(sessionID, snapshot)
+	if err != nil {
 		log.Printf("api: persist history %q: %v", sessionID, err)
+	} else if historyDigest(saved) != historyDigest(snapshot) {
+		// Another runtime wrote the session meanwhile; this run's messages
+		// were appended on top of its version.
+		history.Replace(saved)
+		snapshot = saved
+	}
+	meta := rt.recordSession(sessionID, snapshot)
+	if rt.historyPersister != nil {
+		if err := rt.historyPersister.SetMetadata(context.Background(), sessionID, meta); err != nil {
+			log.Printf("api: persist session metadata %q: %v", sessionID, err)
+		}
+	}
+}
+
+// saveHistory writes msgs to the history store and the session transcript,
+// whichever are enabled, and returns the history as saved: msgs, or msgs'
+// new messages appended to a version another runtime stored meanwhile.
+func (rt *Runtime) saveHistory(sessionID string, msgs []message.Message) ([]message.Message, error) {
+	var errs []error
+	if rt.historyPersister != nil {
+		stored, err := rt.storeHistory(context.Background(), sessionID, msgs)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			msgs = stored
+		}
+	}
+	if rt.transcripts != nil {
+		if _, err := rt.transcripts.Sync(sessionID, msgs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return msgs, errors.Join(errs...)
+}
+
+// storedHistory is what the runtime last read from or wrote to the history
+// store for a session.
+type storedHistory struct {
+	version int64
+	count   int
+	digest  uint64
+}
+
+func newStoredHistory(version int64, msgs []message.Message) storedHistory {
+	return storedHistory{version: version, count: len(msgs), digest: historyDigest(msgs)}
+}
+
+// storeHistory writes msgs at the version last seen for sessionID: only the
+// new messages when msgs extends the stored history, the whole history
+// otherwise. When another runtime wrote the session meanwhile, the new
+// messages (all of msgs for a session that was not stored yet) are appended
+// to its version and the merged history is returned; a whole-history write
+// (after compaction or rewind) fails with ErrHistoryConflict instead and the
+// next run reloads the stored version.
+func (rt *Runtime) storeHistory(ctx context.Context, sessionID string, msgs []message.Message) ([]message.Message, error) {
+	prev, known := rt.storedHistory(sessionID)
+	if !known {
+		rec, found, err := rt.historyPersister.Load(ctx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			prev = newStoredHistory(rec.Version, rec.Messages)
+		}
+	}
+
+	var (
+		version int64
+		err     error
+	)
+	extends := prev.version > 0 && prev.digest != 0 && len(msgs) >= prev.count && historyDigest(msgs[:prev.count]) == prev.digest
+	switch {
+	case extends && len(msgs) == prev.count:
+		return msgs, nil
+	case extends:
+		version, err = rt.historyPersister.Append(ctx, sessionID, prev.version, msgs[prev.count:])
+		if errors.Is(err, ErrHistoryConflict) {
+			msgs, version, err = rt.rebaseHistory(ctx, sessionID, msgs[prev.count:])
+		}
+	default:
+		// A history this runtime never read from the store is written as a
+		// new session. If another runtime created it meanwhile, every message
+		// of this first write is new and goes on top of its version.
+		base := prev.version
+		if !known {
+			base = 0
+		}
+		version, err = rt.historyPersister.Replace(ctx, sessionID, base, msgs)
+		if base == 0 && errors.Is(err, ErrHistoryConflict) {
+			msgs, version, err = rt.rebaseHistory(ctx, sessionID, msgs)
+		}
+	}
+	if err != nil {
+		rt.forgetStoredHistory(sessionID)
+		return nil, err
+	}
+	rt.setStoredHistory(sessionID, newStoredHistory(version, msgs))
+	return msgs, nil
+}
+
+// historyRebaseAttempts bounds how often storeHistory reloads a session that
+// other writers keep moving on.
+const historyRebaseAttempts = 3
+
+// rebaseHistory appends added to the latest stored history of sessionID.
+func (rt *Runtime) rebaseHistory(ctx context.Context, sessionID string, added []message.Message) ([]message.Message, int64, error) {
+	for range historyRebaseAttempts {
+		rec, _, err := rt.historyPersister.Load(ctx, sessionID)
+		if err != nil {
+			return nil, 0, err
+		}
+		version, err := rt.historyPersister.Append(ctx, sessionID, rec.Version, added)
+		if errors.Is(err, ErrHistoryConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		return append(message.CloneMessages(rec.Messages), added...), version, nil
+	}
+	return nil, 0, ErrHistoryConflict
+}
+
+// loadHistory restores a session from the history store, falling back to its
+// transcript.
+func (rt *Runtime) loadHistory(sessionID string) ([]message.Message, error) {
+	if rt.historyPersister != nil {
+		rec, found, err := rt.historyPersister.Load(context.Background(), sessionID)
+		if err != nil {
+			log.Printf("api: load history %q: %v", sessionID, err)
+		} else if found {
+			rt.setStoredHistory(sessionID, newStoredHistory(rec.Version, rec.Messages))
+			rt.sessions.adopt(sessionID, rec)
+			if len(rec.Messages) > 0 {
+				return rec.Messages, nil
+			}
+		}
+	}
+	if rt.transcripts != nil {
+		return rt.transcripts.Load(sessionID)
+	}
+	return nil, nil
+}
+
+// refreshHistory replaces history with the stored copy when the history store
+// holds a version this runtime has not seen, i.e. another replica ran the
+// session since.
+func (rt *Runtime) refreshHistory(ctx context.Context, sessionID string, history *message.History) {
+	if rt == nil || rt.historyPersister == nil || history == nil {
+		return
+	}
+	rec, found, err := rt.historyPersister.Load(ctx, sessionID)
+	if err != nil {
+		log.Printf("api: refresh history %q: %v", sessionID, err)
+		return
+	}
+	if !found {
+		return
+	}
+	// Metadata can change without a new version, so it is taken every time.
+	rt.sessions.adopt(sessionID, rec)
+	if prev, known := rt.storedHistory(sessionID); known && prev.version == rec.Version {
+		return
+	}
+	history.Replace(rec.Messages)
+	rt.setStoredHistory(sessionID, newStoredHistory(rec.Version, rec.Messages))
+}
+
+func (rt *Runtime) storedHistory(sessionID string) (storedHistory, bool) {
+	rt.storedMu.Lock()
+	defer rt.storedMu.Unlock()
+	prev, ok := rt.stored[sessionID]
+	return prev, ok
+}
+
+func (rt *Runtime) setStoredHistory(sessionID string, sh storedHistory) {
+	rt.storedMu.Lock()
+	defer rt.storedMu.Unlock()
+	if rt.stored == nil {
+		rt.stored = map[string]storedHistory{}
+	}
+	rt.stored[sessionID] = sh
+}
+
+// evictSession drops what the runtime tracks for a session evicted from
+// memory, including its session budget usage, so the ledger stays bounded by
+// MaxSessions.
+func (rt *Runtime) evictSession(sessionID string) {
+	rt.forgetStoredHistory(sessionID)
+	rt.sessions.remove(sessionID)
+	rt.budgets.forget(sessionID)
+}
+
+func (rt *Runtime) forgetStoredHistory(sessionID string) {
+	rt.storedMu.Lock()
+	defer rt.storedMu.Unlock()
+	delete(rt.stored, sessionID)
+}
+
+func historyDigest(msgs []message.Message) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, msg := range msgs {
+		digest, err := messageDigest(msg)
+		if err != nil {
+			// Unencodable messages never match, forcing a full replace.
+			return 0
+		}
+		binary.LittleEndian.PutUint64(buf[:], digest)
+		_, _ = h.Write(buf[:])
 	}
+	return h.Sum64()
 }
@@ -65,7 +65,7 @@ func TestPersistHistoryWritesSnapshot(t *testing.T) {
 	if p == nil {
 		t.Fatalf("expected persister")
 	}
-	rt := &Runtime{historyPersister: p}
+	rt := &Runtime{historyPersister: NewFileHistoryStore(p.dir)}
 	h := message.NewHistory()
 	h.Append(message.Message{Role: "user", Content: "hello"})
 
@@ -147,7 +147,7 @@ func TestPersistHistorySkipsEmptyCases(t *testing.T) {
 	var rt *Runtime
 	rt.persistHistory("sess", message.NewHistory())
 
-	rt = &Runtime{historyPersister: newDiskHistoryPersister(t.TempDir())}
+	rt = &Runtime{historyPersister: NewFileHistoryStore(newDiskHistoryPersister(t.TempDir()).dir)}
 	rt.persistHistory(" ", message.NewHistory())
 	h := message.NewHistory()
 	rt.persistHistory("sess", h)
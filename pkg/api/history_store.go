@@ -0,0 +1,461 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/google/uuid"
+)
+
+// ErrHistoryConflict is returned by HistoryStore writes based on a version
+// that is no longer current.
+var ErrHistoryConflict = errors.New("api: history version conflict")
+
+// ErrSessionNotFound is returned for sessions that are neither stored nor
+// held in memory.
+var ErrSessionNotFound = errors.New("api: session not found")
+
+// HistoryRecord is a stored session history.
+type HistoryRecord struct {
+	SessionID string
+	// Version increases with every write; 0 means the session is not stored.
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Metadata  SessionMetadata
+	Messages  []message.Message
+}
+
+// HistoryInfo summarises a stored session without its messages.
+type HistoryInfo struct {
+	SessionID string
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Metadata  SessionMetadata
+	Messages  int
+}
+
+func (r HistoryRecord) info() HistoryInfo {
+	return HistoryInfo{
+		SessionID: r.SessionID,
+		Version:   r.Version,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+		Metadata:  r.Metadata,
+		Messages:  len(r.Messages),
+	}
+}
+
+// HistoryStore persists session histories so they survive restarts and can
+// be shared by several runtimes. Writes name the version they are based on
+// and fail with ErrHistoryConflict when another writer got there first.
+type HistoryStore interface {
+	// Load returns the history of sessionID; found is false when none is
+	// stored.
+	Load(ctx context.Context, sessionID string) (rec HistoryRecord, found bool, err error)
+	// Append adds msgs to the history at version and returns the new version.
+	Append(ctx context.Context, sessionID string, version int64, msgs []message.Message) (int64, error)
+	// Replace overwrites the history at version with msgs and returns the new
+	// version.
+	Replace(ctx context.Context, sessionID string, version int64, msgs []message.Message) (int64, error)
+	// SetMetadata replaces the metadata of a stored session without changing
+	// its version. It returns ErrSessionNotFound when sessionID is not stored.
+	SetMetadata(ctx context.Context, sessionID string, meta SessionMetadata) error
+	// Delete removes sessionID. Deleting a missing session is not an error.
+	Delete(ctx context.Context, sessionID string) error
+	// List describes every stored session.
+	List(ctx context.Context) ([]HistoryInfo, error)
+}
+
+// MemoryHistoryStore is a HistoryStore kept in process memory, meant for
+// tests and single-process setups.
+type MemoryHistoryStore struct {
+	mu      sync.Mutex
+	records map[string]HistoryRecord
+}
+
+// NewMemoryHistoryStore constructs an empty MemoryHistoryStore.
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{records: map[string]HistoryRecord{}}
+}
+
+// Load implements HistoryStore.
+func (s *MemoryHistoryStore) Load(_ context.Context, sessionID string) (HistoryRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[sessionID]
+	if !ok {
+		return HistoryRecord{}, false, nil
+	}
+	rec.Messages = message.CloneMessages(rec.Messages)
+	rec.Metadata = rec.Metadata.clone()
+	return rec, true, nil
+}
+
+// Append implements HistoryStore.
+func (s *MemoryHistoryStore) Append(_ context.Context, sessionID string, version int64, msgs []message.Message) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.records[sessionID]
+	if rec.Version != version {
+		return rec.Version, fmt.Errorf("%w: session %q is at version %d, not %d", ErrHistoryConflict, sessionID, rec.Version, version)
+	}
+	return s.storeLocked(sessionID, version, append(rec.Messages, message.CloneMessages(msgs)...)), nil
+}
+
+// Replace implements HistoryStore.
+func (s *MemoryHistoryStore) Replace(_ context.Context, sessionID string, version int64, msgs []message.Message) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current := s.records[sessionID].Version; current != version {
+		return current, fmt.Errorf("%w: session %q is at version %d, not %d", ErrHistoryConflict, sessionID, current, version)
+	}
+	return s.storeLocked(sessionID, version, message.CloneMessages(msgs)), nil
+}
+
+func (s *MemoryHistoryStore) storeLocked(sessionID string, version int64, msgs []message.Message) int64 {
+	rec := s.records[sessionID]
+	now := time.Now().UTC()
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = now
+	}
+	rec.SessionID, rec.Version, rec.UpdatedAt, rec.Messages = sessionID, version+1, now, msgs
+	s.records[sessionID] = rec
+	return rec.Version
+}
+
+// SetMetadata implements HistoryStore.
+func (s *MemoryHistoryStore) SetMetadata(_ context.Context, sessionID string, meta SessionMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[sessionID]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrSessionNotFound, sessionID)
+	}
+	rec.Metadata = meta.clone()
+	s.records[sessionID] = rec
+	return nil
+}
+
+// Delete implements HistoryStore.
+func (s *MemoryHistoryStore) Delete(_ context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, sessionID)
+	return nil
+}
+
+// List implements HistoryStore.
+func (s *MemoryHistoryStore) List(context.Context) ([]HistoryInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]HistoryInfo, 0, len(s.records))
+	for _, rec := range s.records {
+		info := rec.info()
+		info.Metadata = info.Metadata.clone()
+		out = append(out, info)
+	}
+	sortHistoryInfos(out)
+	return out, nil
+}
+
+const (
+	defaultHistoryLockTimeout = 10 * time.Second
+	defaultHistoryStaleLock   = 30 * time.Second
+	historyLockPoll           = 20 * time.Millisecond
+)
+
+// FileHistoryStore is a HistoryStore keeping one JSON file per session in a
+// directory that may be shared between hosts, such as an NFS mount. Writers
+// serialise on a per-session lock file created with O_EXCL; readers rely on
+// writes replacing the file atomically. The file format is the one the
+// runtime uses under .claude/history.
+type FileHistoryStore struct {
+	dir string
+	// LockTimeout bounds how long a write waits for the session lock; zero
+	// uses 10 seconds.
+	LockTimeout time.Duration
+	// StaleLockAge is the age after which a lock left by a crashed writer is
+	// broken; zero uses 30 seconds.
+	StaleLockAge time.Duration
+}
+
+// NewFileHistoryStore constructs a FileHistoryStore rooted at dir.
+func NewFileHistoryStore(dir string) *FileHistoryStore {
+	return &FileHistoryStore{dir: dir}
+}
+
+func (s *FileHistoryStore) filePath(sessionID string) string {
+	return (&diskHistoryPersister{dir: s.dir}).filePath(sessionID)
+}
+
+// Load implements HistoryStore.
+func (s *FileHistoryStore) Load(_ context.Context, sessionID string) (HistoryRecord, bool, error) {
+	path := s.filePath(sessionID)
+	if path == "" {
+		return HistoryRecord{}, false, nil
+	}
+	return readHistoryRecord(path, sessionID)
+}
+
+func readHistoryRecord(path, sessionID string) (HistoryRecord, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return HistoryRecord{}, false, nil
+		}
+		return HistoryRecord{}, false, fmt.Errorf("read history: %w", err)
+	}
+	decoded, err := decodePersistedHistory(data)
+	if err != nil {
+		return HistoryRecord{}, false, err
+	}
+	rec := HistoryRecord{
+		SessionID: decoded.SessionID,
+		Version:   decoded.Revision,
+		CreatedAt: decoded.CreatedAt,
+		UpdatedAt: decoded.UpdatedAt,
+		Messages:  decoded.Messages,
+	}
+	if decoded.Metadata != nil {
+		rec.Metadata = *decoded.Metadata
+	}
+	if rec.SessionID == "" {
+		rec.SessionID = sessionID
+	}
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = rec.UpdatedAt
+	}
+	if rec.Version == 0 {
+		// Files written before revisions existed.
+		rec.Version = 1
+	}
+	return rec, true, nil
+}
+
+// Append implements HistoryStore.
+func (s *FileHistoryStore) Append(ctx context.Context, sessionID string, version int64, msgs []message.Message) (int64, error) {
+	return s.write(ctx, sessionID, version, func(current []message.Message) []message.Message {
+		return append(current, message.CloneMessages(msgs)...)
+	})
+}
+
+// Replace implements HistoryStore.
+func (s *FileHistoryStore) Replace(ctx context.Context, sessionID string, version int64, msgs []message.Message) (int64, error) {
+	return s.write(ctx, sessionID, version, func([]message.Message) []message.Message {
+		return message.CloneMessages(msgs)
+	})
+}
+
+func (s *FileHistoryStore) write(ctx context.Context, sessionID string, version int64, next func([]message.Message) []message.Message) (int64, error) {
+	path := s.filePath(sessionID)
+	if path == "" {
+		return 0, errors.New("api: history store directory is not set")
+	}
+	unlock, err := s.lock(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	current, _, err := readHistoryRecord(path, sessionID)
+	if err != nil {
+		return 0, err
+	}
+	if current.Version != version {
+		return current.Version, fmt.Errorf("%w: session %q is at version %d, not %d", ErrHistoryConflict, sessionID, current.Version, version)
+	}
+	current.Messages = next(current.Messages)
+	current.Version = version + 1
+	current.UpdatedAt = time.Now().UTC()
+	if current.CreatedAt.IsZero() {
+		current.CreatedAt = current.UpdatedAt
+	}
+	if err := s.writeRecord(path, sessionID, current); err != nil {
+		return 0, err
+	}
+	return current.Version, nil
+}
+
+// SetMetadata implements HistoryStore.
+func (s *FileHistoryStore) SetMetadata(ctx context.Context, sessionID string, meta SessionMetadata) error {
+	path := s.filePath(sessionID)
+	if path == "" {
+		return fmt.Errorf("%w: %q", ErrSessionNotFound, sessionID)
+	}
+	unlock, err := s.lock(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, found, err := readHistoryRecord(path, sessionID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %q", ErrSessionNotFound, sessionID)
+	}
+	current.Metadata = meta
+	return s.writeRecord(path, sessionID, current)
+}
+
+func (s *FileHistoryStore) writeRecord(path, sessionID string, rec HistoryRecord) error {
+	payload := persistedHistory{
+		Version:   1,
+		Revision:  rec.Version,
+		SessionID: sessionID,
+		CreatedAt: rec.CreatedAt,
+		UpdatedAt: rec.UpdatedAt,
+		Messages:  rec.Messages,
+	}
+	if !rec.Metadata.empty() {
+		payload.Metadata = &rec.Metadata
+	}
+	return writePersistedHistory(s.dir, path, payload)
+}
+
+// Delete implements HistoryStore.
+func (s *FileHistoryStore) Delete(ctx context.Context, sessionID string) error {
+	path := s.filePath(sessionID)
+	if path == "" {
+		return nil
+	}
+	unlock, err := s.lock(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove history: %w", err)
+	}
+	return nil
+}
+
+// List implements HistoryStore.
+func (s *FileHistoryStore) List(context.Context) ([]HistoryInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read history dir: %w", err)
+	}
+	var out []HistoryInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(strings.ToLower(name), ".json") {
+			continue
+		}
+		rec, found, err := readHistoryRecord(filepath.Join(s.dir, name), strings.TrimSuffix(name, filepath.Ext(name)))
+		if err != nil || !found {
+			// A file removed or replaced by a foreign writer mid-listing.
+			continue
+		}
+		out = append(out, rec.info())
+	}
+	sortHistoryInfos(out)
+	return out, nil
+}
+
+// lock takes the lock file next to path, breaking locks older than
+// StaleLockAge, and returns the func releasing it. The lock file holds a
+// token unique to this acquisition so a writer only ever removes its own
+// lock.
+func (s *FileHistoryStore) lock(ctx context.Context, path string) (func(), error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := s.LockTimeout
+	if timeout <= 0 {
+		timeout = defaultHistoryLockTimeout
+	}
+	stale := s.StaleLockAge
+	if stale <= 0 {
+		stale = defaultHistoryStaleLock
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("mkdir history dir: %w", err)
+	}
+
+	lockPath := path + ".lock"
+	host, _ := os.Hostname() //nolint:errcheck // informational only
+	owner := fmt.Sprintf("%s %d %s\n", host, os.Getpid(), uuid.NewString())
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, werr := f.WriteString(owner)
+			if cerr := f.Close(); werr == nil {
+				werr = cerr
+			}
+			if werr != nil {
+				_ = os.Remove(lockPath)
+				return nil, fmt.Errorf("lock history: %w", werr)
+			}
+			return func() { removeHistoryLock(lockPath, owner, stale) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("lock history: %w", err)
+		}
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > stale {
+			if held, readErr := os.ReadFile(lockPath); readErr == nil && removeHistoryLock(lockPath, string(held), stale) {
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("lock history %s: %w", filepath.Base(path), ctx.Err())
+		case <-time.After(historyLockPoll):
+		}
+	}
+}
+
+// removeHistoryLock removes lockPath if it still holds owner. Removals are
+// serialised through a short-lived guard file, so two writers breaking the
+// same stale lock cannot end up removing the lock one of them just took.
+func removeHistoryLock(lockPath, owner string, stale time.Duration) bool {
+	guardPath := lockPath + ".guard"
+	deadline := time.Now().Add(time.Second)
+	for {
+		guard, err := os.OpenFile(guardPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_ = guard.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return false
+		}
+		// A guard is only held for a read and a remove; one this old was
+		// left by a crashed writer.
+		if info, statErr := os.Stat(guardPath); statErr == nil && time.Since(info.ModTime()) > stale {
+			_ = os.Remove(guardPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(historyLockPoll)
+	}
+	defer func() { _ = os.Remove(guardPath) }()
+
+	held, err := os.ReadFile(lockPath)
+	if err != nil || string(held) != owner {
+		return false
+	}
+	return os.Remove(lockPath) == nil
+}
+
+func sortHistoryInfos(infos []HistoryInfo) {
+	sort.Slice(infos, func(i, j int) bool { return infos[i].SessionID < infos[j].SessionID })
+}
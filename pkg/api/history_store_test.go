@@ -0,0 +1,354 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+func TestHistoryStoreImplementations(t *testing.T) {
+	stores := map[string]func(t *testing.T) HistoryStore{
+		"memory": func(*testing.T) HistoryStore { return NewMemoryHistoryStore() },
+		"file":   func(t *testing.T) HistoryStore { return NewFileHistoryStore(t.TempDir()) },
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+			if _, found, err := store.Load(ctx, "s"); err != nil || found {
+				t.Fatalf("expected missing session, found=%v err=%v", found, err)
+			}
+
+			v1, err := store.Append(ctx, "s", 0, []message.Message{{Role: "user", Content: "hi"}})
+			if err != nil || v1 != 1 {
+				t.Fatalf("append: v=%d err=%v", v1, err)
+			}
+			if _, err := store.Append(ctx, "s", 0, []message.Message{{Role: "user", Content: "stale"}}); !errors.Is(err, ErrHistoryConflict) {
+				t.Fatalf("expected conflict for stale append, got %v", err)
+			}
+			v2, err := store.Append(ctx, "s", v1, []message.Message{{Role: "assistant", Content: "hello"}})
+			if err != nil || v2 != 2 {
+				t.Fatalf("append: v=%d err=%v", v2, err)
+			}
+			rec, found, err := store.Load(ctx, "s")
+			if err != nil || !found || rec.Version != 2 || len(rec.Messages) != 2 || rec.Messages[1].Content != "hello" || rec.SessionID != "s" {
+				t.Fatalf("unexpected record %+v found=%v err=%v", rec, found, err)
+			}
+
+			if err := store.SetMetadata(ctx, "missing", SessionMetadata{Title: "x"}); !errors.Is(err, ErrSessionNotFound) {
+				t.Fatalf("expected ErrSessionNotFound, got %v", err)
+			}
+			if err := store.SetMetadata(ctx, "s", SessionMetadata{Title: "greeting", Tags: []string{"demo"}}); err != nil {
+				t.Fatalf("set metadata: %v", err)
+			}
+			if rec, _, _ := store.Load(ctx, "s"); rec.Version != v2 || rec.Metadata.Title != "greeting" || rec.CreatedAt.IsZero() {
+				t.Fatalf("metadata should be kept without a new version, got %+v", rec)
+			}
+
+			if _, err := store.Replace(ctx, "s", v1, nil); !errors.Is(err, ErrHistoryConflict) {
+				t.Fatalf("expected conflict for stale replace, got %v", err)
+			}
+			v3, err := store.Replace(ctx, "s", v2, []message.Message{{Role: "user", Content: "summary"}})
+			if err != nil || v3 != 3 {
+				t.Fatalf("replace: v=%d err=%v", v3, err)
+			}
+			if _, err := store.Append(ctx, "other", 0, []message.Message{{Role: "user", Content: "x"}}); err != nil {
+				t.Fatalf("append other: %v", err)
+			}
+
+			infos, err := store.List(ctx)
+			if err != nil || len(infos) != 2 || infos[0].SessionID != "other" || infos[1].SessionID != "s" || infos[1].Version != 3 || infos[1].Messages != 1 || infos[1].UpdatedAt.IsZero() || infos[1].Metadata.Tags[0] != "demo" {
+				t.Fatalf("unexpected list %+v err=%v", infos, err)
+			}
+
+			if err := store.Delete(ctx, "s"); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			if err := store.Delete(ctx, "s"); err != nil {
+				t.Fatalf("second delete: %v", err)
+			}
+			if _, found, _ := store.Load(ctx, "s"); found {
+				t.Fatal("expected deleted session to be gone")
+			}
+		})
+	}
+}
+
+func TestFileHistoryStoreSerialisesWriters(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	const writers, turns = 4, 5
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Separate instances model separate hosts sharing the directory.
+			store := NewFileHistoryStore(dir)
+			for i := 0; i < turns; {
+				rec, _, err := store.Load(ctx, "s")
+				if err != nil {
+					t.Errorf("load: %v", err)
+					return
+				}
+				msg := message.Message{Role: "user", Content: fmt.Sprintf("%d-%d", w, i)}
+				if _, err := store.Append(ctx, "s", rec.Version, []message.Message{msg}); errors.Is(err, ErrHistoryConflict) {
+					continue
+				} else if err != nil {
+					t.Errorf("append: %v", err)
+					return
+				}
+				i++
+			}
+		}(w)
+	}
+	wg.Wait()
+	rec, _, err := NewFileHistoryStore(dir).Load(ctx, "s")
+	if err != nil || len(rec.Messages) != writers*turns || rec.Version != writers*turns {
+		t.Fatalf("expected every append to land once, got %d messages at version %d err=%v", len(rec.Messages), rec.Version, err)
+	}
+}
+
+func TestFileHistoryStoreLocks(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileHistoryStore(dir)
+	store.LockTimeout = 50 * time.Millisecond
+	lockPath := store.filePath("s") + ".lock"
+	if err := os.WriteFile(lockPath, []byte("other-host 1\n"), 0o600); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+	if _, err := store.Append(context.Background(), "s", 0, []message.Message{{Role: "user"}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected lock timeout, got %v", err)
+	}
+
+	old := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	if _, err := store.Append(context.Background(), "s", 0, []message.Message{{Role: "user"}}); err != nil {
+		t.Fatalf("expected stale lock to be broken, got %v", err)
+	}
+	if _, err := os.Stat(lockPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected lock released, got %v", err)
+	}
+}
+
+func TestFileHistoryStoreReadsLegacyFiles(t *testing.T) {
+	root := t.TempDir()
+	if err := SavePersistedHistory(root, "s", []message.Message{{Role: "user", Content: "old"}}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	store := NewFileHistoryStore(filepath.Join(root, ".claude", "history"))
+	rec, found, err := store.Load(context.Background(), "s")
+	if err != nil || !found || rec.Version != 1 || rec.Messages[0].Content != "old" {
+		t.Fatalf("unexpected legacy record %+v found=%v err=%v", rec, found, err)
+	}
+	if _, err := store.Append(context.Background(), "s", 1, []message.Message{{Role: "assistant", Content: "new"}}); err != nil {
+		t.Fatalf("append to legacy file: %v", err)
+	}
+}
+
+func TestRuntimesShareSessionsThroughHistoryStore(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	newReplica := func(answer string) (*Runtime, *stubModel) {
+		mdl := &stubModel{responses: []*model.Response{{Message: model.Message{Role: "assistant", Content: answer}}}}
+		rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdl, HistoryStore: store})
+		if err != nil {
+			t.Fatalf("runtime: %v", err)
+		}
+		t.Cleanup(func() { _ = rt.Close() })
+		return rt, mdl
+	}
+	rtA, mdlA := newReplica("from A")
+	rtB, mdlB := newReplica("from B")
+
+	if _, err := rtA.Run(context.Background(), Request{Prompt: "one", SessionID: "s"}); err != nil {
+		t.Fatalf("run A: %v", err)
+	}
+	if _, err := rtB.Run(context.Background(), Request{Prompt: "two", SessionID: "s"}); err != nil {
+		t.Fatalf("run B: %v", err)
+	}
+	if got := mdlB.requests[0].Messages; len(got) != 3 || got[1].Content != "from A" {
+		t.Fatalf("replica B should continue the session started on A, got %+v", got)
+	}
+	if _, err := rtA.Run(context.Background(), Request{Prompt: "three", SessionID: "s"}); err != nil {
+		t.Fatalf("second run A: %v", err)
+	}
+	if got := mdlA.requests[1].Messages; len(got) != 5 || got[3].Content != "from B" {
+		t.Fatalf("replica A should pick up the turn taken on B, got %+v", got)
+	}
+	rec, _, _ := store.Load(context.Background(), "s")
+	if rec.Version != 3 || len(rec.Messages) != 6 {
+		t.Fatalf("expected three appends holding six messages, got version %d with %d messages", rec.Version, len(rec.Messages))
+	}
+}
+
+// interleavingModel runs before once, in the middle of its first model call.
+type interleavingModel struct {
+	stubModel
+	before func()
+}
+
+func (m *interleavingModel) CompleteStream(ctx context.Context, req model.Request, cb model.StreamHandler) error {
+	if before := m.before; before != nil {
+		m.before = nil
+		before()
+	}
+	return m.stubModel.CompleteStream(ctx, req, cb)
+}
+
+func TestRunAppendsOnTopOfConcurrentWrite(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	mdlA := &interleavingModel{stubModel: stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "from A"}},
+		{Message: model.Message{Role: "assistant", Content: "from A again"}},
+	}}}
+	rtA, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdlA, HistoryStore: store})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rtA.Close() })
+	rtB, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: &stubModel{responses: []*model.Response{{Message: model.Message{Role: "assistant", Content: "from B"}}}}, HistoryStore: store})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rtB.Close() })
+
+	if _, err := rtA.Run(context.Background(), Request{Prompt: "one", SessionID: "s"}); err != nil {
+		t.Fatalf("run A: %v", err)
+	}
+	mdlA.before = func() {
+		if _, err := rtB.Run(context.Background(), Request{Prompt: "two", SessionID: "s"}); err != nil {
+			t.Errorf("run B: %v", err)
+		}
+	}
+	if _, err := rtA.Run(context.Background(), Request{Prompt: "three", SessionID: "s"}); err != nil {
+		t.Fatalf("second run A: %v", err)
+	}
+
+	want := []string{"one", "from A", "two", "from B", "three", "from A again"}
+	rec, _, _ := store.Load(context.Background(), "s")
+	got := make([]string, 0, len(rec.Messages))
+	for _, msg := range rec.Messages {
+		got = append(got, msg.Content)
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("run on A must be appended after B's turn, got %q", got)
+	}
+	if n := rtA.histories.Get("s").Len(); n != len(want) {
+		t.Fatalf("replica A should adopt the merged history, got %d messages", n)
+	}
+}
+
+func TestFirstRunAppendsOnTopOfConcurrentlyCreatedSession(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	mdlA := &interleavingModel{stubModel: stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "from A"}},
+	}}}
+	rtA, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdlA, HistoryStore: store})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rtA.Close() })
+	rtB, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: &stubModel{responses: []*model.Response{{Message: model.Message{Role: "assistant", Content: "from B"}}}}, HistoryStore: store})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rtB.Close() })
+
+	mdlA.before = func() {
+		if _, err := rtB.Run(context.Background(), Request{Prompt: "two", SessionID: "s"}); err != nil {
+			t.Errorf("run B: %v", err)
+		}
+	}
+	if _, err := rtA.Run(context.Background(), Request{Prompt: "one", SessionID: "s"}); err != nil {
+		t.Fatalf("run A: %v", err)
+	}
+
+	want := []string{"two", "from B", "one", "from A"}
+	rec, _, _ := store.Load(context.Background(), "s")
+	got := make([]string, 0, len(rec.Messages))
+	for _, msg := range rec.Messages {
+		got = append(got, msg.Content)
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("the first run on A must be kept after B's turn, got %q", got)
+	}
+	if n := rtA.histories.Get("s").Len(); n != len(want) {
+		t.Fatalf("replica A should adopt the merged history, got %d messages", n)
+	}
+}
+
+func TestFileHistoryStoreUnlockKeepsForeignLock(t *testing.T) {
+	store := NewFileHistoryStore(t.TempDir())
+	path := store.filePath("s")
+	unlock, err := store.lock(context.Background(), path)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	// Another writer broke the lock as stale and took it over.
+	if err := os.WriteFile(path+".lock", []byte("other-host 2 token\n"), 0o600); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+	unlock()
+	if held, err := os.ReadFile(path + ".lock"); err != nil || string(held) != "other-host 2 token\n" {
+		t.Fatalf("unlock must not remove another writer's lock, got %q err=%v", held, err)
+	}
+}
+
+func TestFileHistoryStoreBreaksStaleLockOnce(t *testing.T) {
+	store := NewFileHistoryStore(t.TempDir())
+	store.LockTimeout = 5 * time.Second
+	path := store.filePath("s")
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path+".lock", []byte("crashed 1\n"), 0o600); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+	old := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path+".lock", old, old); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	var holders, maxHolders atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := store.lock(context.Background(), path)
+			if err != nil {
+				t.Errorf("lock: %v", err)
+				return
+			}
+			n := holders.Add(1)
+			for {
+				prev := maxHolders.Load()
+				if n <= prev || maxHolders.CompareAndSwap(prev, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			holders.Add(-1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if got := maxHolders.Load(); got != 1 {
+		t.Fatalf("expected one lock holder at a time, got %d", got)
+	}
+	if _, err := os.Stat(path + ".lock"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected lock released, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+// ModelParams tunes the model calls of a run. Zero fields leave the
+// provider default; see model.Request for how each provider maps them.
+type ModelParams struct {
+	MaxTokens     int
+	Temperature   *float64
+	TopP          *float64
+	TopK          *int
+	StopSequences []string
+	// ToolChoice constrains tool use. A choice that forces a call (any or a
+	// named tool) applies to the first model call of the run only, so the
+	// run can still finish; later calls fall back to auto.
+	ToolChoice *model.ToolChoice
+	// UserID identifies the end user to the provider; empty uses the
+	// session ID.
+	UserID string
+}
+
+// merge returns p with the non-zero fields of override applied.
+func (p ModelParams) merge(override ModelParams) ModelParams {
+	if override.MaxTokens > 0 {
+		p.MaxTokens = override.MaxTokens
+	}
+	if override.Temperature != nil {
+		p.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.TopK != nil {
+		p.TopK = override.TopK
+	}
+	if len(override.StopSequences) > 0 {
+		p.StopSequences = override.StopSequences
+	}
+	if override.ToolChoice != nil {
+		p.ToolChoice = override.ToolChoice
+	}
+	if strings.TrimSpace(override.UserID) != "" {
+		p.UserID = override.UserID
+	}
+	return p
+}
+
+func (p ModelParams) clone() ModelParams {
+	if p.Temperature != nil {
+		v := *p.Temperature
+		p.Temperature = &v
+	}
+	if p.TopP != nil {
+		v := *p.TopP
+		p.TopP = &v
+	}
+	if p.TopK != nil {
+		v := *p.TopK
+		p.TopK = &v
+	}
+	if len(p.StopSequences) > 0 {
+		p.StopSequences = append([]string(nil), p.StopSequences...)
+	}
+	if p.ToolChoice != nil {
+		choice := *p.ToolChoice
+		p.ToolChoice = &choice
+	}
+	return p
+}
+
+func (p ModelParams) validate() error {
+	if p.MaxTokens < 0 {
+		return fmt.Errorf("api: max tokens %d is negative", p.MaxTokens)
+	}
+	if err := p.ToolChoice.Validate(); err != nil {
+		return fmt.Errorf("api: %w", err)
+	}
+	return nil
+}
+
+// modelParamsFor resolves the params of a run: Options.ModelParams, then the
+// defaults of the target subagent, then the request.
+func (rt *Runtime) modelParamsFor(req Request) ModelParams {
+	params := rt.opts.ModelParams
+	if sub, ok := rt.opts.SubagentModelParams[strings.ToLower(strings.TrimSpace(req.TargetSubagent))]; ok {
+		params = params.merge(sub)
+	}
+	return params.merge(req.ModelParams)
+}
+
+// checkToolChoice reports a choice naming a tool the run does not offer.
+func checkToolChoice(choice *model.ToolChoice, tools []model.ToolDefinition) error {
+	if choice == nil || choice.Type != model.ToolChoiceTool {
+		return nil
+	}
+	if !slices.ContainsFunc(tools, func(def model.ToolDefinition) bool { return def.Name == choice.Name }) {
+		return fmt.Errorf("api: tool choice names unavailable tool %q", choice.Name)
+	}
+	return nil
+}
+
+// toolChoice returns the choice for the next model call; forced choices are
+// spent by the first call.
+func (m *conversationModel) toolChoice() *model.ToolChoice {
+	choice := m.params.ToolChoice
+	if !m.toolChoiceSpent || !choice.Forces() {
+		return choice
+	}
+	if choice.DisableParallelToolUse {
+		return &model.ToolChoice{Type: model.ToolChoiceAuto, DisableParallelToolUse: true}
+	}
+	return nil
+}
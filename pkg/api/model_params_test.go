@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/tool"
+)
+
+func TestRuntimeModelParamsForceToolOnFirstCall(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", ToolCalls: []model.ToolCall{{ID: "1", Name: "echo", Arguments: map[string]any{"text": "hi"}}}}},
+		{Message: model.Message{Role: "assistant", Content: "done"}},
+	}}
+	temp, topP := 0.3, 0.8
+	rt, err := New(context.Background(), Options{
+		ProjectRoot: root,
+		Model:       mdl,
+		Tools:       []tool.Tool{&echoTool{}},
+		Sandbox:     SandboxOptions{AllowedPaths: []string{root}, Root: root, NetworkAllow: []string{"localhost"}},
+		ModelParams: ModelParams{MaxTokens: 512, Temperature: &temp, StopSequences: []string{"STOP"}},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	_, err = rt.Run(context.Background(), Request{
+		Prompt:    "classify",
+		SessionID: "s1",
+		ModelParams: ModelParams{
+			TopP:       &topP,
+			ToolChoice: &model.ToolChoice{Type: model.ToolChoiceTool, Name: "echo", DisableParallelToolUse: true},
+			UserID:     "user-1",
+		},
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(mdl.requests) != 2 {
+		t.Fatalf("expected two model calls, got %d", len(mdl.requests))
+	}
+	first, second := mdl.requests[0], mdl.requests[1]
+	if first.MaxTokens != 512 || first.Temperature == nil || *first.Temperature != 0.3 || first.TopP == nil || *first.TopP != 0.8 {
+		t.Fatalf("unexpected sampling params %+v", first)
+	}
+	if len(first.StopSequences) != 1 || first.StopSequences[0] != "STOP" || first.UserID != "user-1" {
+		t.Fatalf("unexpected stop sequences %v or user %q", first.StopSequences, first.UserID)
+	}
+	if choice := first.ToolChoice; choice == nil || choice.Type != model.ToolChoiceTool || choice.Name != "echo" {
+		t.Fatalf("expected forced tool on the first call, got %+v", choice)
+	}
+	if choice := second.ToolChoice; choice == nil || choice.Type != model.ToolChoiceAuto || !choice.DisableParallelToolUse {
+		t.Fatalf("expected auto without parallel calls after the forced call, got %+v", choice)
+	}
+	if second.MaxTokens != 512 || second.UserID != "user-1" {
+		t.Fatalf("expected params kept on later calls, got %+v", second)
+	}
+}
+
+func TestRuntimeModelParamsValidation(t *testing.T) {
+	root := newClaudeProject(t)
+	if _, err := New(context.Background(), Options{
+		ProjectRoot:         root,
+		Model:               &stubModel{},
+		SubagentModelParams: map[string]ModelParams{"plan": {ToolChoice: &model.ToolChoice{Type: model.ToolChoiceTool}}},
+	}); err == nil || !strings.Contains(err.Error(), `subagent "plan"`) {
+		t.Fatalf("expected invalid subagent params to fail, got %v", err)
+	}
+
+	mdl := &stubModel{}
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: mdl})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "hi", ModelParams: ModelParams{MaxTokens: -1}}); err == nil {
+		t.Fatalf("expected negative max tokens to fail")
+	}
+	choice := &model.ToolChoice{Type: model.ToolChoiceTool, Name: "missing"}
+	if _, err := rt.Run(context.Background(), Request{Prompt: "hi", ModelParams: ModelParams{ToolChoice: choice}}); err == nil || !strings.Contains(err.Error(), `"missing"`) {
+		t.Fatalf("expected unavailable tool to fail, got %v", err)
+	}
+	if len(mdl.requests) != 0 {
+		t.Fatalf("expected no model calls, got %d", len(mdl.requests))
+	}
+}
+
+func TestModelParamsResolution(t *testing.T) {
+	low, high := 0.1, 0.9
+	topK := 5
+	rt := &Runtime{opts: Options{
+		ModelParams: ModelParams{MaxTokens: 100, Temperature: &low, UserID: "default"},
+		SubagentModelParams: map[string]ModelParams{
+			"plan": {Temperature: &high, TopK: &topK, ToolChoice: &model.ToolChoice{Type: model.ToolChoiceNone}},
+		},
+	}}
+
+	got := rt.modelParamsFor(Request{TargetSubagent: " Plan "})
+	if got.MaxTokens != 100 || *got.Temperature != 0.9 || *got.TopK != 5 || got.ToolChoice.Type != model.ToolChoiceNone || got.UserID != "default" {
+		t.Fatalf("unexpected subagent params %+v", got)
+	}
+	got = rt.modelParamsFor(Request{TargetSubagent: "plan", ModelParams: ModelParams{MaxTokens: 50, StopSequences: []string{"x"}}})
+	if got.MaxTokens != 50 || *got.Temperature != 0.9 || len(got.StopSequences) != 1 {
+		t.Fatalf("unexpected request override %+v", got)
+	}
+	if got = rt.modelParamsFor(Request{}); got.TopK != nil || *got.Temperature != 0.1 {
+		t.Fatalf("expected runtime defaults without a subagent, got %+v", got)
+	}
+
+	frozen := rt.opts.frozen()
+	*frozen.SubagentModelParams["plan"].TopK = 7
+	if topK != 5 {
+		t.Fatalf("expected frozen options to copy subagent params")
+	}
+}
@@ -2,10 +2,14 @@ package api
 
 import (
 	"context"
+	"path/filepath"
 	"sync"
 	"testing"
 
+	anthropicsdk "github.com/anthropics/anthropic-sdk-go"
+	coreevents "github.com/cexll/agentsdk-go/pkg/core/events"
 	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/tool"
 )
 
 // mockModel implements model.Model for testing
@@ -392,3 +396,69 @@ func TestSelectModelForSubagentEmptyInputs(t *testing.T) {
 		t.Errorf("tier should be empty with empty inputs, got %q", tier)
 	}
 }
+
+func TestFailoverSwitchPublishesModelSelected(t *testing.T) {
+	root := newClaudeProject(t)
+	primary := &stubModel{err: &anthropicsdk.Error{StatusCode: 529}}
+	backup := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "from backup"}, Model: "backup-model"},
+	}}
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: model.NewFailover(primary, backup)})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	resp, err := rt.Run(context.Background(), Request{Prompt: "hi", SessionID: "s"})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Output != "from backup" {
+		t.Fatalf("unexpected result %+v", resp.Result)
+	}
+	var selected *coreevents.ModelSelectedPayload
+	for _, evt := range resp.HookEvents {
+		if payload, ok := evt.Payload.(coreevents.ModelSelectedPayload); ok && evt.Type == coreevents.ModelSelected {
+			selected = &payload
+		}
+	}
+	if selected == nil || selected.Model != "backup-model" || selected.Reason == "" {
+		t.Fatalf("expected ModelSelected event for the failover, got %+v", selected)
+	}
+}
+
+func TestRuntimeReplaysRecordedCassetteOffline(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "run.cassette.json")
+	record := func(mdl model.Model) *Response {
+		t.Helper()
+		echo := &echoTool{}
+		rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdl, Tools: []tool.Tool{echo}})
+		if err != nil {
+			t.Fatalf("runtime: %v", err)
+		}
+		t.Cleanup(func() { _ = rt.Close() })
+		resp, err := rt.Run(context.Background(), Request{Prompt: "say hi", SessionID: "cassette"})
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		if echo.calls != 1 {
+			t.Fatalf("expected the real tool to run, got %d calls", echo.calls)
+		}
+		return resp
+	}
+
+	live := &stubModel{responses: []*model.Response{
+		echoCallResponse("call_1"),
+		{Message: model.Message{Role: "assistant", Content: "said hi"}},
+	}}
+	recorded := record(model.NewRecorder(live, cassette))
+
+	replayer, err := model.NewReplayer(cassette, model.MatchStrict)
+	if err != nil {
+		t.Fatalf("replayer: %v", err)
+	}
+	replayed := record(replayer)
+	if replayed.Result.Output != recorded.Result.Output || replayer.Remaining() != 0 {
+		t.Fatalf("replay diverged: %q vs %q, %d left", replayed.Result.Output, recorded.Result.Output, replayer.Remaining())
+	}
+}
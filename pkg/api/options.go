@@ -10,12 +10,14 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cexll/agentsdk-go/pkg/config"
 	coreevents "github.com/cexll/agentsdk-go/pkg/core/events"
 	corehooks "github.com/cexll/agentsdk-go/pkg/core/hooks"
 	coremw "github.com/cexll/agentsdk-go/pkg/core/middleware"
+	"github.com/cexll/agentsdk-go/pkg/message"
 	"github.com/cexll/agentsdk-go/pkg/middleware"
 	"github.com/cexll/agentsdk-go/pkg/model"
 	"github.com/cexll/agentsdk-go/pkg/runtime/commands"
@@ -182,16 +184,61 @@ type Options struct {
 	// Individual requests can override this via Request.EnablePromptCache.
 	// Prompt caching reduces costs for repeated context (system prompts, conversation history).
 	DefaultEnableCache bool
+	// CachePolicy places prompt cache breakpoints and sets their TTL for all
+	// requests instead of the automatic placement of DefaultEnableCache;
+	// Request.CachePolicy overrides it. Message indexes refer to the
+	// conversation as sent, after trimming, so negative indexes counting from
+	// the newest message are usually what you want.
+	CachePolicy *model.CachePolicy
+	// Thinking is the default reasoning configuration of model calls;
+	// Request.Thinking overrides it.
+	Thinking *model.ThinkingConfig
+	// ModelParams are the default sampling, stop and tool choice settings of
+	// model calls. SubagentModelParams, keyed like SubagentModelMapping, apply
+	// on top for requests targeting a subagent, and Request.ModelParams on
+	// top of both; each level only overrides the fields it sets.
+	ModelParams         ModelParams
+	SubagentModelParams map[string]ModelParams
 
 	SystemPrompt string
+	// SystemPromptSections follow SystemPrompt in the system prompt. Each
+	// section is a text/template rendered against PromptData at the start of
+	// every run, so it can describe state that changes between runs;
+	// EnvironmentSection reports the working directory, platform, date and
+	// git state. Order cacheable sections first so prompt caching covers
+	// them.
+	SystemPromptSections []PromptSection
+	// PromptVars are available to section templates as .Vars.
+	PromptVars   map[string]any
 	RulesEnabled *bool // nil = 默认启用，false = 禁用
 
 	Middleware        []middleware.Middleware
 	MiddlewareTimeout time.Duration
 	MaxIterations     int
-	Timeout           time.Duration
-	TokenLimit        int
-	MaxSessions       int
+	// MaxParallelToolCalls lets tool calls from a single model turn run
+	// concurrently when every call in a consecutive run targets a tool that
+	// implements tool.ConcurrencySafeTool. Values <= 1 keep sequential
+	// execution. Results are still recorded in the original call order.
+	MaxParallelToolCalls int
+	Timeout              time.Duration
+	TokenLimit           int
+	MaxSessions          int
+	// TokenCounter sizes messages for TokenLimit trimming, the AutoCompact
+	// threshold and session token counts. Nil keeps message.NaiveCounter.
+	// Counters implementing message.Calibrator, such as
+	// message.CalibratedCounter, are fed the input tokens every model call
+	// reports. NewModelTokenCounter counts through the provider.
+	TokenCounter message.TokenCounter
+	// HistoryStore persists session histories. Nil stores them under
+	// .claude/history when settings.cleanupPeriodDays > 0. Share one store,
+	// such as a FileHistoryStore on a shared volume, between replicas so a
+	// session can move between them; each run reloads a session another
+	// replica has written since.
+	HistoryStore HistoryStore
+	// AutoTitleSessions asks the ModelTierLow model of ModelPool for a short
+	// title after the first run of a session. Without it, or when the pool
+	// has no low tier model, sessions are titled after their first prompt.
+	AutoTitleSessions bool
 
 	Tools []tool.Tool
 
@@ -239,6 +286,29 @@ type Options struct {
 	// and non-blocking to avoid delaying the agent execution. If you need async
 	// processing, spawn a goroutine inside the callback.
 	TokenCallback TokenCallback
+	// Pricing prices model calls for TokenStats.CostUSD. nil uses the
+	// built-in prices with the settings.json modelPricing overrides applied.
+	Pricing *model.PricingRegistry
+
+	// Budget caps each request; Request.Budget overrides it. SessionBudget
+	// and RuntimeBudget accumulate across requests of a session and across
+	// the runtime. Caps are checked after every model call and a run that
+	// reaches one stops with a *BudgetExceededError. Session usage is kept
+	// in SessionMetadata, so it survives eviction (see MaxSessions) when
+	// history is persisted; DeleteSession resets it.
+	Budget        Budget
+	SessionBudget Budget
+	RuntimeBudget Budget
+	// CostFunc estimates the USD cost of a model call for MaxCostUSD caps.
+	// nil prices calls with the runtime pricing registry.
+	CostFunc CostFunc
+
+	// SessionConcurrency decides what a run does when its session is busy:
+	// wait (default), reject, queue or interrupt the in-flight run.
+	SessionConcurrency SessionConcurrency
+	// SessionQueueSize bounds the waiting runs per session in queue mode.
+	// Zero uses 8.
+	SessionQueueSize int
 
 	// PermissionRequestHandler handles sandbox PermissionAsk decisions. Returning
 	// PermissionAllow continues tool execution; PermissionDeny rejects it; PermissionAsk
@@ -252,6 +322,10 @@ type Options struct {
 	ApprovalWhitelistTTL time.Duration
 	// ApprovalWait blocks tool execution until a pending approval is resolved.
 	ApprovalWait bool
+	// ApprovalSuspend stops the run at a tool call whose approval is still
+	// pending instead of failing or blocking it. The response carries a
+	// SuspendedRun token for Runtime.Resume. Takes precedence over ApprovalWait.
+	ApprovalSuspend bool
 
 	// AutoCompact enables automatic context compaction for long sessions.
 	AutoCompact CompactConfig
@@ -288,6 +362,32 @@ type Request struct {
 	TargetSubagent    string
 	ToolWhitelist     []string
 	ForceSkills       []string
+
+	// OutputSchema requires the final answer to be a JSON object matching the
+	// schema. The model submits it through the synthetic final_answer tool and
+	// the validated value is returned on Result.Structured.
+	OutputSchema *tool.JSONSchema
+	// OutputRetries bounds how many times an invalid answer is sent back to
+	// the model with the validation error. Zero uses the default of 2.
+	OutputRetries int
+
+	// Budget overrides Options.Budget for this request.
+	Budget *Budget
+	// Thinking overrides Options.Thinking for this request.
+	Thinking *model.ThinkingConfig
+	// CachePolicy overrides Options.CachePolicy for this request.
+	CachePolicy *model.CachePolicy
+	// ModelParams override the fields they set of Options.ModelParams and
+	// Options.SubagentModelParams for this request.
+	ModelParams ModelParams
+
+	// PromptSections override Options.SystemPromptSections for this request.
+	// A section replaces the configured one with the same name, or is added
+	// after them when none matches; a section without a Template removes the
+	// configured one.
+	PromptSections []PromptSection
+	// PromptVars add to and override Options.PromptVars for this request.
+	PromptVars map[string]any
 }
 
 // Response aggregates the final agent result together with metadata emitted
@@ -305,6 +405,9 @@ type Response struct {
 	Settings        *config.Settings
 	SandboxSnapshot SandboxReport
 	Tags            map[string]string
+	// Suspended is set when the run stopped at a pending approval; resume it
+	// with Runtime.Resume.
+	Suspended *SuspendedRun
 }
 
 // Result represents the agent execution result.
@@ -313,6 +416,8 @@ type Result struct {
 	StopReason string
 	Usage      model.Usage
 	ToolCalls  []model.ToolCall
+	// Structured holds the validated answer when Request.OutputSchema is set.
+	Structured map[string]any
 }
 
 // SkillExecution records individual skill invocations.
@@ -518,6 +623,29 @@ func (o Options) frozen() Options {
 
 	o.Sandbox = freezeSandboxOptions(o.Sandbox)
 
+	if o.Thinking != nil {
+		thinking := *o.Thinking
+		o.Thinking = &thinking
+	}
+	if o.CachePolicy != nil {
+		policy := *o.CachePolicy
+		policy.AfterMessages = append([]int(nil), policy.AfterMessages...)
+		o.CachePolicy = &policy
+	}
+	o.ModelParams = o.ModelParams.clone()
+	if len(o.SubagentModelParams) > 0 {
+		params := make(map[string]ModelParams, len(o.SubagentModelParams))
+		for name, p := range o.SubagentModelParams {
+			params[name] = p.clone()
+		}
+		o.SubagentModelParams = params
+	}
+	if len(o.SystemPromptSections) > 0 {
+		o.SystemPromptSections = append([]PromptSection(nil), o.SystemPromptSections...)
+	}
+	if len(o.PromptVars) > 0 {
+		o.PromptVars = maps.Clone(o.PromptVars)
+	}
 	if len(o.ModelPool) > 0 {
 		o.ModelPool = maps.Clone(o.ModelPool)
 	}
@@ -625,6 +753,13 @@ func (r Request) normalized(defaultMode ModeContext, fallbackSession string) Req
 	if len(req.Traits) > 0 {
 		req.Traits = cloneStrings(req.Traits)
 	}
+	if len(req.PromptSections) > 0 {
+		req.PromptSections = append([]PromptSection(nil), req.PromptSections...)
+	}
+	if len(req.PromptVars) > 0 {
+		req.PromptVars = maps.Clone(req.PromptVars)
+	}
+	req.ModelParams = req.ModelParams.clone()
 	return req
 }
 
@@ -679,8 +814,10 @@ type HookRecorder interface {
 	Drain() []coreevents.Event
 }
 
-// hookRecorder stores hook events for the response payload.
+// hookRecorder stores hook events for the response payload. It is safe for
+// concurrent use because tool hooks may fire from parallel tool calls.
 type hookRecorder struct {
+	mu     sync.Mutex
 	events []coreevents.Event
 }
 
@@ -688,10 +825,14 @@ func (r *hookRecorder) Record(evt coreevents.Event) {
 	if evt.Timestamp.IsZero() {
 		evt.Timestamp = time.Now().UTC()
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.events = append(r.events, evt)
 }
 
 func (r *hookRecorder) Drain() []coreevents.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	defer func() { r.events = nil }()
 	if len(r.events) == 0 {
 		return nil
@@ -895,6 +1036,17 @@ func (h *runtimeHookAdapter) ModelSelected(ctx context.Context, evt coreevents.M
 	return nil
 }
 
+func (h *runtimeHookAdapter) Notification(ctx context.Context, evt coreevents.NotificationPayload) error {
+	if h == nil || h.executor == nil {
+		return nil
+	}
+	if err := h.executor.Publish(coreevents.Event{Type: coreevents.Notification, Payload: evt}); err != nil {
+		return err
+	}
+	h.record(coreevents.Event{Type: coreevents.Notification, Payload: evt})
+	return nil
+}
+
 func (h *runtimeHookAdapter) record(evt coreevents.Event) {
 	if h == nil || h.recorder == nil {
 		return
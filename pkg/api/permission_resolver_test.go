@@ -12,7 +12,7 @@ import (
 )
 
 func TestBuildPermissionResolverHandlerAndApprovals(t *testing.T) {
-	if buildPermissionResolver(nil, nil, nil, "", 0, false) != nil {
+	if buildPermissionResolver(nil, nil, nil, "", 0, false, false) != nil {
 		t.Fatalf("expected nil resolver when no handlers configured")
 	}
 
@@ -22,7 +22,7 @@ func TestBuildPermissionResolverHandlerAndApprovals(t *testing.T) {
 	}
 	resolver := buildPermissionResolver(nil, func(context.Context, PermissionRequest) (coreevents.PermissionDecisionType, error) {
 		return coreevents.PermissionAllow, nil
-	}, queue, "tester", time.Hour, false)
+	}, queue, "tester", time.Hour, false, false)
 	if resolver == nil {
 		t.Fatalf("expected resolver")
 	}
@@ -48,7 +48,7 @@ func TestBuildPermissionResolverHandlerAndApprovals(t *testing.T) {
 func TestBuildPermissionResolverHandlerUnknown(t *testing.T) {
 	resolver := buildPermissionResolver(nil, func(context.Context, PermissionRequest) (coreevents.PermissionDecisionType, error) {
 		return coreevents.PermissionAsk, nil
-	}, nil, "", 0, false)
+	}, nil, "", 0, false, false)
 	decision := security.PermissionDecision{Action: security.PermissionAsk, Rule: "rule", Target: "target"}
 	res, err := resolver(context.Background(), tool.Call{Name: "Bash"}, decision)
 	if err != nil {
@@ -68,7 +68,7 @@ func TestBuildPermissionResolverWaitsForApproval(t *testing.T) {
 
 	resolver := buildPermissionResolver(nil, func(context.Context, PermissionRequest) (coreevents.PermissionDecisionType, error) {
 		return coreevents.PermissionAsk, nil
-	}, queue, "tester", 0, true)
+	}, queue, "tester", 0, true, false)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
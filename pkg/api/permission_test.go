@@ -42,7 +42,7 @@ func TestBuildPermissionResolverAllowDeny(t *testing.T) {
 
 	allowResolver := buildPermissionResolver(nil, func(context.Context, PermissionRequest) (coreevents.PermissionDecisionType, error) {
 		return coreevents.PermissionAllow, nil
-	}, queue, "tester", time.Hour, false)
+	}, queue, "tester", time.Hour, false, false)
 
 	call := tool.Call{Name: "Bash", Params: map[string]any{"command": "ls"}, SessionID: "sess"}
 	decision := security.PermissionDecision{Action: security.PermissionAsk, Rule: "rule", Target: "ls"}
@@ -63,7 +63,7 @@ func TestBuildPermissionResolverAllowDeny(t *testing.T) {
 	}
 	denyResolver := buildPermissionResolver(nil, func(context.Context, PermissionRequest) (coreevents.PermissionDecisionType, error) {
 		return coreevents.PermissionDeny, nil
-	}, queue2, "tester", 0, false)
+	}, queue2, "tester", 0, false, false)
 	denied, err := denyResolver(context.Background(), call, decision)
 	if err != nil {
 		t.Fatalf("resolver failed: %v", err)
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"github.com/cexll/agentsdk-go/pkg/config"
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+// newPricingRegistry returns Options.Pricing, or the built-in prices with the
+// settings.json modelPricing overrides applied.
+func newPricingRegistry(opts Options, settings *config.Settings) *model.PricingRegistry {
+	if opts.Pricing != nil {
+		return opts.Pricing
+	}
+	var overrides map[string]model.Price
+	if settings != nil && len(settings.ModelPricing) > 0 {
+		overrides = make(map[string]model.Price, len(settings.ModelPricing))
+		for name, price := range settings.ModelPricing {
+			overrides[name] = model.Price{
+				Input:      price.Input,
+				Output:     price.Output,
+				CacheRead:  price.CacheRead,
+				CacheWrite: price.CacheWrite,
+			}
+		}
+	}
+	return model.NewPricingRegistry(overrides)
+}
+
+// costFunc returns Options.CostFunc, falling back to the pricing registry.
+func (rt *Runtime) costFunc() CostFunc {
+	if rt.opts.CostFunc != nil {
+		return rt.opts.CostFunc
+	}
+	if rt.pricing == nil {
+		return nil
+	}
+	return rt.pricing.Cost
+}
+
+// Pricing returns the registry used to estimate the cost of model calls.
+func (rt *Runtime) Pricing() *model.PricingRegistry {
+	if rt == nil {
+		return nil
+	}
+	return rt.pricing
+}
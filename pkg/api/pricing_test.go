@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/tool"
+)
+
+func TestTokenStatsReportCostPerCall(t *testing.T) {
+	root := newClaudeProjectWithSettings(t, `{"modelPricing":{"test-model":{"input":2,"output":10}}}`)
+	echo := &echoTool{}
+	mdl := &stubModel{responses: []*model.Response{
+		usageResponse("", 1000, 100, model.ToolCall{ID: "c1", Name: "echo", Arguments: map[string]any{"text": "a"}}),
+		usageResponse("done", 2000, 200),
+	}}
+	var calls []TokenStats
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:   root,
+		Model:         mdl,
+		Tools:         []tool.Tool{echo},
+		TokenTracking: true,
+		TokenCallback: func(stats TokenStats) { calls = append(calls, stats) },
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "go", SessionID: "s"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("expected one callback per model call, got %d", len(calls))
+	}
+	first := (1000*2 + 100*10) / 1e6
+	second := (2000*2 + 200*10) / 1e6
+	if math.Abs(calls[0].CostUSD-first) > 1e-12 || math.Abs(calls[1].CostUSD-second) > 1e-12 {
+		t.Fatalf("unexpected per-call costs %v, %v", calls[0].CostUSD, calls[1].CostUSD)
+	}
+	if calls[0].Model != "test-model" {
+		t.Fatalf("expected served model on stats, got %q", calls[0].Model)
+	}
+
+	session := rt.GetSessionStats("s")
+	if session == nil || math.Abs(session.TotalCostUSD-(first+second)) > 1e-12 || session.TotalInput != 3000 {
+		t.Fatalf("unexpected session stats %+v", session)
+	}
+	if byModel := session.ByModel["test-model"]; byModel == nil || math.Abs(byModel.CostUSD-(first+second)) > 1e-12 {
+		t.Fatalf("unexpected per-model stats %+v", session.ByModel)
+	}
+	if total := rt.GetTotalStats(); math.Abs(total.TotalCostUSD-(first+second)) > 1e-12 {
+		t.Fatalf("unexpected total cost %v", total.TotalCostUSD)
+	}
+}
+
+func TestBudgetDefaultsToPricingRegistry(t *testing.T) {
+	root := newClaudeProject(t)
+	pricing := model.NewPricingRegistry(map[string]model.Price{"test-model": {Input: 1000}})
+	mdl := &stubModel{responses: []*model.Response{usageResponse("one", 1000, 0)}}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot: root,
+		Model:       mdl,
+		Pricing:     pricing,
+		Budget:      Budget{MaxCostUSD: 0.5},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	var exceeded *BudgetExceededError
+	_, err = rt.Run(context.Background(), Request{Prompt: "go", SessionID: "s"})
+	if !errors.As(err, &exceeded) || exceeded.Limit != BudgetLimitCostUSD || exceeded.Used != 1 {
+		t.Fatalf("expected cost budget error priced by registry, got %v", err)
+	}
+	if rt.Pricing() != pricing {
+		t.Fatalf("expected Options.Pricing to be used as is")
+	}
+}
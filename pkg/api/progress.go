@@ -3,15 +3,23 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"sync"
 
 	"github.com/cexll/agentsdk-go/pkg/agent"
 	"github.com/cexll/agentsdk-go/pkg/middleware"
+	"github.com/cexll/agentsdk-go/pkg/model"
 )
 
 // streamEmitFunc is stored on context so tools can push incremental output
 // without depending on middleware details.
 type streamEmitFunc func(context.Context, StreamEvent)
 
+// modelStreamObserver receives provider stream fragments while the model is
+// still generating so they can be forwarded before AfterModel runs.
+type modelStreamObserver interface {
+	ObserveModelStream(ctx context.Context, sr model.StreamResult)
+}
+
 // newProgressMiddleware surfaces Anthropic-compatible SSE progress events at
 // each middleware interception point. The event ordering mirrors Anthropic's
 // streaming payloads while adding agent/tool lifecycle markers.
@@ -23,6 +31,26 @@ func newProgressMiddleware(events chan<- StreamEvent) *progressMiddleware {
 // middleware hooks stay terse and ordered.
 type progressMiddleware struct {
 	emitter progressEmitter
+
+	mu   sync.Mutex
+	live liveBlocks
+}
+
+// liveBlocks tracks content blocks opened from live model fragments during a
+// single iteration so AfterModel only synthesises what was not streamed.
+type liveBlocks struct {
+	next  int
+	open  bool
+	key   liveBlockKey
+	idx   int
+	text  bool
+	tools map[string]struct{}
+}
+
+type liveBlockKey struct {
+	kind   string
+	source int
+	toolID string
 }
 
 func (p *progressMiddleware) Name() string { return "progress" }
@@ -42,25 +70,104 @@ func (p *progressMiddleware) BeforeAgent(ctx context.Context, _ *middleware.Stat
 
 func (p *progressMiddleware) BeforeModel(ctx context.Context, st *middleware.State) error {
 	iter := st.Iteration
+	p.mu.Lock()
+	p.live = liveBlocks{}
+	p.mu.Unlock()
 	p.emit(ctx, StreamEvent{Type: EventIterationStart, Iteration: &iter})
 	p.emit(ctx, StreamEvent{Type: EventMessageStart, Message: &Message{Role: "assistant"}})
 	return nil
 }
 
+// ObserveModelStream forwards text, thinking and tool input fragments as
+// content_block_* events. A new block starts whenever the fragment kind,
+// provider block index or tool call changes.
+func (p *progressMiddleware) ObserveModelStream(ctx context.Context, sr model.StreamResult) {
+	var (
+		key   liveBlockKey
+		block ContentBlock
+		delta Delta
+	)
+	switch {
+	case sr.ToolInputDelta != "":
+		encoded, err := json.Marshal(sr.ToolInputDelta)
+		if err != nil {
+			return
+		}
+		key = liveBlockKey{kind: "tool_use", source: sr.Index, toolID: sr.ToolCallID}
+		block = ContentBlock{Type: "tool_use", ID: sr.ToolCallID, Name: sr.ToolCallName}
+		delta = Delta{Type: "input_json_delta", PartialJSON: json.RawMessage(encoded)}
+	case sr.Thinking != "":
+		key = liveBlockKey{kind: "thinking", source: sr.Index}
+		block = ContentBlock{Type: "thinking"}
+		delta = Delta{Type: "thinking_delta", Thinking: sr.Thinking}
+	case sr.Delta != "":
+		key = liveBlockKey{kind: "text", source: sr.Index}
+		block = ContentBlock{Type: "text"}
+		delta = Delta{Type: "text_delta", Text: sr.Delta}
+	default:
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.live.open || p.live.key != key {
+		p.closeLiveLocked(ctx)
+		idx := p.live.next
+		p.live.next++
+		p.live.open = true
+		p.live.key = key
+		p.live.idx = idx
+		switch key.kind {
+		case "text":
+			p.live.text = true
+		case "tool_use":
+			if p.live.tools == nil {
+				p.live.tools = map[string]struct{}{}
+			}
+			p.live.tools[key.toolID] = struct{}{}
+		}
+		p.emit(ctx, StreamEvent{Type: EventContentBlockStart, Index: &idx, ContentBlock: &block})
+	}
+	idx := p.live.idx
+	p.emit(ctx, StreamEvent{Type: EventContentBlockDelta, Index: &idx, Delta: &delta})
+}
+
+func (p *progressMiddleware) closeLiveLocked(ctx context.Context) {
+	if !p.live.open {
+		return
+	}
+	idx := p.live.idx
+	p.live.open = false
+	p.emit(ctx, StreamEvent{Type: EventContentBlockStop, Index: &idx})
+}
+
 func (p *progressMiddleware) AfterModel(ctx context.Context, st *middleware.State) error {
+	p.mu.Lock()
+	p.closeLiveLocked(ctx)
+	live := p.live
+	p.live = liveBlocks{}
+	p.mu.Unlock()
+
 	out, ok := st.ModelOutput.(*agent.ModelOutput)
 	if !ok || out == nil {
 		return nil
 	}
 
-	idx := 0
-	text := out.Content
-	p.textBlock(ctx, idx, text)
-	if text != "" {
-		idx++
+	// Anything the model already streamed live is not repeated; the rest is
+	// synthesised so models without fine-grained deltas still stream blocks.
+	idx := live.next
+	if !live.text {
+		text := out.Content
+		p.textBlock(ctx, idx, text)
+		if text != "" {
+			idx++
+		}
 	}
 
 	for _, call := range out.ToolCalls {
+		if _, streamed := live.tools[call.ID]; streamed {
+			continue
+		}
 		p.toolBlock(ctx, idx, call)
 		idx++
 	}
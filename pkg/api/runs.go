@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StopReasonCancelled marks a run stopped through Runtime.Cancel or
+	// Runtime.CancelSession.
+	StopReasonCancelled = "cancelled"
+
+	defaultCancelReason = "cancelled by user"
+)
+
+var (
+	// ErrRunCancelled matches the error of a run stopped through
+	// Runtime.Cancel or Runtime.CancelSession.
+	ErrRunCancelled = errors.New("api: run cancelled")
+	// ErrNoActiveRun is returned when no active run matches a cancel request.
+	ErrNoActiveRun = errors.New("api: no active run")
+)
+
+// RunCancelledError is returned by a run stopped through Runtime.Cancel or
+// Runtime.CancelSession. It matches ErrRunCancelled and context.Canceled.
+type RunCancelledError struct {
+	RequestID string
+	Reason    string
+}
+
+func (e *RunCancelledError) Error() string {
+	return fmt.Sprintf("api: run %s cancelled: %s", e.RequestID, e.Reason)
+}
+
+// Is reports whether target is ErrRunCancelled or context.Canceled.
+func (e *RunCancelledError) Is(target error) bool {
+	return target == ErrRunCancelled || target == context.Canceled
+}
+
+func (e *RunCancelledError) marker() string {
+	return fmt.Sprintf("[Request cancelled: %s]", e.Reason)
+}
+
+// RunInfo describes a run in progress.
+type RunInfo struct {
+	RequestID string
+	SessionID string
+	StartedAt time.Time
+	// Iteration counts the model turns started so far.
+	Iteration int
+	// CurrentTool names the longest running tool call, empty between tools.
+	CurrentTool string
+}
+
+// ActiveRuns lists the runs in progress, oldest first. Runs waiting for
+// their session are not included.
+func (rt *Runtime) ActiveRuns() []RunInfo {
+	if rt == nil || rt.runs == nil {
+		return nil
+	}
+	return rt.runs.list()
+}
+
+// Cancel stops the run with requestID. The run returns a *RunCancelledError,
+// its Bash subprocesses are killed, and reason is recorded in the session
+// history and in the Stop hook payload.
+func (rt *Runtime) Cancel(requestID, reason string) error {
+	if rt == nil || rt.runs == nil {
+		return ErrNoActiveRun
+	}
+	if rt.runs.cancel(func(run *activeRun) bool { return run.requestID == requestID }, reason) == 0 {
+		return fmt.Errorf("%w: request %q", ErrNoActiveRun, requestID)
+	}
+	return nil
+}
+
+// CancelSession stops the runs of sessionID like Cancel.
+func (rt *Runtime) CancelSession(sessionID string) error {
+	if rt == nil || rt.runs == nil {
+		return ErrNoActiveRun
+	}
+	if rt.runs.cancel(func(run *activeRun) bool { return run.sessionID == sessionID }, defaultCancelReason) == 0 {
+		return fmt.Errorf("%w: session %q", ErrNoActiveRun, sessionID)
+	}
+	return nil
+}
+
+// Steer queues a user message for the run in progress on sessionID. The
+// message joins the conversation at the next iteration boundary, after the
+// results of the current tool calls and before the next model call, and fires
+// the UserPromptSubmit hooks. A run about to finish takes another turn to
+// answer it. Messages still queued when the run ends are dropped.
+func (rt *Runtime) Steer(sessionID, message string) error {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return errors.New("api: steering message is empty")
+	}
+	if rt == nil || rt.runs == nil {
+		return ErrNoActiveRun
+	}
+	run := rt.runs.find(func(run *activeRun) bool { return run.sessionID == sessionID })
+	if run == nil {
+		return fmt.Errorf("%w: session %q", ErrNoActiveRun, sessionID)
+	}
+	run.steer(message)
+	return nil
+}
+
+// runRegistry tracks the runs in progress by RequestID.
+type runRegistry struct {
+	mu   sync.Mutex
+	runs map[string]*activeRun
+}
+
+func newRunRegistry() *runRegistry {
+	return &runRegistry{runs: map[string]*activeRun{}}
+}
+
+type activeRun struct {
+	requestID string
+	sessionID string
+	startedAt time.Time
+	cancel    context.CancelCauseFunc
+
+	mu        sync.Mutex
+	iteration int
+	tools     []runningTool
+	steering  []string
+}
+
+type runningTool struct {
+	id   string
+	name string
+}
+
+// track registers a run and returns its cancellable context together with
+// the func that unregisters it.
+func (r *runRegistry) track(ctx context.Context, requestID, sessionID string) (context.Context, *activeRun, func(), error) {
+	runCtx, cancel := context.WithCancelCause(ctx)
+	run := &activeRun{requestID: requestID, sessionID: sessionID, startedAt: time.Now(), cancel: cancel}
+	r.mu.Lock()
+	if _, exists := r.runs[requestID]; exists {
+		r.mu.Unlock()
+		cancel(nil)
+		return nil, nil, nil, fmt.Errorf("api: request %q is already running", requestID)
+	}
+	r.runs[requestID] = run
+	r.mu.Unlock()
+	return runCtx, run, func() {
+		r.mu.Lock()
+		delete(r.runs, requestID)
+		r.mu.Unlock()
+		cancel(nil)
+	}, nil
+}
+
+func (r *runRegistry) cancel(match func(*activeRun) bool, reason string) int {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		reason = defaultCancelReason
+	}
+	r.mu.Lock()
+	var targets []*activeRun
+	for _, run := range r.runs {
+		if match(run) {
+			targets = append(targets, run)
+		}
+	}
+	r.mu.Unlock()
+	for _, run := range targets {
+		run.cancel(&RunCancelledError{RequestID: run.requestID, Reason: reason})
+	}
+	return len(targets)
+}
+
+// find returns the oldest run matching match.
+func (r *runRegistry) find(match func(*activeRun) bool) *activeRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found *activeRun
+	for _, run := range r.runs {
+		if match(run) && (found == nil || run.startedAt.Before(found.startedAt)) {
+			found = run
+		}
+	}
+	return found
+}
+
+func (r *runRegistry) list() []RunInfo {
+	r.mu.Lock()
+	runs := make([]*activeRun, 0, len(r.runs))
+	for _, run := range r.runs {
+		runs = append(runs, run)
+	}
+	r.mu.Unlock()
+	out := make([]RunInfo, 0, len(runs))
+	for _, run := range runs {
+		out = append(out, run.info())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+func (a *activeRun) info() RunInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	info := RunInfo{RequestID: a.requestID, SessionID: a.sessionID, StartedAt: a.startedAt, Iteration: a.iteration}
+	if len(a.tools) > 0 {
+		info.CurrentTool = a.tools[0].name
+	}
+	return info
+}
+
+func (a *activeRun) nextIteration() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.iteration++
+	a.mu.Unlock()
+}
+
+func (a *activeRun) toolStarted(id, name string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.tools = append(a.tools, runningTool{id: id, name: name})
+	a.mu.Unlock()
+}
+
+func (a *activeRun) toolFinished(id string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, tool := range a.tools {
+		if tool.id == id {
+			a.tools = append(a.tools[:i], a.tools[i+1:]...)
+			return
+		}
+	}
+}
+
+func (a *activeRun) steer(message string) {
+	a.mu.Lock()
+	a.steering = append(a.steering, message)
+	a.mu.Unlock()
+}
+
+// hasSteering reports whether steering messages are waiting.
+func (a *activeRun) hasSteering() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.steering) > 0
+}
+
+// takeSteering drains the queued steering messages in arrival order.
+func (a *activeRun) takeSteering() []string {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	msgs := a.steering
+	a.steering = nil
+	return msgs
+}
+
+// stopError maps a run context cancelled by the runtime to the error the run
+// reports: ErrRunInterrupted or a *RunCancelledError. It returns nil for
+// cancellations that came from the caller.
+func stopError(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	cause := context.Cause(ctx)
+	var cancelled *RunCancelledError
+	switch {
+	case errors.Is(cause, ErrRunInterrupted):
+		return ErrRunInterrupted
+	case errors.As(cause, &cancelled):
+		return cancelled
+	}
+	return nil
+}
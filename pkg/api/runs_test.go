@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	coreevents "github.com/cexll/agentsdk-go/pkg/core/events"
+	coremw "github.com/cexll/agentsdk-go/pkg/core/middleware"
+	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/tool"
+)
+
+// waitTool blocks until its context ends.
+type waitTool struct {
+	started chan struct{}
+}
+
+func (w *waitTool) Name() string             { return "wait" }
+func (w *waitTool) Description() string      { return "waits until cancelled" }
+func (w *waitTool) Schema() *tool.JSONSchema { return &tool.JSONSchema{Type: "object"} }
+func (w *waitTool) Execute(ctx context.Context, _ map[string]interface{}) (*tool.ToolResult, error) {
+	close(w.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCancelStopsRunAndRecordsReason(t *testing.T) {
+	waiter := &waitTool{started: make(chan struct{})}
+	mdl := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", ToolCalls: []model.ToolCall{{ID: "w1", Name: "wait"}}}},
+	}}
+	var (
+		mu    sync.Mutex
+		stops []string
+	)
+	rt, err := New(context.Background(), Options{
+		ProjectRoot: newClaudeProject(t),
+		Model:       mdl,
+		Tools:       []tool.Tool{waiter},
+		HookMiddleware: []coremw.Middleware{func(next coremw.Handler) coremw.Handler {
+			return func(ctx context.Context, evt coreevents.Event) error {
+				if payload, ok := evt.Payload.(coreevents.StopPayload); ok {
+					mu.Lock()
+					stops = append(stops, payload.Reason)
+					mu.Unlock()
+				}
+				return next(ctx, evt)
+			}
+		}},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := rt.Run(context.Background(), Request{Prompt: "wait please", SessionID: "s", RequestID: "req-1"})
+		done <- err
+	}()
+	<-waiter.started
+
+	runs := rt.ActiveRuns()
+	if len(runs) != 1 {
+		t.Fatalf("expected one active run, got %+v", runs)
+	}
+	if info := runs[0]; info.RequestID != "req-1" || info.SessionID != "s" || info.Iteration != 1 || info.CurrentTool != "wait" || info.StartedAt.IsZero() {
+		t.Fatalf("unexpected run info %+v", info)
+	}
+
+	if err := rt.Cancel("missing", "x"); !errors.Is(err, ErrNoActiveRun) {
+		t.Fatalf("expected ErrNoActiveRun, got %v", err)
+	}
+	if err := rt.Cancel("req-1", "user pressed stop"); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	err = <-done
+	var cancelled *RunCancelledError
+	if !errors.As(err, &cancelled) || cancelled.Reason != "user pressed stop" {
+		t.Fatalf("expected *RunCancelledError, got %v", err)
+	}
+	if !errors.Is(err, ErrRunCancelled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancel error should match ErrRunCancelled and context.Canceled: %v", err)
+	}
+
+	msgs := rt.histories.Get("s").All()
+	last := msgs[len(msgs)-1]
+	if last.Role != "assistant" || last.Content != "[Request cancelled: user pressed stop]" {
+		t.Fatalf("expected cancellation recorded in history, got %+v", last)
+	}
+	toolMsg := msgs[len(msgs)-2]
+	if toolMsg.Role != "tool" || len(toolMsg.ToolCalls) != 1 || toolMsg.ToolCalls[0].ID != "w1" {
+		t.Fatalf("expected the cancelled tool call to keep a result, got %+v", toolMsg)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(stops) != 1 || stops[0] != "cancelled: user pressed stop" {
+		t.Fatalf("expected Stop hook with reason, got %v", stops)
+	}
+	if runs := rt.ActiveRuns(); len(runs) != 0 {
+		t.Fatalf("expected no active runs after cancel, got %+v", runs)
+	}
+}
+
+func TestCancelSessionStopsStream(t *testing.T) {
+	mdl := newBlockingModel()
+	rt := newConcurrentRuntime(t, mdl)
+
+	stream, err := rt.RunStream(context.Background(), Request{Prompt: "hi", SessionID: "s"})
+	if err != nil {
+		t.Fatalf("run stream: %v", err)
+	}
+	waitSignals(t, mdl.started, 1)
+	if runs := rt.ActiveRuns(); len(runs) != 1 || runs[0].RequestID == "" {
+		t.Fatalf("expected a tracked stream run with a generated request id, got %+v", runs)
+	}
+	if err := rt.CancelSession("s"); err != nil {
+		t.Fatalf("cancel session: %v", err)
+	}
+	got, ok := findStreamError(drainStream(t, stream))
+	if !ok || !strings.Contains(got, defaultCancelReason) {
+		t.Fatalf("expected cancellation error event, got %q", got)
+	}
+	if err := rt.CancelSession("s"); !errors.Is(err, ErrNoActiveRun) {
+		t.Fatalf("expected ErrNoActiveRun once the stream ended, got %v", err)
+	}
+}
+
+// gateTool signals when it starts and returns once released.
+type gateTool struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *gateTool) Name() string             { return "gate" }
+func (g *gateTool) Description() string      { return "waits for release" }
+func (g *gateTool) Schema() *tool.JSONSchema { return &tool.JSONSchema{Type: "object"} }
+func (g *gateTool) Execute(ctx context.Context, _ map[string]interface{}) (*tool.ToolResult, error) {
+	close(g.started)
+	<-g.release
+	return &tool.ToolResult{Success: true, Output: "installed"}, nil
+}
+
+func TestSteerInjectsMessageAfterToolResults(t *testing.T) {
+	gate := &gateTool{started: make(chan struct{}), release: make(chan struct{})}
+	mdl := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", ToolCalls: []model.ToolCall{{ID: "g1", Name: "gate"}}}},
+		{Message: model.Message{Role: "assistant", Content: "switched to pnpm"}},
+	}}
+	var (
+		mu      sync.Mutex
+		prompts []string
+	)
+	rt, err := New(context.Background(), Options{
+		ProjectRoot: newClaudeProject(t),
+		Model:       mdl,
+		Tools:       []tool.Tool{gate},
+		HookMiddleware: []coremw.Middleware{func(next coremw.Handler) coremw.Handler {
+			return func(ctx context.Context, evt coreevents.Event) error {
+				if payload, ok := evt.Payload.(coreevents.UserPromptPayload); ok {
+					mu.Lock()
+					prompts = append(prompts, payload.Prompt)
+					mu.Unlock()
+				}
+				return next(ctx, evt)
+			}
+		}},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if err := rt.Steer("s", "use pnpm"); !errors.Is(err, ErrNoActiveRun) {
+		t.Fatalf("expected ErrNoActiveRun without a run, got %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := rt.Run(context.Background(), Request{Prompt: "install deps", SessionID: "s"})
+		done <- err
+	}()
+	<-gate.started
+	if err := rt.Steer("s", "  "); err == nil {
+		t.Fatal("expected empty steering message to be rejected")
+	}
+	if err := rt.Steer("s", "use pnpm, not npm"); err != nil {
+		t.Fatalf("steer: %v", err)
+	}
+	close(gate.release)
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if len(mdl.requests) != 2 {
+		t.Fatalf("expected two model calls, got %d", len(mdl.requests))
+	}
+	msgs := mdl.requests[1].Messages
+	if len(msgs) != 4 {
+		t.Fatalf("expected prompt, tool call, tool result and steering message, got %+v", msgs)
+	}
+	if msgs[2].Role != "tool" || msgs[3].Role != "user" || msgs[3].Content != "use pnpm, not npm" {
+		t.Fatalf("steering message should follow the tool result, got %+v", msgs)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(prompts) != 2 || prompts[1] != "use pnpm, not npm" {
+		t.Fatalf("expected UserPromptSubmit for the steering message, got %v", prompts)
+	}
+}
+
+// steeringModel calls onCall before answering each request.
+type steeringModel struct {
+	stubModel
+	onCall func(n int)
+}
+
+func (s *steeringModel) Complete(ctx context.Context, req model.Request) (*model.Response, error) {
+	s.onCall(len(s.requests) + 1)
+	return s.stubModel.Complete(ctx, req)
+}
+
+func (s *steeringModel) CompleteStream(ctx context.Context, req model.Request, cb model.StreamHandler) error {
+	resp, err := s.Complete(ctx, req)
+	if err != nil {
+		return err
+	}
+	return cb(model.StreamResult{Final: true, Response: resp})
+}
+
+func TestSteerDuringFinalTurnKeepsRunGoing(t *testing.T) {
+	mdl := &steeringModel{stubModel: stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "ran npm install"}},
+		{Message: model.Message{Role: "assistant", Content: "reran with pnpm"}},
+	}}}
+	rt := newConcurrentRuntime(t, mdl)
+	mdl.onCall = func(n int) {
+		if n == 1 {
+			if err := rt.Steer("s", "use pnpm"); err != nil {
+				t.Errorf("steer: %v", err)
+			}
+		}
+	}
+
+	stream, err := rt.RunStream(context.Background(), Request{Prompt: "install deps", SessionID: "s"})
+	if err != nil {
+		t.Fatalf("run stream: %v", err)
+	}
+	events := drainStream(t, stream)
+	if msg, ok := findStreamError(events); ok {
+		t.Fatalf("unexpected error event %q", msg)
+	}
+	var applied []StreamEvent
+	for _, evt := range events {
+		if evt.Type == EventSteeringApplied {
+			applied = append(applied, evt)
+		}
+	}
+	if len(applied) != 1 || applied[0].Output != "use pnpm" || applied[0].SessionID != "s" {
+		t.Fatalf("expected one steering_applied event, got %+v", applied)
+	}
+
+	msgs := mdl.requests[1].Messages
+	if n := len(msgs); n != 3 || msgs[1].Content != "ran npm install" || msgs[2].Content != "use pnpm" {
+		t.Fatalf("expected the final turn to be followed by the steering message, got %+v", msgs)
+	}
+}
+
+func TestSteerAfterBudgetExhaustedStopsRun(t *testing.T) {
+	mdl := &steeringModel{stubModel: stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "ran npm install"}},
+		{Message: model.Message{Role: "assistant", Content: "reran with pnpm"}},
+	}}}
+	var rt *Runtime
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:         newClaudeProject(t),
+		Model:               mdl,
+		EnabledBuiltinTools: []string{},
+		RulesEnabled:        ptrBool(false),
+		RuntimeBudget:       Budget{MaxOutputTokens: 100},
+		HookMiddleware: []coremw.Middleware{func(next coremw.Handler) coremw.Handler {
+			return func(ctx context.Context, evt coreevents.Event) error {
+				if payload, ok := evt.Payload.(coreevents.UserPromptPayload); ok && payload.Prompt == "use pnpm" {
+					// Another run spends the runtime budget while this one
+					// takes the steering message.
+					rt.budgets.mu.Lock()
+					rt.budgets.total.output = 100
+					rt.budgets.mu.Unlock()
+				}
+				return next(ctx, evt)
+			}
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	mdl.onCall = func(n int) {
+		if n == 1 {
+			if err := rt.Steer("s", "use pnpm"); err != nil {
+				t.Errorf("steer: %v", err)
+			}
+		}
+	}
+
+	_, err = rt.Run(context.Background(), Request{Prompt: "install deps", SessionID: "s"})
+	var exceeded *BudgetExceededError
+	if !errors.As(err, &exceeded) || exceeded.Scope != BudgetScopeRuntime {
+		t.Fatalf("expected runtime budget error, got %v", err)
+	}
+	if len(mdl.requests) != 1 {
+		t.Fatalf("expected no model call after the budget ran out, got %d", len(mdl.requests))
+	}
+	if exceeded.Partial == nil || exceeded.Partial.Result == nil || exceeded.Partial.Result.Output != "ran npm install" {
+		t.Fatalf("expected the last answer as partial output, got %+v", exceeded.Partial)
+	}
+}
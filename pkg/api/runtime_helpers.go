@@ -1,7 +1,6 @@
 package api
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -83,11 +82,34 @@ func convertMessages(msgs []message.Message) []model.Message {
 			ContentBlocks:    convertContentBlocksToModel(msg.ContentBlocks),
 			ToolCalls:        convertToolCalls(msg.ToolCalls),
 			ReasoningContent: msg.ReasoningContent,
+			ThinkingBlocks:   convertThinkingBlocksToModel(msg.ThinkingBlocks),
 		})
 	}
 	return out
 }
 
+func convertThinkingBlocksToModel(blocks []message.ThinkingBlock) []model.ThinkingBlock {
+	if len(blocks) == 0 {
+		return nil
+	}
+	out := make([]model.ThinkingBlock, len(blocks))
+	for i, b := range blocks {
+		out[i] = model.ThinkingBlock{Text: b.Text, Signature: b.Signature, Redacted: b.Redacted}
+	}
+	return out
+}
+
+func convertThinkingBlocksFromModel(blocks []model.ThinkingBlock) []message.ThinkingBlock {
+	if len(blocks) == 0 {
+		return nil
+	}
+	out := make([]message.ThinkingBlock, len(blocks))
+	for i, b := range blocks {
+		out[i] = message.ThinkingBlock{Text: b.Text, Signature: b.Signature, Redacted: b.Redacted}
+	}
+	return out
+}
+
 func convertContentBlocksToModel(blocks []message.ContentBlock) []model.ContentBlock {
 	if len(blocks) == 0 {
 		return nil
@@ -404,6 +426,7 @@ type historyStore struct {
 	maxSize  int
 	onEvict  func(string)
 	loader   func(string) ([]message.Message, error)
+	counter  message.TokenCounter
 }
 
 func newHistoryStore(maxSize int) *historyStore {
@@ -428,7 +451,7 @@ func (s *historyStore) Get(id string) *message.History {
 		s.mu.Unlock()
 		return hist
 	}
-	hist := message.NewHistory()
+	hist := message.NewHistoryWithCounter(s.counter)
 	s.data[id] = hist
 	s.lastUsed[id] = now
 	onEvict := s.onEvict
@@ -474,6 +497,28 @@ func (s *historyStore) evictOldest() string {
 	return oldestKey
 }
 
+// Peek returns the history of id without loading or touching it, or nil when
+// it is not held in memory.
+func (s *historyStore) Peek(id string) *message.History {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data[id]
+}
+
+// Delete drops id from memory.
+func (s *historyStore) Delete(id string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, id)
+	delete(s.lastUsed, id)
+}
+
 func (s *historyStore) SessionIDs() []string {
 	if s == nil {
 		return nil
@@ -548,47 +593,3 @@ func snapshotSandbox(mgr *sandbox.Manager) SandboxReport {
 	}
 	return SandboxReport{ResourceLimits: mgr.Limits()}
 }
-
-type sessionGate struct {
-	gates sync.Map // map[string]chan struct{}
-}
-
-func newSessionGate() *sessionGate {
-	return &sessionGate{}
-}
-
-func (g *sessionGate) Acquire(ctx context.Context, sessionID string) error {
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	for {
-		gate := make(chan struct{})
-		existing, loaded := g.gates.LoadOrStore(sessionID, gate)
-		if !loaded {
-			if err := ctx.Err(); err != nil {
-				g.Release(sessionID)
-				return err
-			}
-			return nil
-		}
-
-		held := existing.(chan struct{}) //nolint:errcheck // sync.Map guarantees type safety for stored values
-		select {
-		case <-held:
-			continue
-		case <-ctx.Done():
-			return ctx.Err()
-		}
-	}
-}
-
-func (g *sessionGate) Release(sessionID string) {
-	if g == nil {
-		return
-	}
-	existing, ok := g.gates.LoadAndDelete(sessionID)
-	if !ok {
-		return
-	}
-	close(existing.(chan struct{})) //nolint:errcheck // sync.Map guarantees type safety for stored values
-}
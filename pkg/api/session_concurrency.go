@@ -0,0 +1,268 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// SessionConcurrency selects what happens when a run arrives for a session
+// that is already running.
+type SessionConcurrency string
+
+const (
+	// SessionConcurrencyWait is the default: the new run waits for the session
+	// until its context ends and then fails with ErrConcurrentExecution.
+	SessionConcurrencyWait SessionConcurrency = ""
+	// SessionConcurrencyReject fails the new run with ErrConcurrentExecution
+	// immediately.
+	SessionConcurrencyReject SessionConcurrency = "reject"
+	// SessionConcurrencyQueue queues the new run in a bounded FIFO of
+	// Options.SessionQueueSize entries. A full queue fails with
+	// ErrSessionQueueFull.
+	SessionConcurrencyQueue SessionConcurrency = "queue"
+	// SessionConcurrencyInterrupt cancels the in-flight run, records its
+	// partial assistant turn as interrupted and starts the new run once the
+	// session is released. Runs still waiting are superseded as well.
+	SessionConcurrencyInterrupt SessionConcurrency = "interrupt"
+
+	defaultSessionQueueSize = 8
+
+	// StopReasonInterrupted marks a run cancelled by a newer run on its session.
+	StopReasonInterrupted = "interrupted"
+	// interruptedMarker closes the partial assistant turn of an interrupted run.
+	interruptedMarker = "[Request interrupted by user]"
+)
+
+var (
+	// ErrSessionQueueFull is returned when a queued session already holds
+	// Options.SessionQueueSize waiting runs.
+	ErrSessionQueueFull = errors.New("api: session queue is full")
+	// ErrRunInterrupted is returned by a run cancelled by a newer run on the
+	// same session in SessionConcurrencyInterrupt mode.
+	ErrRunInterrupted = errors.New("api: run interrupted by a newer request")
+)
+
+func (m SessionConcurrency) validate() error {
+	switch m {
+	case SessionConcurrencyWait, SessionConcurrencyReject, SessionConcurrencyQueue, SessionConcurrencyInterrupt:
+		return nil
+	}
+	return fmt.Errorf("api: unknown session concurrency mode %q", m)
+}
+
+// SessionQueueStats reports session concurrency activity.
+type SessionQueueStats struct {
+	// Depth is the number of runs waiting per busy session.
+	Depth map[string]int
+	// MaxDepth is the deepest any session queue has been.
+	MaxDepth int
+	// Queued counts runs that had to wait, Rejected runs turned away by the
+	// reject mode or a full queue, Interrupted in-flight runs cancelled by a
+	// newer one.
+	Queued      int64
+	Rejected    int64
+	Interrupted int64
+}
+
+// SessionQueueStats returns a snapshot of the per-session run queues.
+func (rt *Runtime) SessionQueueStats() SessionQueueStats {
+	if rt == nil || rt.sessionGate == nil {
+		return SessionQueueStats{}
+	}
+	return rt.sessionGate.stats()
+}
+
+// acquireSession admits a run on sessionID according to
+// Options.SessionConcurrency. It returns the context the run must use, which
+// an interrupting run cancels with ErrRunInterrupted, and the func releasing
+// the session.
+func (rt *Runtime) acquireSession(ctx context.Context, sessionID string) (context.Context, func(), error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	runCtx, cancel := context.WithCancelCause(ctx)
+	req := gateRequest{wait: true, limit: -1, cancel: cancel}
+	mode := rt.opts.SessionConcurrency
+	switch mode {
+	case SessionConcurrencyReject:
+		req.wait = false
+	case SessionConcurrencyQueue:
+		req.limit = rt.opts.SessionQueueSize
+		if req.limit <= 0 {
+			req.limit = defaultSessionQueueSize
+		}
+	case SessionConcurrencyInterrupt:
+		req.interrupt = true
+	}
+	if err := rt.sessionGate.acquire(ctx, sessionID, req); err != nil {
+		cancel(nil)
+		switch {
+		case errors.Is(err, ErrSessionQueueFull), errors.Is(err, ErrRunInterrupted):
+			return nil, nil, err
+		case mode == SessionConcurrencyQueue || mode == SessionConcurrencyInterrupt:
+			return nil, nil, fmt.Errorf("%w: %w", ErrConcurrentExecution, err)
+		}
+		return nil, nil, ErrConcurrentExecution
+	}
+	return runCtx, func() {
+		cancel(nil)
+		rt.sessionGate.Release(sessionID)
+	}, nil
+}
+
+// sessionGate serialises runs per session. Waiters are admitted in FIFO
+// order; an entry stays in gates while its session is held.
+type sessionGate struct {
+	mu    sync.Mutex
+	gates sync.Map // map[string]*sessionSlot
+
+	maxDepth    int
+	queued      int64
+	rejected    int64
+	interrupted int64
+}
+
+type sessionSlot struct {
+	// cancel stops the run holding the session; nil for holders that cannot
+	// be interrupted.
+	cancel  context.CancelCauseFunc
+	waiters []*gateWaiter
+}
+
+type gateWaiter struct {
+	ready  chan struct{}
+	err    error
+	cancel context.CancelCauseFunc
+}
+
+type gateRequest struct {
+	// wait queues behind the holder instead of failing immediately.
+	wait bool
+	// limit caps the waiters ahead of this request; negative is unbounded.
+	limit int
+	// interrupt cancels the holder and supersedes earlier waiters.
+	interrupt bool
+	cancel    context.CancelCauseFunc
+}
+
+var errSessionBusy = errors.New("api: session busy")
+
+func newSessionGate() *sessionGate {
+	return &sessionGate{}
+}
+
+// Acquire waits until sessionID is free or ctx ends.
+func (g *sessionGate) Acquire(ctx context.Context, sessionID string) error {
+	return g.acquire(ctx, sessionID, gateRequest{wait: true, limit: -1})
+}
+
+func (g *sessionGate) acquire(ctx context.Context, sessionID string, req gateRequest) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	existing, held := g.gates.Load(sessionID)
+	if !held {
+		g.gates.Store(sessionID, &sessionSlot{cancel: req.cancel})
+		g.mu.Unlock()
+		return nil
+	}
+	slot := existing.(*sessionSlot) //nolint:errcheck // sync.Map guarantees type safety for stored values
+	switch {
+	case !req.wait:
+		g.rejected++
+		g.mu.Unlock()
+		return errSessionBusy
+	case req.interrupt:
+		for _, w := range slot.waiters {
+			w.err = ErrRunInterrupted
+			close(w.ready)
+		}
+		slot.waiters = nil
+		if slot.cancel != nil {
+			slot.cancel(ErrRunInterrupted)
+			slot.cancel = nil
+			g.interrupted++
+		}
+	case req.limit >= 0 && len(slot.waiters) >= req.limit:
+		g.rejected++
+		g.mu.Unlock()
+		return ErrSessionQueueFull
+	}
+	w := &gateWaiter{ready: make(chan struct{}), cancel: req.cancel}
+	slot.waiters = append(slot.waiters, w)
+	g.queued++
+	g.maxDepth = max(g.maxDepth, len(slot.waiters))
+	g.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+	case <-ctx.Done():
+	}
+	g.mu.Lock()
+	admitted := !slot.remove(w) && w.err == nil
+	g.mu.Unlock()
+	if admitted {
+		// Ownership was handed over while ctx ended; pass it on.
+		g.Release(sessionID)
+	}
+	return ctx.Err()
+}
+
+// Release frees sessionID or hands it to the next waiter.
+func (g *sessionGate) Release(sessionID string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	existing, ok := g.gates.Load(sessionID)
+	if !ok {
+		return
+	}
+	slot := existing.(*sessionSlot) //nolint:errcheck // sync.Map guarantees type safety for stored values
+	if len(slot.waiters) == 0 {
+		g.gates.Delete(sessionID)
+		return
+	}
+	next := slot.waiters[0]
+	slot.waiters = slot.waiters[1:]
+	slot.cancel = next.cancel
+	close(next.ready)
+}
+
+func (g *sessionGate) stats() SessionQueueStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := SessionQueueStats{
+		Depth:       map[string]int{},
+		MaxDepth:    g.maxDepth,
+		Queued:      g.queued,
+		Rejected:    g.rejected,
+		Interrupted: g.interrupted,
+	}
+	g.gates.Range(func(key, value any) bool {
+		if n := len(value.(*sessionSlot).waiters); n > 0 { //nolint:errcheck // sync.Map guarantees type safety for stored values
+			out.Depth[key.(string)] = n //nolint:errcheck // keys are session IDs
+		}
+		return true
+	})
+	return out
+}
+
+func (s *sessionSlot) remove(w *gateWaiter) bool {
+	for i, candidate := range s.waiters {
+		if candidate == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
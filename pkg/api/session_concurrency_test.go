@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+func newModeRuntime(t *testing.T, mdl model.Model, mode SessionConcurrency, queueSize int) *Runtime {
+	t.Helper()
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:        newClaudeProject(t),
+		Model:              mdl,
+		SessionConcurrency: mode,
+		SessionQueueSize:   queueSize,
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	return rt
+}
+
+func TestSessionConcurrencyRejectFailsImmediately(t *testing.T) {
+	mdl := newBlockingModel()
+	rt := newModeRuntime(t, mdl, SessionConcurrencyReject, 0)
+
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := rt.Run(context.Background(), Request{Prompt: "first", SessionID: "s"})
+		firstDone <- err
+	}()
+	waitSignals(t, mdl.started, 1)
+
+	start := time.Now()
+	if _, err := rt.Run(context.Background(), Request{Prompt: "second", SessionID: "s"}); !errors.Is(err, ErrConcurrentExecution) {
+		t.Fatalf("expected ErrConcurrentExecution, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("reject mode must not wait for the session")
+	}
+	if stats := rt.SessionQueueStats(); stats.Rejected != 1 || stats.Queued != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	mdl.Unblock()
+	if err := <-firstDone; err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+}
+
+func TestSessionConcurrencyQueueIsBounded(t *testing.T) {
+	mdl := newBlockingModel()
+	rt := newModeRuntime(t, mdl, SessionConcurrencyQueue, 1)
+
+	results := make(chan error, 2)
+	go func() {
+		_, err := rt.Run(context.Background(), Request{Prompt: "first", SessionID: "s"})
+		results <- err
+	}()
+	waitSignals(t, mdl.started, 1)
+	go func() {
+		_, err := rt.Run(context.Background(), Request{Prompt: "second", SessionID: "s"})
+		results <- err
+	}()
+	waitFor(t, func() bool { return rt.SessionQueueStats().Depth["s"] == 1 })
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "third", SessionID: "s"}); !errors.Is(err, ErrSessionQueueFull) {
+		t.Fatalf("expected ErrSessionQueueFull, got %v", err)
+	}
+	mdl.Unblock()
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("queued run failed: %v", err)
+		}
+	}
+	stats := rt.SessionQueueStats()
+	if stats.Queued != 1 || stats.Rejected != 1 || stats.MaxDepth != 1 || len(stats.Depth) != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if _, ok := rt.sessionGate.gates.Load("s"); ok {
+		t.Fatalf("gate entry leaked")
+	}
+}
+
+func TestSessionGateAdmitsWaitersInOrder(t *testing.T) {
+	gate := newSessionGate()
+	if err := gate.Acquire(context.Background(), "s"); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := gate.Acquire(context.Background(), "s"); err != nil {
+				t.Errorf("waiter %d: %v", i, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			gate.Release("s")
+		}(i)
+		waitFor(t, func() bool { return gate.stats().Depth["s"] == i+1 })
+	}
+	gate.Release("s")
+	wg.Wait()
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Fatalf("expected FIFO admission, got %v", order)
+	}
+}
+
+// interruptibleModel streams a partial answer on the first call and then
+// blocks until its context ends; later calls answer immediately.
+type interruptibleModel struct {
+	mu       sync.Mutex
+	calls    int
+	requests []model.Request
+	started  chan struct{}
+}
+
+func (m *interruptibleModel) Complete(ctx context.Context, req model.Request) (*model.Response, error) {
+	var resp *model.Response
+	err := m.CompleteStream(ctx, req, func(sr model.StreamResult) error {
+		if sr.Final {
+			resp = sr.Response
+		}
+		return nil
+	})
+	return resp, err
+}
+
+func (m *interruptibleModel) CompleteStream(ctx context.Context, req model.Request, cb model.StreamHandler) error {
+	m.mu.Lock()
+	m.calls++
+	call := m.calls
+	m.requests = append(m.requests, req)
+	m.mu.Unlock()
+	if call == 1 {
+		if err := cb(model.StreamResult{Delta: "Here is the first half"}); err != nil {
+			return err
+		}
+		close(m.started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return cb(model.StreamResult{Final: true, Response: &model.Response{Message: model.Message{Role: "assistant", Content: "fresh answer"}}})
+}
+
+func TestSessionConcurrencyInterruptRecordsPartialTurn(t *testing.T) {
+	mdl := &interruptibleModel{started: make(chan struct{})}
+	rt := newModeRuntime(t, mdl, SessionConcurrencyInterrupt, 0)
+
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := rt.Run(context.Background(), Request{Prompt: "long question", SessionID: "s"})
+		firstDone <- err
+	}()
+	<-mdl.started
+
+	resp, err := rt.Run(context.Background(), Request{Prompt: "never mind", SessionID: "s"})
+	if err != nil {
+		t.Fatalf("interrupting run: %v", err)
+	}
+	if resp.Result.Output != "fresh answer" {
+		t.Fatalf("unexpected output %q", resp.Result.Output)
+	}
+	if err := <-firstDone; !errors.Is(err, ErrRunInterrupted) {
+		t.Fatalf("expected ErrRunInterrupted, got %v", err)
+	}
+
+	msgs := mdl.requests[1].Messages
+	if len(msgs) != 3 {
+		t.Fatalf("expected user, interrupted assistant and new user messages, got %+v", msgs)
+	}
+	interrupted := msgs[1]
+	if interrupted.Role != "assistant" || !strings.HasPrefix(interrupted.Content, "Here is the first half") || !strings.HasSuffix(interrupted.Content, interruptedMarker) {
+		t.Fatalf("unexpected interrupted turn %+v", interrupted)
+	}
+	if stats := rt.SessionQueueStats(); stats.Interrupted != 1 {
+		t.Fatalf("expected one interruption, got %+v", stats)
+	}
+}
+
+func TestSessionConcurrencyRejectsUnknownMode(t *testing.T) {
+	_, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: &stubModel{}, SessionConcurrency: "drop"})
+	if err == nil || !strings.Contains(err.Error(), "session concurrency") {
+		t.Fatalf("expected unknown mode error, got %v", err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
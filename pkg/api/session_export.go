@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+// ExportFormat selects the output of ExportSession.
+type ExportFormat string
+
+const (
+	// ExportMarkdown renders a readable transcript.
+	ExportMarkdown ExportFormat = "markdown"
+	// ExportAnthropic renders the system and messages fields of an Anthropic
+	// Messages API request.
+	ExportAnthropic ExportFormat = "anthropic"
+	// ExportOpenAI renders the messages field of an OpenAI Chat Completions
+	// request.
+	ExportOpenAI ExportFormat = "openai"
+)
+
+// ExportSession renders the messages of sessionID in format. An empty format
+// means ExportMarkdown.
+func (rt *Runtime) ExportSession(ctx context.Context, sessionID string, format ExportFormat) ([]byte, error) {
+	info, msgs, err := rt.sessionRecord(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	switch format {
+	case ExportMarkdown, "":
+		return renderSessionMarkdown(info, msgs), nil
+	case ExportAnthropic:
+		data, err = model.EncodeAnthropicMessages(convertMessages(msgs))
+	case ExportOpenAI:
+		data, err = model.EncodeOpenAIMessages(convertMessages(msgs))
+	default:
+		return nil, fmt.Errorf("api: unsupported export format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return nil, fmt.Errorf("api: indent export: %w", err)
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// renderSessionMarkdown renders a readable transcript of msgs.
+func renderSessionMarkdown(info SessionInfo, msgs []message.Message) []byte {
+	var b strings.Builder
+	title := info.Title
+	if title == "" {
+		title = "Session " + info.ID
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "- Session: `%s`\n", info.ID)
+	if !info.CreatedAt.IsZero() {
+		fmt.Fprintf(&b, "- Created: %s\n", info.CreatedAt.UTC().Format(time.RFC3339))
+	}
+	if !info.UpdatedAt.IsZero() {
+		fmt.Fprintf(&b, "- Updated: %s\n", info.UpdatedAt.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "- Messages: %d\n", len(msgs))
+	if info.InputTokens > 0 || info.OutputTokens > 0 {
+		fmt.Fprintf(&b, "- Tokens: %d in, %d out\n", info.InputTokens, info.OutputTokens)
+	}
+	if len(info.Tags) > 0 {
+		fmt.Fprintf(&b, "- Tags: %s\n", strings.Join(info.Tags, ", "))
+	}
+
+	for _, msg := range msgs {
+		if msg.Role == "tool" {
+			for _, call := range msg.ToolCalls {
+				fmt.Fprintf(&b, "\n### Tool result: `%s`\n\n", call.Name)
+				writeFenced(&b, "", call.Result)
+			}
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n", markdownRole(msg.Role))
+		if text := strings.TrimSpace(msg.Content); text != "" {
+			b.WriteString(text)
+			b.WriteString("\n")
+		}
+		for _, block := range msg.ContentBlocks {
+			switch block.Type {
+			case message.ContentBlockText:
+				b.WriteString(strings.TrimSpace(block.Text))
+				b.WriteString("\n")
+			default:
+				fmt.Fprintf(&b, "\n*[%s %s]*\n", block.Type, block.MediaType)
+			}
+		}
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(&b, "\n**Tool call** `%s`\n\n", call.Name)
+			args, err := json.MarshalIndent(call.Arguments, "", "  ")
+			if err != nil || len(call.Arguments) == 0 {
+				args = []byte("{}")
+			}
+			writeFenced(&b, "json", string(args))
+		}
+	}
+	return []byte(b.String())
+}
+
+func markdownRole(role string) string {
+	switch role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case "system":
+		return "System"
+	}
+	return role
+}
+
+// writeFenced writes text as a code block whose fence outgrows any backtick
+// run inside it.
+func writeFenced(b *strings.Builder, lang, text string) {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	fmt.Fprintf(b, "%s%s\n%s\n%s\n", fence, lang, strings.TrimRight(text, "\n"), fence)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/google/uuid"
+)
+
+// ImportFormat names the source format of ImportSession.
+type ImportFormat string
+
+const (
+	// ImportAnthropic reads an Anthropic Messages API request body or
+	// messages array.
+	ImportAnthropic ImportFormat = "anthropic"
+	// ImportOpenAI reads an OpenAI Chat Completions request body or messages
+	// array.
+	ImportOpenAI ImportFormat = "openai"
+	// ImportClaudeCode reads a Claude Code session log from
+	// ~/.claude/projects.
+	ImportClaudeCode ImportFormat = "claude-code"
+)
+
+// missingToolResult answers imported tool calls whose result the source did
+// not record, so the conversation stays valid for providers.
+const missingToolResult = `{"error":"tool result missing from imported conversation"}`
+
+// abandonedToolResult answers the tool calls of a suspended run when a new
+// prompt arrives before Resume.
+const abandonedToolResult = `{"error":"tool call abandoned: the run awaiting approval was not resumed"}`
+
+// ImportSession seeds a session with a conversation recorded elsewhere and
+// returns its ID; an empty sessionID generates one. The history is stored
+// like any other session, so the next Run on the ID continues the imported
+// conversation. Importing into a session that already exists fails.
+func (rt *Runtime) ImportSession(ctx context.Context, sessionID string, format ImportFormat, data []byte) (string, error) {
+	msgs, err := importMessages(format, data)
+	if err != nil {
+		return "", err
+	}
+	if len(msgs) == 0 {
+		return "", errors.New("api: imported conversation has no messages")
+	}
+	msgs = answerDanglingToolCalls(msgs, missingToolResult)
+
+	if strings.TrimSpace(sessionID) == "" {
+		sessionID = uuid.New().String()
+	}
+	sessionID, err = rt.beginSessionOp(sessionID)
+	if err != nil {
+		return "", err
+	}
+	defer rt.endRun()
+
+	if err := rt.sessionGate.Acquire(ctx, sessionID); err != nil {
+		return "", err
+	}
+	defer rt.sessionGate.Release(sessionID)
+
+	if _, _, err := rt.sessionRecord(ctx, sessionID); err == nil {
+		return "", fmt.Errorf("api: session %q already exists", sessionID)
+	} else if !errors.Is(err, ErrSessionNotFound) {
+		return "", err
+	}
+
+	rt.histories.Get(sessionID).Replace(msgs)
+	meta := rt.recordSession(sessionID, msgs)
+	if _, err := rt.saveHistory(sessionID, msgs); err != nil {
+		return "", err
+	}
+	if rt.historyPersister != nil {
+		if err := rt.historyPersister.SetMetadata(ctx, sessionID, meta); err != nil {
+			return "", err
+		}
+	}
+	return sessionID, nil
+}
+
+func importMessages(format ImportFormat, data []byte) ([]message.Message, error) {
+	switch format {
+	case ImportAnthropic:
+		return message.ImportAnthropicMessages(data)
+	case ImportOpenAI:
+		return message.ImportOpenAIMessages(data)
+	case ImportClaudeCode:
+		return message.ImportClaudeCodeTranscript(bytes.NewReader(data))
+	}
+	return nil, fmt.Errorf("api: unsupported import format %q", format)
+}
+
+// answerDanglingToolCalls answers every tool call the conversation never
+// answered, such as the last call of an interrupted session, with result.
+// The synthetic results join the tool message holding the call's other
+// results, ahead of any user turn such as the images a tool returned, as
+// Anthropic expects every result right after the tool_use turn.
+func answerDanglingToolCalls(msgs []message.Message, result string) []message.Message {
+	answered := map[string]bool{}
+	for _, msg := range msgs {
+		if msg.Role == "tool" {
+			for _, call := range msg.ToolCalls {
+				answered[call.ID] = true
+			}
+		}
+	}
+	out := make([]message.Message, 0, len(msgs))
+	var pending []message.ToolCall
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		results := make([]message.ToolCall, 0, len(pending))
+		for _, call := range pending {
+			results = append(results, message.ToolCall{ID: call.ID, Name: call.Name, Result: result})
+		}
+		if last := len(out) - 1; out[last].Role == "tool" {
+			out[last].ToolCalls = append(slices.Clone(out[last].ToolCalls), results...)
+		} else {
+			out = append(out, message.Message{Role: "tool", ToolCalls: results})
+		}
+		pending = nil
+	}
+	for _, msg := range msgs {
+		if msg.Role != "tool" {
+			flush()
+		}
+		out = append(out, msg)
+		if msg.Role == "assistant" {
+			for _, call := range msg.ToolCalls {
+				if !answered[call.ID] {
+					pending = append(pending, call)
+				}
+			}
+		}
+	}
+	flush()
+	return out
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+func TestImportSessionSeedsContinuableHistory(t *testing.T) {
+	mdl := &stubModel{responses: []*model.Response{{Message: model.Message{Role: "assistant", Content: "continuing"}}}}
+	rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdl})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	ctx := context.Background()
+
+	log := strings.Join([]string{
+		`{"type":"user","message":{"role":"user","content":"run the tests"}}`,
+		`{"type":"assistant","message":{"id":"m1","role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"go test"}}]}}`,
+	}, "\n")
+	id, err := rt.ImportSession(ctx, "", ImportClaudeCode, []byte(log))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if id == "" {
+		t.Fatal("expected a generated session id")
+	}
+	info, err := rt.Session(ctx, id)
+	if err != nil || info.Messages != 3 || info.Title != "run the tests" {
+		t.Fatalf("unexpected imported session %+v err=%v", info, err)
+	}
+	if _, err := rt.ImportSession(ctx, id, ImportClaudeCode, []byte(log)); err == nil {
+		t.Fatal("expected importing over an existing session to fail")
+	}
+
+	if _, err := rt.Run(ctx, Request{Prompt: "go on", SessionID: id}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	msgs := mdl.requests[0].Messages
+	if len(msgs) != 4 || msgs[0].Content != "run the tests" || msgs[3].Content != "go on" {
+		t.Fatalf("run should continue the imported conversation, got %+v", msgs)
+	}
+	if result := msgs[2]; result.Role != "tool" || result.ToolCalls[0].ID != "toolu_1" || result.ToolCalls[0].Result != missingToolResult {
+		t.Fatalf("expected the dangling tool call to be answered, got %+v", result)
+	}
+}
+
+func TestImportSessionAnswersPartialTurnBeforeToolImages(t *testing.T) {
+	mdl := &stubModel{responses: []*model.Response{{Message: model.Message{Role: "assistant", Content: "continuing"}}}}
+	rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdl})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	ctx := context.Background()
+
+	body := `[
+		{"role":"user","content":"take two screenshots"},
+		{"role":"assistant","content":[
+			{"type":"tool_use","id":"t1","name":"screenshot","input":{}},
+			{"type":"tool_use","id":"t2","name":"screenshot","input":{}}
+		]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":[
+			{"type":"text","text":"captured"},
+			{"type":"image","source":{"type":"base64","media_type":"image/png","data":"aGk="}}
+		]}]}
+	]`
+	id, err := rt.ImportSession(ctx, "", ImportAnthropic, []byte(body))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if _, err := rt.Run(ctx, Request{Prompt: "go on", SessionID: id}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	msgs := mdl.requests[0].Messages
+	if len(msgs) != 5 {
+		t.Fatalf("expected prompt, tool calls, results, image and new prompt, got %+v", msgs)
+	}
+	results := msgs[2]
+	if results.Role != "tool" || len(results.ToolCalls) != 2 || results.ToolCalls[0].Result != "captured" ||
+		results.ToolCalls[1].ID != "t2" || results.ToolCalls[1].Result != missingToolResult {
+		t.Fatalf("expected the synthetic result next to the real one, got %+v", results)
+	}
+	if image := msgs[3]; image.Role != "user" || len(image.ContentBlocks) != 1 || image.ContentBlocks[0].Type != model.ContentBlockImage {
+		t.Fatalf("expected the tool image after every result, got %+v", image)
+	}
+}
+
+func TestImportSessionFormats(t *testing.T) {
+	rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: &stubModel{}})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	ctx := context.Background()
+
+	if _, err := rt.ImportSession(ctx, "a", ImportAnthropic, []byte(`[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]`)); err != nil {
+		t.Fatalf("anthropic: %v", err)
+	}
+	if _, err := rt.ImportSession(ctx, "o", ImportOpenAI, []byte(`{"messages":[{"role":"user","content":"hi"}]}`)); err != nil {
+		t.Fatalf("openai: %v", err)
+	}
+	if sessions, err := rt.ListSessions(ctx, SessionFilter{}); err != nil || len(sessions) != 2 {
+		t.Fatalf("expected both imports listed, got %+v err=%v", sessions, err)
+	}
+	if _, err := rt.ImportSession(ctx, "x", "csv", []byte("a,b")); err == nil {
+		t.Fatal("expected unsupported format error")
+	}
+	if _, err := rt.ImportSession(ctx, "e", ImportOpenAI, []byte(`[]`)); err == nil {
+		t.Fatal("expected empty conversation error")
+	}
+}
@@ -0,0 +1,483 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+// SessionMetadata describes a session beyond its messages. It is kept in the
+// HistoryStore next to the history.
+type SessionMetadata struct {
+	Title string   `json:"title,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// InputTokens and OutputTokens total the usage every model call of the
+	// session reported.
+	InputTokens  int64 `json:"input_tokens,omitempty"`
+	OutputTokens int64 `json:"output_tokens,omitempty"`
+	// CostUSD totals the spend Options.CostFunc estimated for those calls.
+	CostUSD float64 `json:"cost_usd,omitempty"`
+}
+
+func (m SessionMetadata) clone() SessionMetadata {
+	m.Tags = slices.Clone(m.Tags)
+	return m
+}
+
+func (m SessionMetadata) empty() bool {
+	return m.Title == "" && len(m.Tags) == 0 && m.InputTokens == 0 && m.OutputTokens == 0 && m.CostUSD == 0
+}
+
+// SessionInfo describes a session returned by ListSessions.
+type SessionInfo struct {
+	ID           string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Messages     int
+	InputTokens  int64
+	OutputTokens int64
+	CostUSD      float64
+	Title        string
+	Tags         []string
+}
+
+func newSessionInfo(id string, created, updated time.Time, messages int, meta SessionMetadata) SessionInfo {
+	return SessionInfo{
+		ID:           id,
+		CreatedAt:    created,
+		UpdatedAt:    updated,
+		Messages:     messages,
+		InputTokens:  meta.InputTokens,
+		OutputTokens: meta.OutputTokens,
+		CostUSD:      meta.CostUSD,
+		Title:        meta.Title,
+		Tags:         slices.Clone(meta.Tags),
+	}
+}
+
+// SessionFilter narrows ListSessions. The zero value lists every session.
+type SessionFilter struct {
+	// Tags keeps sessions carrying every listed tag.
+	Tags []string
+	// Query keeps sessions whose ID or title contains it, ignoring case.
+	Query string
+	// UpdatedAfter keeps sessions updated after it.
+	UpdatedAfter time.Time
+	// Limit caps the number of sessions returned; zero means no limit.
+	Limit int
+}
+
+func (f SessionFilter) match(info SessionInfo) bool {
+	if !f.UpdatedAfter.IsZero() && !info.UpdatedAt.After(f.UpdatedAfter) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !slices.Contains(info.Tags, tag) {
+			return false
+		}
+	}
+	if q := strings.ToLower(strings.TrimSpace(f.Query)); q != "" {
+		return strings.Contains(strings.ToLower(info.ID), q) || strings.Contains(strings.ToLower(info.Title), q)
+	}
+	return true
+}
+
+// ListSessions describes the sessions in the history store and those held in
+// memory, most recently updated first.
+func (rt *Runtime) ListSessions(ctx context.Context, filter SessionFilter) ([]SessionInfo, error) {
+	if rt == nil {
+		return nil, ErrRuntimeClosed
+	}
+	byID := map[string]SessionInfo{}
+	if rt.historyPersister != nil {
+		infos, err := rt.historyPersister.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			byID[info.SessionID] = newSessionInfo(info.SessionID, info.CreatedAt, info.UpdatedAt, info.Messages, info.Metadata)
+		}
+	}
+	for _, info := range rt.sessions.list() {
+		if _, stored := byID[info.ID]; !stored {
+			byID[info.ID] = info
+		}
+	}
+
+	out := make([]SessionInfo, 0, len(byID))
+	for _, info := range byID {
+		if filter.match(info) {
+			out = append(out, info)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].UpdatedAt.Equal(out[j].UpdatedAt) {
+			return out[i].UpdatedAt.After(out[j].UpdatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	if filter.Limit > 0 && len(out) > filter.Limit {
+		out = out[:filter.Limit]
+	}
+	return out, nil
+}
+
+// Session describes sessionID or returns ErrSessionNotFound.
+func (rt *Runtime) Session(ctx context.Context, sessionID string) (SessionInfo, error) {
+	info, _, err := rt.sessionRecord(ctx, sessionID)
+	return info, err
+}
+
+// TagSession adds tags to sessionID.
+func (rt *Runtime) TagSession(ctx context.Context, sessionID string, tags ...string) error {
+	return rt.updateSessionMetadata(ctx, sessionID, func(meta *SessionMetadata) {
+		for _, tag := range tags {
+			if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(meta.Tags, tag) {
+				meta.Tags = append(meta.Tags, tag)
+			}
+		}
+	})
+}
+
+// RenameSession sets the title of sessionID.
+func (rt *Runtime) RenameSession(ctx context.Context, sessionID, title string) error {
+	return rt.updateSessionMetadata(ctx, sessionID, func(meta *SessionMetadata) {
+		meta.Title = strings.TrimSpace(title)
+	})
+}
+
+// DeleteSession removes sessionID from memory, the history store and the
+// transcript directory, resets its session budget and drops its suspended
+// runs, whose tokens stop resuming. It waits for an in-flight run of the
+// session to finish first. Deleting an unknown session is not an error.
+func (rt *Runtime) DeleteSession(ctx context.Context, sessionID string) error {
+	sessionID, err := rt.beginSessionOp(sessionID)
+	if err != nil {
+		return err
+	}
+	defer rt.endRun()
+
+	if err := rt.sessionGate.Acquire(ctx, sessionID); err != nil {
+		return err
+	}
+	defer rt.sessionGate.Release(sessionID)
+
+	rt.histories.Delete(sessionID)
+	rt.sessions.remove(sessionID)
+	rt.forgetStoredHistory(sessionID)
+	rt.budgets.forget(sessionID)
+	cleanupToolOutputSessionDir(sessionID) //nolint:errcheck
+	var errs []error
+	if err := newSuspendedRunStore(rt.opts.ProjectRoot).DeleteSession(sessionID); err != nil {
+		errs = append(errs, err)
+	}
+	if rt.historyPersister != nil {
+		if err := rt.historyPersister.Delete(ctx, sessionID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if rt.transcripts != nil {
+		if err := rt.transcripts.Delete(sessionID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sessionRecord reads sessionID from the history store, falling back to the
+// copy held in memory.
+func (rt *Runtime) sessionRecord(ctx context.Context, sessionID string) (SessionInfo, []message.Message, error) {
+	if rt == nil {
+		return SessionInfo{}, nil, ErrRuntimeClosed
+	}
+	sessionID = strings.TrimSpace(sessionID)
+	if rt.historyPersister != nil {
+		rec, found, err := rt.historyPersister.Load(ctx, sessionID)
+		if err != nil {
+			return SessionInfo{}, nil, err
+		}
+		if found {
+			return newSessionInfo(sessionID, rec.CreatedAt, rec.UpdatedAt, len(rec.Messages), rec.Metadata), rec.Messages, nil
+		}
+	}
+	if info, ok := rt.sessions.info(sessionID); ok {
+		if history := rt.histories.Peek(sessionID); history != nil {
+			return info, history.All(), nil
+		}
+	}
+	return SessionInfo{}, nil, fmt.Errorf("%w: %q", ErrSessionNotFound, sessionID)
+}
+
+func (rt *Runtime) updateSessionMetadata(ctx context.Context, sessionID string, update func(*SessionMetadata)) error {
+	if rt == nil {
+		return ErrRuntimeClosed
+	}
+	sessionID = strings.TrimSpace(sessionID)
+	if _, ok := rt.sessions.info(sessionID); !ok {
+		if rt.historyPersister == nil {
+			return fmt.Errorf("%w: %q", ErrSessionNotFound, sessionID)
+		}
+		rec, found, err := rt.historyPersister.Load(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: %q", ErrSessionNotFound, sessionID)
+		}
+		rt.sessions.adopt(sessionID, rec)
+	}
+	meta := rt.sessions.update(sessionID, update)
+	if rt.historyPersister == nil {
+		return nil
+	}
+	if err := rt.historyPersister.SetMetadata(ctx, sessionID, meta); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		// A session not stored yet picks the metadata up when its run ends.
+		return err
+	}
+	return nil
+}
+
+// recordSession notes a finished run of sessionID holding msgs and returns
+// the metadata to store. The first run titles the session.
+func (rt *Runtime) recordSession(sessionID string, msgs []message.Message) SessionMetadata {
+	meta, untitled := rt.sessions.touch(sessionID, len(msgs))
+	if !untitled {
+		return meta
+	}
+	title := promptTitle(msgs)
+	if title != "" {
+		meta = rt.sessions.update(sessionID, func(m *SessionMetadata) { m.Title = title })
+	}
+	if rt.opts.AutoTitleSessions {
+		if mdl := rt.opts.ModelPool[ModelTierLow]; mdl != nil && rt.beginRun() == nil {
+			go func() {
+				defer rt.endRun()
+				rt.generateTitle(mdl, sessionID, title, msgs)
+			}()
+		}
+	}
+	return meta
+}
+
+const (
+	sessionTitleTimeout = 30 * time.Second
+	maxTitleRunes       = 60
+	maxTitleExcerpt     = 2000
+)
+
+const sessionTitlePrompt = "Write a title of at most six words for the conversation below. Reply with the title only, without quotes or punctuation at the end."
+
+// generateTitle asks mdl for a title and applies it unless the session was
+// renamed since it got fallback.
+func (rt *Runtime) generateTitle(mdl model.Model, sessionID, fallback string, msgs []message.Message) {
+	var excerpt strings.Builder
+	for _, msg := range msgs {
+		if (msg.Role != "user" && msg.Role != "assistant") || strings.TrimSpace(msg.Content) == "" {
+			continue
+		}
+		fmt.Fprintf(&excerpt, "%s: %s\n", msg.Role, strings.TrimSpace(msg.Content))
+		if excerpt.Len() >= maxTitleExcerpt {
+			break
+		}
+	}
+	if excerpt.Len() == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sessionTitleTimeout)
+	defer cancel()
+	resp, err := mdl.Complete(ctx, model.Request{
+		System:    sessionTitlePrompt,
+		Messages:  []model.Message{{Role: "user", Content: truncateRunes(excerpt.String(), maxTitleExcerpt)}},
+		MaxTokens: 32,
+	})
+	if err != nil || resp == nil {
+		log.Printf("api: title session %q: %v", sessionID, err)
+		return
+	}
+	title := truncateRunes(strings.Trim(strings.Join(strings.Fields(resp.Message.Content), " "), `"'.`), maxTitleRunes)
+	if title == "" {
+		return
+	}
+	applied := false
+	meta := rt.sessions.update(sessionID, func(m *SessionMetadata) {
+		if m.Title == fallback {
+			m.Title, applied = title, true
+		}
+	})
+	if applied && rt.historyPersister != nil {
+		if err := rt.historyPersister.SetMetadata(ctx, sessionID, meta); err != nil {
+			log.Printf("api: store session title %q: %v", sessionID, err)
+		}
+	}
+}
+
+// promptTitle titles a session after its first user prompt.
+func promptTitle(msgs []message.Message) string {
+	for _, msg := range msgs {
+		if msg.Role != "user" {
+			continue
+		}
+		if text := strings.Join(strings.Fields(msg.Content), " "); text != "" {
+			return truncateRunes(text, maxTitleRunes)
+		}
+	}
+	return ""
+}
+
+func truncateRunes(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:limit-1])) + "…"
+}
+
+// pruneHistoryStore deletes the sessions of store not updated within
+// retainDays.
+func pruneHistoryStore(ctx context.Context, store HistoryStore, retainDays int) error {
+	if store == nil || retainDays <= 0 {
+		return nil
+	}
+	infos, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().AddDate(0, 0, -retainDays)
+	var errs []error
+	for _, info := range infos {
+		if !info.UpdatedAt.IsZero() && info.UpdatedAt.Before(cutoff) {
+			if err := store.Delete(ctx, info.SessionID); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sessionIndex keeps the metadata of the sessions held in memory. Entries
+// leave with their history when the session is evicted.
+type sessionIndex struct {
+	mu      sync.Mutex
+	entries map[string]*sessionEntry
+}
+
+type sessionEntry struct {
+	createdAt time.Time
+	updatedAt time.Time
+	messages  int
+	meta      SessionMetadata
+	// titled is set once the session got its first title attempt.
+	titled bool
+}
+
+func newSessionIndex() *sessionIndex {
+	return &sessionIndex{entries: map[string]*sessionEntry{}}
+}
+
+// entryLocked returns the entry of sessionID, creating it when missing.
+func (s *sessionIndex) entryLocked(sessionID string) *sessionEntry {
+	entry, ok := s.entries[sessionID]
+	if !ok {
+		now := time.Now().UTC()
+		entry = &sessionEntry{createdAt: now, updatedAt: now}
+		s.entries[sessionID] = entry
+	}
+	return entry
+}
+
+// adopt replaces the entry of sessionID with what the history store holds.
+func (s *sessionIndex) adopt(sessionID string, rec HistoryRecord) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entryLocked(sessionID)
+	entry.createdAt, entry.updatedAt = rec.CreatedAt, rec.UpdatedAt
+	entry.messages = len(rec.Messages)
+	entry.meta = rec.Metadata.clone()
+	entry.titled = entry.titled || rec.Metadata.Title != ""
+}
+
+// touch records a run of sessionID and reports whether the session still
+// needs a title.
+func (s *sessionIndex) touch(sessionID string, messages int) (SessionMetadata, bool) {
+	if s == nil {
+		return SessionMetadata{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entryLocked(sessionID)
+	entry.updatedAt = time.Now().UTC()
+	entry.messages = messages
+	untitled := !entry.titled && entry.meta.Title == ""
+	entry.titled = true
+	return entry.meta.clone(), untitled
+}
+
+func (s *sessionIndex) addUsage(sessionID string, usage model.Usage, cost float64) {
+	if s == nil || strings.TrimSpace(sessionID) == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entryLocked(sessionID)
+	entry.meta.InputTokens += int64(usage.InputTokens)
+	entry.meta.OutputTokens += int64(usage.OutputTokens)
+	entry.meta.CostUSD += cost
+}
+
+func (s *sessionIndex) update(sessionID string, update func(*SessionMetadata)) SessionMetadata {
+	if s == nil {
+		return SessionMetadata{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entryLocked(sessionID)
+	update(&entry.meta)
+	return entry.meta.clone()
+}
+
+func (s *sessionIndex) remove(sessionID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, sessionID)
+}
+
+func (s *sessionIndex) info(sessionID string) (SessionInfo, bool) {
+	if s == nil {
+		return SessionInfo{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[sessionID]
+	if !ok {
+		return SessionInfo{}, false
+	}
+	return newSessionInfo(sessionID, entry.createdAt, entry.updatedAt, entry.messages, entry.meta), true
+}
+
+func (s *sessionIndex) list() []SessionInfo {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]SessionInfo, 0, len(s.entries))
+	for id, entry := range s.entries {
+		out = append(out, newSessionInfo(id, entry.createdAt, entry.updatedAt, entry.messages, entry.meta))
+	}
+	return out
+}
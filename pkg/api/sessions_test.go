@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/tool"
+)
+
+func TestListSessionsFiltersInMemorySessions(t *testing.T) {
+	mdl := &stubModel{responses: []*model.Response{usageResponse("done", 10, 3)}}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot: newClaudeProjectWithSettings(t, `{"cleanupPeriodDays":0}`),
+		Model:       mdl,
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	ctx := context.Background()
+
+	if _, err := rt.Run(ctx, Request{Prompt: "Fix   the login\nbug", SessionID: "s1"}); err != nil {
+		t.Fatalf("run s1: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := rt.Run(ctx, Request{Prompt: "Write docs", SessionID: "s2"}); err != nil {
+		t.Fatalf("run s2: %v", err)
+	}
+	if err := rt.TagSession(ctx, "s2", "docs", " docs ", ""); err != nil {
+		t.Fatalf("tag: %v", err)
+	}
+	if err := rt.TagSession(ctx, "missing", "x"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got %v", err)
+	}
+
+	all, err := rt.ListSessions(ctx, SessionFilter{})
+	if err != nil || len(all) != 2 {
+		t.Fatalf("list: %+v err=%v", all, err)
+	}
+	if all[0].ID != "s2" || all[1].ID != "s1" {
+		t.Fatalf("expected most recently updated first, got %s, %s", all[0].ID, all[1].ID)
+	}
+	s1 := all[1]
+	if s1.Title != "Fix the login bug" || s1.Messages != 2 || s1.InputTokens != 10 || s1.OutputTokens != 3 || s1.CreatedAt.IsZero() {
+		t.Fatalf("unexpected session info %+v", s1)
+	}
+	if got := all[0].Tags; len(got) != 1 || got[0] != "docs" {
+		t.Fatalf("expected one docs tag, got %v", got)
+	}
+
+	for _, tc := range []struct {
+		filter SessionFilter
+		want   string
+	}{
+		{SessionFilter{Tags: []string{"docs"}}, "s2"},
+		{SessionFilter{Query: "LOGIN"}, "s1"},
+		{SessionFilter{UpdatedAfter: s1.UpdatedAt}, "s2"},
+		{SessionFilter{Limit: 1}, "s2"},
+	} {
+		got, err := rt.ListSessions(ctx, tc.filter)
+		if err != nil || len(got) != 1 || got[0].ID != tc.want {
+			t.Fatalf("filter %+v: got %+v err=%v", tc.filter, got, err)
+		}
+	}
+}
+
+func TestSessionMetadataSharedThroughHistoryStore(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	newReplica := func() *Runtime {
+		rt, err := New(context.Background(), Options{
+			ProjectRoot:  newClaudeProject(t),
+			Model:        &stubModel{responses: []*model.Response{usageResponse("ok", 7, 2)}},
+			HistoryStore: store,
+		})
+		if err != nil {
+			t.Fatalf("runtime: %v", err)
+		}
+		t.Cleanup(func() { _ = rt.Close() })
+		return rt
+	}
+	rtA, rtB := newReplica(), newReplica()
+	ctx := context.Background()
+
+	if _, err := rtA.Run(ctx, Request{Prompt: "plan the release", SessionID: "s"}); err != nil {
+		t.Fatalf("run A: %v", err)
+	}
+	if err := rtB.RenameSession(ctx, "s", "Release plan"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if err := rtB.TagSession(ctx, "s", "release"); err != nil {
+		t.Fatalf("tag: %v", err)
+	}
+	if _, err := rtA.Run(ctx, Request{Prompt: "and the changelog", SessionID: "s"}); err != nil {
+		t.Fatalf("second run A: %v", err)
+	}
+
+	info, err := rtB.Session(ctx, "s")
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	if info.Title != "Release plan" || len(info.Tags) != 1 || info.Messages != 4 || info.InputTokens != 14 || info.OutputTokens != 4 {
+		t.Fatalf("metadata set on B should survive the next run on A, got %+v", info)
+	}
+	if _, err := rtB.Session(ctx, "missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestDeleteSessionRemovesPersistedState(t *testing.T) {
+	root := newClaudeProject(t)
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: &stubModel{responses: []*model.Response{usageResponse("ok", 1, 1)}}})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	ctx := context.Background()
+
+	if _, err := rt.Run(ctx, Request{Prompt: "hello", SessionID: "gone"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	historyPath := PersistedHistoryFilePath(root, "gone")
+	transcriptPath := filepath.Join(root, ".claude", "transcripts", "gone.jsonl")
+	for _, path := range []string{historyPath, transcriptPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected %s before delete: %v", path, err)
+		}
+	}
+
+	if err := rt.DeleteSession(ctx, "gone"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	for _, path := range []string{historyPath, transcriptPath} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected %s removed, got %v", path, err)
+		}
+	}
+	if sessions, err := rt.ListSessions(ctx, SessionFilter{}); err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no sessions, got %+v err=%v", sessions, err)
+	}
+	if rt.histories.Peek("gone") != nil {
+		t.Fatal("expected history dropped from memory")
+	}
+	if err := rt.DeleteSession(ctx, "gone"); err != nil {
+		t.Fatalf("deleting twice should succeed, got %v", err)
+	}
+}
+
+func TestExportSessionFormats(t *testing.T) {
+	mdl := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", ToolCalls: []model.ToolCall{{ID: "c1", Name: "echo", Arguments: map[string]any{"text": "```hi```"}}}}},
+		{Message: model.Message{Role: "assistant", Content: "echoed"}},
+	}}
+	rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdl, Tools: []tool.Tool{&echoTool{}}})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	ctx := context.Background()
+	if _, err := rt.Run(ctx, Request{Prompt: "echo please", SessionID: "s"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	md, err := rt.ExportSession(ctx, "s", ExportMarkdown)
+	if err != nil {
+		t.Fatalf("markdown: %v", err)
+	}
+	for _, want := range []string{"# echo please", "### User\n\necho please", "**Tool call** `echo`", "````json", "### Tool result: `echo`", "### Assistant\n\nechoed"} {
+		if !strings.Contains(string(md), want) {
+			t.Fatalf("markdown missing %q:\n%s", want, md)
+		}
+	}
+
+	anthropic, err := rt.ExportSession(ctx, "s", ExportAnthropic)
+	if err != nil {
+		t.Fatalf("anthropic: %v", err)
+	}
+	var anthropicDoc struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Type string `json:"type"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(anthropic, &anthropicDoc); err != nil || len(anthropicDoc.Messages) != 4 {
+		t.Fatalf("unexpected anthropic export %s err=%v", anthropic, err)
+	}
+	if got := anthropicDoc.Messages[1].Content[0].Type; got != "tool_use" {
+		t.Fatalf("expected tool_use block, got %q", got)
+	}
+
+	openai, err := rt.ExportSession(ctx, "s", ExportOpenAI)
+	if err != nil {
+		t.Fatalf("openai: %v", err)
+	}
+	var openaiDoc struct {
+		Messages []struct {
+			Role       string `json:"role"`
+			ToolCallID string `json:"tool_call_id"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(openai, &openaiDoc); err != nil || len(openaiDoc.Messages) != 4 {
+		t.Fatalf("unexpected openai export %s err=%v", openai, err)
+	}
+	if msg := openaiDoc.Messages[2]; msg.Role != "tool" || msg.ToolCallID != "c1" {
+		t.Fatalf("expected tool message, got %+v", msg)
+	}
+
+	if _, err := rt.ExportSession(ctx, "s", "yaml"); err == nil {
+		t.Fatal("expected unsupported format error")
+	}
+	if _, err := rt.ExportSession(ctx, "missing", ExportMarkdown); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestAutoTitleSessionsUsesLowTierModel(t *testing.T) {
+	low := &stubModel{responses: []*model.Response{{Message: model.Message{Role: "assistant", Content: "\"Login Bug Fix.\""}}}}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:       newClaudeProject(t),
+		Model:             &stubModel{responses: []*model.Response{{Message: model.Message{Role: "assistant", Content: "fixed"}}}},
+		ModelPool:         map[ModelTier]model.Model{ModelTierLow: low},
+		AutoTitleSessions: true,
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	ctx := context.Background()
+
+	if _, err := rt.Run(ctx, Request{Prompt: "users cannot log in after the upgrade", SessionID: "s"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	waitFor(t, func() bool {
+		info, err := rt.Session(ctx, "s")
+		return err == nil && info.Title == "Login Bug Fix"
+	})
+	if _, err := rt.Run(ctx, Request{Prompt: "thanks", SessionID: "s"}); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if err := rt.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if len(low.requests) != 1 || !strings.Contains(low.requests[0].Messages[0].Content, "users cannot log in") {
+		t.Fatalf("expected one title request with the conversation, got %+v", low.requests)
+	}
+}
+
+func TestPruneHistoryStoreDropsStaleSessions(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileHistoryStore(dir)
+	ctx := context.Background()
+	if _, err := store.Append(ctx, "fresh", 0, []message.Message{{Role: "user", Content: "hi"}}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	stale := persistedHistory{Version: 1, Revision: 3, SessionID: "stale", UpdatedAt: time.Now().AddDate(0, 0, -10)}
+	if err := writePersistedHistory(dir, store.filePath("stale"), stale); err != nil {
+		t.Fatalf("write stale: %v", err)
+	}
+
+	if err := pruneHistoryStore(ctx, store, 7); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	infos, err := store.List(ctx)
+	if err != nil || len(infos) != 1 || infos[0].SessionID != "fresh" {
+		t.Fatalf("expected only the fresh session to remain, got %+v err=%v", infos, err)
+	}
+}
@@ -1,11 +1,14 @@
 package api
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/cexll/agentsdk-go/pkg/config"
+	"github.com/cexll/agentsdk-go/pkg/model"
 )
 
 func TestLoadSettingsMergesOverridesAndInitialisesEnv(t *testing.T) {
@@ -122,3 +125,49 @@ func TestLoadSettingsErrorsOnMissingExplicitOverlay(t *testing.T) {
 		t.Fatal("expected loadSettings to fail for missing overlay")
 	}
 }
+
+func TestNewAppliesAWSCredentialScriptsToBedrockProvider(t *testing.T) {
+	root := newClaudeProjectWithSettings(t, `{"awsCredentialExport":"aws configure export-credentials","awsAuthRefresh":"aws sso login"}`)
+	provider := &model.BedrockProvider{Region: "us-west-2", AuthRefresh: "custom-login"}
+
+	rt, err := New(context.Background(), Options{ProjectRoot: root, ModelFactory: provider})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if provider.CredentialExport != "aws configure export-credentials" {
+		t.Fatalf("expected awsCredentialExport from settings, got %q", provider.CredentialExport)
+	}
+	if provider.AuthRefresh != "custom-login" {
+		t.Fatalf("expected explicit auth refresh to win, got %q", provider.AuthRefresh)
+	}
+}
+
+func TestNewAppliesAPIKeyHelperToProvider(t *testing.T) {
+	root := newClaudeProjectWithSettings(t, `{"apiKeyHelper":"echo sk-from-vault","env":{"CLAUDE_CODE_API_KEY_HELPER_TTL_MS":"60000"}}`)
+	provider := &model.AnthropicProvider{}
+
+	rt, err := New(context.Background(), Options{ProjectRoot: root, ModelFactory: provider})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if provider.APIKeyHelper == nil {
+		t.Fatal("expected apiKeyHelper from settings")
+	}
+	if provider.APIKeyHelper.Command != "echo sk-from-vault" || provider.APIKeyHelper.TTL != time.Minute {
+		t.Fatalf("unexpected helper %q ttl=%s", provider.APIKeyHelper.Command, provider.APIKeyHelper.TTL)
+	}
+}
+
+func TestAPIKeyHelperTTL(t *testing.T) {
+	t.Setenv(apiKeyHelperTTLEnv, "1500")
+	if got := apiKeyHelperTTL(nil); got != 1500*time.Millisecond {
+		t.Fatalf("expected process env ttl, got %s", got)
+	}
+	if got := apiKeyHelperTTL(map[string]string{apiKeyHelperTTLEnv: "bogus"}); got != 0 {
+		t.Fatalf("expected default for invalid ttl, got %s", got)
+	}
+}
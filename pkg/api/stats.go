@@ -14,6 +14,7 @@ type TokenStats struct {
 	TotalTokens   int64     `json:"total_tokens"`
 	CacheCreation int64     `json:"cache_creation_input_tokens,omitempty"`
 	CacheRead     int64     `json:"cache_read_input_tokens,omitempty"`
+	CostUSD       float64   `json:"cost_usd,omitempty"` // Estimated from the runtime pricing registry.
 	Model         string    `json:"model"`
 	SessionID     string    `json:"session_id"`
 	RequestID     string    `json:"request_id"`
@@ -28,20 +29,36 @@ type SessionTokenStats struct {
 	TotalTokens  int64                  `json:"total_tokens"`
 	CacheCreated int64                  `json:"cache_created,omitempty"`
 	CacheRead    int64                  `json:"cache_read,omitempty"`
+	TotalCostUSD float64                `json:"total_cost_usd,omitempty"`
 	ByModel      map[string]*ModelStats `json:"by_model,omitempty"`
-	RequestCount int                    `json:"request_count"`
-	FirstRequest time.Time              `json:"first_request"`
-	LastRequest  time.Time              `json:"last_request"`
+	// RequestCount counts Run/RunStream requests; ModelCalls counts the
+	// model calls they made, one per agent iteration.
+	RequestCount int       `json:"request_count"`
+	ModelCalls   int       `json:"model_calls"`
+	FirstRequest time.Time `json:"first_request"`
+	LastRequest  time.Time `json:"last_request"`
+
+	// CacheHitRate is the share of prompt cache tokens read from the cache,
+	// CacheRead / (CacheRead + CacheCreated); CacheMissRate is the share
+	// written to it. Both stay zero until a request uses the cache.
+	CacheHitRate  float64 `json:"cache_hit_rate,omitempty"`
+	CacheMissRate float64 `json:"cache_miss_rate,omitempty"`
+
+	lastRequestID string
 }
 
 // ModelStats aggregates token usage for a specific model.
 type ModelStats struct {
-	InputTokens   int64 `json:"input_tokens"`
-	OutputTokens  int64 `json:"output_tokens"`
-	TotalTokens   int64 `json:"total_tokens"`
-	CacheCreation int64 `json:"cache_creation_input_tokens,omitempty"`
-	CacheRead     int64 `json:"cache_read_input_tokens,omitempty"`
-	RequestCount  int   `json:"request_count"`
+	InputTokens   int64   `json:"input_tokens"`
+	OutputTokens  int64   `json:"output_tokens"`
+	TotalTokens   int64   `json:"total_tokens"`
+	CacheCreation int64   `json:"cache_creation_input_tokens,omitempty"`
+	CacheRead     int64   `json:"cache_read_input_tokens,omitempty"`
+	CostUSD       float64 `json:"cost_usd,omitempty"`
+	RequestCount  int     `json:"request_count"`
+	ModelCalls    int     `json:"model_calls"`
+
+	lastRequestID string
 }
 
 // TokenCallback is called synchronously after token usage is recorded.
@@ -96,10 +113,18 @@ func (t *tokenTracker) Record(stats TokenStats) {
 	session.TotalTokens += stats.TotalTokens
 	session.CacheCreated += stats.CacheCreation
 	session.CacheRead += stats.CacheRead
-	session.RequestCount++
+	session.TotalCostUSD += stats.CostUSD
+	session.ModelCalls++
+	// Calls of one request share its ID; an empty ID counts as a request.
+	newRequest := stats.RequestID == "" || stats.RequestID != session.lastRequestID
+	session.lastRequestID = stats.RequestID
+	if newRequest {
+		session.RequestCount++
+	}
 	session.LastRequest = stats.Timestamp
 
 	// Update per-model stats for session
+	var newModelRequest bool
 	if stats.Model != "" {
 		modelStats, ok := session.ByModel[stats.Model]
 		if !ok {
@@ -111,7 +136,13 @@ func (t *tokenTracker) Record(stats TokenStats) {
 		modelStats.TotalTokens += stats.TotalTokens
 		modelStats.CacheCreation += stats.CacheCreation
 		modelStats.CacheRead += stats.CacheRead
-		modelStats.RequestCount++
+		modelStats.CostUSD += stats.CostUSD
+		modelStats.ModelCalls++
+		newModelRequest = stats.RequestID == "" || stats.RequestID != modelStats.lastRequestID
+		modelStats.lastRequestID = stats.RequestID
+		if newModelRequest {
+			modelStats.RequestCount++
+		}
 	}
 
 	// Update global total
@@ -120,7 +151,11 @@ func (t *tokenTracker) Record(stats TokenStats) {
 	t.total.TotalTokens += stats.TotalTokens
 	t.total.CacheCreated += stats.CacheCreation
 	t.total.CacheRead += stats.CacheRead
-	t.total.RequestCount++
+	t.total.TotalCostUSD += stats.CostUSD
+	t.total.ModelCalls++
+	if newRequest {
+		t.total.RequestCount++
+	}
 	if t.total.FirstRequest.IsZero() {
 		t.total.FirstRequest = stats.Timestamp
 	}
@@ -138,7 +173,11 @@ func (t *tokenTracker) Record(stats TokenStats) {
 		modelStats.TotalTokens += stats.TotalTokens
 		modelStats.CacheCreation += stats.CacheCreation
 		modelStats.CacheRead += stats.CacheRead
-		modelStats.RequestCount++
+		modelStats.CostUSD += stats.CostUSD
+		modelStats.ModelCalls++
+		if newModelRequest {
+			modelStats.RequestCount++
+		}
 	}
 
 	cb = t.callback
@@ -198,10 +237,16 @@ func copySessionStats(s *SessionTokenStats) *SessionTokenStats {
 		TotalTokens:  s.TotalTokens,
 		CacheCreated: s.CacheCreated,
 		CacheRead:    s.CacheRead,
+		TotalCostUSD: s.TotalCostUSD,
 		RequestCount: s.RequestCount,
+		ModelCalls:   s.ModelCalls,
 		FirstRequest: s.FirstRequest,
 		LastRequest:  s.LastRequest,
 	}
+	if cached := s.CacheRead + s.CacheCreated; cached > 0 {
+		cp.CacheHitRate = float64(s.CacheRead) / float64(cached)
+		cp.CacheMissRate = float64(s.CacheCreated) / float64(cached)
+	}
 	if len(s.ByModel) > 0 {
 		cp.ByModel = make(map[string]*ModelStats, len(s.ByModel))
 		for k, v := range s.ByModel {
@@ -211,7 +256,9 @@ func copySessionStats(s *SessionTokenStats) *SessionTokenStats {
 				TotalTokens:   v.TotalTokens,
 				CacheCreation: v.CacheCreation,
 				CacheRead:     v.CacheRead,
+				CostUSD:       v.CostUSD,
 				RequestCount:  v.RequestCount,
+				ModelCalls:    v.ModelCalls,
 			}
 		}
 	}
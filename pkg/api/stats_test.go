@@ -54,6 +54,9 @@ func TestTokenTracker_RecordAndGetStats(t *testing.T) {
 	if s.CacheCreated != 2 || s.CacheRead != 1 {
 		t.Fatalf("unexpected cache totals: %+v", s)
 	}
+	if s.CacheHitRate != 1.0/3 || s.CacheMissRate != 2.0/3 {
+		t.Fatalf("unexpected cache rates: hit=%v miss=%v", s.CacheHitRate, s.CacheMissRate)
+	}
 	if s.RequestCount != 3 {
 		t.Fatalf("unexpected request count: %d", s.RequestCount)
 	}
@@ -92,11 +95,40 @@ func TestTokenTracker_RecordAndGetStats(t *testing.T) {
 		t.Fatalf("expected stats to be copied, got %+v", again)
 	}
 
+	tr.Record(TokenStats{InputTokens: 4, TotalTokens: 4, SessionID: "uncached", Timestamp: base})
+	if u := tr.GetSessionStats("uncached"); u.CacheHitRate != 0 || u.CacheMissRate != 0 {
+		t.Fatalf("expected no cache rates without cache traffic, got %+v", u)
+	}
+
 	if tr.GetSessionStats("missing") != nil {
 		t.Fatalf("expected nil for missing session")
 	}
 }
 
+func TestTokenTracker_CountsRequestsAndModelCalls(t *testing.T) {
+	tr := newTokenTracker(true, nil)
+	for _, rec := range []struct{ model, request string }{
+		{"m1", "r1"},
+		{"m1", "r1"},
+		{"m2", "r1"},
+		{"m1", "r2"},
+	} {
+		tr.Record(TokenStats{InputTokens: 1, TotalTokens: 1, Model: rec.model, SessionID: "s1", RequestID: rec.request})
+	}
+
+	for _, s := range []*SessionTokenStats{tr.GetSessionStats("s1"), tr.GetTotalStats()} {
+		if s.RequestCount != 2 || s.ModelCalls != 4 {
+			t.Fatalf("expected 2 requests and 4 model calls, got %+v", s)
+		}
+		if m1 := s.ByModel["m1"]; m1.RequestCount != 2 || m1.ModelCalls != 3 {
+			t.Fatalf("unexpected m1 stats: %+v", m1)
+		}
+		if m2 := s.ByModel["m2"]; m2.RequestCount != 1 || m2.ModelCalls != 1 {
+			t.Fatalf("unexpected m2 stats: %+v", m2)
+		}
+	}
+}
+
 func TestTokenTracker_ConcurrencySafety(t *testing.T) {
 	tr := newTokenTracker(true, nil)
 	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
@@ -27,6 +27,8 @@ const (
 	EventToolExecutionStart  = "tool_execution_start"
 	EventToolExecutionOutput = "tool_execution_output"
 	EventToolExecutionResult = "tool_execution_result"
+	EventRunSuspended        = "run_suspended"
+	EventSteeringApplied     = "steering_applied"
 	EventError               = "error"
 )
 
@@ -64,7 +66,7 @@ type Message struct {
 
 // ContentBlock carries either text segments or tool invocation details.
 type ContentBlock struct {
-	Type  string          `json:"type,omitempty"`  // Type is "text" or "tool_use".
+	Type  string          `json:"type,omitempty"`  // Type is "text", "thinking" or "tool_use".
 	Text  string          `json:"text,omitempty"`  // Text contains streamed text when Type == "text".
 	ID    string          `json:"id,omitempty"`    // ID uniquely names the content block.
 	Name  string          `json:"name,omitempty"`  // Name describes the tool/function when Type == "tool_use".
@@ -73,8 +75,9 @@ type ContentBlock struct {
 
 // Delta models incremental updates for content blocks or message-level stop data.
 type Delta struct {
-	Type        string          `json:"type,omitempty"`         // Type is "text_delta" or "input_json_delta".
+	Type        string          `json:"type,omitempty"`         // Type is "text_delta", "thinking_delta" or "input_json_delta".
 	Text        string          `json:"text,omitempty"`         // Text contains the appended text fragment when Type == "text_delta".
+	Thinking    string          `json:"thinking,omitempty"`     // Thinking contains the appended reasoning fragment when Type == "thinking_delta".
 	PartialJSON json.RawMessage `json:"partial_json,omitempty"` // PartialJSON carries incremental JSON payload slices for tools.
 	StopReason  string          `json:"stop_reason,omitempty"`  // StopReason explains why a message terminated.
 }
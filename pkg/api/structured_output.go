@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/tool"
+)
+
+// FinalAnswerToolName is the synthetic tool the model calls to deliver a
+// structured answer when Request.OutputSchema is set.
+const FinalAnswerToolName = "final_answer"
+
+const defaultOutputRetries = 2
+
+// ErrStructuredOutput reports that the model did not produce an answer
+// matching Request.OutputSchema within the allowed retries.
+var ErrStructuredOutput = errors.New("api: structured output does not match schema")
+
+// structuredOutput drives the final_answer contract for a single run: it
+// exposes the synthetic tool, validates candidate answers and tracks retries.
+type structuredOutput struct {
+	schema    *tool.JSONSchema
+	validator tool.Validator
+	retries   int
+	attempts  int
+	value     map[string]any
+}
+
+func newStructuredOutput(schema *tool.JSONSchema, retries int) *structuredOutput {
+	if schema == nil {
+		return nil
+	}
+	if retries <= 0 {
+		retries = defaultOutputRetries
+	}
+	return &structuredOutput{schema: schema, validator: tool.DefaultValidator{}, retries: retries}
+}
+
+func validateOutputSchema(schema *tool.JSONSchema) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Type != "" && schema.Type != "object" {
+		return fmt.Errorf("api: output schema must describe an object, got %q", schema.Type)
+	}
+	return nil
+}
+
+func (s *structuredOutput) toolDefinition() model.ToolDefinition {
+	params := schemaToMap(s.schema)
+	if params == nil {
+		params = map[string]any{}
+	}
+	params["type"] = "object"
+	return model.ToolDefinition{
+		Name:        FinalAnswerToolName,
+		Description: "Submit the final answer. Call this exactly once when the task is complete; the arguments must match the required output schema.",
+		Parameters:  params,
+	}
+}
+
+func (s *structuredOutput) instructions() string {
+	return fmt.Sprintf("When you have finished, deliver your answer by calling the %s tool with arguments that match its schema. Do not reply with plain text as the final answer.", FinalAnswerToolName)
+}
+
+// resolve inspects a completed assistant message. ok reports an accepted
+// answer; otherwise feedback explains the rejection while retries remain and
+// ErrStructuredOutput is returned once they are exhausted. Messages that only
+// call other tools are not final: ok is false and feedback is empty. call is
+// the final_answer invocation, when the model made one.
+func (s *structuredOutput) resolve(assistant message.Message) (ok bool, call *message.ToolCall, feedback string, err error) {
+	for i := range assistant.ToolCalls {
+		if assistant.ToolCalls[i].Name == FinalAnswerToolName {
+			call = &assistant.ToolCalls[i]
+			break
+		}
+	}
+	if call == nil && len(assistant.ToolCalls) > 0 {
+		return false, nil, "", nil
+	}
+
+	var (
+		candidate map[string]any
+		problem   error
+	)
+	if call != nil {
+		candidate = call.Arguments
+	} else {
+		candidate, problem = decodeJSONAnswer(assistant.Content)
+		if problem != nil {
+			problem = fmt.Errorf("no %s call and the reply is not a JSON object: %w", FinalAnswerToolName, problem)
+		}
+	}
+	if problem == nil {
+		problem = s.validator.Validate(candidate, s.schema)
+	}
+	if problem == nil {
+		s.value = candidate
+		return true, call, "", nil
+	}
+
+	s.attempts++
+	if s.attempts > s.retries {
+		return false, call, "", fmt.Errorf("%w: %v", ErrStructuredOutput, problem)
+	}
+	feedback = fmt.Sprintf("The final answer was rejected: %v. Call the %s tool again with arguments that satisfy the schema.", problem, FinalAnswerToolName)
+	return false, call, feedback, nil
+}
+
+// raw returns the accepted answer encoded as JSON.
+func (s *structuredOutput) raw() string {
+	if s == nil || s.value == nil {
+		return ""
+	}
+	data, err := json.Marshal(s.value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func decodeJSONAnswer(text string) (map[string]any, error) {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "```") {
+		trimmed = strings.TrimPrefix(trimmed, "```json")
+		trimmed = strings.TrimPrefix(trimmed, "```")
+		trimmed = strings.TrimSuffix(strings.TrimSpace(trimmed), "```")
+	}
+	var out map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(trimmed)), &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TypedResponse pairs the runtime response with the decoded structured answer.
+type TypedResponse[T any] struct {
+	*Response
+	Value T
+}
+
+// RunTyped runs req with an OutputSchema derived from T and decodes the
+// validated final answer into T. A schema already set on req takes precedence.
+func RunTyped[T any](ctx context.Context, rt *Runtime, req Request) (*TypedResponse[T], error) {
+	if rt == nil {
+		return nil, ErrRuntimeClosed
+	}
+	if req.OutputSchema == nil {
+		schema, err := SchemaFor[T]()
+		if err != nil {
+			return nil, err
+		}
+		req.OutputSchema = schema
+	}
+	resp, err := rt.Run(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	out := &TypedResponse[T]{Response: resp}
+	if resp == nil || resp.Result == nil || resp.Result.Structured == nil {
+		return out, fmt.Errorf("%w: no structured answer returned", ErrStructuredOutput)
+	}
+	data, err := json.Marshal(resp.Result.Structured)
+	if err != nil {
+		return out, fmt.Errorf("api: encode structured output: %w", err)
+	}
+	if err := json.Unmarshal(data, &out.Value); err != nil {
+		return out, fmt.Errorf("api: decode structured output: %w", err)
+	}
+	return out, nil
+}
+
+// SchemaFor derives a JSON Schema from the Go type T, which must be a struct
+// or pointer to struct. Field names follow encoding/json tags; fields that are
+// neither pointers nor tagged omitempty are required. A `desc` struct tag is
+// copied into the property description.
+func SchemaFor[T any]() (*tool.JSONSchema, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("api: output type must be a struct, got %s", typ)
+	}
+	def := schemaForType(typ, map[reflect.Type]bool{})
+	props, _ := def["properties"].(map[string]any)
+	required, _ := def["required"].([]string)
+	return &tool.JSONSchema{Type: "object", Properties: props, Required: required}, nil
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+func schemaForType(typ reflect.Type, seen map[reflect.Type]bool) map[string]any {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	switch {
+	case typ == timeType:
+		return map[string]any{"type": "string"}
+	case typ == rawMessageType:
+		return map[string]any{}
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string"}
+		}
+		return map[string]any{"type": "array", "items": schemaForType(typ.Elem(), seen)}
+	case reflect.Map:
+		return map[string]any{"type": "object"}
+	case reflect.Struct:
+		if seen[typ] {
+			// Recursive types are left open rather than expanded forever.
+			return map[string]any{"type": "object"}
+		}
+		seen[typ] = true
+		defer delete(seen, typ)
+		props := map[string]any{}
+		var required []string
+		collectStructFields(typ, seen, props, &required)
+		out := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			out["required"] = required
+		}
+		return out
+	default:
+		return map[string]any{}
+	}
+}
+
+func collectStructFields(typ reflect.Type, seen map[reflect.Type]bool, props map[string]any, required *[]string) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				collectStructFields(embedded, seen, props, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		def := schemaForType(field.Type, seen)
+		if desc := strings.TrimSpace(field.Tag.Get("desc")); desc != "" {
+			def["description"] = desc
+		}
+		props[name] = def
+		optional := field.Type.Kind() == reflect.Pointer
+		for _, opt := range strings.Split(opts, ",") {
+			if opt == "omitempty" || opt == "omitzero" {
+				optional = true
+			}
+		}
+		if !optional {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/tool"
+)
+
+type weatherAnswer struct {
+	City    string   `json:"city" desc:"city name"`
+	TempC   float64  `json:"temp_c"`
+	Tags    []string `json:"tags,omitempty"`
+	Note    *string  `json:"note"`
+	private int
+}
+
+func finalAnswerResponse(id string, args map[string]any) *model.Response {
+	return &model.Response{Message: model.Message{
+		Role:      "assistant",
+		ToolCalls: []model.ToolCall{{ID: id, Name: FinalAnswerToolName, Arguments: args}},
+	}}
+}
+
+func TestSchemaForDerivesObjectSchema(t *testing.T) {
+	schema, err := SchemaFor[weatherAnswer]()
+	if err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	if schema.Type != "object" {
+		t.Fatalf("unexpected type %q", schema.Type)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"city", "temp_c"}) {
+		t.Fatalf("unexpected required %v", schema.Required)
+	}
+	city, _ := schema.Properties["city"].(map[string]any)
+	if city["type"] != "string" || city["description"] != "city name" {
+		t.Fatalf("unexpected city schema %+v", city)
+	}
+	tags, _ := schema.Properties["tags"].(map[string]any)
+	if tags["type"] != "array" {
+		t.Fatalf("unexpected tags schema %+v", tags)
+	}
+	if _, ok := schema.Properties["private"]; ok {
+		t.Fatalf("unexported fields must be skipped")
+	}
+
+	if _, err := SchemaFor[string](); err == nil {
+		t.Fatalf("expected error for non-struct type")
+	}
+}
+
+func TestRunTypedDecodesFinalAnswer(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &stubModel{responses: []*model.Response{
+		finalAnswerResponse("call_1", map[string]any{"city": "Paris", "temp_c": 21.5}),
+	}}
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: mdl})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	resp, err := RunTyped[weatherAnswer](context.Background(), rt, Request{Prompt: "weather?", SessionID: "typed"})
+	if err != nil {
+		t.Fatalf("run typed: %v", err)
+	}
+	if resp.Value.City != "Paris" || resp.Value.TempC != 21.5 {
+		t.Fatalf("unexpected value %+v", resp.Value)
+	}
+	if !strings.Contains(resp.Result.Output, `"city":"Paris"`) {
+		t.Fatalf("expected JSON output, got %q", resp.Result.Output)
+	}
+
+	req := mdl.requests[0]
+	var found bool
+	for _, def := range req.Tools {
+		if def.Name == FinalAnswerToolName {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("final_answer tool not offered: %+v", req.Tools)
+	}
+}
+
+func TestStructuredOutputRepromptsWithValidationErrors(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &stubModel{responses: []*model.Response{
+		finalAnswerResponse("call_1", map[string]any{"city": "Paris"}),
+		{Message: model.Message{Role: "assistant", Content: "it is sunny"}},
+		{Message: model.Message{Role: "assistant", Content: "```json\n{\"city\":\"Paris\",\"temp_c\":20}\n```"}},
+	}}
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: mdl})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	schema, err := SchemaFor[weatherAnswer]()
+	if err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	resp, err := rt.Run(context.Background(), Request{Prompt: "weather?", SessionID: "retry", OutputSchema: schema})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if resp.Result.Structured["temp_c"] != float64(20) {
+		t.Fatalf("unexpected structured %+v", resp.Result.Structured)
+	}
+	if len(mdl.requests) != 3 {
+		t.Fatalf("expected 3 model calls, got %d", len(mdl.requests))
+	}
+
+	second := mdl.requests[1].Messages
+	last := second[len(second)-1]
+	if last.Role != "tool" || !strings.Contains(last.ToolCalls[0].Result, "temp_c") {
+		t.Fatalf("expected validation feedback as tool result, got %+v", last)
+	}
+	third := mdl.requests[2].Messages
+	if fb := third[len(third)-1]; fb.Role != "user" || !strings.Contains(fb.Content, "not a JSON object") {
+		t.Fatalf("expected feedback user message, got %+v", fb)
+	}
+}
+
+func TestStructuredOutputGivesUpAfterRetries(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "no json here"}},
+	}}
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: mdl})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	schema := &tool.JSONSchema{Type: "object", Required: []string{"answer"}}
+	_, err = rt.Run(context.Background(), Request{Prompt: "q", SessionID: "give-up", OutputSchema: schema, OutputRetries: 1})
+	if !errors.Is(err, ErrStructuredOutput) {
+		t.Fatalf("expected ErrStructuredOutput, got %v", err)
+	}
+	if len(mdl.requests) != 2 {
+		t.Fatalf("expected one retry, got %d calls", len(mdl.requests))
+	}
+}
+
+func TestStructuredOutputRejectsNonObjectSchema(t *testing.T) {
+	root := newClaudeProject(t)
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: &stubModel{}})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	_, err = rt.Run(context.Background(), Request{Prompt: "q", OutputSchema: &tool.JSONSchema{Type: "array"}})
+	if err == nil || !strings.Contains(err.Error(), "object") {
+		t.Fatalf("expected schema error, got %v", err)
+	}
+}
@@ -0,0 +1,520 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/agent"
+	coreevents "github.com/cexll/agentsdk-go/pkg/core/events"
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/security"
+	"github.com/cexll/agentsdk-go/pkg/tool"
+	"github.com/google/uuid"
+)
+
+// StopReasonPendingApproval is reported on Result.StopReason when a run was
+// suspended by Options.ApprovalSuspend.
+const StopReasonPendingApproval = "pending_approval"
+
+var (
+	// ErrRunNotFound reports that Resume was given an unknown or already
+	// consumed token.
+	ErrRunNotFound = errors.New("api: suspended run not found")
+	// ErrApprovalPending reports that Resume was called without a decision
+	// while the approval record is still pending.
+	ErrApprovalPending = errors.New("api: approval still pending")
+	// ErrRunStale reports that the session moved on after the run was
+	// suspended, for example because another Run answered its pending tool
+	// calls. The suspended run is discarded.
+	ErrRunStale = errors.New("api: session changed since the run was suspended")
+)
+
+// SuspendedRun describes a run stopped at a tool call awaiting approval. Pass
+// Token to Runtime.Resume, from this or another process sharing the project
+// root, to continue the same agent loop.
+type SuspendedRun struct {
+	Token     string
+	SessionID string
+	ToolName  string
+	ToolUseID string
+	Params    map[string]any
+	Approval  *security.ApprovalRecord
+}
+
+// approvalPendingError is returned by the permission resolver when
+// Options.ApprovalSuspend is set and nobody decided on the approval yet.
+type approvalPendingError struct {
+	record *security.ApprovalRecord
+	call   agent.ToolCall
+}
+
+func (e *approvalPendingError) Error() string {
+	return fmt.Sprintf("api: tool %s awaits approval %s", e.call.Name, e.record.ID)
+}
+
+func (e *approvalPendingError) Unwrap() error { return agent.ErrSuspended }
+
+// resumeApproval carries the caller's decision for the approval a resumed run
+// was suspended on. It applies once, to the first matching permission check.
+type resumeApproval struct {
+	command string
+	action  security.PermissionAction
+
+	mu   sync.Mutex
+	used bool
+}
+
+func (r *resumeApproval) take(command string) (security.PermissionAction, bool) {
+	if r == nil {
+		return "", false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.used || r.command != command {
+		return "", false
+	}
+	r.used = true
+	return r.action, true
+}
+
+const resumeApprovalCtxKey streamContextKey = "agentsdk.resume.approval"
+
+func withResumeApproval(ctx context.Context, approval *resumeApproval) context.Context {
+	if approval == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, resumeApprovalCtxKey, approval)
+}
+
+func resumeApprovalFromContext(ctx context.Context) *resumeApproval {
+	if ctx == nil {
+		return nil
+	}
+	approval, _ := ctx.Value(resumeApprovalCtxKey).(*resumeApproval)
+	return approval
+}
+
+// suspendedRunState is the on-disk form of a suspended run.
+type suspendedRunState struct {
+	Version         int                 `json:"version"`
+	Token           string              `json:"token"`
+	SessionID       string              `json:"session_id"`
+	RequestID       string              `json:"request_id,omitempty"`
+	CreatedAt       time.Time           `json:"created_at"`
+	Request         suspendedRequest    `json:"request"`
+	ApprovalID      string              `json:"approval_id,omitempty"`
+	ApprovalCommand string              `json:"approval_command,omitempty"`
+	Pending         []suspendedToolCall `json:"pending"`
+	Messages        []message.Message   `json:"messages,omitempty"`
+}
+
+// suspendedRequest keeps the request fields that shape the agent loop; the
+// prompt was already consumed into the history.
+type suspendedRequest struct {
+	Mode              ModeContext           `json:"mode"`
+	Model             ModelTier             `json:"model,omitempty"`
+	EnablePromptCache *bool                 `json:"enable_prompt_cache,omitempty"`
+	Thinking          *model.ThinkingConfig `json:"thinking,omitempty"`
+	CachePolicy       *model.CachePolicy    `json:"cache_policy,omitempty"`
+	Tags              map[string]string     `json:"tags,omitempty"`
+	TargetSubagent    string                `json:"target_subagent,omitempty"`
+	ToolWhitelist     []string              `json:"tool_whitelist,omitempty"`
+	ForceSkills       []string              `json:"force_skills,omitempty"`
+	OutputSchema      *tool.JSONSchema      `json:"output_schema,omitempty"`
+	OutputRetries     int                   `json:"output_retries,omitempty"`
+	Budget            *Budget               `json:"budget,omitempty"`
+	PromptSections    []PromptSection       `json:"prompt_sections,omitempty"`
+	PromptVars        map[string]any        `json:"prompt_vars,omitempty"`
+	ModelParams       ModelParams           `json:"model_params,omitzero"`
+}
+
+type suspendedToolCall struct {
+	ID    string         `json:"id"`
+	Name  string         `json:"name"`
+	Input map[string]any `json:"input,omitempty"`
+}
+
+func (r suspendedRequest) request(sessionID, requestID string) Request {
+	return Request{
+		Mode:              r.Mode,
+		SessionID:         sessionID,
+		RequestID:         requestID,
+		Model:             r.Model,
+		EnablePromptCache: r.EnablePromptCache,
+		Thinking:          r.Thinking,
+		CachePolicy:       r.CachePolicy,
+		Tags:              r.Tags,
+		TargetSubagent:    r.TargetSubagent,
+		ToolWhitelist:     r.ToolWhitelist,
+		ForceSkills:       r.ForceSkills,
+		OutputSchema:      r.OutputSchema,
+		OutputRetries:     r.OutputRetries,
+		Budget:            r.Budget,
+		PromptSections:    r.PromptSections,
+		PromptVars:        r.PromptVars,
+		ModelParams:       r.ModelParams,
+	}
+}
+
+// suspendedRunStore keeps suspended runs under .claude/runs so another
+// process sharing the project root can resume them.
+type suspendedRunStore struct {
+	dir string
+}
+
+func newSuspendedRunStore(projectRoot string) *suspendedRunStore {
+	projectRoot = strings.TrimSpace(projectRoot)
+	if projectRoot == "" {
+		return nil
+	}
+	return &suspendedRunStore{dir: filepath.Join(projectRoot, ".claude", "runs")}
+}
+
+func (s *suspendedRunStore) filePath(token string) string {
+	token = strings.TrimSpace(token)
+	if s == nil || token == "" {
+		return ""
+	}
+	return filepath.Join(s.dir, sanitizePathComponent(token)+".json")
+}
+
+func (s *suspendedRunStore) Save(state *suspendedRunState) error {
+	path := s.filePath(state.Token)
+	if path == "" {
+		return errors.New("api: suspended run persistence is disabled")
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("mkdir runs dir: %w", err)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encode suspended run: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, sanitizePathComponent(state.Token)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp run: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write run temp: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close run temp: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename run: %w", err)
+	}
+	return nil
+}
+
+func (s *suspendedRunStore) Load(token string) (*suspendedRunState, error) {
+	path := s.filePath(token)
+	if path == "" {
+		return nil, ErrRunNotFound
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrRunNotFound
+		}
+		return nil, fmt.Errorf("read suspended run: %w", err)
+	}
+	var state suspendedRunState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("decode suspended run: %w", err)
+	}
+	return &state, nil
+}
+
+// Claim removes the stored run so that only one caller resumes it.
+func (s *suspendedRunStore) Claim(token string) error {
+	path := s.filePath(token)
+	if path == "" {
+		return ErrRunNotFound
+	}
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrRunNotFound
+		}
+		return fmt.Errorf("claim suspended run: %w", err)
+	}
+	return nil
+}
+
+// Cleanup removes suspended runs untouched for more than retainDays.
+func (s *suspendedRunStore) Cleanup(retainDays int) error {
+	if s == nil || retainDays <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read runs dir: %w", err)
+	}
+	cutoff := time.Now().AddDate(0, 0, -retainDays)
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeleteSession removes every run suspended in sessionID so its tokens can no
+// longer be resumed.
+func (s *suspendedRunStore) DeleteSession(sessionID string) error {
+	if s == nil {
+		return nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read runs dir: %w", err)
+	}
+	var errs []error
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		state, err := s.Load(strings.TrimSuffix(name, ".json"))
+		if err != nil || state.SessionID != sessionID {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("remove suspended run: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// suspendRun persists the state of a run stopped by pending and describes it
+// for the caller.
+func (rt *Runtime) suspendRun(prep preparedRun, pending *approvalPendingError) (*SuspendedRun, error) {
+	req := prep.normalized
+	state := &suspendedRunState{
+		Version:   1,
+		Token:     uuid.New().String(),
+		SessionID: req.SessionID,
+		RequestID: req.RequestID,
+		CreatedAt: time.Now().UTC(),
+		Request: suspendedRequest{
+			Mode:              req.Mode,
+			Model:             req.Model,
+			EnablePromptCache: req.EnablePromptCache,
+			Thinking:          req.Thinking,
+			CachePolicy:       req.CachePolicy,
+			Tags:              req.Tags,
+			TargetSubagent:    req.TargetSubagent,
+			ToolWhitelist:     req.ToolWhitelist,
+			ForceSkills:       req.ForceSkills,
+			OutputSchema:      req.OutputSchema,
+			OutputRetries:     req.OutputRetries,
+			Budget:            req.Budget,
+			PromptSections:    req.PromptSections,
+			PromptVars:        req.PromptVars,
+			ModelParams:       req.ModelParams,
+		},
+		ApprovalID:      pending.record.ID,
+		ApprovalCommand: pending.record.Command,
+		Messages:        prep.history.All(),
+	}
+	for _, call := range unansweredToolCalls(state.Messages) {
+		state.Pending = append(state.Pending, suspendedToolCall{ID: call.ID, Name: call.Name, Input: call.Arguments})
+	}
+	if err := newSuspendedRunStore(rt.opts.ProjectRoot).Save(state); err != nil {
+		return nil, err
+	}
+	return &SuspendedRun{
+		Token:     state.Token,
+		SessionID: state.SessionID,
+		ToolName:  pending.call.Name,
+		ToolUseID: pending.call.ID,
+		Params:    pending.call.Input,
+		Approval:  pending.record,
+	}, nil
+}
+
+// unansweredToolCalls returns the tool calls of the latest assistant turn
+// that have no tool result yet, in call order.
+func unansweredToolCalls(msgs []message.Message) []message.ToolCall {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role != "assistant" || len(msgs[i].ToolCalls) == 0 {
+			continue
+		}
+		answered := map[string]struct{}{}
+		for _, msg := range msgs[i+1:] {
+			if msg.Role != "tool" {
+				continue
+			}
+			for _, res := range msg.ToolCalls {
+				answered[res.ID] = struct{}{}
+			}
+		}
+		var out []message.ToolCall
+		for _, call := range msgs[i].ToolCalls {
+			if _, ok := answered[call.ID]; !ok {
+				out = append(out, call)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Resume continues a run suspended at a pending approval (see
+// Options.ApprovalSuspend). decision approves or rejects the pending tool
+// call and is recorded on the approval record; an empty decision defers to the
+// record's current state in Options.ApprovalQueue. The pending tool then runs
+// or is rejected and the agent loop keeps iterating. Tokens are single use: a
+// run that suspends again returns a fresh token. Resume fails with
+// ErrRunStale when the session history changed after the run was suspended.
+func (rt *Runtime) Resume(ctx context.Context, token string, decision coreevents.PermissionDecisionType) (*Response, error) {
+	if rt == nil {
+		return nil, ErrRuntimeClosed
+	}
+	if err := rt.beginRun(); err != nil {
+		return nil, err
+	}
+	defer rt.endRun()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	store := newSuspendedRunStore(rt.opts.ProjectRoot)
+	state, err := store.Load(token)
+	if err != nil {
+		return nil, err
+	}
+	ctx, release, err := rt.acquireSession(ctx, state.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	requestID := state.RequestID
+	if strings.TrimSpace(requestID) == "" {
+		requestID = uuid.New().String()
+	}
+	ctx, run, untrack, err := rt.runs.track(ctx, requestID, state.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer untrack()
+
+	history := rt.histories.Get(state.SessionID)
+	rt.refreshHistory(ctx, state.SessionID, history)
+	if current := history.All(); len(current) > 0 && historyDigest(current) != historyDigest(state.Messages) {
+		if err := store.Claim(state.Token); err != nil {
+			return nil, err
+		}
+		return nil, ErrRunStale
+	}
+	action, record, err := rt.resolveResumeDecision(state, decision)
+	if err != nil {
+		return nil, err
+	}
+	// Claim before touching the approval queue so that only the winner of
+	// concurrent Resume calls records its decision.
+	if err := store.Claim(state.Token); err != nil {
+		return nil, err
+	}
+	if err := rt.recordResumeDecision(record, action); err != nil {
+		return nil, err
+	}
+
+	history.Replace(state.Messages)
+	defer rt.persistHistory(state.SessionID, history)
+
+	normalized := state.Request.request(state.SessionID, state.RequestID).normalized(rt.mode, state.SessionID)
+	pending := make([]agent.ToolCall, len(state.Pending))
+	for i, call := range state.Pending {
+		pending[i] = agent.ToolCall{ID: call.ID, Name: call.Name, Input: call.Input}
+	}
+	prep := preparedRun{
+		ctx:           withResumeApproval(ctx, &resumeApproval{command: state.ApprovalCommand, action: action}),
+		history:       history,
+		normalized:    normalized,
+		recorder:      defaultHookRecorder(),
+		mode:          normalized.Mode,
+		toolWhitelist: combineToolWhitelists(normalized.ToolWhitelist, nil),
+		pending:       pending,
+		run:           run,
+	}
+	result, err := rt.runAgent(prep)
+	return rt.finishRun(prep, result, err)
+}
+
+// resolveResumeDecision maps decision onto a permission action. It returns
+// the approval record when the queue knows it, without changing it.
+func (rt *Runtime) resolveResumeDecision(state *suspendedRunState, decision coreevents.PermissionDecisionType) (security.PermissionAction, *security.ApprovalRecord, error) {
+	var record *security.ApprovalRecord
+	if queue := rt.opts.ApprovalQueue; queue != nil && state.ApprovalID != "" {
+		if rec, ok := queue.Get(state.ApprovalID); ok {
+			record = rec
+		}
+	}
+
+	switch decision {
+	case coreevents.PermissionAllow:
+		return security.PermissionAllow, record, nil
+	case coreevents.PermissionDeny:
+		return security.PermissionDeny, record, nil
+	case "", coreevents.PermissionAsk:
+		if record == nil {
+			return "", nil, fmt.Errorf("api: resume requires a decision: approval %s is unknown to the queue", state.ApprovalID)
+		}
+		switch record.State {
+		case security.ApprovalApproved:
+			return security.PermissionAllow, record, nil
+		case security.ApprovalDenied:
+			return security.PermissionDeny, record, nil
+		}
+		return "", nil, fmt.Errorf("%w: %s", ErrApprovalPending, record.ID)
+	default:
+		return "", nil, fmt.Errorf("api: unsupported resume decision %q", decision)
+	}
+}
+
+// recordResumeDecision mirrors action onto record unless it already holds it.
+func (rt *Runtime) recordResumeDecision(record *security.ApprovalRecord, action security.PermissionAction) error {
+	if record == nil {
+		return nil
+	}
+	queue := rt.opts.ApprovalQueue
+	switch {
+	case action == security.PermissionAllow && record.State != security.ApprovalApproved:
+		_, err := queue.Approve(record.ID, approvalActor(rt.opts.ApprovalApprover), rt.opts.ApprovalWhitelistTTL)
+		return err
+	case action == security.PermissionDeny && record.State != security.ApprovalDenied:
+		_, err := queue.Deny(record.ID, approvalActor(rt.opts.ApprovalApprover), "denied on resume")
+		return err
+	}
+	return nil
+}
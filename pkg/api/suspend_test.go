@@ -0,0 +1,314 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	coreevents "github.com/cexll/agentsdk-go/pkg/core/events"
+	"github.com/cexll/agentsdk-go/pkg/model"
+	"github.com/cexll/agentsdk-go/pkg/security"
+	"github.com/cexll/agentsdk-go/pkg/tool"
+)
+
+const askEchoSettings = `{"permissions":{"ask":["echo"]},"sandbox":{"enabled":true}}`
+
+func echoCallResponse(id string) *model.Response {
+	return &model.Response{Message: model.Message{
+		Role:      "assistant",
+		ToolCalls: []model.ToolCall{{ID: id, Name: "echo", Arguments: map[string]any{"text": "hi"}}},
+	}}
+}
+
+func newSuspendingRuntime(t *testing.T, root, queuePath string, mdl model.Model, echo *echoTool) *Runtime {
+	t.Helper()
+	queue, err := security.NewApprovalQueue(queuePath)
+	if err != nil {
+		t.Fatalf("approval queue: %v", err)
+	}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:     root,
+		Model:           mdl,
+		Tools:           []tool.Tool{echo},
+		ApprovalQueue:   queue,
+		ApprovalSuspend: true,
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+	return rt
+}
+
+func suspendEchoRun(t *testing.T, root, queuePath string) *SuspendedRun {
+	t.Helper()
+	echo := &echoTool{}
+	mdl := &stubModel{responses: []*model.Response{echoCallResponse("call_1")}}
+	rt := newSuspendingRuntime(t, root, queuePath, mdl, echo)
+
+	resp, err := rt.Run(context.Background(), Request{Prompt: "say hi", SessionID: "sess-suspend"})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if resp.Suspended == nil || resp.Suspended.Token == "" {
+		t.Fatalf("expected suspended run, got %+v", resp)
+	}
+	if resp.Result == nil || resp.Result.StopReason != StopReasonPendingApproval {
+		t.Fatalf("unexpected result %+v", resp.Result)
+	}
+	if resp.Suspended.ToolName != "echo" || resp.Suspended.ToolUseID != "call_1" {
+		t.Fatalf("unexpected suspended call %+v", resp.Suspended)
+	}
+	if resp.Suspended.Approval == nil || resp.Suspended.Approval.State != security.ApprovalPending {
+		t.Fatalf("expected pending approval record, got %+v", resp.Suspended.Approval)
+	}
+	if echo.calls != 0 {
+		t.Fatalf("tool must not run before approval, got %d calls", echo.calls)
+	}
+	return resp.Suspended
+}
+
+func TestRunSuspendsAndResumesInAnotherRuntime(t *testing.T) {
+	root := newClaudeProjectWithSettings(t, askEchoSettings)
+	queuePath := filepath.Join(t.TempDir(), "approvals.json")
+	suspended := suspendEchoRun(t, root, queuePath)
+
+	if _, err := os.Stat(filepath.Join(root, ".claude", "runs", suspended.Token+".json")); err != nil {
+		t.Fatalf("expected persisted run state: %v", err)
+	}
+
+	// A fresh runtime stands in for a different process sharing the project.
+	echo := &echoTool{}
+	mdl := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "done"}},
+	}}
+	rt := newSuspendingRuntime(t, root, queuePath, mdl, echo)
+
+	resp, err := rt.Resume(context.Background(), suspended.Token, coreevents.PermissionAllow)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if resp.Suspended != nil {
+		t.Fatalf("resumed run should complete, got %+v", resp.Suspended)
+	}
+	if resp.Result == nil || resp.Result.Output != "done" {
+		t.Fatalf("unexpected result %+v", resp.Result)
+	}
+	if echo.calls != 1 {
+		t.Fatalf("expected approved tool to run once, got %d", echo.calls)
+	}
+
+	msgs := mdl.requests[0].Messages
+	last := msgs[len(msgs)-1]
+	if last.Role != "tool" || last.ToolCalls[0].ID != "call_1" || last.ToolCalls[0].Result != "hi" {
+		t.Fatalf("expected tool result in resumed history, got %+v", last)
+	}
+	if msgs[0].Role != "user" || msgs[0].Content != "say hi" {
+		t.Fatalf("expected original prompt restored, got %+v", msgs[0])
+	}
+
+	rec, ok := rt.opts.ApprovalQueue.Get(suspended.Approval.ID)
+	if !ok || rec.State != security.ApprovalApproved {
+		t.Fatalf("expected approval recorded, got %+v", rec)
+	}
+	if _, err := rt.Resume(context.Background(), suspended.Token, coreevents.PermissionAllow); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("expected token to be consumed, got %v", err)
+	}
+}
+
+func TestResumeWithDenyRejectsPendingTool(t *testing.T) {
+	root := newClaudeProjectWithSettings(t, askEchoSettings)
+	queuePath := filepath.Join(t.TempDir(), "approvals.json")
+	suspended := suspendEchoRun(t, root, queuePath)
+
+	echo := &echoTool{}
+	mdl := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "ok, skipped"}},
+	}}
+	rt := newSuspendingRuntime(t, root, queuePath, mdl, echo)
+
+	resp, err := rt.Resume(context.Background(), suspended.Token, coreevents.PermissionDeny)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if echo.calls != 0 {
+		t.Fatalf("denied tool must not run, got %d calls", echo.calls)
+	}
+	if resp.Result == nil || resp.Result.Output != "ok, skipped" {
+		t.Fatalf("unexpected result %+v", resp.Result)
+	}
+	msgs := mdl.requests[0].Messages
+	last := msgs[len(msgs)-1]
+	if last.Role != "tool" || !strings.Contains(last.ToolCalls[0].Result, "denied") {
+		t.Fatalf("expected denial tool result, got %+v", last)
+	}
+	if rec, _ := rt.opts.ApprovalQueue.Get(suspended.Approval.ID); rec == nil || rec.State != security.ApprovalDenied {
+		t.Fatalf("expected denied record, got %+v", rec)
+	}
+}
+
+func TestResumeDefersToApprovalQueue(t *testing.T) {
+	root := newClaudeProjectWithSettings(t, askEchoSettings)
+	queuePath := filepath.Join(t.TempDir(), "approvals.json")
+	suspended := suspendEchoRun(t, root, queuePath)
+
+	echo := &echoTool{}
+	mdl := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "done"}},
+	}}
+	rt := newSuspendingRuntime(t, root, queuePath, mdl, echo)
+
+	if _, err := rt.Resume(context.Background(), suspended.Token, ""); !errors.Is(err, ErrApprovalPending) {
+		t.Fatalf("expected ErrApprovalPending, got %v", err)
+	}
+	if _, err := rt.opts.ApprovalQueue.Approve(suspended.Approval.ID, "ops", 0); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if _, err := rt.Resume(context.Background(), suspended.Token, ""); err != nil {
+		t.Fatalf("resume after approval: %v", err)
+	}
+	if echo.calls != 1 {
+		t.Fatalf("expected tool to run after queue approval, got %d", echo.calls)
+	}
+}
+
+func TestRunBeforeResumeAnswersPendingCallsAndStalesRun(t *testing.T) {
+	root := newClaudeProjectWithSettings(t, askEchoSettings)
+	queuePath := filepath.Join(t.TempDir(), "approvals.json")
+	suspended := suspendEchoRun(t, root, queuePath)
+
+	echo := &echoTool{}
+	mdl := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "moved on"}},
+	}}
+	rt := newSuspendingRuntime(t, root, queuePath, mdl, echo)
+	if _, err := rt.Run(context.Background(), Request{Prompt: "never mind", SessionID: suspended.SessionID}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	msgs := mdl.requests[0].Messages
+	var answered bool
+	for i, msg := range msgs {
+		if msg.Role == "assistant" && len(msg.ToolCalls) == 1 {
+			next := msgs[i+1]
+			answered = next.Role == "tool" && next.ToolCalls[0].ID == "call_1" && next.ToolCalls[0].Result == abandonedToolResult
+		}
+	}
+	if !answered {
+		t.Fatalf("expected the pending call answered before the new prompt, got %+v", msgs)
+	}
+
+	if _, err := rt.Resume(context.Background(), suspended.Token, coreevents.PermissionAllow); !errors.Is(err, ErrRunStale) {
+		t.Fatalf("expected ErrRunStale, got %v", err)
+	}
+	if echo.calls != 0 {
+		t.Fatalf("stale run must not execute the tool, got %d calls", echo.calls)
+	}
+	if rec, _ := rt.opts.ApprovalQueue.Get(suspended.Approval.ID); rec == nil || rec.State != security.ApprovalPending {
+		t.Fatalf("stale resume must leave the approval untouched, got %+v", rec)
+	}
+	if got := rt.histories.Get(suspended.SessionID).All(); got[len(got)-1].Content != "moved on" {
+		t.Fatalf("stale resume must keep the newer history, got %+v", got)
+	}
+	if _, err := rt.Resume(context.Background(), suspended.Token, coreevents.PermissionAllow); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("expected the stale run discarded, got %v", err)
+	}
+}
+
+func TestSuspendedRunsExpireWithCleanupPeriod(t *testing.T) {
+	root := newClaudeProjectWithSettings(t, askEchoSettings)
+	queuePath := filepath.Join(t.TempDir(), "approvals.json")
+	suspended := suspendEchoRun(t, root, queuePath)
+
+	path := filepath.Join(root, ".claude", "runs", suspended.Token+".json")
+	old := time.Now().AddDate(0, 0, -60)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	newSuspendingRuntime(t, root, queuePath, &stubModel{}, &echoTool{})
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected expired run removed, got %v", err)
+	}
+}
+
+func TestDeleteSessionDropsSuspendedRuns(t *testing.T) {
+	root := newClaudeProjectWithSettings(t, askEchoSettings)
+	queuePath := filepath.Join(t.TempDir(), "approvals.json")
+	suspended := suspendEchoRun(t, root, queuePath)
+
+	echo := &echoTool{}
+	rt := newSuspendingRuntime(t, root, queuePath, &stubModel{}, echo)
+	if err := rt.DeleteSession(context.Background(), suspended.SessionID); err != nil {
+		t.Fatalf("delete session: %v", err)
+	}
+	if _, err := rt.Resume(context.Background(), suspended.Token, coreevents.PermissionAllow); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("expected the deleted session's token to be gone, got %v", err)
+	}
+	if echo.calls != 0 {
+		t.Fatalf("tool must not run for a deleted session, got %d calls", echo.calls)
+	}
+}
+
+func TestResumeUnknownToken(t *testing.T) {
+	root := newClaudeProject(t)
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: &stubModel{}})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if _, err := rt.Resume(context.Background(), "missing", coreevents.PermissionAllow); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("expected ErrRunNotFound, got %v", err)
+	}
+}
+
+func TestSuspendedRequestKeepsPromptSections(t *testing.T) {
+	in := suspendedRequest{
+		PromptSections: []PromptSection{{Name: "role", Template: "{{.Vars.role}}", Cacheable: true}, {Name: EnvironmentSectionName}},
+		PromptVars:     map[string]any{"role": "reviewer"},
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var out suspendedRequest
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	req := out.request("sess", "req")
+	if len(req.PromptSections) != 2 || req.PromptSections[0] != in.PromptSections[0] || req.PromptSections[1].Name != EnvironmentSectionName {
+		t.Fatalf("unexpected prompt sections %+v", req.PromptSections)
+	}
+	if req.PromptVars["role"] != "reviewer" {
+		t.Fatalf("unexpected prompt vars %+v", req.PromptVars)
+	}
+}
+
+func TestSuspendedRequestKeepsModelParams(t *testing.T) {
+	temp := 0.4
+	in := suspendedRequest{ModelParams: ModelParams{
+		MaxTokens:   256,
+		Temperature: &temp,
+		ToolChoice:  &model.ToolChoice{Type: model.ToolChoiceTool, Name: "echo"},
+	}}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var out suspendedRequest
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	params := out.request("sess", "req").ModelParams
+	if params.MaxTokens != 256 || params.Temperature == nil || *params.Temperature != 0.4 || *params.ToolChoice != *in.ModelParams.ToolChoice {
+		t.Fatalf("unexpected model params %+v", params)
+	}
+
+	if data, err = json.Marshal(suspendedRequest{}); err != nil || strings.Contains(string(data), "model_params") {
+		t.Fatalf("expected empty params omitted, got %s err=%v", data, err)
+	}
+}
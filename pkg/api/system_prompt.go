@@ -0,0 +1,242 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+// EnvironmentSectionName names the section returned by EnvironmentSection.
+const EnvironmentSectionName = "environment"
+
+const environmentTemplate = `## Environment
+
+Working directory: {{.Env.WorkingDir}}
+Platform: {{.Env.Platform}}
+Today's date: {{.Env.Date}}
+{{- if .Env.IsGitRepo}}
+Is a git repository: true
+Current branch: {{.Env.GitBranch}}
+
+Status:
+{{or .Env.GitStatus "(clean)"}}
+{{- with .Env.RecentCommits}}
+
+Recent commits:
+{{.}}
+{{- end}}
+{{- end}}`
+
+const (
+	// gitTimeout bounds each git command run for the environment section.
+	gitTimeout = 2 * time.Second
+	// gitStatusLines caps the status lines listed in the environment.
+	gitStatusLines = 40
+	recentCommits  = 5
+)
+
+// PromptSection is a named part of the system prompt.
+type PromptSection struct {
+	Name string
+	// Template is text/template source rendered against PromptData. A
+	// missing variable fails the run; sections that render empty are left
+	// out.
+	Template string
+	// Cacheable marks sections that render the same text on every run.
+	Cacheable bool
+}
+
+// PromptData is what PromptSection templates render against.
+type PromptData struct {
+	SessionID string
+	Vars      map[string]any
+
+	env func() PromptEnvironment
+}
+
+// Env describes where the runtime is working. Templates reach it as .Env;
+// it is gathered on first use in a run, so runs whose sections never mention
+// it do not run git.
+func (d PromptData) Env() PromptEnvironment {
+	if d.env == nil {
+		return PromptEnvironment{}
+	}
+	return d.env()
+}
+
+// PromptEnvironment describes where the runtime is working.
+type PromptEnvironment struct {
+	WorkingDir string
+	Platform   string
+	// Date is today's date as YYYY-MM-DD.
+	Date      string
+	IsGitRepo bool
+	GitBranch string
+	// GitStatus is the short status of the working tree, empty when clean.
+	GitStatus string
+	// RecentCommits lists the last commits one per line.
+	RecentCommits string
+}
+
+// EnvironmentSection returns the built-in section describing the working
+// directory, platform, date, git branch, status and recent commits. It
+// changes from run to run and is not cacheable, so list it after the
+// cacheable sections.
+func EnvironmentSection() PromptSection {
+	return PromptSection{Name: EnvironmentSectionName, Template: environmentTemplate}
+}
+
+// validatePromptSections checks that sections have unique names and parse.
+// Removal entries without a Template are only allowed in requests.
+func validatePromptSections(sections []PromptSection, allowRemoval bool) error {
+	seen := make(map[string]bool, len(sections))
+	for _, section := range sections {
+		name := strings.TrimSpace(section.Name)
+		if name == "" {
+			return errors.New("api: prompt section name is empty")
+		}
+		if seen[name] {
+			return fmt.Errorf("api: duplicate prompt section %q", name)
+		}
+		seen[name] = true
+		if strings.TrimSpace(section.Template) == "" {
+			if allowRemoval {
+				continue
+			}
+			return fmt.Errorf("api: prompt section %q has no template", name)
+		}
+		if _, err := parsePromptSection(section); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parsePromptSection(section PromptSection) (*template.Template, error) {
+	tmpl, err := template.New(section.Name).Option("missingkey=error").Parse(section.Template)
+	if err != nil {
+		return nil, fmt.Errorf("api: prompt section %q: %w", section.Name, err)
+	}
+	return tmpl, nil
+}
+
+// mergePromptSections applies request overrides to the configured sections.
+func mergePromptSections(base, overrides []PromptSection) []PromptSection {
+	if len(overrides) == 0 {
+		return base
+	}
+	merged := append([]PromptSection(nil), base...)
+	for _, override := range overrides {
+		name := strings.TrimSpace(override.Name)
+		idx := -1
+		for i, section := range merged {
+			if strings.TrimSpace(section.Name) == name {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case strings.TrimSpace(override.Template) == "":
+			if idx >= 0 {
+				merged = append(merged[:idx], merged[idx+1:]...)
+			}
+		case idx >= 0:
+			merged[idx] = override
+		default:
+			merged = append(merged, override)
+		}
+	}
+	return merged
+}
+
+// renderSystemSections renders the sections in effect for req as system
+// blocks. The environment is gathered at most once per call.
+func (rt *Runtime) renderSystemSections(ctx context.Context, req Request) ([]model.SystemBlock, error) {
+	sections := mergePromptSections(rt.opts.SystemPromptSections, req.PromptSections)
+	if len(sections) == 0 {
+		return nil, nil
+	}
+	vars := maps.Clone(rt.opts.PromptVars)
+	if vars == nil {
+		vars = map[string]any{}
+	}
+	maps.Copy(vars, req.PromptVars)
+	now := time.Now()
+	return renderPromptSections(sections, PromptData{
+		SessionID: req.SessionID,
+		Vars:      vars,
+		env: sync.OnceValue(func() PromptEnvironment {
+			return collectEnvironment(ctx, rt.opts.ProjectRoot, now)
+		}),
+	})
+}
+
+func renderPromptSections(sections []PromptSection, data PromptData) ([]model.SystemBlock, error) {
+	blocks := make([]model.SystemBlock, 0, len(sections))
+	for _, section := range sections {
+		tmpl, err := parsePromptSection(section)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("api: render prompt section %q: %w", section.Name, err)
+		}
+		if text := strings.TrimSpace(buf.String()); text != "" {
+			blocks = append(blocks, model.SystemBlock{Text: text, Cacheable: section.Cacheable})
+		}
+	}
+	return blocks, nil
+}
+
+// collectEnvironment describes dir at now. Git details are left empty when
+// git is unavailable or dir is not inside a repository.
+func collectEnvironment(ctx context.Context, dir string, now time.Time) PromptEnvironment {
+	env := PromptEnvironment{
+		WorkingDir: dir,
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		Date:       now.Format(time.DateOnly),
+	}
+	branch, err := runGit(ctx, dir, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return env
+	}
+	env.IsGitRepo = true
+	env.GitBranch = branch
+	if status, err := runGit(ctx, dir, "status", "--short"); err == nil {
+		env.GitStatus = limitLines(status, gitStatusLines)
+	}
+	if commits, err := runGit(ctx, dir, "log", "--oneline", fmt.Sprintf("-n%d", recentCommits)); err == nil {
+		env.RecentCommits = commits
+	}
+	return env
+}
+
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func limitLines(text string, limit int) string {
+	lines := strings.Split(text, "\n")
+	if len(lines) <= limit {
+		return text
+	}
+	return strings.Join(lines[:limit], "\n") + fmt.Sprintf("\n... and %d more", len(lines)-limit)
+}
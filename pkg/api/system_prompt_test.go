@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+func TestSystemPromptSectionsRenderEveryRun(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &stubModel{responses: []*model.Response{usageResponse("done", 1, 1)}}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:  root,
+		Model:        mdl,
+		SystemPrompt: "base",
+		SystemPromptSections: []PromptSection{
+			{Name: "identity", Template: "You are {{.Vars.role}}.", Cacheable: true},
+			{Name: "session", Template: "Session {{.SessionID}}"},
+			{Name: "empty", Template: "{{if .Vars.missing_ok}}never{{end}}"},
+			EnvironmentSection(),
+		},
+		PromptVars: map[string]any{"role": "a reviewer", "missing_ok": false},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "hi", SessionID: "s1"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	req := mdl.requests[len(mdl.requests)-1]
+	if req.System != "base" {
+		t.Fatalf("expected static prompt kept in System, got %q", req.System)
+	}
+	if len(req.SystemBlocks) != 3 {
+		t.Fatalf("expected empty section dropped, got %+v", req.SystemBlocks)
+	}
+	if got := req.SystemBlocks[0]; got.Text != "You are a reviewer." || !got.Cacheable {
+		t.Fatalf("unexpected identity block %+v", got)
+	}
+	if got := req.SystemBlocks[1]; got.Text != "Session s1" || got.Cacheable {
+		t.Fatalf("unexpected session block %+v", got)
+	}
+	env := req.SystemBlocks[2].Text
+	for _, want := range []string{"## Environment", "Working directory: " + root, "Today's date: " + time.Now().Format(time.DateOnly)} {
+		if !strings.Contains(env, want) {
+			t.Fatalf("environment missing %q:\n%s", want, env)
+		}
+	}
+
+	if _, err := rt.Run(context.Background(), Request{
+		Prompt:    "again",
+		SessionID: "s1",
+		PromptSections: []PromptSection{
+			{Name: "identity", Template: "You are {{.Vars.role}} on {{.Vars.team}}.", Cacheable: true},
+			{Name: EnvironmentSectionName},
+			{Name: "extra", Template: "Extra"},
+		},
+		PromptVars: map[string]any{"team": "infra"},
+	}); err != nil {
+		t.Fatalf("run with overrides: %v", err)
+	}
+	req = mdl.requests[len(mdl.requests)-1]
+	var texts []string
+	for _, block := range req.SystemBlocks {
+		texts = append(texts, block.Text)
+	}
+	if strings.Join(texts, "|") != "You are a reviewer on infra.|Session s1|Extra" {
+		t.Fatalf("unexpected overridden sections %q", texts)
+	}
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "third", SessionID: "s1"}); err != nil {
+		t.Fatalf("run after overrides: %v", err)
+	}
+	if got := len(mdl.requests[len(mdl.requests)-1].SystemBlocks); got != 3 {
+		t.Fatalf("expected overrides limited to their request, got %d blocks", got)
+	}
+}
+
+func TestSystemPromptSectionsValidation(t *testing.T) {
+	for name, sections := range map[string][]PromptSection{
+		"empty name":   {{Template: "x"}},
+		"duplicate":    {{Name: "a", Template: "x"}, {Name: " a ", Template: "y"}},
+		"no template":  {{Name: "a"}},
+		"bad template": {{Name: "a", Template: "{{.Vars"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(context.Background(), Options{
+				ProjectRoot:          newClaudeProject(t),
+				Model:                &stubModel{},
+				SystemPromptSections: sections,
+			})
+			if err == nil {
+				t.Fatalf("expected invalid sections to fail")
+			}
+		})
+	}
+
+	mdl := &stubModel{}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:          newClaudeProject(t),
+		Model:                mdl,
+		SystemPromptSections: []PromptSection{{Name: "role", Template: "{{.Vars.role}}"}},
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "hi"}); err == nil || !strings.Contains(err.Error(), `prompt section "role"`) {
+		t.Fatalf("expected missing variable error, got %v", err)
+	}
+	if _, err := rt.Run(context.Background(), Request{Prompt: "hi", PromptSections: []PromptSection{{Name: "x", Template: "{{"}}}); err == nil {
+		t.Fatalf("expected invalid request section to fail")
+	}
+	if len(mdl.requests) != 0 {
+		t.Fatalf("expected no model calls, got %d", len(mdl.requests))
+	}
+	if _, err := rt.Run(context.Background(), Request{Prompt: "hi", PromptVars: map[string]any{"role": "tester"}}); err != nil {
+		t.Fatalf("run with vars: %v", err)
+	}
+	if blocks := mdl.requests[0].SystemBlocks; len(blocks) != 1 || blocks[0].Text != "tester" {
+		t.Fatalf("unexpected blocks %+v", blocks)
+	}
+}
+
+func TestPromptEnvironmentCollectedOnlyWhenUsed(t *testing.T) {
+	calls := 0
+	data := PromptData{SessionID: "s", env: sync.OnceValue(func() PromptEnvironment {
+		calls++
+		return PromptEnvironment{Platform: "test/os"}
+	})}
+
+	if _, err := renderPromptSections([]PromptSection{{Name: "plain", Template: "Session {{.SessionID}}"}}, data); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("sections without .Env must not gather the environment, got %d calls", calls)
+	}
+
+	blocks, err := renderPromptSections([]PromptSection{
+		{Name: "a", Template: "{{.Env.Platform}}"},
+		{Name: "b", Template: "{{with .Env}}{{.Platform}}{{end}}"},
+	}, data)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if calls != 1 || len(blocks) != 2 || blocks[1].Text != "test/os" {
+		t.Fatalf("expected one collection shared by sections, got calls=%d blocks=%+v", calls, blocks)
+	}
+}
+
+func TestCollectEnvironmentReadsGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com", "GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	now := time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC)
+	env := collectEnvironment(context.Background(), dir, now)
+	if env.IsGitRepo || env.Date != "2025-03-04" || env.WorkingDir != dir || env.Platform == "" {
+		t.Fatalf("unexpected environment outside a repository %+v", env)
+	}
+
+	git("init", "-q", "-b", "main")
+	if err := os.WriteFile(dir+"/a.txt", []byte("a"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	git("add", "a.txt")
+	git("commit", "-q", "-m", "first commit")
+	if err := os.WriteFile(dir+"/b.txt", []byte("b"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	env = collectEnvironment(context.Background(), dir, now)
+	if !env.IsGitRepo || env.GitBranch != "main" {
+		t.Fatalf("unexpected git details %+v", env)
+	}
+	if env.GitStatus != "?? b.txt" || !strings.HasSuffix(env.RecentCommits, "first commit") {
+		t.Fatalf("unexpected status %q or commits %q", env.GitStatus, env.RecentCommits)
+	}
+}
+
+func TestMergePromptSectionsAndLimitLines(t *testing.T) {
+	base := []PromptSection{{Name: "a", Template: "A"}, {Name: "b", Template: "B"}}
+	merged := mergePromptSections(base, []PromptSection{{Name: "b", Template: "B2"}, {Name: "a"}, {Name: "c", Template: "C"}, {Name: "gone"}})
+	if len(merged) != 2 || merged[0].Template != "B2" || merged[1].Template != "C" {
+		t.Fatalf("unexpected merge %+v", merged)
+	}
+	if base[0].Template != "A" || base[1].Template != "B" {
+		t.Fatalf("expected configured sections untouched, got %+v", base)
+	}
+
+	if got := limitLines("1\n2\n3\n4", 2); got != "1\n2\n... and 2 more" {
+		t.Fatalf("unexpected limited lines %q", got)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+// NewModelTokenCounter returns a cached message.ProviderCounter that sizes
+// each message through the token counting endpoint of mdl, or fallback when
+// mdl cannot count tokens. A nil fallback uses message.EstimatingCounter.
+func NewModelTokenCounter(mdl model.Model, fallback message.TokenCounter) message.TokenCounter {
+	if fallback == nil {
+		fallback = message.EstimatingCounter{}
+	}
+	counter, ok := mdl.(model.TokenCounter)
+	if !ok {
+		return fallback
+	}
+	return message.NewProviderCounter(func(ctx context.Context, msg message.Message) (int, error) {
+		return counter.CountTokens(ctx, model.Request{Messages: []model.Message{countableMessage(msg)}})
+	}, fallback)
+}
+
+// countableMessage renders msg as a standalone user turn: providers reject
+// tool calls and tool results counted without their counterpart.
+func countableMessage(msg message.Message) model.Message {
+	var b strings.Builder
+	write := func(text string) {
+		if text = strings.TrimSpace(text); text == "" {
+			return
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(text)
+	}
+	write(msg.ReasoningContent)
+	write(msg.Content)
+	for _, call := range msg.ToolCalls {
+		if msg.Role != "tool" {
+			raw, err := json.Marshal(call.Arguments)
+			if err != nil {
+				raw = nil
+			}
+			write(call.Name + " " + string(raw))
+		}
+		write(call.Result)
+	}
+	text := b.String()
+	if text == "" {
+		text = "."
+	}
+	return model.Message{Role: "user", Content: text, ContentBlocks: convertContentBlocksToModel(msg.ContentBlocks)}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+func TestTokenCounterOptionIsCalibratedByUsage(t *testing.T) {
+	counter := message.NewCalibratedCounter(nil)
+	mdl := &stubModel{responses: []*model.Response{usageResponse("done", 100000, 10)}}
+	rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdl, TokenCounter: counter})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "hello", SessionID: "s"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if ratio := counter.Ratio(); ratio <= 1 {
+		t.Fatalf("expected the reported input tokens to raise the ratio, got %v", ratio)
+	}
+	hist := rt.histories.Get("s")
+	naive, estimated := 0, 0
+	for _, msg := range hist.All() {
+		naive += message.NaiveCounter{}.Count(msg)
+		estimated += message.EstimatingCounter{}.Count(msg)
+	}
+	// The prompt was sized before calibration, the answer after.
+	if got := hist.TokenCount(); got == naive || got <= estimated {
+		t.Fatalf("session history should be sized by the configured counter: got %d naive %d estimated %d", got, naive, estimated)
+	}
+}
+
+func TestTokenCounterCalibrationIncludesCachedInput(t *testing.T) {
+	counter := message.NewCalibratedCounter(nil)
+	resp := usageResponse("done", 3, 10)
+	resp.Usage.CacheReadTokens = 90000
+	resp.Usage.CacheCreationTokens = 10000
+	mdl := &stubModel{responses: []*model.Response{resp}}
+	rt, err := New(context.Background(), Options{ProjectRoot: newClaudeProject(t), Model: mdl, TokenCounter: counter})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "hello", SessionID: "s"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if ratio := counter.Ratio(); ratio <= 1 {
+		t.Fatalf("cache read and creation tokens should count as input, got ratio %v", ratio)
+	}
+}
+
+// countingModel counts tokens as the length of the first message.
+type countingModel struct {
+	stubModel
+	mu      sync.Mutex
+	counted []model.Request
+}
+
+func (c *countingModel) CountTokens(_ context.Context, req model.Request) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counted = append(c.counted, req)
+	return len(req.Messages[0].Content), nil
+}
+
+func TestNewModelTokenCounter(t *testing.T) {
+	if _, ok := NewModelTokenCounter(&stubModel{}, nil).(message.EstimatingCounter); !ok {
+		t.Fatal("models without token counting should fall back to the estimator")
+	}
+
+	mdl := &countingModel{}
+	counter := NewModelTokenCounter(mdl, nil)
+	call := message.Message{Role: "assistant", Content: "reading", ToolCalls: []message.ToolCall{{ID: "t1", Name: "read", Arguments: map[string]any{"path": "a.go"}}}}
+	result := message.Message{Role: "tool", ToolCalls: []message.ToolCall{{ID: "t1", Name: "read", Result: "package a"}}}
+
+	counter.(message.Primer).Prime(context.Background(), []message.Message{call, result})
+	if got := counter.Count(call); got != len("reading\nread {\"path\":\"a.go\"}") {
+		t.Fatalf("unexpected tool call count %d", got)
+	}
+	if got := counter.Count(result); got != len("package a") {
+		t.Fatalf("unexpected tool result count %d", got)
+	}
+	counter.(message.Primer).Prime(context.Background(), []message.Message{call})
+	if len(mdl.counted) != 2 {
+		t.Fatalf("expected repeated messages to be served from cache, got %d provider calls", len(mdl.counted))
+	}
+	for _, req := range mdl.counted {
+		if len(req.Messages) != 1 || req.Messages[0].Role != "user" || len(req.Messages[0].ToolCalls) != 0 {
+			t.Fatalf("count requests must be standalone user turns, got %+v", req.Messages)
+		}
+	}
+	empty := message.Message{Role: "assistant"}
+	counter.(message.Primer).Prime(context.Background(), []message.Message{empty})
+	if got := counter.Count(empty); got != len(".") {
+		t.Fatalf("empty messages should count as a placeholder turn, got %d", got)
+	}
+	if strings.TrimSpace(countableMessage(message.Message{Role: "user", Content: " hi "}).Content) != "hi" {
+		t.Fatal("content should be trimmed")
+	}
+}
+
+func TestRunPrimesProviderCounterBeforeTrimming(t *testing.T) {
+	mdl := &countingModel{stubModel: stubModel{responses: []*model.Response{{Message: model.Message{Role: "assistant", Content: "ok"}}}}}
+	rt, err := New(context.Background(), Options{
+		ProjectRoot:  newClaudeProject(t),
+		Model:        mdl,
+		TokenLimit:   100000,
+		TokenCounter: NewModelTokenCounter(mdl, nil),
+	})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	if _, err := rt.Run(context.Background(), Request{Prompt: "hello there", SessionID: "s"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	mdl.mu.Lock()
+	defer mdl.mu.Unlock()
+	if len(mdl.counted) != 1 || mdl.counted[0].Messages[0].Content != "hello there" {
+		t.Fatalf("expected the prompt counted once before the model call, got %+v", mdl.counted)
+	}
+}
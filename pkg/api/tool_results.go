@@ -0,0 +1,124 @@
+package api
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+)
+
+// toolResultSequencer appends tool result messages to history in the order
+// the assistant emitted the matching tool calls, even when the calls finish
+// out of order because they ran in parallel.
+type toolResultSequencer struct {
+	mu      sync.Mutex
+	history *message.History
+	pending []string                    // call IDs awaiting a result, in call order
+	ready   map[string]*message.Message // nil entries mark calls that produced no message
+	names   map[string]string           // tool name per pending call ID
+}
+
+func newToolResultSequencer(history *message.History) *toolResultSequencer {
+	return &toolResultSequencer{history: history}
+}
+
+// Expect registers the call order of a new assistant turn. Results still
+// buffered from a previous turn are flushed first so nothing is lost.
+func (s *toolResultSequencer) Expect(calls []message.ToolCall) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range s.pending {
+		if msg := s.ready[id]; msg != nil {
+			s.history.Append(*msg)
+		}
+	}
+	s.pending = s.pending[:0]
+	s.ready = map[string]*message.Message{}
+	s.names = map[string]string{}
+	seen := map[string]struct{}{}
+	for _, call := range calls {
+		id := strings.TrimSpace(call.ID)
+		if id == "" {
+			continue
+		}
+		if _, dup := seen[id]; dup {
+			continue
+		}
+		seen[id] = struct{}{}
+		s.pending = append(s.pending, id)
+		s.names[id] = call.Name
+	}
+}
+
+// Abandon flushes the current turn, answering calls that never finished with
+// content so every tool call in history keeps a result.
+func (s *toolResultSequencer) Abandon(content string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range s.pending {
+		msg, ok := s.ready[id]
+		if !ok {
+			msg = &message.Message{Role: "tool", ToolCalls: []message.ToolCall{{ID: id, Name: s.names[id], Result: content}}}
+		}
+		if msg != nil {
+			s.history.Append(*msg)
+		}
+	}
+	s.pending = s.pending[:0]
+	s.ready = map[string]*message.Message{}
+}
+
+// Append records the result message for callID. Messages for unknown calls
+// are appended immediately.
+func (s *toolResultSequencer) Append(callID string, msg message.Message) {
+	s.record(callID, &msg)
+}
+
+// Skip marks callID as finished without a history message.
+func (s *toolResultSequencer) Skip(callID string) {
+	s.record(callID, nil)
+}
+
+func (s *toolResultSequencer) record(callID string, msg *message.Message) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.awaitingLocked(callID) {
+		if msg != nil {
+			s.history.Append(*msg)
+		}
+		return
+	}
+	s.ready[callID] = msg
+	for len(s.pending) > 0 {
+		next, ok := s.ready[s.pending[0]]
+		if !ok {
+			break
+		}
+		if next != nil {
+			s.history.Append(*next)
+		}
+		delete(s.ready, s.pending[0])
+		s.pending = s.pending[1:]
+	}
+}
+
+func (s *toolResultSequencer) awaitingLocked(callID string) bool {
+	if _, done := s.ready[callID]; done {
+		return false
+	}
+	for _, id := range s.pending {
+		if id == callID {
+			return true
+		}
+	}
+	return false
+}
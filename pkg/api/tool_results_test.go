@@ -0,0 +1,67 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+)
+
+func toolMsg(id string) message.Message {
+	return message.Message{Role: "tool", ToolCalls: []message.ToolCall{{ID: id, Result: id}}}
+}
+
+func historyCallIDs(h *message.History) []string {
+	var ids []string
+	for _, msg := range h.All() {
+		for _, call := range msg.ToolCalls {
+			ids = append(ids, call.ID)
+		}
+	}
+	return ids
+}
+
+func TestToolResultSequencerOrdersOutOfOrderResults(t *testing.T) {
+	h := message.NewHistory()
+	seq := newToolResultSequencer(h)
+	seq.Expect([]message.ToolCall{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+
+	seq.Append("c", toolMsg("c"))
+	seq.Append("b", toolMsg("b"))
+	if h.Len() != 0 {
+		t.Fatalf("expected results to be buffered until first call completes, got %d", h.Len())
+	}
+	seq.Append("a", toolMsg("a"))
+
+	got := historyCallIDs(h)
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatalf("unexpected history order %v", got)
+	}
+}
+
+func TestToolResultSequencerSkipAndUnknownCalls(t *testing.T) {
+	h := message.NewHistory()
+	seq := newToolResultSequencer(h)
+	seq.Expect([]message.ToolCall{{ID: "a"}, {ID: "b"}})
+
+	seq.Append("b", toolMsg("b"))
+	seq.Append("zzz", toolMsg("zzz"))
+	seq.Skip("a")
+
+	got := historyCallIDs(h)
+	if len(got) != 2 || got[0] != "zzz" || got[1] != "b" {
+		t.Fatalf("unexpected history order %v", got)
+	}
+}
+
+func TestToolResultSequencerFlushesOnNextTurn(t *testing.T) {
+	h := message.NewHistory()
+	seq := newToolResultSequencer(h)
+	seq.Expect([]message.ToolCall{{ID: "a"}, {ID: "b"}})
+	seq.Append("b", toolMsg("b"))
+	seq.Expect(nil)
+
+	got := historyCallIDs(h)
+	if len(got) != 1 || got[0] != "b" {
+		t.Fatalf("expected buffered result to be flushed, got %v", got)
+	}
+}
@@ -0,0 +1,528 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/google/uuid"
+)
+
+// Transcript record operations. A transcript is an append-only JSONL log;
+// replaying its records in order yields the session history.
+const (
+	transcriptOpAppend     = "append"
+	transcriptOpRewind     = "rewind"
+	transcriptOpCheckpoint = "checkpoint"
+)
+
+// transcriptRecord is one line of a session transcript. Every append record
+// gets a new Seq, larger than any before it, so a sequence number names one
+// message for the life of the transcript even after rewinds and compaction.
+// Appends extend the current head; rewind records move the head back to the
+// message at Seq (0 for an empty history); checkpoint records mark the head
+// at Seq as a durable point to fork or rewind to.
+type transcriptRecord struct {
+	Op      string           `json:"op"`
+	Seq     int64            `json:"seq"`
+	Time    time.Time        `json:"ts"`
+	Message *message.Message `json:"message,omitempty"`
+}
+
+// transcriptStore keeps one append-only transcript per session under
+// .claude/transcripts. Torn trailing writes left by a crash are truncated
+// when a transcript is loaded.
+type transcriptStore struct {
+	dir string
+
+	mu sync.Mutex
+	// heads caches the current branch of each loaded transcript so syncing
+	// only appends what changed.
+	heads map[string]*transcriptHead
+}
+
+// transcriptHead is the current branch of a transcript: the sequence number
+// and digest of each message, plus the last sequence number handed out.
+type transcriptHead struct {
+	seqs    []int64
+	digests []uint64
+	last    int64
+}
+
+func (h *transcriptHead) seq() int64 {
+	if len(h.seqs) == 0 {
+		return 0
+	}
+	return h.seqs[len(h.seqs)-1]
+}
+
+// transcriptTree is a replayed transcript. Rewinds leave abandoned branches
+// behind, so messages form a tree keyed by sequence number.
+type transcriptTree struct {
+	nodes map[int64]transcriptNode
+	head  int64
+	last  int64
+}
+
+type transcriptNode struct {
+	parent int64
+	msg    message.Message
+}
+
+func newTranscriptStore(projectRoot string) *transcriptStore {
+	projectRoot = strings.TrimSpace(projectRoot)
+	if projectRoot == "" {
+		return nil
+	}
+	return &transcriptStore{
+		dir:   filepath.Join(projectRoot, ".claude", "transcripts"),
+		heads: map[string]*transcriptHead{},
+	}
+}
+
+func (s *transcriptStore) filePath(sessionID string) string {
+	if s == nil || strings.TrimSpace(s.dir) == "" || strings.TrimSpace(sessionID) == "" {
+		return ""
+	}
+	return filepath.Join(s.dir, sanitizePathComponent(sessionID)+".jsonl")
+}
+
+// Load replays the transcript of sessionID. A missing transcript yields an
+// empty history.
+func (s *transcriptStore) Load(sessionID string) ([]message.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tree, err := s.loadLocked(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	msgs, _ := tree.branch(tree.head)
+	return msgs, nil
+}
+
+// At replays the transcript of sessionID up to seq and returns the history
+// as it was when the message at seq was the latest one.
+func (s *transcriptStore) At(sessionID string, seq int64) ([]message.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tree, err := s.loadLocked(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	msgs, ok := tree.branch(seq)
+	if !ok {
+		return nil, fmt.Errorf("api: unknown transcript sequence %d", seq)
+	}
+	return msgs, nil
+}
+
+// Rewind moves the head of sessionID back to seq, which may also name a
+// message of a branch abandoned by an earlier rewind, and returns the
+// resulting history.
+func (s *transcriptStore) Rewind(sessionID string, seq int64) ([]message.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tree, err := s.loadLocked(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	msgs, ok := tree.branch(seq)
+	if !ok {
+		return nil, fmt.Errorf("api: unknown transcript sequence %d", seq)
+	}
+	if seq != tree.head {
+		rec := transcriptRecord{Op: transcriptOpRewind, Seq: seq, Time: time.Now().UTC()}
+		if err := s.writeLocked(sessionID, []transcriptRecord{rec}, false); err != nil {
+			return nil, err
+		}
+		tree.head = seq
+	}
+	head, err := tree.headState()
+	if err != nil {
+		return nil, err
+	}
+	s.heads[sessionID] = head
+	return msgs, nil
+}
+
+// loadLocked replays the whole transcript of sessionID and caches its head.
+func (s *transcriptStore) loadLocked(sessionID string) (*transcriptTree, error) {
+	tree := &transcriptTree{nodes: map[int64]transcriptNode{}}
+	path := s.filePath(sessionID)
+	if path == "" {
+		return tree, nil
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.heads[sessionID] = &transcriptHead{}
+			return tree, nil
+		}
+		return nil, fmt.Errorf("open transcript: %w", err)
+	}
+	defer f.Close()
+
+	var good int64
+	reader := bufio.NewReader(f)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return nil, fmt.Errorf("read transcript: %w", readErr)
+		}
+		if len(line) == 0 {
+			break
+		}
+		var rec transcriptRecord
+		complete := line[len(line)-1] == '\n'
+		if !complete || json.Unmarshal(line, &rec) != nil || !tree.apply(rec) {
+			// Everything from the first torn or invalid record on is discarded.
+			log.Printf("api: truncating torn transcript %s at offset %d", path, good)
+			if err := f.Truncate(good); err != nil {
+				return nil, fmt.Errorf("truncate transcript: %w", err)
+			}
+			break
+		}
+		good += int64(len(line))
+		if readErr != nil {
+			break
+		}
+	}
+	head, err := tree.headState()
+	if err != nil {
+		return nil, err
+	}
+	s.heads[sessionID] = head
+	return tree, nil
+}
+
+// apply replays rec onto the tree. It reports false for records that do not
+// fit, which marks the log as corrupt.
+func (t *transcriptTree) apply(rec transcriptRecord) bool {
+	switch rec.Op {
+	case transcriptOpAppend:
+		if rec.Message == nil || rec.Seq <= t.last {
+			return false
+		}
+		t.nodes[rec.Seq] = transcriptNode{parent: t.head, msg: *rec.Message}
+		t.head, t.last = rec.Seq, rec.Seq
+	case transcriptOpRewind:
+		if _, ok := t.nodes[rec.Seq]; !ok && rec.Seq != 0 {
+			return false
+		}
+		t.head = rec.Seq
+	case transcriptOpCheckpoint:
+		if rec.Seq != t.head {
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// branch returns the messages leading up to and including seq.
+func (t *transcriptTree) branch(seq int64) ([]message.Message, bool) {
+	msgs, _, ok := t.walk(seq)
+	return message.CloneMessages(msgs), ok
+}
+
+func (t *transcriptTree) walk(seq int64) ([]message.Message, []int64, bool) {
+	var (
+		msgs []message.Message
+		seqs []int64
+	)
+	for seq != 0 {
+		node, ok := t.nodes[seq]
+		if !ok {
+			return nil, nil, false
+		}
+		msgs = append(msgs, node.msg)
+		seqs = append(seqs, seq)
+		seq = node.parent
+	}
+	slices.Reverse(msgs)
+	slices.Reverse(seqs)
+	return msgs, seqs, true
+}
+
+func (t *transcriptTree) headState() (*transcriptHead, error) {
+	msgs, seqs, _ := t.walk(t.head)
+	head := &transcriptHead{seqs: seqs, digests: make([]uint64, len(msgs)), last: t.last}
+	for i, msg := range msgs {
+		digest, err := messageDigest(msg)
+		if err != nil {
+			return nil, err
+		}
+		head.digests[i] = digest
+	}
+	return head, nil
+}
+
+// Sync appends whatever differs between the transcript and msgs, rewinding
+// first when the history diverged (for example after compaction). It returns
+// the sequence number of the last message.
+func (s *transcriptStore) Sync(sessionID string, msgs []message.Message) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncLocked(sessionID, msgs)
+}
+
+func (s *transcriptStore) syncLocked(sessionID string, msgs []message.Message) (int64, error) {
+	if s.filePath(sessionID) == "" {
+		return int64(len(msgs)), nil
+	}
+	head, ok := s.heads[sessionID]
+	if !ok {
+		if _, err := s.loadLocked(sessionID); err != nil {
+			return 0, err
+		}
+		head = s.heads[sessionID]
+	}
+
+	digests := make([]uint64, len(msgs))
+	for i, msg := range msgs {
+		digest, err := messageDigest(msg)
+		if err != nil {
+			return 0, err
+		}
+		digests[i] = digest
+	}
+	common := 0
+	for common < len(head.digests) && common < len(digests) && head.digests[common] == digests[common] {
+		common++
+	}
+
+	now := time.Now().UTC()
+	next := &transcriptHead{seqs: append([]int64(nil), head.seqs[:common]...), digests: digests, last: head.last}
+	var records []transcriptRecord
+	if common < len(head.seqs) {
+		records = append(records, transcriptRecord{Op: transcriptOpRewind, Seq: next.seq(), Time: now})
+	}
+	for i := common; i < len(msgs); i++ {
+		msg := msgs[i]
+		next.last++
+		next.seqs = append(next.seqs, next.last)
+		records = append(records, transcriptRecord{Op: transcriptOpAppend, Seq: next.last, Time: now, Message: &msg})
+	}
+	if err := s.writeLocked(sessionID, records, false); err != nil {
+		return 0, err
+	}
+	s.heads[sessionID] = next
+	return next.seq(), nil
+}
+
+// Checkpoint syncs msgs, appends a checkpoint record and flushes the
+// transcript to stable storage.
+func (s *transcriptStore) Checkpoint(sessionID string, msgs []message.Message) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq, err := s.syncLocked(sessionID, msgs)
+	if err != nil {
+		return 0, err
+	}
+	rec := transcriptRecord{Op: transcriptOpCheckpoint, Seq: seq, Time: time.Now().UTC()}
+	if err := s.writeLocked(sessionID, []transcriptRecord{rec}, true); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+func (s *transcriptStore) writeLocked(sessionID string, records []transcriptRecord, sync bool) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return fmt.Errorf("encode transcript record: %w", err)
+		}
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("mkdir transcript dir: %w", err)
+	}
+	f, err := os.OpenFile(s.filePath(sessionID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open transcript: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		_ = f.Close()
+		// Drop the cached head so the next sync reloads and repairs the log.
+		delete(s.heads, sessionID)
+		return fmt.Errorf("append transcript: %w", err)
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("sync transcript: %w", err)
+		}
+	}
+	return f.Close()
+}
+
+// Delete removes the transcript of sessionID.
+func (s *transcriptStore) Delete(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.heads, sessionID)
+	path := s.filePath(sessionID)
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove transcript: %w", err)
+	}
+	return nil
+}
+
+// Cleanup removes transcripts untouched for more than retainDays.
+func (s *transcriptStore) Cleanup(retainDays int) error {
+	if s == nil || retainDays <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read transcript dir: %w", err)
+	}
+	cutoff := time.Now().AddDate(0, 0, -retainDays)
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jsonl") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func messageDigest(msg message.Message) (uint64, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return 0, fmt.Errorf("encode transcript message: %w", err)
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return h.Sum64(), nil
+}
+
+// Checkpoint flushes the session transcript to stable storage and returns the
+// sequence number of its latest message. Pass it to Fork or Rewind to branch
+// from or roll back to this point later. Sequence numbers are never reused, so
+// a checkpoint stays valid across later rewinds and compaction. Without a
+// project root there is no transcript and sequence numbers are plain message
+// counts.
+func (rt *Runtime) Checkpoint(sessionID string) (int64, error) {
+	sessionID, err := rt.beginSessionOp(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	defer rt.endRun()
+
+	msgs := rt.histories.Get(sessionID).All()
+	if rt.transcripts == nil {
+		return int64(len(msgs)), nil
+	}
+	return rt.transcripts.Checkpoint(sessionID, msgs)
+}
+
+// Fork starts a new session holding the history of sessionID as of atSeq,
+// replayed from its transcript, and returns its ID. The source session is left
+// untouched.
+func (rt *Runtime) Fork(sessionID string, atSeq int64) (string, error) {
+	sessionID, err := rt.beginSessionOp(sessionID)
+	if err != nil {
+		return "", err
+	}
+	defer rt.endRun()
+
+	var branch []message.Message
+	if rt.transcripts != nil {
+		if branch, err = rt.transcripts.At(sessionID, atSeq); err != nil {
+			return "", err
+		}
+	} else if branch, err = historyPrefix(rt.histories.Get(sessionID).All(), atSeq); err != nil {
+		return "", err
+	}
+	forkID := uuid.New().String()
+	rt.histories.Get(forkID).Replace(branch)
+	rt.recordSession(forkID, branch)
+	if _, err := rt.saveHistory(forkID, branch); err != nil {
+		return "", err
+	}
+	return forkID, nil
+}
+
+// Rewind restores sessionID to its history as of toSeq. It waits for an
+// in-flight run of the session to finish first.
+func (rt *Runtime) Rewind(sessionID string, toSeq int64) error {
+	sessionID, err := rt.beginSessionOp(sessionID)
+	if err != nil {
+		return err
+	}
+	defer rt.endRun()
+
+	if err := rt.sessionGate.Acquire(context.Background(), sessionID); err != nil {
+		return err
+	}
+	defer rt.sessionGate.Release(sessionID)
+
+	history := rt.histories.Get(sessionID)
+	var kept []message.Message
+	if rt.transcripts != nil {
+		if kept, err = rt.transcripts.Rewind(sessionID, toSeq); err != nil {
+			return err
+		}
+	} else if kept, err = historyPrefix(history.All(), toSeq); err != nil {
+		return err
+	}
+	history.Replace(kept)
+	_, err = rt.saveHistory(sessionID, kept)
+	return err
+}
+
+// historyPrefix resolves seq as a message count when there is no transcript.
+func historyPrefix(msgs []message.Message, seq int64) ([]message.Message, error) {
+	if seq < 0 || seq > int64(len(msgs)) {
+		return nil, fmt.Errorf("api: sequence %d out of range [0,%d]", seq, len(msgs))
+	}
+	return message.CloneMessages(msgs[:seq]), nil
+}
+
+func (rt *Runtime) beginSessionOp(sessionID string) (string, error) {
+	if rt == nil {
+		return "", ErrRuntimeClosed
+	}
+	sessionID = strings.TrimSpace(sessionID)
+	if sessionID == "" {
+		return "", errors.New("api: session id is required")
+	}
+	if err := rt.beginRun(); err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cexll/agentsdk-go/pkg/message"
+	"github.com/cexll/agentsdk-go/pkg/model"
+)
+
+func transcriptLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read transcript: %v", err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestTranscriptStoreAppendsOnlyChanges(t *testing.T) {
+	store := newTranscriptStore(t.TempDir())
+	msgs := []message.Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}
+	if seq, err := store.Sync("sess", msgs); err != nil || seq != 2 {
+		t.Fatalf("sync: seq=%d err=%v", seq, err)
+	}
+	msgs = append(msgs, message.Message{Role: "user", Content: "again"})
+	if _, err := store.Sync("sess", msgs); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	path := store.filePath("sess")
+	if got := transcriptLines(t, path); got != 3 {
+		t.Fatalf("expected 3 append records, got %d", got)
+	}
+
+	// A diverged history (e.g. after compaction) rewinds to the common prefix.
+	diverged := []message.Message{msgs[0], {Role: "assistant", Content: "summary"}}
+	if _, err := store.Sync("sess", diverged); err != nil {
+		t.Fatalf("sync diverged: %v", err)
+	}
+	if got := transcriptLines(t, path); got != 5 {
+		t.Fatalf("expected rewind plus append, got %d records", got)
+	}
+
+	reloaded, err := newTranscriptStore(filepath.Dir(filepath.Dir(filepath.Dir(path)))).Load("sess")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(reloaded) != 2 || reloaded[1].Content != "summary" {
+		t.Fatalf("unexpected replayed history %+v", reloaded)
+	}
+}
+
+func TestTranscriptStoreSequenceNumbersSurviveRewind(t *testing.T) {
+	root := t.TempDir()
+	store := newTranscriptStore(root)
+	msgs := []message.Message{{Role: "user", Content: "a"}, {Role: "assistant", Content: "b"}, {Role: "user", Content: "c"}}
+	checkpoint, err := store.Checkpoint("sess", msgs)
+	if err != nil || checkpoint != 3 {
+		t.Fatalf("checkpoint: seq=%d err=%v", checkpoint, err)
+	}
+	if _, err := store.Rewind("sess", 1); err != nil {
+		t.Fatalf("rewind: %v", err)
+	}
+	seq, err := store.Sync("sess", []message.Message{msgs[0], {Role: "assistant", Content: "other"}})
+	if err != nil || seq != 4 {
+		t.Fatalf("expected a fresh sequence number after rewind, got seq=%d err=%v", seq, err)
+	}
+
+	reloaded := newTranscriptStore(root)
+	at, err := reloaded.At("sess", checkpoint)
+	if err != nil {
+		t.Fatalf("at: %v", err)
+	}
+	if len(at) != 3 || at[2].Content != "c" {
+		t.Fatalf("checkpoint should resolve to its original history, got %+v", at)
+	}
+	current, err := reloaded.Load("sess")
+	if err != nil || len(current) != 2 || current[1].Content != "other" {
+		t.Fatalf("unexpected current history %+v err=%v", current, err)
+	}
+	if _, err := reloaded.At("sess", 9); err == nil {
+		t.Fatalf("expected unknown sequence to fail")
+	}
+}
+
+func TestTranscriptStoreTruncatesTornWrite(t *testing.T) {
+	root := t.TempDir()
+	store := newTranscriptStore(root)
+	msgs := []message.Message{{Role: "user", Content: "hi"}}
+	if _, err := store.Checkpoint("sess", msgs); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	path := store.filePath("sess")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := f.WriteString(`{"op":"append","seq":2,"message":{"role":"assi`); err != nil {
+		t.Fatalf("write torn record: %v", err)
+	}
+	_ = f.Close()
+
+	loaded, err := newTranscriptStore(root).Load("sess")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(loaded) != 1 || loaded[0].Content != "hi" {
+		t.Fatalf("unexpected recovered history %+v", loaded)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if after.Size() != info.Size() {
+		t.Fatalf("expected torn tail truncated to %d bytes, got %d", info.Size(), after.Size())
+	}
+}
+
+func TestRuntimeCheckpointForkAndRewind(t *testing.T) {
+	root := newClaudeProject(t)
+	mdl := &stubModel{responses: []*model.Response{
+		{Message: model.Message{Role: "assistant", Content: "first"}},
+		{Message: model.Message{Role: "assistant", Content: "second"}},
+		{Message: model.Message{Role: "assistant", Content: "branch"}},
+		{Message: model.Message{Role: "assistant", Content: "rewound"}},
+	}}
+	rt, err := New(context.Background(), Options{ProjectRoot: root, Model: mdl})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = rt.Close() })
+
+	ctx := context.Background()
+	if _, err := rt.Run(ctx, Request{Prompt: "one", SessionID: "main"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if _, err := rt.Run(ctx, Request{Prompt: "two", SessionID: "main"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	seq, err := rt.Checkpoint("main")
+	if err != nil || seq != 4 {
+		t.Fatalf("checkpoint: seq=%d err=%v", seq, err)
+	}
+
+	forkID, err := rt.Fork("main", 2)
+	if err != nil {
+		t.Fatalf("fork: %v", err)
+	}
+	if _, err := rt.Run(ctx, Request{Prompt: "other two", SessionID: forkID}); err != nil {
+		t.Fatalf("run fork: %v", err)
+	}
+	sent := mdl.requests[2].Messages
+	if len(sent) != 3 || sent[1].Content != "first" || sent[2].Content != "other two" {
+		t.Fatalf("fork should continue from seq 2, got %+v", sent)
+	}
+	if got := rt.histories.Get("main").Len(); got != 4 {
+		t.Fatalf("fork must not touch the source session, got %d messages", got)
+	}
+
+	if err := rt.Rewind("main", 2); err != nil {
+		t.Fatalf("rewind: %v", err)
+	}
+	if _, err := rt.Fork("main", 99); err == nil {
+		t.Fatalf("expected unknown sequence to fail")
+	}
+	if _, err := rt.Run(ctx, Request{Prompt: "three", SessionID: "main"}); err != nil {
+		t.Fatalf("run after rewind: %v", err)
+	}
+	if seq, err := rt.Checkpoint("main"); err != nil || seq != 6 {
+		t.Fatalf("sequence numbers must not be reused after rewind: seq=%d err=%v", seq, err)
+	}
+
+	// The checkpoint taken before the rewind still names the same history.
+	oldID, err := rt.Fork("main", 4)
+	if err != nil {
+		t.Fatalf("fork old checkpoint: %v", err)
+	}
+	if msgs := rt.histories.Get(oldID).All(); len(msgs) != 4 || msgs[3].Content != "second" {
+		t.Fatalf("unexpected fork of old checkpoint %+v", msgs)
+	}
+	if err := rt.Rewind("main", 2); err != nil {
+		t.Fatalf("rewind: %v", err)
+	}
+
+	// Another runtime replays the rewound transcript.
+	other, err := New(context.Background(), Options{ProjectRoot: root, Model: &stubModel{}})
+	if err != nil {
+		t.Fatalf("runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = other.Close() })
+	restored := other.histories.Get("main").All()
+	if len(restored) != 2 || restored[1].Content != "first" {
+		t.Fatalf("unexpected restored history %+v", restored)
+	}
+	if got := other.histories.Get(forkID).Len(); got != 4 {
+		t.Fatalf("expected persisted fork with 4 messages, got %d", got)
+	}
+}
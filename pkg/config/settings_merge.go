@@ -65,6 +65,7 @@ func MergeSettings(lower, higher *Settings) *Settings {
 	if higher.AWSCredentialExport != "" {
 		result.AWSCredentialExport = higher.AWSCredentialExport
 	}
+	result.ModelPricing = mergeModelPricing(lower.ModelPricing, higher.ModelPricing)
 	return result
 }
 
@@ -273,6 +274,21 @@ func mergeIntMap(lower, higher map[string]int) map[string]int {
 	return out
 }
 
+// mergeModelPricing merges per-model prices; higher entries replace lower ones.
+func mergeModelPricing(lower, higher map[string]ModelPrice) map[string]ModelPrice {
+	if len(lower) == 0 && len(higher) == 0 {
+		return nil
+	}
+	out := make(map[string]ModelPrice, len(lower)+len(higher))
+	for k, v := range lower {
+		out[k] = v
+	}
+	for k, v := range higher {
+		out[k] = v
+	}
+	return out
+}
+
 // mergeStringSlices appends slices and removes duplicates while preserving order.
 func mergeStringSlices(lower, higher []string) []string {
 	if len(lower) == 0 && len(higher) == 0 {
@@ -380,6 +396,7 @@ func cloneSettings(src *Settings) *Settings {
 	out.DeniedMcpServers = mergeMCPServerRules(nil, src.DeniedMcpServers)
 	out.MCP = cloneMCPConfig(src.MCP)
 	out.LegacyMCPServers = mergeStringSlices(nil, src.LegacyMCPServers)
+	out.ModelPricing = mergeModelPricing(nil, src.ModelPricing)
 	return &out
 }
 
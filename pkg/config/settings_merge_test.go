@@ -48,3 +48,27 @@ func TestMergeSettingsNilInputs(t *testing.T) {
 		t.Fatalf("expected lower preserved")
 	}
 }
+
+func TestMergeSettingsModelPricing(t *testing.T) {
+	t.Parallel()
+
+	lower := &Settings{ModelPricing: map[string]ModelPrice{
+		"a": {Input: 1, Output: 2},
+		"b": {Input: 3, Output: 4},
+	}}
+	higher := &Settings{ModelPricing: map[string]ModelPrice{
+		"b": {Input: 5, Output: 6, CacheRead: 0.5},
+	}}
+
+	merged := MergeSettings(lower, higher)
+	if merged.ModelPricing["a"].Output != 2 {
+		t.Fatalf("expected lower-only entry kept, got %+v", merged.ModelPricing)
+	}
+	if got := merged.ModelPricing["b"]; got.Input != 5 || got.CacheRead != 0.5 {
+		t.Fatalf("expected higher entry to replace lower, got %+v", got)
+	}
+	merged.ModelPricing["a"] = ModelPrice{}
+	if lower.ModelPricing["a"].Output != 2 {
+		t.Fatalf("merge must not alias the input map")
+	}
+}
@@ -41,6 +41,9 @@ func ValidateSettings(s *Settings) error {
 	// tool output persistence thresholds
 	errs = append(errs, validateToolOutputConfig(s.ToolOutput)...)
 
+	// model pricing overrides
+	errs = append(errs, validateModelPricing(s.ModelPricing)...)
+
 	// mcp
 	errs = append(errs, validateMCPConfig(s.MCP, s.LegacyMCPServers)...)
 
@@ -260,6 +263,30 @@ func validateBashOutputConfig(cfg *BashOutputConfig) []error {
 	return errs
 }
 
+func validateModelPricing(prices map[string]ModelPrice) []error {
+	if len(prices) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(prices))
+	for name := range prices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, errors.New("modelPricing has an empty model name"))
+			continue
+		}
+		price := prices[name]
+		if price.Input < 0 || price.Output < 0 || price.CacheRead < 0 || price.CacheWrite < 0 {
+			errs = append(errs, fmt.Errorf("modelPricing[%s] prices must be >=0", name))
+		}
+	}
+	return errs
+}
+
 func validateToolOutputConfig(cfg *ToolOutputConfig) []error {
 	if cfg == nil {
 		return nil
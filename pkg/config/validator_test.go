@@ -64,6 +64,22 @@ func TestValidateToolOutputConfigRejectsInvalidThresholds(t *testing.T) {
 	require.Contains(t, msg, "toolOutput.perToolThresholdBytes")
 }
 
+func TestValidateModelPricingRejectsNegativePrices(t *testing.T) {
+	s := &Settings{
+		Model: "claude-3",
+		ModelPricing: map[string]ModelPrice{
+			"custom": {Input: 1, Output: -2},
+			" ":      {Input: 1},
+		},
+	}
+
+	err := ValidateSettings(s)
+	require.Error(t, err)
+	msg := err.Error()
+	require.Contains(t, msg, "modelPricing[custom]")
+	require.Contains(t, msg, "empty model name")
+}
+
 func TestValidateSettingsAggregatesErrors(t *testing.T) {
 	badHTTP, badSocks := 0, 70000
 	s := &Settings{
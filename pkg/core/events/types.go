@@ -160,16 +160,17 @@ type NotificationPayload struct {
 	Meta             map[string]any
 }
 
-// TokenUsagePayload reports model token usage for a completed run.
+// TokenUsagePayload reports the token usage and estimated cost of a model call.
 type TokenUsagePayload struct {
-	InputTokens   int64  `json:"input_tokens"`
-	OutputTokens  int64  `json:"output_tokens"`
-	TotalTokens   int64  `json:"total_tokens"`
-	CacheCreation int64  `json:"cache_creation_input_tokens,omitempty"`
-	CacheRead     int64  `json:"cache_read_input_tokens,omitempty"`
-	Model         string `json:"model,omitempty"`
-	SessionID     string `json:"session_id,omitempty"`
-	RequestID     string `json:"request_id,omitempty"`
+	InputTokens   int64   `json:"input_tokens"`
+	OutputTokens  int64   `json:"output_tokens"`
+	TotalTokens   int64   `json:"total_tokens"`
+	CacheCreation int64   `json:"cache_creation_input_tokens,omitempty"`
+	CacheRead     int64   `json:"cache_read_input_tokens,omitempty"`
+	CostUSD       float64 `json:"cost_usd,omitempty"`
+	Model         string  `json:"model,omitempty"`
+	SessionID     string  `json:"session_id,omitempty"`
+	RequestID     string  `json:"request_id,omitempty"`
 }
 
 // ModelSelectedPayload is emitted when a model is selected for tool execution.
@@ -177,6 +178,10 @@ type ModelSelectedPayload struct {
 	ToolName  string
 	ModelTier string
 	Reason    string
+	// Model and PreviousModel name the provider models when a failover
+	// chain switched between them.
+	Model         string
+	PreviousModel string
 }
 
 // MCPToolsChangedPayload is emitted when an MCP server notifies the client that
@@ -27,6 +27,17 @@ type Message struct {
 	ContentBlocks    []ContentBlock // Multimodal content; takes precedence over Content when non-empty
 	ToolCalls        []ToolCall
 	ReasoningContent string
+	// ThinkingBlocks keep signed and redacted reasoning for replay to the
+	// provider that produced it.
+	ThinkingBlocks []ThinkingBlock
+}
+
+// ThinkingBlock is one block of provider reasoning. Redacted blocks carry
+// only their encrypted data.
+type ThinkingBlock struct {
+	Text      string `json:"text,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Redacted  string `json:"redacted,omitempty"`
 }
 
 // ToolCall mirrors the shape of a tool invocation produced by the assistant.
@@ -43,6 +54,9 @@ func CloneMessage(msg Message) Message {
 	clone := Message{Role: msg.Role, Content: msg.Content, ReasoningContent: msg.ReasoningContent}
 	clone.ContentBlocks = cloneContentBlocks(msg.ContentBlocks)
 	clone.ToolCalls = cloneToolCalls(msg.ToolCalls)
+	if len(msg.ThinkingBlocks) > 0 {
+		clone.ThinkingBlocks = append([]ThinkingBlock(nil), msg.ThinkingBlocks...)
+	}
 	return clone
 }
 
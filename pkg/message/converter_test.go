@@ -31,6 +31,18 @@ func TestCloneMessagePreservesReasoningContent(t *testing.T) {
 	}
 }
 
+func TestCloneMessageCopiesThinkingBlocks(t *testing.T) {
+	msg := Message{Role: "assistant", ThinkingBlocks: []ThinkingBlock{{Text: "plan", Signature: "sig"}, {Redacted: "opaque"}}}
+	cloned := CloneMessage(msg)
+	if len(cloned.ThinkingBlocks) != 2 || cloned.ThinkingBlocks[0].Signature != "sig" || cloned.ThinkingBlocks[1].Redacted != "opaque" {
+		t.Fatalf("thinking blocks not preserved: %+v", cloned.ThinkingBlocks)
+	}
+	cloned.ThinkingBlocks[0].Signature = "changed"
+	if msg.ThinkingBlocks[0].Signature != "sig" {
+		t.Fatalf("original thinking blocks mutated")
+	}
+}
+
 func TestCloneMessageEmptyReasoningContent(t *testing.T) {
 	msg := Message{Role: "assistant", Content: "hello"}
 	cloned := CloneMessage(msg)
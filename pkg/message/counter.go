@@ -0,0 +1,307 @@
+package message
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Primer is implemented by counters whose exact counts come from a remote
+// service. Callers prime the messages they are about to size with their own
+// ctx, so Count itself never blocks.
+type Primer interface {
+	Prime(ctx context.Context, msgs []Message)
+}
+
+// Calibrator is implemented by counters that learn from the input token
+// counts providers report. sample holds the messages of a request as they were
+// sent and actualTokens the input tokens the provider billed for them.
+type Calibrator interface {
+	Observe(sample []Message, actualTokens int)
+}
+
+// perMessageOverhead approximates the role and framing tokens providers add
+// around every message.
+const perMessageOverhead = 4
+
+// EstimatingCounter approximates tokenizer output offline. It splits text into
+// words, digit groups, punctuation and whitespace runs the way BPE tokenizers
+// tend to, and counts CJK characters one token each, so it tracks code and
+// non-Latin text far better than NaiveCounter.
+type EstimatingCounter struct{}
+
+// Count implements TokenCounter.
+func (EstimatingCounter) Count(msg Message) int {
+	tokens := perMessageOverhead + EstimateTokens(msg.Content) + EstimateTokens(msg.ReasoningContent)
+	for _, block := range msg.ContentBlocks {
+		switch block.Type {
+		case ContentBlockText:
+			tokens += EstimateTokens(block.Text)
+		case ContentBlockImage:
+			tokens += 1600
+		case ContentBlockDocument:
+			tokens += len(block.Data)/6 + 500
+		default:
+			tokens++
+		}
+	}
+	for _, call := range msg.ToolCalls {
+		tokens += EstimateTokens(call.Name) + EstimateTokens(call.Result)
+		if len(call.Arguments) > 0 {
+			if raw, err := json.Marshal(call.Arguments); err == nil {
+				tokens += EstimateTokens(string(raw))
+			}
+		}
+	}
+	return tokens
+}
+
+// EstimateTokens approximates the token count of text.
+func EstimateTokens(text string) int {
+	tokens := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case isCJK(r):
+			tokens++
+			i += size
+		case unicode.IsSpace(r):
+			n := 0
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsSpace(r) {
+					break
+				}
+				n++
+				i += size
+			}
+			// A single space usually merges into the next word.
+			if n > 1 {
+				tokens += (n + 3) / 4
+			}
+		case unicode.IsDigit(r):
+			n := 0
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsDigit(r) {
+					break
+				}
+				n++
+				i += size
+			}
+			tokens += (n + 2) / 3
+		case unicode.IsLetter(r):
+			n, ascii := 0, true
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsLetter(r) || isCJK(r) {
+					break
+				}
+				ascii = ascii && r < utf8.RuneSelf
+				n++
+				i += size
+			}
+			if ascii {
+				tokens += 1 + (n-1)/6
+			} else {
+				tokens += 1 + (n-1)/3
+			}
+		default:
+			tokens++
+			i += size
+		}
+	}
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// CalibratedCounter scales a base counter by the ratio between its estimates
+// and the input tokens providers actually report. The ratio starts at 1 and
+// follows an exponential moving average of the observed samples.
+type CalibratedCounter struct {
+	base TokenCounter
+
+	mu      sync.Mutex
+	ratio   float64
+	samples int
+}
+
+const (
+	calibrationWeight = 0.3
+	minCalibration    = 0.25
+	maxCalibration    = 8
+)
+
+// NewCalibratedCounter wraps base, defaulting to EstimatingCounter when nil.
+func NewCalibratedCounter(base TokenCounter) *CalibratedCounter {
+	if base == nil {
+		base = EstimatingCounter{}
+	}
+	return &CalibratedCounter{base: base, ratio: 1}
+}
+
+// Count implements TokenCounter.
+func (c *CalibratedCounter) Count(msg Message) int {
+	estimate := c.base.Count(msg)
+	return max(1, int(math.Ceil(float64(estimate)*c.Ratio())))
+}
+
+// Observe implements Calibrator.
+func (c *CalibratedCounter) Observe(sample []Message, actualTokens int) {
+	estimate := 0
+	for _, msg := range sample {
+		estimate += c.base.Count(msg)
+	}
+	if estimate <= 0 || actualTokens <= 0 {
+		return
+	}
+	ratio := math.Min(maxCalibration, math.Max(minCalibration, float64(actualTokens)/float64(estimate)))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.samples == 0 {
+		c.ratio = ratio
+	} else {
+		c.ratio += calibrationWeight * (ratio - c.ratio)
+	}
+	c.samples++
+}
+
+// Prime implements Primer when the base counter does.
+func (c *CalibratedCounter) Prime(ctx context.Context, msgs []Message) {
+	if primer, ok := c.base.(Primer); ok {
+		primer.Prime(ctx, msgs)
+	}
+}
+
+// Ratio reports the current scale applied to the base counter.
+func (c *CalibratedCounter) Ratio() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ratio
+}
+
+// CountFunc asks a provider for the exact token count of a single message.
+type CountFunc func(ctx context.Context, msg Message) (int, error)
+
+const (
+	defaultProviderCountTimeout = 10 * time.Second
+	providerCountCacheSize      = 4096
+	providerCountParallelism    = 4
+)
+
+// ProviderCounter counts tokens through a provider endpoint and caches the
+// result per message content, so each distinct message costs one request.
+// Count only reads the cache and never blocks: Prime fetches the missing
+// counts, and until then, or when the provider fails, Count returns the
+// fallback estimate.
+type ProviderCounter struct {
+	count    CountFunc
+	fallback TokenCounter
+	// Timeout bounds each provider request; zero uses 10 seconds.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]int
+	order [][sha256.Size]byte
+}
+
+// NewProviderCounter constructs a ProviderCounter. A nil fallback uses
+// EstimatingCounter.
+func NewProviderCounter(count CountFunc, fallback TokenCounter) *ProviderCounter {
+	if fallback == nil {
+		fallback = EstimatingCounter{}
+	}
+	return &ProviderCounter{count: count, fallback: fallback, cache: map[[sha256.Size]byte]int{}}
+}
+
+// Count implements TokenCounter.
+func (p *ProviderCounter) Count(msg Message) int {
+	if key, ok := cacheKey(msg); ok {
+		if n, hit := p.cached(key); hit {
+			return n
+		}
+	}
+	return p.fallback.Count(msg)
+}
+
+// Prime implements Primer. It counts the uncached messages through the
+// provider, a few at a time, and stops starting new requests once ctx is
+// done.
+func (p *ProviderCounter) Prime(ctx context.Context, msgs []Message) {
+	if p.count == nil {
+		return
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultProviderCountTimeout
+	}
+	var (
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, providerCountParallelism)
+		seen = map[[sha256.Size]byte]bool{}
+	)
+	defer wg.Wait()
+	for _, msg := range msgs {
+		key, ok := cacheKey(msg)
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if _, hit := p.cached(key); hit {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		wg.Add(1)
+		go func(msg Message, key [sha256.Size]byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			reqCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if n, err := p.count(reqCtx, msg); err == nil && n > 0 {
+				p.store(key, n)
+			}
+		}(msg, key)
+	}
+}
+
+func (p *ProviderCounter) cached(key [sha256.Size]byte) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n, hit := p.cache[key]
+	return n, hit
+}
+
+func (p *ProviderCounter) store(key [sha256.Size]byte, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.cache[key]; exists {
+		return
+	}
+	if len(p.order) >= providerCountCacheSize {
+		delete(p.cache, p.order[0])
+		p.order = p.order[1:]
+	}
+	p.cache[key] = n
+	p.order = append(p.order, key)
+}
+
+func cacheKey(msg Message) ([sha256.Size]byte, bool) {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return [sha256.Size]byte{}, false
+	}
+	return sha256.Sum256(raw), true
+}
@@ -0,0 +1,149 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "empty", text: "", want: 0},
+		{name: "short words", text: "hello world", want: 2},
+		{name: "long word splits", text: "internationalization", want: 4},
+		{name: "cjk one token per character", text: "使用pnpm安装", want: 5},
+		{name: "digits grouped by three", text: "1234567", want: 3},
+		{name: "code punctuation", text: "if (x > 10) {", want: 7},
+		{name: "indentation", text: "\n        return", want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateTokens(tt.text); got != tt.want {
+				t.Fatalf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimatingCounterTracksCJKBetterThanNaive(t *testing.T) {
+	msg := Message{Role: "user", Content: strings.Repeat("汉", 300)}
+	if got := (EstimatingCounter{}).Count(msg); got != 300+perMessageOverhead {
+		t.Fatalf("expected one token per character plus overhead, got %d", got)
+	}
+	// len/4 on three-byte runes undercounts by a quarter.
+	if naive := (NaiveCounter{}).Count(msg); naive >= 300 {
+		t.Fatalf("naive counter changed, got %d", naive)
+	}
+}
+
+func TestCalibratedCounterLearnsFromUsage(t *testing.T) {
+	base := tokenCounterFunc(func(Message) int { return 10 })
+	counter := NewCalibratedCounter(base)
+	msg := Message{Role: "user", Content: "x"}
+	if got := counter.Count(msg); got != 10 {
+		t.Fatalf("uncalibrated count should match base, got %d", got)
+	}
+
+	counter.Observe([]Message{msg, msg}, 40)
+	if got := counter.Count(msg); got != 20 {
+		t.Fatalf("first sample should set the ratio, got %d", got)
+	}
+	counter.Observe([]Message{msg, msg}, 20)
+	if ratio := counter.Ratio(); ratio < 1.69 || ratio > 1.71 {
+		t.Fatalf("expected moving average 1.7, got %v", ratio)
+	}
+	counter.Observe([]Message{msg}, 0)
+	counter.Observe(nil, 50)
+	if ratio := counter.Ratio(); ratio < 1.69 || ratio > 1.71 {
+		t.Fatalf("empty samples must be ignored, got %v", ratio)
+	}
+	counter.Observe([]Message{msg}, 100000)
+	if ratio := counter.Ratio(); ratio > maxCalibration {
+		t.Fatalf("ratio should be clamped, got %v", ratio)
+	}
+}
+
+func TestProviderCounterCachesAndFallsBack(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls int
+		fail  bool
+	)
+	counter := NewProviderCounter(func(ctx context.Context, msg Message) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if fail {
+			return 0, errors.New("unavailable")
+		}
+		return len(msg.Content), nil
+	}, tokenCounterFunc(func(Message) int { return 99 }))
+
+	msg := Message{Role: "user", Content: "abcdef", ToolCalls: []ToolCall{{ID: "t", Arguments: map[string]any{"b": 1, "a": 2}}}}
+	if got := counter.Count(msg); got != 99 || calls != 0 {
+		t.Fatalf("Count must not call the provider, got %d after %d calls", got, calls)
+	}
+	counter.Prime(context.Background(), []Message{msg, CloneMessage(msg)})
+	if got := counter.Count(CloneMessage(msg)); got != 6 || calls != 1 {
+		t.Fatalf("expected one provider call for equal messages, got %d after %d calls", got, calls)
+	}
+	counter.Prime(context.Background(), []Message{msg})
+	if calls != 1 {
+		t.Fatalf("cached messages must not be primed again, got %d calls", calls)
+	}
+
+	fail = true
+	other := Message{Role: "user", Content: "other"}
+	counter.Prime(context.Background(), []Message{other})
+	if got := counter.Count(other); got != 99 {
+		t.Fatalf("expected fallback on provider error, got %d", got)
+	}
+	fail = false
+	counter.Prime(context.Background(), []Message{other})
+	if got := counter.Count(other); got != 5 || calls != 3 {
+		t.Fatalf("fallback results must not be cached, got %d after %d calls", got, calls)
+	}
+}
+
+func TestProviderCounterPrimeStopsWithContext(t *testing.T) {
+	var calls atomic.Int32
+	counter := NewProviderCounter(func(ctx context.Context, msg Message) (int, error) {
+		calls.Add(1)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}, nil)
+	msgs := make([]Message, 20)
+	for i := range msgs {
+		msgs[i] = Message{Role: "user", Content: strings.Repeat("x", i+1)}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	counter.Prime(ctx, msgs)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Prime must return once ctx is done, took %s", elapsed)
+	}
+	if n := calls.Load(); n > providerCountParallelism {
+		t.Fatalf("expected at most %d requests in flight before cancellation, got %d", providerCountParallelism, n)
+	}
+}
+
+func TestHistoryUsesConfiguredCounter(t *testing.T) {
+	hist := NewHistoryWithCounter(tokenCounterFunc(func(Message) int { return 7 }))
+	hist.Append(Message{Role: "user"})
+	hist.Replace([]Message{{Role: "user"}, {Role: "assistant"}})
+	if got := hist.TokenCount(); got != 14 {
+		t.Fatalf("expected counter to drive TokenCount, got %d", got)
+	}
+	if NewHistoryWithCounter(nil).counter == nil {
+		t.Fatal("nil counter should fall back to NaiveCounter")
+	}
+}
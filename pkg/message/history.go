@@ -14,37 +14,48 @@ type History struct {
 // NewHistory constructs an empty history.
 func NewHistory() *History { return &History{counter: NaiveCounter{}} }
 
+// NewHistoryWithCounter constructs an empty history whose TokenCount uses
+// counter. A nil counter falls back to NaiveCounter.
+func NewHistoryWithCounter(counter TokenCounter) *History {
+	if counter == nil {
+		counter = NaiveCounter{}
+	}
+	return &History{counter: counter}
+}
+
 // Append stores a message at the end of the history. The message is cloned to
 // avoid external mutation after insertion.
 func (h *History) Append(msg Message) {
+	cloned := CloneMessage(msg)
+	// Count outside the lock: custom counters may be slow.
+	cost := h.count(cloned)
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	cloned := CloneMessage(msg)
 	h.messages = append(h.messages, cloned)
-	counter := h.counter
-	if counter == nil {
-		counter = NaiveCounter{}
-	}
-	h.tokenCount += counter.Count(cloned)
+	h.tokenCount += cost
 }
 
 // Replace swaps the stored history with the provided slice, cloning entries to
 // keep ownership local to the History.
 func (h *History) Replace(msgs []Message) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.messages = CloneMessages(msgs)
-	counter := h.counter
-	if counter == nil {
-		counter = NaiveCounter{}
-	}
+	cloned := CloneMessages(msgs)
 	total := 0
-	for _, msg := range h.messages {
-		total += counter.Count(msg)
+	for _, msg := range cloned {
+		total += h.count(msg)
 	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = cloned
 	h.tokenCount = total
 }
 
+func (h *History) count(msg Message) int {
+	if h.counter == nil {
+		return NaiveCounter{}.Count(msg)
+	}
+	return h.counter.Count(msg)
+}
+
 // All returns a cloned snapshot of the history in order from oldest to newest.
 func (h *History) All() []Message {
 	h.mu.RLock()
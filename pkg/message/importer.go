@@ -0,0 +1,443 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ImportAnthropicMessages converts an Anthropic Messages API payload into a
+// history. data holds either a request body with system and messages fields
+// or a bare messages array. tool_use blocks become ToolCalls, every
+// tool_result becomes a "tool" message, thinking blocks become
+// ReasoningContent, signed and redacted thinking is kept on ThinkingBlocks,
+// and images and documents are kept as ContentBlocks. Images and documents
+// inside a tool_result are kept as ContentBlocks on the user message that
+// follows the tool results.
+func ImportAnthropicMessages(data []byte) ([]Message, error) {
+	var payload struct {
+		System   json.RawMessage    `json:"system"`
+		Messages []anthropicMessage `json:"messages"`
+	}
+	if isJSONArray(data) {
+		if err := json.Unmarshal(data, &payload.Messages); err != nil {
+			return nil, fmt.Errorf("decode anthropic messages: %w", err)
+		}
+	} else if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("decode anthropic messages: %w", err)
+	}
+
+	var out []Message
+	if system, err := anthropicText(payload.System); err != nil {
+		return nil, fmt.Errorf("decode anthropic system: %w", err)
+	} else if system != "" {
+		out = append(out, Message{Role: "system", Content: system})
+	}
+	im := newAnthropicImporter()
+	for i, msg := range payload.Messages {
+		converted, err := im.convert(msg.Role, msg.Content)
+		if err != nil {
+			return nil, fmt.Errorf("decode anthropic message %d: %w", i, err)
+		}
+		out = append(out, converted...)
+	}
+	return out, nil
+}
+
+// ImportClaudeCodeTranscript converts a Claude Code session log, one of the
+// ~/.claude/projects/<project>/<session>.jsonl files, into a history. Entries
+// of subagents (sidechains), meta entries and summaries are skipped, and the
+// per-block entries Claude Code writes for one assistant response are merged
+// back into a single message.
+func ImportClaudeCodeTranscript(r io.Reader) ([]Message, error) {
+	var (
+		out     []Message
+		lastID  string
+		im      = newAnthropicImporter()
+		reader  = bufio.NewReader(r)
+		lineNum int
+	)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return nil, fmt.Errorf("read claude code transcript: %w", readErr)
+		}
+		lineNum++
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var entry struct {
+				Type        string `json:"type"`
+				IsSidechain bool   `json:"isSidechain"`
+				IsMeta      bool   `json:"isMeta"`
+				Message     struct {
+					ID      string          `json:"id"`
+					Role    string          `json:"role"`
+					Content json.RawMessage `json:"content"`
+				} `json:"message"`
+			}
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return nil, fmt.Errorf("decode claude code transcript line %d: %w", lineNum, err)
+			}
+			if (entry.Type == "user" || entry.Type == "assistant") && !entry.IsSidechain && !entry.IsMeta {
+				converted, err := im.convert(entry.Message.Role, entry.Message.Content)
+				if err != nil {
+					return nil, fmt.Errorf("decode claude code transcript line %d: %w", lineNum, err)
+				}
+				if len(converted) == 1 && converted[0].Role == "assistant" && entry.Message.ID != "" &&
+					entry.Message.ID == lastID && len(out) > 0 && out[len(out)-1].Role == "assistant" {
+					out[len(out)-1] = mergeAssistant(out[len(out)-1], converted[0])
+				} else {
+					out = append(out, converted...)
+				}
+				lastID = entry.Message.ID
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			return out, nil
+		}
+	}
+}
+
+// ImportOpenAIMessages converts OpenAI Chat Completions messages into a
+// history. data holds either a request body with a messages field or a bare
+// messages array. Assistant tool_calls become ToolCalls, "tool" messages
+// their results, reasoning_content is kept and image and file parts become
+// ContentBlocks.
+func ImportOpenAIMessages(data []byte) ([]Message, error) {
+	var payload struct {
+		Messages []openAIMessage `json:"messages"`
+	}
+	if isJSONArray(data) {
+		if err := json.Unmarshal(data, &payload.Messages); err != nil {
+			return nil, fmt.Errorf("decode openai messages: %w", err)
+		}
+	} else if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("decode openai messages: %w", err)
+	}
+
+	names := map[string]string{}
+	out := make([]Message, 0, len(payload.Messages))
+	for i, msg := range payload.Messages {
+		text, blocks, err := openAIContent(msg.Content)
+		if err != nil {
+			return nil, fmt.Errorf("decode openai message %d: %w", i, err)
+		}
+		switch msg.Role {
+		case "system", "developer":
+			out = append(out, Message{Role: "system", Content: text})
+		case "assistant":
+			converted := Message{Role: "assistant", Content: text, ReasoningContent: msg.ReasoningContent}
+			if converted.Content == "" {
+				converted.Content = msg.Refusal
+			}
+			for _, call := range msg.ToolCalls {
+				names[call.ID] = call.Function.Name
+				converted.ToolCalls = append(converted.ToolCalls, ToolCall{
+					ID:        call.ID,
+					Name:      call.Function.Name,
+					Arguments: decodeArguments(call.Function.Arguments),
+				})
+			}
+			out = append(out, converted)
+		case "tool":
+			name := msg.Name
+			if name == "" {
+				name = names[msg.ToolCallID]
+			}
+			out = append(out, Message{Role: "tool", ToolCalls: []ToolCall{{ID: msg.ToolCallID, Name: name, Result: text}}})
+		case "user":
+			out = append(out, userMessage(text, blocks))
+		default:
+			return nil, fmt.Errorf("decode openai message %d: unsupported role %q", i, msg.Role)
+		}
+	}
+	return out, nil
+}
+
+type anthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text"`
+	Thinking  string           `json:"thinking"`
+	Signature string           `json:"signature"`
+	Data      string           `json:"data"`
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Input     json.RawMessage  `json:"input"`
+	ToolUseID string           `json:"tool_use_id"`
+	Content   json.RawMessage  `json:"content"`
+	IsError   bool             `json:"is_error"`
+	Source    *anthropicSource `json:"source"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+	URL       string `json:"url"`
+}
+
+// anthropicImporter converts Anthropic messages, remembering tool names so
+// results can be labelled with the tool that produced them.
+type anthropicImporter struct {
+	names map[string]string
+}
+
+func newAnthropicImporter() *anthropicImporter {
+	return &anthropicImporter{names: map[string]string{}}
+}
+
+func (im *anthropicImporter) convert(role string, content json.RawMessage) ([]Message, error) {
+	blocks, err := decodeAnthropicBlocks(content)
+	if err != nil {
+		return nil, err
+	}
+	if role == "assistant" {
+		msg := Message{Role: "assistant"}
+		var text, reasoning []string
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				text = append(text, block.Text)
+			case "thinking":
+				reasoning = append(reasoning, block.Thinking)
+				msg.ThinkingBlocks = append(msg.ThinkingBlocks, ThinkingBlock{Text: block.Thinking, Signature: block.Signature})
+			case "redacted_thinking":
+				msg.ThinkingBlocks = append(msg.ThinkingBlocks, ThinkingBlock{Redacted: block.Data})
+			case "tool_use":
+				im.names[block.ID] = block.Name
+				msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: block.ID, Name: block.Name, Arguments: decodeArguments(string(block.Input))})
+			}
+		}
+		msg.Content = strings.Join(text, "\n\n")
+		msg.ReasoningContent = strings.Join(reasoning, "\n\n")
+		return []Message{msg}, nil
+	}
+
+	var (
+		out   []Message
+		texts []string
+		rich  []ContentBlock
+	)
+	for _, block := range blocks {
+		switch block.Type {
+		case "tool_result":
+			inner, err := decodeAnthropicBlocks(block.Content)
+			if err != nil {
+				return nil, err
+			}
+			// Tool messages carry only text, so images and documents a tool
+			// returned move to the user turn that follows its results.
+			var parts []string
+			for _, b := range inner {
+				switch b.Type {
+				case "text":
+					if b.Text != "" {
+						parts = append(parts, b.Text)
+					}
+				case "image", "document":
+					if cb, ok := sourceBlock(b); ok {
+						rich = append(rich, cb)
+					}
+				}
+			}
+			result := strings.Join(parts, "\n\n")
+			if block.IsError && !strings.HasPrefix(strings.TrimSpace(result), `{"error"`) {
+				result = fmt.Sprintf(`{"error":%q}`, result)
+			}
+			out = append(out, Message{Role: "tool", ToolCalls: []ToolCall{{ID: block.ToolUseID, Name: im.names[block.ToolUseID], Result: result}}})
+		case "text":
+			texts = append(texts, block.Text)
+			rich = append(rich, ContentBlock{Type: ContentBlockText, Text: block.Text})
+		case "image", "document":
+			if cb, ok := sourceBlock(block); ok {
+				rich = append(rich, cb)
+			}
+		}
+	}
+	if len(rich) > 0 {
+		if len(rich) == len(texts) {
+			rich = nil
+		}
+		out = append(out, userMessage(strings.Join(texts, "\n\n"), rich))
+	}
+	return out, nil
+}
+
+func mergeAssistant(prev, next Message) Message {
+	prev.Content = joinNonEmpty(prev.Content, next.Content)
+	prev.ReasoningContent = joinNonEmpty(prev.ReasoningContent, next.ReasoningContent)
+	prev.ThinkingBlocks = append(prev.ThinkingBlocks, next.ThinkingBlocks...)
+	prev.ToolCalls = append(prev.ToolCalls, next.ToolCalls...)
+	return prev
+}
+
+func joinNonEmpty(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	return a + "\n\n" + b
+}
+
+func sourceBlock(block anthropicBlock) (ContentBlock, bool) {
+	if block.Source == nil {
+		return ContentBlock{}, false
+	}
+	cb := ContentBlock{Type: ContentBlockImage, MediaType: block.Source.MediaType, Data: block.Source.Data, URL: block.Source.URL}
+	if block.Type == "document" {
+		cb.Type = ContentBlockDocument
+		if block.Source.Type == "text" {
+			cb.Type, cb.Text, cb.Data = ContentBlockText, block.Source.Data, ""
+		}
+	}
+	return cb, cb.Data != "" || cb.URL != "" || cb.Text != ""
+}
+
+// decodeAnthropicBlocks accepts content given as a string or a block array.
+func decodeAnthropicBlocks(raw json.RawMessage) ([]anthropicBlock, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if raw[0] == '"' {
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return nil, err
+		}
+		return []anthropicBlock{{Type: "text", Text: text}}, nil
+	}
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// anthropicText joins the text of content given as a string or block array.
+func anthropicText(raw json.RawMessage) (string, error) {
+	blocks, err := decodeAnthropicBlocks(raw)
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+type openAIMessage struct {
+	Role             string          `json:"role"`
+	Content          json.RawMessage `json:"content"`
+	Name             string          `json:"name"`
+	Refusal          string          `json:"refusal"`
+	ReasoningContent string          `json:"reasoning_content"`
+	ToolCallID       string          `json:"tool_call_id"`
+	ToolCalls        []struct {
+		ID       string `json:"id"`
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	} `json:"tool_calls"`
+}
+
+// openAIContent decodes content given as a string or a part array into its
+// joined text and, when it holds images or files, the full block list.
+func openAIContent(raw json.RawMessage) (string, []ContentBlock, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return "", nil, nil
+	}
+	if raw[0] == '"' {
+		var text string
+		err := json.Unmarshal(raw, &text)
+		return text, nil, err
+	}
+	var parts []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+		File struct {
+			FileData string `json:"file_data"`
+		} `json:"file"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil, err
+	}
+	var (
+		texts  []string
+		blocks []ContentBlock
+		rich   bool
+	)
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+			blocks = append(blocks, ContentBlock{Type: ContentBlockText, Text: part.Text})
+		case "image_url":
+			cb := dataURLBlock(ContentBlockImage, part.ImageURL.URL)
+			blocks, rich = append(blocks, cb), true
+		case "file":
+			if part.File.FileData != "" {
+				cb := dataURLBlock(ContentBlockDocument, part.File.FileData)
+				blocks, rich = append(blocks, cb), true
+			}
+		}
+	}
+	if !rich {
+		blocks = nil
+	}
+	return strings.Join(texts, "\n\n"), blocks, nil
+}
+
+// dataURLBlock splits data: URLs into media type and base64 payload and
+// keeps other URLs as they are.
+func dataURLBlock(kind ContentBlockType, url string) ContentBlock {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		if meta, data, ok := strings.Cut(rest, ","); ok {
+			return ContentBlock{Type: kind, MediaType: strings.TrimSuffix(meta, ";base64"), Data: data}
+		}
+	}
+	return ContentBlock{Type: kind, URL: url}
+}
+
+func userMessage(text string, blocks []ContentBlock) Message {
+	if len(blocks) > 0 {
+		return Message{Role: "user", ContentBlocks: blocks}
+	}
+	return Message{Role: "user", Content: text}
+}
+
+// decodeArguments parses tool arguments the way the provider adapters do,
+// keeping undecodable input under "raw".
+func decodeArguments(raw string) map[string]any {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "null" {
+		return nil
+	}
+	var args map[string]any
+	if err := json.Unmarshal([]byte(raw), &args); err != nil {
+		return map[string]any{"raw": raw}
+	}
+	return args
+}
+
+func isJSONArray(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] == '['
+}
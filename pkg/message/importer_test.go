@@ -0,0 +1,161 @@
+package message
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportAnthropicMessages(t *testing.T) {
+	payload := `{
+		"system": [{"type": "text", "text": "be brief"}],
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "what is in this picture?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aGk="}},
+				{"type": "document", "source": {"type": "url", "url": "https://example.com/a.pdf"}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "look closely", "signature": "sig"},
+				{"type": "redacted_thinking", "data": "opaque"},
+				{"type": "text", "text": "let me check"},
+				{"type": "tool_use", "id": "t1", "name": "read", "input": {"path": "a.txt"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "t1", "content": [{"type": "text", "text": "no such file"}], "is_error": true},
+				{"type": "text", "text": "try b.txt"}
+			]},
+			{"role": "assistant", "content": "done"}
+		]
+	}`
+	msgs, err := ImportAnthropicMessages([]byte(payload))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(msgs) != 6 {
+		t.Fatalf("expected 6 messages, got %d: %+v", len(msgs), msgs)
+	}
+	if msgs[0].Role != "system" || msgs[0].Content != "be brief" {
+		t.Fatalf("unexpected system message %+v", msgs[0])
+	}
+	blocks := msgs[1].ContentBlocks
+	if len(blocks) != 3 || blocks[0].Text != "what is in this picture?" || blocks[1].Type != ContentBlockImage || blocks[1].MediaType != "image/png" || blocks[1].Data != "aGk=" || blocks[2].Type != ContentBlockDocument || blocks[2].URL == "" {
+		t.Fatalf("expected text, image and document blocks, got %+v", blocks)
+	}
+	assistant := msgs[2]
+	if assistant.ReasoningContent != "look closely" || assistant.Content != "let me check" || len(assistant.ToolCalls) != 1 || assistant.ToolCalls[0].Arguments["path"] != "a.txt" {
+		t.Fatalf("unexpected assistant message %+v", assistant)
+	}
+	if len(assistant.ThinkingBlocks) != 2 || assistant.ThinkingBlocks[0] != (ThinkingBlock{Text: "look closely", Signature: "sig"}) || assistant.ThinkingBlocks[1] != (ThinkingBlock{Redacted: "opaque"}) {
+		t.Fatalf("expected signed and redacted thinking kept, got %+v", assistant.ThinkingBlocks)
+	}
+	result := msgs[3]
+	if result.Role != "tool" || result.ToolCalls[0].ID != "t1" || result.ToolCalls[0].Name != "read" || result.ToolCalls[0].Result != `{"error":"no such file"}` {
+		t.Fatalf("unexpected tool result %+v", result)
+	}
+	if msgs[4].Role != "user" || msgs[4].Content != "try b.txt" || msgs[5].Content != "done" {
+		t.Fatalf("unexpected trailing messages %+v", msgs[4:])
+	}
+
+	bare, err := ImportAnthropicMessages([]byte(`[{"role":"user","content":"hi"}]`))
+	if err != nil || len(bare) != 1 || bare[0].Content != "hi" {
+		t.Fatalf("bare array: %+v err=%v", bare, err)
+	}
+	if _, err := ImportAnthropicMessages([]byte(`{"messages": 3}`)); err == nil {
+		t.Fatal("expected decode error")
+	}
+}
+
+func TestImportAnthropicToolResultImages(t *testing.T) {
+	payload := `[
+		{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "screenshot", "input": {}}]},
+		{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": [
+			{"type": "text", "text": "captured"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aGk="}}
+		]}]}
+	]`
+	msgs, err := ImportAnthropicMessages([]byte(payload))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("expected assistant, tool and user messages, got %+v", msgs)
+	}
+	if msgs[1].Role != "tool" || msgs[1].ToolCalls[0].Result != "captured" {
+		t.Fatalf("unexpected tool result %+v", msgs[1])
+	}
+	blocks := msgs[2].ContentBlocks
+	if msgs[2].Role != "user" || len(blocks) != 1 || blocks[0].Type != ContentBlockImage || blocks[0].Data != "aGk=" {
+		t.Fatalf("expected the tool's image on the following user message, got %+v", msgs[2])
+	}
+}
+
+func TestImportOpenAIMessages(t *testing.T) {
+	payload := `{"model": "gpt-4o", "messages": [
+		{"role": "developer", "content": "be brief"},
+		{"role": "user", "content": [
+			{"type": "text", "text": "describe"},
+			{"type": "image_url", "image_url": {"url": "data:image/jpeg;base64,/9j/"}},
+			{"type": "image_url", "image_url": {"url": "https://example.com/cat.png"}}
+		]},
+		{"role": "assistant", "content": null, "reasoning_content": "need the file", "tool_calls": [
+			{"id": "c1", "type": "function", "function": {"name": "read", "arguments": "{\"path\":\"a.txt\"}"}},
+			{"id": "c2", "type": "function", "function": {"name": "grep", "arguments": "not json"}}
+		]},
+		{"role": "tool", "tool_call_id": "c1", "content": "contents"},
+		{"role": "tool", "tool_call_id": "c2", "content": [{"type": "text", "text": "matches"}]},
+		{"role": "assistant", "content": "a cat"}
+	]}`
+	msgs, err := ImportOpenAIMessages([]byte(payload))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(msgs) != 6 || msgs[0].Role != "system" {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	blocks := msgs[1].ContentBlocks
+	if len(blocks) != 3 || blocks[1].MediaType != "image/jpeg" || blocks[1].Data != "/9j/" || blocks[2].URL != "https://example.com/cat.png" {
+		t.Fatalf("unexpected image blocks %+v", blocks)
+	}
+	calls := msgs[2].ToolCalls
+	if msgs[2].ReasoningContent != "need the file" || len(calls) != 2 || calls[0].Arguments["path"] != "a.txt" || calls[1].Arguments["raw"] != "not json" {
+		t.Fatalf("unexpected assistant message %+v", msgs[2])
+	}
+	if r := msgs[4].ToolCalls[0]; r.ID != "c2" || r.Name != "grep" || r.Result != "matches" {
+		t.Fatalf("unexpected tool result %+v", r)
+	}
+	if _, err := ImportOpenAIMessages([]byte(`[{"role":"narrator","content":"x"}]`)); err == nil {
+		t.Fatal("expected unsupported role error")
+	}
+}
+
+func TestImportClaudeCodeTranscript(t *testing.T) {
+	log := strings.Join([]string{
+		`{"type":"summary","summary":"Fix tests","leafUuid":"x"}`,
+		`{"type":"user","isMeta":true,"message":{"role":"user","content":"<local-command-caveat>"}}`,
+		`{"type":"user","message":{"role":"user","content":"run the tests"}}`,
+		`{"type":"assistant","message":{"id":"msg_1","role":"assistant","content":[{"type":"thinking","thinking":"use go test"}]}}`,
+		`{"type":"assistant","message":{"id":"msg_1","role":"assistant","content":[{"type":"text","text":"Running them."}]}}`,
+		`{"type":"assistant","message":{"id":"msg_1","role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"go test ./..."}}]}}`,
+		`{"type":"assistant","isSidechain":true,"message":{"id":"msg_s","role":"assistant","content":"subagent chatter"}}`,
+		``,
+		`{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"ok"}]},"toolUseResult":{"stdout":"ok"}}`,
+		`{"type":"assistant","message":{"id":"msg_2","role":"assistant","content":[{"type":"text","text":"All green."}]}}`,
+	}, "\n")
+	msgs, err := ImportClaudeCodeTranscript(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(msgs) != 4 {
+		t.Fatalf("expected user, merged assistant, tool result and answer, got %d: %+v", len(msgs), msgs)
+	}
+	merged := msgs[1]
+	if merged.ReasoningContent != "use go test" || merged.Content != "Running them." || len(merged.ToolCalls) != 1 || merged.ToolCalls[0].Name != "Bash" {
+		t.Fatalf("assistant blocks should merge into one message, got %+v", merged)
+	}
+	if r := msgs[2].ToolCalls[0]; msgs[2].Role != "tool" || r.ID != "toolu_1" || r.Name != "Bash" || r.Result != "ok" {
+		t.Fatalf("unexpected tool result %+v", msgs[2])
+	}
+	if _, err := ImportClaudeCodeTranscript(strings.NewReader("{not json}\n")); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("expected decode error naming the line, got %v", err)
+	}
+}
@@ -51,6 +51,31 @@ func (NaiveCounter) Count(msg Message) int {
 type Trimmer struct {
 	MaxTokens int
 	Counter   TokenCounter
+	// KeepToolPairs trims whole units instead of single messages: an
+	// assistant message with tool calls and the tool results that follow it
+	// are kept or dropped together, tool results without their call are
+	// dropped, and the first user message and the newest unit are always
+	// kept.
+	KeepToolPairs bool
+	// Pinned marks messages that are always kept in KeepToolPairs mode,
+	// together with the rest of their unit, even past MaxTokens.
+	Pinned func(Message) bool
+}
+
+// TrimReport describes the messages a Trim call dropped.
+type TrimReport struct {
+	Dropped       int
+	DroppedTokens int
+	// Gaps lists each run of consecutive dropped messages in order. Kept
+	// units such as pinned messages can split the dropped range in several.
+	Gaps []TrimGap
+}
+
+// TrimGap is a run of consecutive messages dropped by a Trim call.
+type TrimGap struct {
+	// Index is the position in the trimmed slice where the run used to be.
+	Index   int
+	Dropped int
 }
 
 // NewTrimmer constructs a Trimmer with the provided token limit. When counter
@@ -65,14 +90,23 @@ func NewTrimmer(limit int, counter TokenCounter) *Trimmer {
 // Trim returns a trimmed copy of messages that fits within the token limit. If
 // the limit is zero or negative an empty slice is returned.
 func (t *Trimmer) Trim(history []Message) []Message {
+	kept, _ := t.TrimWithReport(history)
+	return kept
+}
+
+// TrimWithReport trims like Trim and also reports what was dropped.
+func (t *Trimmer) TrimWithReport(history []Message) ([]Message, TrimReport) {
 	if t == nil || t.MaxTokens <= 0 {
-		return []Message{}
+		return []Message{}, TrimReport{Dropped: len(history)}
 	}
 
 	counter := t.Counter
 	if counter == nil {
 		counter = NaiveCounter{}
 	}
+	if t.KeepToolPairs {
+		return t.trimUnits(history, counter)
+	}
 
 	tokens := 0
 	kept := make([]Message, 0, len(history))
@@ -90,5 +124,106 @@ func (t *Trimmer) Trim(history []Message) []Message {
 	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
 		kept[i], kept[j] = kept[j], kept[i]
 	}
-	return kept
+	report := TrimReport{Dropped: len(history) - len(kept)}
+	for _, msg := range history[:report.Dropped] {
+		report.DroppedTokens += counter.Count(msg)
+	}
+	if report.Dropped > 0 {
+		report.Gaps = []TrimGap{{Index: 0, Dropped: report.Dropped}}
+	}
+	return kept, report
+}
+
+// trimUnit is a run of messages kept or dropped as a whole.
+type trimUnit struct {
+	start, end int
+	cost       int
+	keep       bool
+	orphan     bool
+}
+
+// trimUnits keeps the required units, then the newest units that still fit.
+func (t *Trimmer) trimUnits(history []Message, counter TokenCounter) ([]Message, TrimReport) {
+	units := splitUnits(history, counter)
+	firstUser := -1
+	for i, msg := range history {
+		if msg.Role == "user" {
+			firstUser = i
+			break
+		}
+	}
+
+	tokens := 0
+	for i := range units {
+		u := &units[i]
+		if u.orphan {
+			continue
+		}
+		if (firstUser >= u.start && firstUser < u.end) || i == len(units)-1 {
+			u.keep = true
+		}
+		for j := u.start; j < u.end && !u.keep && t.Pinned != nil; j++ {
+			u.keep = t.Pinned(history[j])
+		}
+		if u.keep {
+			tokens += u.cost
+		}
+	}
+	for i := len(units) - 1; i >= 0; i-- {
+		u := &units[i]
+		if u.keep || u.orphan {
+			continue
+		}
+		if tokens+u.cost > t.MaxTokens {
+			break
+		}
+		u.keep = true
+		tokens += u.cost
+	}
+
+	kept := make([]Message, 0, len(history))
+	report := TrimReport{}
+	for _, u := range units {
+		if u.keep {
+			for _, msg := range history[u.start:u.end] {
+				kept = append(kept, CloneMessage(msg))
+			}
+			continue
+		}
+		if n := len(report.Gaps); n > 0 && report.Gaps[n-1].Index == len(kept) {
+			report.Gaps[n-1].Dropped += u.end - u.start
+		} else {
+			report.Gaps = append(report.Gaps, TrimGap{Index: len(kept), Dropped: u.end - u.start})
+		}
+		report.Dropped += u.end - u.start
+		report.DroppedTokens += u.cost
+	}
+	return kept, report
+}
+
+// splitUnits groups each assistant message with tool calls together with the
+// tool results that follow it. Tool results without a preceding call form
+// orphan units.
+func splitUnits(history []Message, counter TokenCounter) []trimUnit {
+	var units []trimUnit
+	for i := 0; i < len(history); {
+		u := trimUnit{start: i, end: i + 1}
+		switch {
+		case history[i].Role == "assistant" && len(history[i].ToolCalls) > 0:
+			for u.end < len(history) && history[u.end].Role == "tool" {
+				u.end++
+			}
+		case history[i].Role == "tool":
+			u.orphan = true
+			for u.end < len(history) && history[u.end].Role == "tool" {
+				u.end++
+			}
+		}
+		for _, msg := range history[u.start:u.end] {
+			u.cost += counter.Count(msg)
+		}
+		units = append(units, u)
+		i = u.end
+	}
+	return units
 }
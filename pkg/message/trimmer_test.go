@@ -164,3 +164,68 @@ func TestTrimmerTableScenarios(t *testing.T) {
 type tokenCounterFunc func(Message) int
 
 func (f tokenCounterFunc) Count(msg Message) int { return f(msg) }
+
+func TestTrimmerKeepToolPairsDropsWholeUnits(t *testing.T) {
+	history := []Message{
+		{Role: "user", Content: "task"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "a", Name: "read"}, {ID: "b", Name: "read"}}},
+		{Role: "tool", Content: "ra", ToolCalls: []ToolCall{{ID: "a"}}},
+		{Role: "tool", Content: "rb", ToolCalls: []ToolCall{{ID: "b"}}},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "c", Name: "read"}}},
+		{Role: "tool", Content: "rc", ToolCalls: []ToolCall{{ID: "c"}}},
+		{Role: "assistant", Content: "done"},
+	}
+	trimmer := Trimmer{MaxTokens: 4, Counter: tokenCounterFunc(func(Message) int { return 1 }), KeepToolPairs: true}
+	got, report := trimmer.TrimWithReport(history)
+
+	// The first user message costs 1; the newest units (1 + 2) fit, the
+	// three-message unit would not and must not be split.
+	want := []string{"task", "", "rc", "done"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d messages, got %+v", len(want), got)
+	}
+	for i, content := range want {
+		if got[i].Content != content {
+			t.Fatalf("message %d: want %q got %q", i, content, got[i].Content)
+		}
+	}
+	if got[1].Role != "assistant" || got[1].ToolCalls[0].ID != "c" {
+		t.Fatalf("tool call should stay with its result, got %+v", got[1])
+	}
+	if report.Dropped != 3 || report.DroppedTokens != 3 || len(report.Gaps) != 1 || report.Gaps[0] != (TrimGap{Index: 1, Dropped: 3}) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestTrimmerKeepToolPairsKeepsPinnedAndDropsOrphans(t *testing.T) {
+	history := []Message{
+		{Role: "tool", Content: "orphan", ToolCalls: []ToolCall{{ID: "x"}}},
+		{Role: "user", Content: "first"},
+		{Role: "system", Content: "summary"},
+		{Role: "assistant", Content: "old"},
+		{Role: "user", Content: "latest"},
+	}
+	trimmer := Trimmer{
+		MaxTokens:     1,
+		Counter:       tokenCounterFunc(func(Message) int { return 1 }),
+		KeepToolPairs: true,
+		Pinned:        func(msg Message) bool { return msg.Role == "system" },
+	}
+	got, report := trimmer.TrimWithReport(history)
+	if len(got) != 3 || got[0].Content != "first" || got[1].Content != "summary" || got[2].Content != "latest" {
+		t.Fatalf("expected first user, pinned and newest message even past the limit, got %+v", got)
+	}
+	// The orphan before the first user message and the message after the
+	// pinned summary leave separate gaps.
+	if report.Dropped != 2 || len(report.Gaps) != 2 || report.Gaps[0] != (TrimGap{Index: 0, Dropped: 1}) || report.Gaps[1] != (TrimGap{Index: 2, Dropped: 1}) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestTrimmerReportsDroppedInDefaultMode(t *testing.T) {
+	trimmer := Trimmer{MaxTokens: 1, Counter: tokenCounterFunc(func(Message) int { return 1 })}
+	got, report := trimmer.TrimWithReport([]Message{{Content: "a"}, {Content: "b"}})
+	if len(got) != 1 || report.Dropped != 1 || report.DroppedTokens != 1 || len(report.Gaps) != 1 || report.Gaps[0] != (TrimGap{Index: 0, Dropped: 1}) {
+		t.Fatalf("unexpected trim %+v report %+v", got, report)
+	}
+}
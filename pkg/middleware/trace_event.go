@@ -275,6 +275,9 @@ func snapshotModelRequest(req *model.Request) map[string]any {
 	if strings.TrimSpace(req.System) != "" {
 		payload["system"] = req.System
 	}
+	if len(req.SystemBlocks) > 0 {
+		payload["system_blocks"] = sanitizePayload(req.SystemBlocks)
+	}
 	if req.MaxTokens != 0 {
 		payload["max_tokens"] = req.MaxTokens
 	}
@@ -28,6 +28,10 @@ type AnthropicConfig struct {
 	System      string
 	Temperature *float64
 	HTTPClient  *http.Client
+
+	// APIKeyHelper, when set, supplies the key of every request in place
+	// of APIKey, which may then be empty.
+	APIKeyHelper *APIKeyHelper
 }
 
 type anthropicMessages interface {
@@ -127,7 +131,7 @@ func (m *anthropicModel) requestOptions() []option.RequestOption {
 // NewAnthropic constructs a production-ready Anthropic-backed Model.
 func NewAnthropic(cfg AnthropicConfig) (Model, error) {
 	apiKey := strings.TrimSpace(cfg.APIKey)
-	if apiKey == "" {
+	if apiKey == "" && cfg.APIKeyHelper == nil {
 		return nil, errors.New("anthropic: api key required")
 	}
 
@@ -142,29 +146,39 @@ func NewAnthropic(cfg AnthropicConfig) (Model, error) {
 	if cfg.BaseURL != "" {
 		opts = append(opts, option.WithBaseURL(cfg.BaseURL))
 	}
-	if cfg.HTTPClient != nil {
-		opts = append(opts, option.WithHTTPClient(cfg.HTTPClient))
+	if client := withAPIKeyHelper(cfg.HTTPClient, cfg.APIKeyHelper, "anthropic", setAnthropicAPIKey); client != nil {
+		opts = append(opts, option.WithHTTPClient(client))
 	}
 
 	client := anthropicsdk.NewClient(opts...)
-	maxTokens := cfg.MaxTokens
+	mdl := newAnthropicModel(&client.Messages, cfg.Model, cfg.MaxTokens, cfg.MaxRetries, cfg.System, cfg.Temperature)
+	mdl.configuredAPIKey = apiKey
+	return mdl, nil
+}
+
+// setAnthropicAPIKey sends key both ways NewAnthropic does.
+func setAnthropicAPIKey(h http.Header, key string) {
+	h.Set("X-Api-Key", key)
+	h.Set("Authorization", "Bearer "+key)
+}
+
+// newAnthropicModel applies the defaults shared by the Anthropic API, Bedrock
+// and Vertex adapters.
+func newAnthropicModel(msgs anthropicMessages, name string, maxTokens, maxRetries int, system string, temperature *float64) *anthropicModel {
 	if maxTokens <= 0 {
 		maxTokens = 4096
 	}
-	retries := cfg.MaxRetries
-	if retries <= 0 {
-		retries = 10
+	if maxRetries <= 0 {
+		maxRetries = 10
 	}
-
 	return &anthropicModel{
-		msgs:             &client.Messages,
-		model:            mapModelName(cfg.Model),
-		maxTokens:        maxTokens,
-		maxRetries:       retries,
-		system:           strings.TrimSpace(cfg.System),
-		temperature:      cfg.Temperature,
-		configuredAPIKey: apiKey,
-	}, nil
+		msgs:        msgs,
+		model:       mapModelName(name),
+		maxTokens:   maxTokens,
+		maxRetries:  maxRetries,
+		system:      strings.TrimSpace(system),
+		temperature: temperature,
+	}
 }
 
 // Complete issues a non-streaming completion.
@@ -188,6 +202,7 @@ func (m *anthropicModel) Complete(ctx context.Context, req Request) (*Response,
 			Message:    convertResponseMessage(*msg),
 			Usage:      usage,
 			StopReason: string(msg.StopReason),
+			Model:      string(msg.Model),
 		}
 		recordModelResponse(ctx, resp)
 		return nil
@@ -224,6 +239,7 @@ func (m *anthropicModel) CompleteStream(ctx context.Context, req Request, cb Str
 		defer stream.Close()
 
 		var final anthropicsdk.Message
+		toolBlocks := map[int64]anthropicsdk.ContentBlockStartEventContentBlockUnion{}
 
 		for stream.Next() {
 			event := stream.Current()
@@ -233,11 +249,27 @@ func (m *anthropicModel) CompleteStream(ctx context.Context, req Request, cb Str
 			}
 
 			switch ev := event.AsAny().(type) {
+			case anthropicsdk.ContentBlockStartEvent:
+				if ev.ContentBlock.Type == "tool_use" {
+					toolBlocks[ev.Index] = ev.ContentBlock
+				}
 			case anthropicsdk.ContentBlockDeltaEvent:
-				if text := ev.Delta.AsTextDelta().Text; text != "" {
-					if err := cb(StreamResult{Delta: text}); err != nil {
-						return err
-					}
+				idx := int(ev.Index)
+				var sr StreamResult
+				switch ev.Delta.Type {
+				case "thinking_delta":
+					sr = StreamResult{Thinking: ev.Delta.Thinking, Index: idx}
+				case "input_json_delta":
+					block := toolBlocks[ev.Index]
+					sr = StreamResult{ToolInputDelta: ev.Delta.PartialJSON, ToolCallID: block.ID, ToolCallName: block.Name, Index: idx}
+				default:
+					sr = StreamResult{Delta: ev.Delta.Text, Index: idx}
+				}
+				if sr.Delta == "" && sr.Thinking == "" && sr.ToolInputDelta == "" {
+					continue
+				}
+				if err := cb(sr); err != nil {
+					return err
 				}
 			case anthropicsdk.ContentBlockStopEvent:
 				if tool := extractToolCall(final); tool != nil {
@@ -262,6 +294,7 @@ func (m *anthropicModel) CompleteStream(ctx context.Context, req Request, cb Str
 			Message:    convertResponseMessage(final),
 			Usage:      usageFromFallback(final.Usage, usage),
 			StopReason: string(final.StopReason),
+			Model:      string(final.Model),
 		}
 		recordModelResponse(ctx, resp)
 		return cb(StreamResult{Final: true, Response: resp})
@@ -269,7 +302,12 @@ func (m *anthropicModel) CompleteStream(ctx context.Context, req Request, cb Str
 }
 
 func (m *anthropicModel) buildParams(req Request) (anthropicsdk.MessageNewParams, error) {
-	systemBlocks, messageParams, err := convertMessages(req.Messages, req.EnablePromptCache, m.system, req.System)
+	system := append([]SystemBlock{{Text: m.system, Cacheable: true}, {Text: req.System, Cacheable: true}}, req.SystemBlocks...)
+	if err := validateCacheTTL(req.Cache.ttl()); err != nil {
+		return anthropicsdk.MessageNewParams{}, err
+	}
+	automatic := req.EnablePromptCache && !req.Cache.explicit()
+	systemBlocks, messageParams, err := convertConversation(req.Messages, automatic, req.Cache.ttl(), system)
 	if err != nil {
 		return anthropicsdk.MessageNewParams{}, err
 	}
@@ -278,6 +316,14 @@ func (m *anthropicModel) buildParams(req Request) (anthropicsdk.MessageNewParams
 	if maxTokens <= 0 {
 		maxTokens = m.maxTokens
 	}
+	thinking, err := thinkingBudget(req.Thinking)
+	if err != nil {
+		return anthropicsdk.MessageNewParams{}, err
+	}
+	if thinking > 0 && maxTokens <= thinking {
+		// The budget counts against max_tokens; keep room for the answer.
+		maxTokens += thinking
+	}
 
 	params := anthropicsdk.MessageNewParams{
 		Model:     m.selectModel(req.Model),
@@ -295,18 +341,45 @@ func (m *anthropicModel) buildParams(req Request) (anthropicsdk.MessageNewParams
 			return anthropicsdk.MessageNewParams{}, err
 		}
 		params.Tools = tools
+		choice, err := convertToolChoice(req.ToolChoice)
+		if err != nil {
+			return anthropicsdk.MessageNewParams{}, err
+		}
+		if thinking > 0 && req.ToolChoice.Forces() {
+			return anthropicsdk.MessageNewParams{}, errors.New("anthropic: thinking does not support forced tool use")
+		}
+		params.ToolChoice = choice
+	}
+	if req.Cache.explicit() {
+		if err := applyCachePolicy(&params, req.Messages, *req.Cache); err != nil {
+			return anthropicsdk.MessageNewParams{}, err
+		}
 	}
 
-	if m.temperature != nil {
-		params.Temperature = param.NewOpt(*m.temperature)
+	if thinking > 0 {
+		// Thinking does not accept a temperature or top_k.
+		params.Thinking = anthropicsdk.ThinkingConfigParamOfEnabled(int64(thinking))
+	} else {
+		if m.temperature != nil {
+			params.Temperature = param.NewOpt(*m.temperature)
+		}
+		if req.Temperature != nil {
+			params.Temperature = param.NewOpt(*req.Temperature)
+		}
+		if req.TopK != nil {
+			params.TopK = param.NewOpt(int64(*req.TopK))
+		}
+	}
+	if req.TopP != nil {
+		params.TopP = param.NewOpt(*req.TopP)
 	}
-	if req.Temperature != nil {
-		params.Temperature = param.NewOpt(*req.Temperature)
+	if len(req.StopSequences) > 0 {
+		params.StopSequences = append([]string(nil), req.StopSequences...)
 	}
 
-	if sessionID := strings.TrimSpace(req.SessionID); sessionID != "" {
+	if user := req.endUser(); user != "" {
 		params.Metadata = anthropicsdk.MetadataParam{
-			UserID: param.NewOpt(sessionID),
+			UserID: param.NewOpt(user),
 		}
 	}
 
@@ -341,9 +414,13 @@ func isRetryable(err error) bool {
 	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 		return false
 	}
+	var credErr *credentialError
+	if errors.As(err, &credErr) {
+		return false
+	}
 	var apiErr *anthropicsdk.Error
 	if errors.As(err, &apiErr) {
-		return apiErr.StatusCode != http.StatusUnauthorized
+		return apiErr.StatusCode != http.StatusUnauthorized && apiErr.StatusCode != http.StatusForbidden
 	}
 	var netErr net.Error
 	if errors.As(err, &netErr) {
@@ -363,6 +440,22 @@ func (m *anthropicModel) selectModel(override string) anthropicsdk.Model {
 	return m.model
 }
 
+// CountTokens implements TokenCounter through the count_tokens endpoint.
+func (m *anthropicModel) CountTokens(ctx context.Context, req Request) (int, error) {
+	params, err := m.buildParams(req)
+	if err != nil {
+		return 0, err
+	}
+	count, err := m.msgs.CountTokens(ctx, m.countParams(params), m.requestOptions()...)
+	if err != nil {
+		return 0, err
+	}
+	if count == nil {
+		return 0, errors.New("anthropic count tokens returned no result")
+	}
+	return int(count.InputTokens), nil
+}
+
 func (m *anthropicModel) countParams(params anthropicsdk.MessageNewParams) anthropicsdk.MessageCountTokensParams {
 	cp := anthropicsdk.MessageCountTokensParams{
 		Messages: params.Messages,
@@ -374,6 +467,8 @@ func (m *anthropicModel) countParams(params anthropicsdk.MessageNewParams) anthr
 	if len(params.Tools) > 0 {
 		cp.Tools = convertCountTools(params.Tools)
 	}
+	cp.Thinking = params.Thinking
+	cp.ToolChoice = params.ToolChoice
 	return cp
 }
 
@@ -388,21 +483,40 @@ func convertCountTools(tools []anthropicsdk.ToolUnionParam) []anthropicsdk.Messa
 }
 
 func convertMessages(msgs []Message, enableCache bool, defaults ...string) ([]anthropicsdk.TextBlockParam, []anthropicsdk.MessageParam, error) {
+	system := make([]SystemBlock, len(defaults))
+	for i, sys := range defaults {
+		system[i] = SystemBlock{Text: sys, Cacheable: true}
+	}
+	return convertConversation(msgs, enableCache, "", system)
+}
+
+// convertConversation is convertMessages for a system prompt made of blocks.
+// With caching enabled the system breakpoint goes after the last block of
+// the leading cacheable run; system messages from the history count as
+// cacheable.
+func convertConversation(msgs []Message, enableCache bool, ttl CacheTTL, system []SystemBlock) ([]anthropicsdk.TextBlockParam, []anthropicsdk.MessageParam, error) {
 	var systemBlocks []anthropicsdk.TextBlockParam
-	for _, sys := range defaults {
-		if trimmed := strings.TrimSpace(sys); trimmed != "" {
-			systemBlocks = append(systemBlocks, anthropicsdk.TextBlockParam{Text: trimmed})
+	cachedPrefix, stable := 0, true
+	addSystem := func(text string, cacheable bool) {
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			return
+		}
+		systemBlocks = append(systemBlocks, anthropicsdk.TextBlockParam{Text: trimmed})
+		if stable = stable && cacheable; stable {
+			cachedPrefix = len(systemBlocks)
 		}
 	}
+	for _, block := range system {
+		addSystem(block.Text, block.Cacheable)
+	}
 
 	messageParams := make([]anthropicsdk.MessageParam, 0, len(msgs))
 	for _, msg := range msgs {
 		role := strings.ToLower(strings.TrimSpace(msg.Role))
 		switch role {
 		case "system":
-			if trimmed := strings.TrimSpace(msg.Content); trimmed != "" {
-				systemBlocks = append(systemBlocks, anthropicsdk.TextBlockParam{Text: trimmed})
-			}
+			addSystem(msg.Content, true)
 			continue
 		case "assistant":
 			content := buildAssistantContent(msg)
@@ -451,9 +565,9 @@ func convertMessages(msgs []Message, enableCache bool, defaults ...string) ([]an
 
 	// Apply cache control if enabled
 	if enableCache {
-		// Mark the last system block for caching
-		if len(systemBlocks) > 0 {
-			systemBlocks[len(systemBlocks)-1].CacheControl = anthropicsdk.NewCacheControlEphemeralParam()
+		// Mark the end of the stable system prefix for caching
+		if cachedPrefix > 0 {
+			systemBlocks[cachedPrefix-1].CacheControl = cacheControl(ttl)
 		}
 
 		// Mark the last 2-3 user messages for caching to optimize multi-turn conversations
@@ -467,7 +581,7 @@ func convertMessages(msgs []Message, enableCache bool, defaults ...string) ([]an
 						messageParams[i].Content[j] = anthropicsdk.ContentBlockParamUnion{
 							OfText: &anthropicsdk.TextBlockParam{
 								Text:         *text,
-								CacheControl: anthropicsdk.NewCacheControlEphemeralParam(),
+								CacheControl: cacheControl(ttl),
 							},
 						}
 						cached = true
@@ -484,11 +598,129 @@ func convertMessages(msgs []Message, enableCache bool, defaults ...string) ([]an
 	return systemBlocks, messageParams, nil
 }
 
+const (
+	defaultThinkingBudget = 4096
+	minThinkingBudget     = 1024
+)
+
+// thinkingBudget returns the thinking budget cfg asks for, or zero when
+// thinking is off.
+func thinkingBudget(cfg *ThinkingConfig) (int, error) {
+	if cfg == nil || !cfg.Enabled {
+		return 0, nil
+	}
+	if cfg.BudgetTokens <= 0 {
+		return defaultThinkingBudget, nil
+	}
+	if cfg.BudgetTokens < minThinkingBudget {
+		return 0, fmt.Errorf("anthropic: thinking budget %d below the minimum of %d", cfg.BudgetTokens, minThinkingBudget)
+	}
+	return cfg.BudgetTokens, nil
+}
+
+func convertToolChoice(choice *ToolChoice) (anthropicsdk.ToolChoiceUnionParam, error) {
+	if choice == nil {
+		return anthropicsdk.ToolChoiceUnionParam{}, nil
+	}
+	if err := choice.Validate(); err != nil {
+		return anthropicsdk.ToolChoiceUnionParam{}, err
+	}
+	var parallel param.Opt[bool]
+	if choice.DisableParallelToolUse {
+		parallel = param.NewOpt(true)
+	}
+	switch choice.Type {
+	case ToolChoiceAny:
+		return anthropicsdk.ToolChoiceUnionParam{OfAny: &anthropicsdk.ToolChoiceAnyParam{DisableParallelToolUse: parallel}}, nil
+	case ToolChoiceNone:
+		return anthropicsdk.ToolChoiceUnionParam{OfNone: &anthropicsdk.ToolChoiceNoneParam{}}, nil
+	case ToolChoiceTool:
+		return anthropicsdk.ToolChoiceUnionParam{OfTool: &anthropicsdk.ToolChoiceToolParam{Name: choice.Name, DisableParallelToolUse: parallel}}, nil
+	}
+	return anthropicsdk.ToolChoiceUnionParam{OfAuto: &anthropicsdk.ToolChoiceAutoParam{DisableParallelToolUse: parallel}}, nil
+}
+
+// maxCacheBreakpoints is the number of cache_control markers the Messages
+// API accepts per request.
+const maxCacheBreakpoints = 4
+
+func cacheControl(ttl CacheTTL) anthropicsdk.CacheControlEphemeralParam {
+	cc := anthropicsdk.NewCacheControlEphemeralParam()
+	if ttl != "" {
+		cc.TTL = anthropicsdk.CacheControlEphemeralTTL(ttl)
+	}
+	return cc
+}
+
+func validateCacheTTL(ttl CacheTTL) error {
+	switch ttl {
+	case "", CacheTTL5m, CacheTTL1h:
+		return nil
+	}
+	return fmt.Errorf("anthropic: unsupported cache ttl %q", ttl)
+}
+
+// applyCachePolicy marks the breakpoints policy names on params built from
+// msgs. Breakpoints on absent tools or an empty system prompt are skipped.
+func applyCachePolicy(params *anthropicsdk.MessageNewParams, msgs []Message, policy CachePolicy) error {
+	cc := cacheControl(policy.TTL)
+	marked := 0
+	mark := func(target *anthropicsdk.CacheControlEphemeralParam) {
+		if target != nil && target.Type == "" {
+			*target = cc
+			marked++
+		}
+	}
+
+	if policy.AfterTools && len(params.Tools) > 0 {
+		mark(params.Tools[len(params.Tools)-1].GetCacheControl())
+	}
+	if policy.AfterSystem && len(params.System) > 0 {
+		mark(&params.System[len(params.System)-1].CacheControl)
+	}
+	for _, idx := range policy.AfterMessages {
+		pos := idx
+		if pos < 0 {
+			pos += len(msgs)
+		}
+		if pos < 0 || pos >= len(msgs) {
+			return fmt.Errorf("anthropic: cache breakpoint after message %d: out of range for %d messages", idx, len(msgs))
+		}
+		if strings.EqualFold(strings.TrimSpace(msgs[pos].Role), "system") {
+			return fmt.Errorf("anthropic: cache breakpoint after message %d: system messages are sent in the system prompt", idx)
+		}
+		// Every other message becomes exactly one API message.
+		param := 0
+		for _, msg := range msgs[:pos] {
+			if !strings.EqualFold(strings.TrimSpace(msg.Role), "system") {
+				param++
+			}
+		}
+		content := params.Messages[param].Content
+		for j := len(content) - 1; j >= 0; j-- {
+			if target := content[j].GetCacheControl(); target != nil {
+				mark(target)
+				break
+			}
+		}
+	}
+	if marked > maxCacheBreakpoints {
+		return fmt.Errorf("anthropic: %d cache breakpoints requested, at most %d allowed", marked, maxCacheBreakpoints)
+	}
+	return nil
+}
+
 func buildAssistantContent(msg Message) []anthropicsdk.ContentBlockParamUnion {
 	blocks := make([]anthropicsdk.ContentBlockParamUnion, 0, 1+len(msg.ToolCalls))
-	// Prepend thinking block if reasoning content is present
-	if msg.ReasoningContent != "" {
-		blocks = append(blocks, anthropicsdk.NewThinkingBlock("", msg.ReasoningContent))
+	// Replay signed thinking first. Reasoning without a signature, such as
+	// from another provider, is rejected by the API and left out.
+	for _, thinking := range msg.ThinkingBlocks {
+		switch {
+		case thinking.Redacted != "":
+			blocks = append(blocks, anthropicsdk.NewRedactedThinkingBlock(thinking.Redacted))
+		case thinking.Signature != "":
+			blocks = append(blocks, anthropicsdk.NewThinkingBlock(thinking.Signature, thinking.Text))
+		}
 	}
 	if strings.TrimSpace(msg.Content) != "" {
 		blocks = append(blocks, anthropicsdk.NewTextBlock(msg.Content))
@@ -636,14 +868,22 @@ func encodeSchema(raw map[string]any) (anthropicsdk.ToolInputSchemaParam, error)
 func convertResponseMessage(msg anthropicsdk.Message) Message {
 	var textParts []string
 	var thinkingParts []string
+	var thinking []ThinkingBlock
 	var toolCalls []ToolCall
 	for _, block := range msg.Content {
 		if tc := toolCallFromBlock(block); tc != nil {
 			toolCalls = append(toolCalls, *tc)
 			continue
 		}
-		if block.Type == "thinking" && block.Thinking != "" {
-			thinkingParts = append(thinkingParts, block.Thinking)
+		switch block.Type {
+		case "thinking":
+			if block.Thinking != "" {
+				thinkingParts = append(thinkingParts, block.Thinking)
+			}
+			thinking = append(thinking, ThinkingBlock{Text: block.Thinking, Signature: block.Signature})
+			continue
+		case "redacted_thinking":
+			thinking = append(thinking, ThinkingBlock{Redacted: block.Data})
 			continue
 		}
 		if text := block.Text; text != "" {
@@ -660,6 +900,7 @@ func convertResponseMessage(msg anthropicsdk.Message) Message {
 		Content:          strings.Join(textParts, ""),
 		ToolCalls:        toolCalls,
 		ReasoningContent: strings.Join(thinkingParts, ""),
+		ThinkingBlocks:   thinking,
 	}
 }
 
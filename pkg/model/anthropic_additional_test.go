@@ -261,6 +261,9 @@ func TestAnthropicHelpers(t *testing.T) {
 	if isRetryable(&anthropicsdk.Error{StatusCode: http.StatusUnauthorized}) {
 		t.Fatalf("expected unauthorized to be non-retryable")
 	}
+	if isRetryable(&anthropicsdk.Error{StatusCode: http.StatusForbidden}) {
+		t.Fatalf("expected forbidden to be non-retryable")
+	}
 
 	systemBlocks, messages, err := convertMessages([]Message{
 		{Role: "system", Content: "sys"},
@@ -409,3 +412,294 @@ func TestBuildToolResultsFallbacks(t *testing.T) {
 		t.Fatalf("expected single block fallback")
 	}
 }
+
+func TestAnthropicCountTokens(t *testing.T) {
+	msgs := &fakeMessages{countResp: &anthropicsdk.MessageTokensCount{InputTokens: 42}}
+	m := &anthropicModel{msgs: msgs, model: mapModelName(""), maxTokens: 16, system: "sys", configuredAPIKey: "key"}
+	var _ TokenCounter = m
+
+	n, err := m.CountTokens(context.Background(), Request{
+		Messages: []Message{{Role: "user", Content: "hello"}},
+		Tools:    []ToolDefinition{{Name: "calc", Parameters: map[string]any{"type": "object"}}},
+	})
+	if err != nil || n != 42 {
+		t.Fatalf("expected 42 tokens, got %d err=%v", n, err)
+	}
+	if len(msgs.countParams.Messages) != 1 || len(msgs.countParams.Tools) != 1 {
+		t.Fatalf("count request should carry messages and tools, got %+v", msgs.countParams)
+	}
+
+	msgs.countErr = errors.New("boom")
+	if _, err := m.CountTokens(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}); err == nil {
+		t.Fatal("expected provider error")
+	}
+	msgs.countErr, msgs.countResp = nil, nil
+	if _, err := m.CountTokens(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}); err == nil {
+		t.Fatal("expected error for empty count response")
+	}
+}
+
+func TestBuildParamsCachesStableSystemPrefix(t *testing.T) {
+	m := &anthropicModel{model: mapModelName(""), maxTokens: 16, system: "default"}
+	req := Request{
+		Messages: []Message{{Role: "system", Content: "history"}, {Role: "user", Content: "hi"}},
+		System:   "base",
+		SystemBlocks: []SystemBlock{
+			{Text: "rules", Cacheable: true},
+			{Text: "  "},
+			{Text: "environment"},
+			{Text: "late", Cacheable: true},
+		},
+		EnablePromptCache: true,
+	}
+	params, err := m.buildParams(req)
+	if err != nil {
+		t.Fatalf("build params: %v", err)
+	}
+	var texts []string
+	cached := -1
+	for i, block := range params.System {
+		texts = append(texts, block.Text)
+		if block.CacheControl.Type != "" {
+			if cached >= 0 {
+				t.Fatalf("expected one system breakpoint, got %+v", params.System)
+			}
+			cached = i
+		}
+	}
+	want := []string{"default", "base", "rules", "environment", "late", "history"}
+	if len(texts) != len(want) {
+		t.Fatalf("system blocks = %q, want %q", texts, want)
+	}
+	for i := range want {
+		if texts[i] != want[i] {
+			t.Fatalf("system blocks = %q, want %q", texts, want)
+		}
+	}
+	if cached != 2 {
+		t.Fatalf("expected breakpoint after the cacheable prefix, got index %d", cached)
+	}
+
+	req.SystemBlocks = []SystemBlock{{Text: "environment"}}
+	req.System = ""
+	m.system = ""
+	params, err = m.buildParams(req)
+	if err != nil {
+		t.Fatalf("build params: %v", err)
+	}
+	for _, block := range params.System {
+		if block.CacheControl.Type != "" {
+			t.Fatalf("expected no system breakpoint when the prompt starts uncacheable, got %+v", params.System)
+		}
+	}
+}
+
+func TestBuildParamsExplicitCacheBreakpoints(t *testing.T) {
+	m := &anthropicModel{model: mapModelName(""), maxTokens: 16}
+	req := Request{
+		Messages: []Message{
+			{Role: "user", Content: "first"},
+			{Role: "system", Content: "note"},
+			{Role: "assistant", ToolCalls: []ToolCall{{ID: "t1", Name: "calc", Arguments: map[string]any{}}}},
+			{Role: "tool", ToolCalls: []ToolCall{{ID: "t1", Name: "calc", Result: "2"}}},
+			{Role: "user", Content: "last"},
+		},
+		Tools:             []ToolDefinition{{Name: "a", Parameters: map[string]any{"type": "object"}}, {Name: "calc", Parameters: map[string]any{"type": "object"}}},
+		System:            "base",
+		EnablePromptCache: true,
+		Cache:             &CachePolicy{AfterTools: true, AfterSystem: true, AfterMessages: []int{3, -1}, TTL: CacheTTL1h},
+	}
+	params, err := m.buildParams(req)
+	if err != nil {
+		t.Fatalf("build params: %v", err)
+	}
+	if cc := params.Tools[1].GetCacheControl(); cc.Type == "" || cc.TTL != anthropicsdk.CacheControlEphemeralTTLTTL1h {
+		t.Fatalf("expected last tool cached for 1h, got %+v", cc)
+	}
+	if cc := params.Tools[0].GetCacheControl(); cc.Type != "" {
+		t.Fatalf("expected first tool unmarked, got %+v", cc)
+	}
+	if cc := params.System[len(params.System)-1].CacheControl; cc.Type == "" || cc.TTL != anthropicsdk.CacheControlEphemeralTTLTTL1h {
+		t.Fatalf("expected system cached for 1h, got %+v", params.System)
+	}
+	var marked []int
+	for i, msg := range params.Messages {
+		for _, block := range msg.Content {
+			if cc := block.GetCacheControl(); cc != nil && cc.Type != "" {
+				marked = append(marked, i)
+			}
+		}
+	}
+	if len(marked) != 2 || marked[0] != 2 || marked[1] != 3 {
+		t.Fatalf("expected the tool result and last prompt marked, got messages %v", marked)
+	}
+	if params.Messages[2].Content[0].OfToolResult == nil {
+		t.Fatalf("expected message 3 to map to the tool result")
+	}
+
+	req.Cache = &CachePolicy{TTL: CacheTTL1h}
+	params, err = m.buildParams(req)
+	if err != nil {
+		t.Fatalf("build params: %v", err)
+	}
+	if cc := params.System[len(params.System)-1].CacheControl; cc.TTL != anthropicsdk.CacheControlEphemeralTTLTTL1h {
+		t.Fatalf("expected automatic placement to use the policy ttl, got %+v", cc)
+	}
+	if cc := params.Messages[3].Content[0].GetCacheControl(); cc.TTL != anthropicsdk.CacheControlEphemeralTTLTTL1h {
+		t.Fatalf("expected automatic message breakpoint with ttl, got %+v", cc)
+	}
+
+	for name, policy := range map[string]*CachePolicy{
+		"out of range":   {AfterMessages: []int{5}},
+		"negative range": {AfterMessages: []int{-6}},
+		"system message": {AfterMessages: []int{1}},
+		"bad ttl":        {AfterSystem: true, TTL: "10m"},
+		"too many":       {AfterTools: true, AfterSystem: true, AfterMessages: []int{0, 2, 4}},
+	} {
+		req.Cache = policy
+		if _, err := m.buildParams(req); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+
+	req.Cache = &CachePolicy{AfterMessages: []int{4, -1}}
+	if _, err := m.buildParams(req); err != nil {
+		t.Fatalf("expected duplicate breakpoint to count once: %v", err)
+	}
+}
+
+func TestBuildParamsThinking(t *testing.T) {
+	temp := 0.2
+	m := &anthropicModel{model: mapModelName(""), maxTokens: 4096, temperature: &temp}
+	req := Request{Messages: []Message{{Role: "user", Content: "hi"}}, Thinking: &ThinkingConfig{Enabled: true}}
+	params, err := m.buildParams(req)
+	if err != nil {
+		t.Fatalf("build params: %v", err)
+	}
+	if params.Thinking.OfEnabled == nil || params.Thinking.OfEnabled.BudgetTokens != 4096 {
+		t.Fatalf("expected default thinking budget, got %+v", params.Thinking)
+	}
+	if params.MaxTokens != 8192 {
+		t.Fatalf("expected max tokens raised above the budget, got %d", params.MaxTokens)
+	}
+	if params.Temperature.Valid() {
+		t.Fatalf("expected temperature dropped while thinking")
+	}
+	if cp := m.countParams(params); cp.Thinking.OfEnabled == nil {
+		t.Fatalf("expected token count to include thinking")
+	}
+
+	req.Thinking = &ThinkingConfig{Enabled: true, BudgetTokens: 2000}
+	req.MaxTokens = 16000
+	if params, err = m.buildParams(req); err != nil || params.Thinking.OfEnabled.BudgetTokens != 2000 || params.MaxTokens != 16000 {
+		t.Fatalf("unexpected params %+v err=%v", params, err)
+	}
+
+	req.Thinking = &ThinkingConfig{Enabled: true, BudgetTokens: 100}
+	if _, err := m.buildParams(req); err == nil {
+		t.Fatalf("expected budget below minimum to fail")
+	}
+
+	req.Thinking = &ThinkingConfig{Effort: ReasoningEffortHigh}
+	if params, err = m.buildParams(req); err != nil || params.Thinking.OfEnabled != nil || !params.Temperature.Valid() {
+		t.Fatalf("expected effort alone to leave thinking off, got %+v err=%v", params.Thinking, err)
+	}
+}
+
+func TestBuildParamsToolChoiceAndSampling(t *testing.T) {
+	m := &anthropicModel{model: mapModelName(""), maxTokens: 4096}
+	topP, topK := 0.9, 40
+	req := Request{
+		Messages:      []Message{{Role: "user", Content: "hi"}},
+		Tools:         []ToolDefinition{{Name: "classify", Parameters: map[string]any{"type": "object"}}},
+		ToolChoice:    &ToolChoice{Type: ToolChoiceTool, Name: "classify", DisableParallelToolUse: true},
+		StopSequences: []string{"END"},
+		TopP:          &topP,
+		TopK:          &topK,
+		SessionID:     "sess",
+		UserID:        "user-1",
+	}
+	params, err := m.buildParams(req)
+	if err != nil {
+		t.Fatalf("build params: %v", err)
+	}
+	if tool := params.ToolChoice.OfTool; tool == nil || tool.Name != "classify" || !tool.DisableParallelToolUse.Value {
+		t.Fatalf("unexpected tool choice %+v", params.ToolChoice)
+	}
+	if params.TopP.Value != 0.9 || params.TopK.Value != 40 || len(params.StopSequences) != 1 || params.StopSequences[0] != "END" {
+		t.Fatalf("unexpected sampling params top_p=%v top_k=%v stop=%v", params.TopP, params.TopK, params.StopSequences)
+	}
+	if params.Metadata.UserID.Value != "user-1" {
+		t.Fatalf("expected UserID to override the session, got %q", params.Metadata.UserID.Value)
+	}
+	if cp := m.countParams(params); cp.ToolChoice.OfTool == nil {
+		t.Fatalf("expected token count to include the tool choice")
+	}
+
+	for typ, check := range map[ToolChoiceType]func(anthropicsdk.ToolChoiceUnionParam) bool{
+		ToolChoiceAuto: func(c anthropicsdk.ToolChoiceUnionParam) bool { return c.OfAuto != nil },
+		ToolChoiceAny:  func(c anthropicsdk.ToolChoiceUnionParam) bool { return c.OfAny != nil },
+		ToolChoiceNone: func(c anthropicsdk.ToolChoiceUnionParam) bool { return c.OfNone != nil },
+	} {
+		req.ToolChoice = &ToolChoice{Type: typ}
+		if params, err = m.buildParams(req); err != nil || !check(params.ToolChoice) {
+			t.Fatalf("unexpected %s choice %+v err=%v", typ, params.ToolChoice, err)
+		}
+	}
+
+	req.ToolChoice = &ToolChoice{Type: ToolChoiceTool}
+	if _, err := m.buildParams(req); err == nil {
+		t.Fatalf("expected tool choice without a name to fail")
+	}
+	req.ToolChoice = &ToolChoice{Type: "sometimes"}
+	if _, err := m.buildParams(req); err == nil {
+		t.Fatalf("expected unknown tool choice to fail")
+	}
+
+	req.ToolChoice = &ToolChoice{Type: ToolChoiceAny}
+	req.Thinking = &ThinkingConfig{Enabled: true}
+	if _, err := m.buildParams(req); err == nil {
+		t.Fatalf("expected forced tool use with thinking to fail")
+	}
+	req.ToolChoice = &ToolChoice{Type: ToolChoiceAuto}
+	if params, err = m.buildParams(req); err != nil || params.TopK.Valid() || !params.TopP.Valid() {
+		t.Fatalf("expected top_k dropped while thinking, got top_k=%v err=%v", params.TopK, err)
+	}
+
+	req = Request{Messages: req.Messages, ToolChoice: &ToolChoice{Type: ToolChoiceAny}, SessionID: "sess"}
+	if params, err = m.buildParams(req); err != nil || params.ToolChoice.OfAny != nil || params.Metadata.UserID.Value != "sess" {
+		t.Fatalf("expected tool choice ignored without tools, got %+v err=%v", params.ToolChoice, err)
+	}
+}
+
+func TestThinkingBlocksRoundTrip(t *testing.T) {
+	var msg anthropicsdk.Message
+	if err := json.Unmarshal([]byte(`{"role":"assistant","content":[
+		{"type":"thinking","thinking":"plan","signature":"sig-1"},
+		{"type":"redacted_thinking","data":"opaque"},
+		{"type":"text","text":"answer"}
+	]}`), &msg); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	converted := convertResponseMessage(msg)
+	if converted.ReasoningContent != "plan" || converted.Content != "answer" {
+		t.Fatalf("unexpected message %+v", converted)
+	}
+	want := []ThinkingBlock{{Text: "plan", Signature: "sig-1"}, {Redacted: "opaque"}}
+	if len(converted.ThinkingBlocks) != 2 || converted.ThinkingBlocks[0] != want[0] || converted.ThinkingBlocks[1] != want[1] {
+		t.Fatalf("unexpected thinking blocks %+v", converted.ThinkingBlocks)
+	}
+
+	blocks := buildAssistantContent(converted)
+	if len(blocks) != 3 || blocks[0].OfThinking == nil || blocks[0].OfThinking.Signature != "sig-1" || blocks[0].OfThinking.Thinking != "plan" {
+		t.Fatalf("expected signed thinking replayed first, got %+v", blocks)
+	}
+	if blocks[1].OfRedactedThinking == nil || blocks[1].OfRedactedThinking.Data != "opaque" {
+		t.Fatalf("expected redacted thinking replayed, got %+v", blocks[1])
+	}
+
+	blocks = buildAssistantContent(Message{Role: "assistant", Content: "answer", ReasoningContent: "unsigned"})
+	if len(blocks) != 1 || blocks[0].OfText == nil {
+		t.Fatalf("expected unsigned reasoning left out, got %+v", blocks)
+	}
+}
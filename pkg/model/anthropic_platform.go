@@ -0,0 +1,103 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// credentialError reports cloud credentials that could not be resolved.
+// Retrying the model call cannot fix it, so doWithRetry gives up at once.
+type credentialError struct {
+	platform string
+	err      error
+}
+
+func (e *credentialError) Error() string {
+	return fmt.Sprintf("%s: credentials: %v", e.platform, e.err)
+}
+
+func (e *credentialError) Unwrap() error { return e.err }
+
+// anthropicModelAliases resolves the undated Anthropic aliases to the dated
+// IDs that Bedrock and Vertex publish.
+var anthropicModelAliases = map[string]string{
+	"claude-3-opus-latest":     "claude-3-opus-20240229",
+	"claude-3-5-haiku-latest":  "claude-3-5-haiku-20241022",
+	"claude-3-5-sonnet-latest": "claude-3-5-sonnet-20241022",
+	"claude-3-7-sonnet-latest": "claude-3-7-sonnet-20250219",
+	"claude-sonnet-4-0":        "claude-sonnet-4-20250514",
+	"claude-4-sonnet-20250514": "claude-sonnet-4-20250514",
+	"claude-opus-4-0":          "claude-opus-4-20250514",
+	"claude-4-opus-20250514":   "claude-opus-4-20250514",
+	"claude-opus-4-1":          "claude-opus-4-1-20250805",
+	"claude-sonnet-4-5":        "claude-sonnet-4-5-20250929",
+	"claude-haiku-4-5":         "claude-haiku-4-5-20251001",
+}
+
+func datedAnthropicModel(name string) string {
+	name = strings.TrimSpace(name)
+	if dated, ok := anthropicModelAliases[name]; ok {
+		return dated
+	}
+	return name
+}
+
+// splitModelDate splits "claude-sonnet-4-5-20250929" into its family and
+// release date; ok is false for names without a trailing date.
+func splitModelDate(name string) (family, date string, ok bool) {
+	idx := strings.LastIndexByte(name, '-')
+	if idx <= 0 || len(name)-idx-1 != 8 {
+		return "", "", false
+	}
+	for _, r := range name[idx+1:] {
+		if r < '0' || r > '9' {
+			return "", "", false
+		}
+	}
+	return name[:idx], name[idx+1:], true
+}
+
+// readJSONBody drains the body of an outgoing Messages API request.
+func readJSONBody(r *http.Request) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if r.Body == nil {
+		return fields, nil
+	}
+	data, err := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return fields, nil
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("decode request body: %w", err)
+	}
+	return fields, nil
+}
+
+// setRequestBody replaces the body of r, keeping it replayable for retries.
+func setRequestBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.ContentLength = int64(len(body))
+}
+
+func jsonString(raw json.RawMessage) string {
+	var s string
+	_ = json.Unmarshal(raw, &s)
+	return s
+}
+
+func jsonBool(raw json.RawMessage) bool {
+	var b bool
+	_ = json.Unmarshal(raw, &b)
+	return b
+}
@@ -0,0 +1,205 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAPIKeyHelperTTL     = 5 * time.Minute
+	defaultAPIKeyHelperTimeout = 30 * time.Second
+)
+
+// APIKeyHelper sources API keys from a shell command, such as the
+// settings.json apiKeyHelper, so rotated keys are picked up without a
+// restart. The key is cached for TTL and fetched again after the provider
+// answers 401. The command's stdout and stderr never appear in logs or
+// errors.
+type APIKeyHelper struct {
+	Command string
+	TTL     time.Duration // zero refreshes every 5 minutes; negative keeps the key until it is rejected
+	Timeout time.Duration // zero allows 30 seconds per run
+
+	mu      sync.Mutex
+	key     string
+	fetched time.Time
+	loading *apiKeyLoad
+}
+
+// apiKeyLoad is a helper run shared by the Key calls that wait for it.
+type apiKeyLoad struct {
+	done chan struct{}
+	key  string
+	err  error
+}
+
+// Key returns the cached key, running the command when there is none or it
+// is older than TTL. Only one run is in flight at a time; concurrent callers
+// wait for it until their ctx is done.
+func (h *APIKeyHelper) Key(ctx context.Context) (string, error) {
+	h.mu.Lock()
+	if h.key != "" && !h.stale(time.Now()) {
+		key := h.key
+		h.mu.Unlock()
+		return key, nil
+	}
+	if load := h.loading; load != nil {
+		h.mu.Unlock()
+		select {
+		case <-load.done:
+			return load.key, load.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	load := &apiKeyLoad{done: make(chan struct{})}
+	h.loading = load
+	h.mu.Unlock()
+
+	load.key, load.err = h.run(ctx)
+
+	h.mu.Lock()
+	if load.err == nil {
+		h.key, h.fetched = load.key, time.Now()
+	}
+	h.loading = nil
+	h.mu.Unlock()
+	close(load.done)
+	return load.key, load.err
+}
+
+// Invalidate drops the cached key so the next Key call runs the command.
+func (h *APIKeyHelper) Invalidate() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.key = ""
+}
+
+// invalidateKey drops the cached key only if it is still rejected, so
+// requests that fail together with the same stale key refresh it once and do
+// not throw away a key another request has just fetched.
+func (h *APIKeyHelper) invalidateKey(rejected string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.key == rejected {
+		h.key = ""
+	}
+}
+
+func (h *APIKeyHelper) stale(now time.Time) bool {
+	ttl := h.TTL
+	if ttl < 0 {
+		return false
+	}
+	if ttl == 0 {
+		ttl = defaultAPIKeyHelperTTL
+	}
+	return now.Sub(h.fetched) >= ttl
+}
+
+func (h *APIKeyHelper) run(ctx context.Context) (string, error) {
+	command := strings.TrimSpace(h.Command)
+	if command == "" {
+		return "", errors.New("apiKeyHelper: command is empty")
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultAPIKeyHelperTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := newShellCommand(ctx, command)
+	cmd.Stdout = &stdout
+	// Children of the shell may keep stdout open after it is killed.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("apiKeyHelper: timed out after %s", timeout)
+		}
+		// The error carries the exit status only, never the output.
+		return "", fmt.Errorf("apiKeyHelper: %w", err)
+	}
+	key := strings.TrimSpace(stdout.String())
+	if key == "" {
+		return "", errors.New("apiKeyHelper: command printed no key")
+	}
+	return key, nil
+}
+
+// withAPIKeyHelper returns client with a transport that authenticates every
+// request with the helper's key; client is returned unchanged without one.
+func withAPIKeyHelper(client *http.Client, helper *APIKeyHelper, platform string, setKey func(http.Header, string)) *http.Client {
+	if helper == nil {
+		return client
+	}
+	var wrapped http.Client
+	if client != nil {
+		wrapped = *client
+	}
+	base := wrapped.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	wrapped.Transport = &apiKeyTransport{base: base, helper: helper, platform: platform, setKey: setKey}
+	return &wrapped
+}
+
+// apiKeyTransport sets the helper's key on each request. After a 401 it runs
+// the helper again and resends the request once with the new key.
+type apiKeyTransport struct {
+	base     http.RoundTripper
+	helper   *APIKeyHelper
+	platform string
+	setKey   func(http.Header, string)
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, err := t.helper.Key(req.Context())
+	if err != nil {
+		return nil, &credentialError{platform: t.platform, err: err}
+	}
+	resp, err := t.send(req, req.Body, key)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	t.helper.invalidateKey(key)
+	key, err = t.helper.Key(req.Context())
+	if err != nil {
+		return nil, &credentialError{platform: t.platform, err: err}
+	}
+	var body io.ReadCloser
+	if req.GetBody != nil {
+		if body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.send(req, body, key)
+}
+
+func (t *apiKeyTransport) send(req *http.Request, body io.ReadCloser, key string) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	out.Body = body
+	t.setKey(out.Header, key)
+	return t.base.RoundTrip(out)
+}
+
+func newShellCommand(ctx context.Context, script string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", script)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", script)
+}
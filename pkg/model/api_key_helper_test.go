@@ -0,0 +1,218 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingHelper prints key-1, key-2, ... on successive runs.
+func countingHelper(t *testing.T) *APIKeyHelper {
+	t.Helper()
+	counter := filepath.Join(t.TempDir(), "runs")
+	return &APIKeyHelper{Command: fmt.Sprintf(`n=$(($(cat %q 2>/dev/null || echo 0)+1)); echo $n > %q; echo "key-$n"`, counter, counter)}
+}
+
+func TestAPIKeyHelperCachesUntilTTL(t *testing.T) {
+	helper := countingHelper(t)
+	ctx := context.Background()
+
+	key, err := helper.Key(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", key)
+	key, err = helper.Key(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", key, "key should be cached")
+
+	helper.fetched = time.Now().Add(-defaultAPIKeyHelperTTL)
+	key, err = helper.Key(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "key-2", key, "default TTL should expire the key")
+
+	helper.Invalidate()
+	key, err = helper.Key(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "key-3", key)
+
+	helper.TTL = -1
+	helper.fetched = time.Now().Add(-24 * time.Hour)
+	key, err = helper.Key(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "key-3", key, "negative TTL keeps the key until invalidated")
+}
+
+func TestAPIKeyHelperErrorsHideOutput(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := (&APIKeyHelper{Command: "echo sk-stdout-secret; echo sk-stderr-secret >&2; exit 3"}).Key(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exit status 3")
+	assert.NotContains(t, err.Error(), "secret")
+
+	_, err = (&APIKeyHelper{Command: "echo sk-stderr-secret >&2"}).Key(ctx)
+	assert.EqualError(t, err, "apiKeyHelper: command printed no key")
+
+	start := time.Now()
+	_, err = (&APIKeyHelper{Command: "sleep 5", Timeout: 50 * time.Millisecond}).Key(ctx)
+	assert.EqualError(t, err, "apiKeyHelper: timed out after 50ms")
+	assert.Less(t, time.Since(start), 4*time.Second)
+}
+
+func TestAPIKeyHelperWaitersHonourContext(t *testing.T) {
+	helper := &APIKeyHelper{Command: "sleep 1; echo slow-key"}
+	first := make(chan string, 1)
+	go func() {
+		key, _ := helper.Key(context.Background())
+		first <- key
+	}()
+	require.Eventually(t, func() bool {
+		helper.mu.Lock()
+		defer helper.mu.Unlock()
+		return helper.loading != nil
+	}, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := helper.Key(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "a waiter must not block on the running helper")
+
+	assert.Equal(t, "slow-key", <-first)
+	key, err := helper.Key(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "slow-key", key, "the shared run should fill the cache")
+}
+
+func TestAnthropicAPIKeyHelperRetriesAfterUnauthorized(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "sk-env")
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		mu.Lock()
+		seen = append(seen, r.Header.Get("X-Api-Key")+"|"+r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("X-Api-Key") != "key-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = io.WriteString(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-5-20250929","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	mdl, err := NewAnthropic(AnthropicConfig{BaseURL: srv.URL, HTTPClient: srv.Client(), APIKeyHelper: countingHelper(t)})
+	require.NoError(t, err)
+
+	resp, err := mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp.Message.Content)
+	assert.Equal(t, []string{"key-1|Bearer key-1", "key-2|Bearer key-2"}, seen)
+
+	// The refreshed key is reused by later calls.
+	_, err = mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "again"}}})
+	require.NoError(t, err)
+	assert.Len(t, seen, 3)
+	assert.Equal(t, "key-2|Bearer key-2", seen[2])
+}
+
+func TestAPIKeyHelperConcurrentUnauthorizedRefreshesOnce(t *testing.T) {
+	const inFlight = 8
+	var (
+		mu      sync.Mutex
+		current = "key-1"
+		arrived int
+	)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Authorization")
+		mu.Lock()
+		if key == "Bearer key-1" {
+			// Hold the first wave until every request has used the old key,
+			// then rotate it.
+			arrived++
+			if arrived == inFlight {
+				current = "key-2"
+				close(release)
+			}
+		}
+		mu.Unlock()
+		if key == "Bearer key-1" {
+			<-release
+		}
+		mu.Lock()
+		ok := key == "Bearer "+current
+		mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+
+	helper := countingHelper(t)
+	client := withAPIKeyHelper(srv.Client(), helper, "test", setOpenAIAPIKey)
+	var wg sync.WaitGroup
+	for i := 0; i < inFlight; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if assert.NoError(t, err) {
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				_ = resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	key, err := helper.Key(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "key-2", key, "the helper should run once after the shared 401")
+}
+
+func TestAPIKeyHelperFailureIsNotRetried(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { calls++ }))
+	t.Cleanup(srv.Close)
+
+	mdl, err := NewGemini(GeminiConfig{BaseURL: srv.URL, HTTPClient: srv.Client(), APIKeyHelper: &APIKeyHelper{Command: "exit 1"}})
+	require.NoError(t, err)
+	_, err = mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "apiKeyHelper: exit status 1")
+	assert.False(t, isGeminiRetryable(err))
+	assert.False(t, isOpenAIRetryable(err))
+	assert.Zero(t, calls)
+
+	_, err = NewOpenAI(OpenAIConfig{})
+	assert.Error(t, err)
+	_, err = NewOpenAI(OpenAIConfig{APIKeyHelper: &APIKeyHelper{Command: "echo key"}})
+	assert.NoError(t, err)
+}
+
+func TestGeminiAPIKeyHelperSetsHeader(t *testing.T) {
+	replay := &geminiReplay{t: t, fixture: "generate_tool_call.json"}
+	srv := httptest.NewServer(replay)
+	t.Cleanup(srv.Close)
+
+	mdl, err := NewGemini(GeminiConfig{BaseURL: srv.URL + "/v1beta", APIKeyHelper: &APIKeyHelper{Command: "echo test-key"}})
+	require.NoError(t, err)
+	_, err = mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), replay.calls.Load())
+}
@@ -0,0 +1,277 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWSCredentials are the keys used to sign Bedrock requests.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // zero never expires
+}
+
+func (c AWSCredentials) valid() bool {
+	return c.AccessKeyID != "" && c.SecretAccessKey != ""
+}
+
+// expired treats credentials as expired a minute early so a request signed
+// now does not reach AWS after the deadline.
+func (c AWSCredentials) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !now.Before(c.Expires.Add(-time.Minute))
+}
+
+// awsCredentialSource resolves Bedrock credentials in order: static keys,
+// the credential export script, the AWS_* environment, then the shared
+// credentials file. The auth refresh script runs before reloading
+// credentials that expired or were rejected; its output goes to
+// refreshOutput (os.Stderr when nil) so device-code prompts reach the user.
+type awsCredentialSource struct {
+	static        AWSCredentials
+	profile       string
+	export        string
+	refresh       string
+	refreshOutput io.Writer
+
+	mu      sync.Mutex
+	current AWSCredentials
+	stale   bool
+	// loading is the reload in progress, shared by every caller that needs
+	// credentials meanwhile; scripts run without holding mu.
+	loading *awsCredentialLoad
+}
+
+type awsCredentialLoad struct {
+	done  chan struct{}
+	creds AWSCredentials
+	err   error
+}
+
+func (s *awsCredentialSource) retrieve(ctx context.Context) (AWSCredentials, error) {
+	if s.static.valid() {
+		return s.static, nil
+	}
+	s.mu.Lock()
+	if s.current.valid() && !s.stale && !s.current.expired(time.Now()) {
+		creds := s.current
+		s.mu.Unlock()
+		return creds, nil
+	}
+	if load := s.loading; load != nil {
+		s.mu.Unlock()
+		select {
+		case <-load.done:
+			return load.creds, load.err
+		case <-ctx.Done():
+			return AWSCredentials{}, ctx.Err()
+		}
+	}
+	load := &awsCredentialLoad{done: make(chan struct{})}
+	s.loading = load
+	refresh := s.refresh != "" && (s.stale || s.current.valid())
+	s.mu.Unlock()
+
+	load.creds, load.err = s.reload(ctx, refresh)
+
+	s.mu.Lock()
+	if load.err == nil {
+		s.current, s.stale = load.creds, false
+	}
+	s.loading = nil
+	s.mu.Unlock()
+	close(load.done)
+	return load.creds, load.err
+}
+
+// reload loads credentials, running the refresh script first when refresh is
+// set or when nothing usable can be loaded without it.
+func (s *awsCredentialSource) reload(ctx context.Context, refresh bool) (AWSCredentials, error) {
+	if refresh {
+		if err := s.runRefresh(ctx); err != nil {
+			return AWSCredentials{}, err
+		}
+	}
+	creds, err := s.load(ctx)
+	if err != nil && !refresh && s.refresh != "" {
+		// Nothing usable yet: let the refresh script log in, then retry.
+		if rerr := s.runRefresh(ctx); rerr != nil {
+			return AWSCredentials{}, rerr
+		}
+		creds, err = s.load(ctx)
+	}
+	if err != nil {
+		return AWSCredentials{}, &credentialError{platform: "bedrock", err: err}
+	}
+	return creds, nil
+}
+
+func (s *awsCredentialSource) runRefresh(ctx context.Context) error {
+	out := s.refreshOutput
+	if out == nil {
+		out = os.Stderr
+	}
+	cmd := newShellCommand(ctx, s.refresh)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Run(); err != nil {
+		return &credentialError{platform: "bedrock", err: fmt.Errorf("awsAuthRefresh: %w", err)}
+	}
+	return nil
+}
+
+// invalidate marks the current credentials as rejected; it reports whether
+// they can be reloaded at all.
+func (s *awsCredentialSource) invalidate() bool {
+	if s.static.valid() {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stale = true
+	return true
+}
+
+func (s *awsCredentialSource) load(ctx context.Context) (AWSCredentials, error) {
+	if s.export != "" {
+		out, err := runCredentialScript(ctx, s.export)
+		if err != nil {
+			return AWSCredentials{}, fmt.Errorf("awsCredentialExport: %w", err)
+		}
+		creds, err := parseAWSCredentialExport(out)
+		if err != nil {
+			return AWSCredentials{}, fmt.Errorf("awsCredentialExport: %w", err)
+		}
+		return creds, nil
+	}
+	env := AWSCredentials{
+		AccessKeyID:     strings.TrimSpace(os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretAccessKey: strings.TrimSpace(os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		SessionToken:    strings.TrimSpace(os.Getenv("AWS_SESSION_TOKEN")),
+	}
+	if env.valid() {
+		return env, nil
+	}
+	return loadAWSProfile(s.profile)
+}
+
+type awsCredentialJSON struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+// parseAWSCredentialExport accepts both the STS shape
+// ({"Credentials": {...}}) and the flat credential_process shape.
+func parseAWSCredentialExport(out []byte) (AWSCredentials, error) {
+	var doc struct {
+		awsCredentialJSON
+		Credentials *awsCredentialJSON `json:"Credentials"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(out), &doc); err != nil {
+		return AWSCredentials{}, fmt.Errorf("decode credentials: %w", err)
+	}
+	raw := doc.awsCredentialJSON
+	if doc.Credentials != nil {
+		raw = *doc.Credentials
+	}
+	creds := AWSCredentials{
+		AccessKeyID:     raw.AccessKeyID,
+		SecretAccessKey: raw.SecretAccessKey,
+		SessionToken:    raw.SessionToken,
+	}
+	if !creds.valid() {
+		return AWSCredentials{}, errors.New("AccessKeyId and SecretAccessKey are required")
+	}
+	if raw.Expiration != "" {
+		expires, err := time.Parse(time.RFC3339, raw.Expiration)
+		if err != nil {
+			return AWSCredentials{}, fmt.Errorf("parse Expiration: %w", err)
+		}
+		creds.Expires = expires
+	}
+	return creds, nil
+}
+
+// loadAWSProfile reads a profile from the shared credentials file
+// (AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials).
+func loadAWSProfile(profile string) (AWSCredentials, error) {
+	if profile == "" {
+		profile = strings.TrimSpace(os.Getenv("AWS_PROFILE"))
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	path := strings.TrimSpace(os.Getenv("AWS_SHARED_CREDENTIALS_FILE"))
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return AWSCredentials{}, errors.New("no AWS credentials found")
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return AWSCredentials{}, errors.New("no AWS credentials found")
+		}
+		return AWSCredentials{}, err
+	}
+
+	var creds AWSCredentials
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if !creds.valid() {
+		return AWSCredentials{}, fmt.Errorf("no AWS credentials for profile %q in %s", profile, path)
+	}
+	return creds, nil
+}
+
+// runCredentialScript runs a settings.json credential helper through the
+// shell and returns its stdout.
+func runCredentialScript(ctx context.Context, script string) ([]byte, error) {
+	cmd := newShellCommand(ctx, script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,172 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/anthropics/anthropic-sdk-go/packages/ssestream"
+)
+
+// awsEventStreamContentType is the response type of Bedrock's
+// invoke-with-response-stream; each chunk carries one Anthropic SSE event.
+const awsEventStreamContentType = "application/vnd.amazon.eventstream"
+
+// awsEventStreamMaxFrame bounds the frame length read from a prelude so a
+// corrupt or hostile stream cannot force a huge allocation. AWS caps frames
+// at 16 MiB.
+const awsEventStreamMaxFrame = 16 << 20
+
+func init() {
+	ssestream.RegisterDecoder(awsEventStreamContentType, func(rc io.ReadCloser) ssestream.Decoder {
+		return &awsEventStreamDecoder{rc: rc}
+	})
+}
+
+// awsEventStreamDecoder adapts the AWS event stream framing to the SSE
+// decoder used by the Anthropic SDK.
+type awsEventStreamDecoder struct {
+	rc  io.ReadCloser
+	evt ssestream.Event
+	err error
+}
+
+func (d *awsEventStreamDecoder) Next() bool {
+	for d.err == nil {
+		msg, err := readAWSEventMessage(d.rc)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				d.err = err
+			}
+			return false
+		}
+		switch msg.headers[":message-type"] {
+		case "event":
+			if msg.headers[":event-type"] != "chunk" {
+				continue
+			}
+			var chunk struct {
+				Bytes []byte `json:"bytes"`
+			}
+			if err := json.Unmarshal(msg.payload, &chunk); err != nil {
+				d.err = fmt.Errorf("bedrock: decode chunk: %w", err)
+				return false
+			}
+			var head struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(chunk.Bytes, &head); err != nil {
+				d.err = fmt.Errorf("bedrock: decode event: %w", err)
+				return false
+			}
+			d.evt = ssestream.Event{Type: head.Type, Data: chunk.Bytes}
+			return true
+		case "exception":
+			var body struct {
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(msg.payload, &body)
+			d.err = fmt.Errorf("bedrock: %s: %s", msg.headers[":exception-type"], body.Message)
+		case "error":
+			d.err = fmt.Errorf("bedrock: %s: %s", msg.headers[":error-code"], msg.headers[":error-message"])
+		default:
+			d.err = fmt.Errorf("bedrock: unexpected event stream message type %q", msg.headers[":message-type"])
+		}
+	}
+	return false
+}
+
+func (d *awsEventStreamDecoder) Event() ssestream.Event { return d.evt }
+
+func (d *awsEventStreamDecoder) Close() error { return d.rc.Close() }
+
+func (d *awsEventStreamDecoder) Err() error { return d.err }
+
+type awsEventMessage struct {
+	headers map[string]string
+	payload []byte
+}
+
+// readAWSEventMessage reads one frame: a 12 byte prelude (total length,
+// headers length, prelude CRC), the headers, the payload and a message CRC.
+// It returns io.EOF only at a frame boundary.
+func readAWSEventMessage(r io.Reader) (awsEventMessage, error) {
+	var prelude [12]byte
+	if _, err := io.ReadFull(r, prelude[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return awsEventMessage{}, fmt.Errorf("bedrock: truncated event stream prelude")
+		}
+		return awsEventMessage{}, err
+	}
+	total := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return awsEventMessage{}, errors.New("bedrock: event stream prelude checksum mismatch")
+	}
+	if total < 16 || total > awsEventStreamMaxFrame || uint64(headersLen)+16 > uint64(total) {
+		return awsEventMessage{}, fmt.Errorf("bedrock: invalid event stream frame length %d", total)
+	}
+
+	frame := make([]byte, total)
+	copy(frame, prelude[:])
+	if _, err := io.ReadFull(r, frame[12:]); err != nil {
+		return awsEventMessage{}, fmt.Errorf("bedrock: truncated event stream frame: %w", err)
+	}
+	if crc32.ChecksumIEEE(frame[:total-4]) != binary.BigEndian.Uint32(frame[total-4:]) {
+		return awsEventMessage{}, errors.New("bedrock: event stream message checksum mismatch")
+	}
+
+	headers, err := parseAWSEventHeaders(frame[12 : 12+headersLen])
+	if err != nil {
+		return awsEventMessage{}, err
+	}
+	return awsEventMessage{headers: headers, payload: frame[12+headersLen : total-4]}, nil
+}
+
+// parseAWSEventHeaders keeps the string headers and skips the other types.
+func parseAWSEventHeaders(data []byte) (map[string]string, error) {
+	headers := map[string]string{}
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		if len(data) < 1+nameLen+1 {
+			return nil, errors.New("bedrock: truncated event stream header")
+		}
+		name := string(data[1 : 1+nameLen])
+		kind := data[1+nameLen]
+		data = data[2+nameLen:]
+
+		var size int
+		switch kind {
+		case 0, 1: // bool true, bool false
+		case 2: // byte
+			size = 1
+		case 3: // int16
+			size = 2
+		case 4: // int32
+			size = 4
+		case 5, 8: // int64, timestamp
+			size = 8
+		case 9: // uuid
+			size = 16
+		case 6, 7: // bytes, string
+			if len(data) < 2 {
+				return nil, errors.New("bedrock: truncated event stream header")
+			}
+			size = int(binary.BigEndian.Uint16(data[:2]))
+			data = data[2:]
+		default:
+			return nil, fmt.Errorf("bedrock: unknown event stream header type %d", kind)
+		}
+		if len(data) < size {
+			return nil, errors.New("bedrock: truncated event stream header")
+		}
+		if kind == 7 {
+			headers[name] = string(data[:size])
+		}
+		data = data[size:]
+	}
+	return headers, nil
+}
@@ -0,0 +1,262 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	anthropicsdk "github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// BedrockConfig runs the Anthropic adapter against Amazon Bedrock. Requests
+// are signed with SigV4 and sent to the Bedrock runtime only; no Anthropic
+// API key is ever forwarded.
+type BedrockConfig struct {
+	Region  string // Optional: defaults to AWS_REGION, AWS_DEFAULT_REGION, then us-east-1
+	BaseURL string // Optional: defaults to https://bedrock-runtime.{region}.amazonaws.com
+	// Model is an Anthropic model name ("claude-sonnet-4-5") or a Bedrock
+	// model ID, inference profile ID or ARN.
+	Model string
+	// InferenceProfile prefixes mapped model IDs with a cross-region
+	// inference profile such as "us", "eu", "apac" or "global". Models only
+	// served through profiles default to the one covering Region.
+	InferenceProfile string
+	MaxTokens        int
+	MaxRetries       int
+	System           string
+	Temperature      *float64
+	HTTPClient       *http.Client
+
+	// Credentials are static keys. When empty, credentials come from
+	// CredentialExport, the AWS_* environment, then the shared credentials
+	// file.
+	Credentials AWSCredentials
+	Profile     string // Optional: shared credentials profile, defaults to AWS_PROFILE then "default"
+	// CredentialExport is a shell script printing JSON credentials
+	// (settings.json awsCredentialExport).
+	CredentialExport string
+	// AuthRefresh is a shell script run before reloading credentials that
+	// expired or were rejected, e.g. "aws sso login" (settings.json
+	// awsAuthRefresh).
+	AuthRefresh string
+	// AuthRefreshOutput receives the AuthRefresh script's stdout and stderr,
+	// such as an SSO device code and URL. Defaults to os.Stderr.
+	AuthRefreshOutput io.Writer
+}
+
+const (
+	defaultBedrockRegion    = "us-east-1"
+	bedrockAnthropicVersion = "bedrock-2023-05-31"
+)
+
+// bedrockModelIDs lists the models whose Bedrock ID is not
+// "anthropic.<name>-v1:0".
+var bedrockModelIDs = map[string]string{
+	"claude-3-5-sonnet-20241022": "anthropic.claude-3-5-sonnet-20241022-v2:0",
+}
+
+// NewBedrock constructs an Anthropic-backed Model served by Amazon Bedrock.
+func NewBedrock(cfg BedrockConfig) (Model, error) {
+	static := cfg.Credentials
+	if (static.AccessKeyID == "") != (static.SecretAccessKey == "") {
+		return nil, errors.New("bedrock: access key id and secret access key must be set together")
+	}
+
+	region := strings.TrimSpace(cfg.Region)
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region = strings.TrimSpace(os.Getenv(env))
+		}
+	}
+	if region == "" {
+		region = defaultBedrockRegion
+	}
+	baseURL := strings.TrimSpace(cfg.BaseURL)
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", region)
+	}
+
+	creds := &awsCredentialSource{
+		static:        static,
+		profile:       strings.TrimSpace(cfg.Profile),
+		export:        strings.TrimSpace(cfg.CredentialExport),
+		refresh:       strings.TrimSpace(cfg.AuthRefresh),
+		refreshOutput: cfg.AuthRefreshOutput,
+	}
+	opts := []option.RequestOption{
+		option.WithBaseURL(baseURL),
+		option.WithMiddleware(bedrockMiddleware(region, strings.TrimSpace(cfg.InferenceProfile), creds)),
+		// doWithRetry owns retries; SDK retries would only resend the same
+		// rejected signature.
+		option.WithMaxRetries(0),
+	}
+	if cfg.HTTPClient != nil {
+		opts = append(opts, option.WithHTTPClient(cfg.HTTPClient))
+	}
+
+	client := anthropicsdk.NewClient(opts...)
+	return newAnthropicModel(&client.Messages, cfg.Model, cfg.MaxTokens, cfg.MaxRetries, cfg.System, cfg.Temperature), nil
+}
+
+// BedrockModelID maps an Anthropic model name or alias to its Bedrock model
+// ID, prefixed with inferenceProfile when set. Models that Bedrock serves
+// only through inference profiles (Claude 3.7 Sonnet and Claude 4 and later)
+// get the geography of region ("us", "eu", "apac", else "global") when
+// inferenceProfile is empty. Bedrock model IDs, inference profile IDs, ARNs
+// and non-Claude names pass through unchanged.
+func BedrockModelID(name, region, inferenceProfile string) string {
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, "arn:") || strings.Contains(name, "anthropic.") {
+		return name
+	}
+	dated := datedAnthropicModel(name)
+	if !strings.HasPrefix(dated, "claude-") {
+		return name
+	}
+	id, ok := bedrockModelIDs[dated]
+	if !ok {
+		id = "anthropic." + dated + "-v1:0"
+	}
+	profile := strings.Trim(strings.TrimSpace(inferenceProfile), ".")
+	if profile == "" && bedrockProfileOnly(dated) {
+		profile = bedrockRegionProfile(region)
+	}
+	if profile != "" {
+		id = profile + "." + id
+	}
+	return id
+}
+
+// bedrockProfileOnly reports models without on-demand throughput in any
+// single region: Claude 3.7 Sonnet and every Claude 4 or later model.
+func bedrockProfileOnly(name string) bool {
+	if strings.HasPrefix(name, "claude-3-7-sonnet") {
+		return true
+	}
+	parts := strings.Split(strings.TrimPrefix(name, "claude-"), "-")
+	if len(parts) < 2 {
+		return false
+	}
+	switch parts[0] {
+	case "opus", "sonnet", "haiku":
+		major, err := strconv.Atoi(parts[1])
+		return err == nil && major >= 4
+	}
+	return false
+}
+
+// bedrockRegionProfile names the cross-region inference profile covering
+// region.
+func bedrockRegionProfile(region string) string {
+	region = strings.TrimSpace(region)
+	if region == "" {
+		region = defaultBedrockRegion
+	}
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "us-gov"
+	case strings.HasPrefix(region, "us-"):
+		return "us"
+	case strings.HasPrefix(region, "eu-"):
+		return "eu"
+	case strings.HasPrefix(region, "ap-"):
+		return "apac"
+	default:
+		return "global"
+	}
+}
+
+// bedrockMiddleware rewrites Messages API calls into Bedrock InvokeModel
+// calls and signs them, reloading credentials once when AWS rejects them.
+func bedrockMiddleware(region, inferenceProfile string, creds *awsCredentialSource) option.Middleware {
+	return func(r *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/v1/messages") {
+			return nil, fmt.Errorf("bedrock: %s %s is not supported", r.Method, r.URL.Path)
+		}
+		fields, err := readJSONBody(r)
+		if err != nil {
+			return nil, fmt.Errorf("bedrock: %w", err)
+		}
+		modelID := BedrockModelID(jsonString(fields["model"]), region, inferenceProfile)
+		if modelID == "" {
+			return nil, errors.New("bedrock: model is required")
+		}
+		action := "invoke"
+		if jsonBool(fields["stream"]) {
+			action = "invoke-with-response-stream"
+		}
+		// InvokeModel rejects keys outside its schema, and metadata.user_id
+		// is set whenever the request names a session.
+		delete(fields, "model")
+		delete(fields, "stream")
+		delete(fields, "metadata")
+		if _, ok := fields["anthropic_version"]; !ok {
+			fields["anthropic_version"] = json.RawMessage(`"` + bedrockAnthropicVersion + `"`)
+		}
+		// Bedrock takes beta flags in the body rather than as a header.
+		if betas := splitHeaderList(r.Header.Values("Anthropic-Beta")); len(betas) > 0 {
+			raw, _ := json.Marshal(betas)
+			fields["anthropic_beta"] = raw
+		}
+		body, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("bedrock: encode request body: %w", err)
+		}
+
+		prefix := strings.TrimSuffix(r.URL.Path, "/v1/messages")
+		rawPrefix := strings.TrimSuffix(r.URL.EscapedPath(), "/v1/messages")
+		r.URL.Path = prefix + "/model/" + modelID + "/" + action
+		r.URL.RawPath = rawPrefix + "/model/" + url.QueryEscape(modelID) + "/" + action
+		r.Header.Del("Anthropic-Beta")
+		r.Header.Del("X-Api-Key")
+		r.Header.Del("Authorization")
+
+		send := func() (*http.Response, error) {
+			current, err := creds.retrieve(r.Context())
+			if err != nil {
+				return nil, err
+			}
+			req := r.Clone(r.Context())
+			setRequestBody(req, body)
+			signAWSRequest(req, body, current, region, "bedrock", time.Now())
+			return next(req)
+		}
+		resp, err := send()
+		if err == nil && bedrockCredentialsRejected(resp) && creds.invalidate() {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			return send()
+		}
+		return resp, err
+	}
+}
+
+// bedrockCredentialsRejected reports a reply to expired or unknown
+// credentials, which a refresh can fix, as opposed to a missing permission.
+func bedrockCredentialsRejected(resp *http.Response) bool {
+	if resp == nil || (resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden) {
+		return false
+	}
+	errType := resp.Header.Get("X-Amzn-Errortype")
+	return strings.HasPrefix(errType, "ExpiredToken") || strings.HasPrefix(errType, "UnrecognizedClient")
+}
+
+func splitHeaderList(values []string) []string {
+	var out []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
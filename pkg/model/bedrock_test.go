@@ -0,0 +1,428 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bedrockTestMessage = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-5-20250929","content":[{"type":"text","text":"hello from bedrock"}],"stop_reason":"end_turn","usage":{"input_tokens":7,"output_tokens":3}}`
+
+// bedrockStub checks the SigV4 signature of every request against the keys
+// it accepts and replies with reply.
+type bedrockStub struct {
+	t       *testing.T
+	keys    map[string]AWSCredentials // access key id -> credentials
+	expired map[string]bool           // access key ids answered with ExpiredTokenException
+	reply   func(w http.ResponseWriter)
+
+	calls  atomic.Int32
+	path   string
+	body   map[string]any
+	header http.Header
+}
+
+func (s *bedrockStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.calls.Add(1)
+	raw, err := io.ReadAll(r.Body)
+	require.NoError(s.t, err)
+	s.path, s.header = r.URL.EscapedPath(), r.Header.Clone()
+	s.body = nil
+	require.NoError(s.t, json.Unmarshal(raw, &s.body))
+
+	keyID, err := verifyAWSSignature(r, raw, s.keys, "us-west-2", "bedrock")
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"message":%q}`, err.Error())
+		return
+	}
+	if s.expired[keyID] {
+		w.Header().Set("X-Amzn-Errortype", "ExpiredTokenException:http://internal.amazon.com/coral/com.amazon.coral.service/")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, `{"message":"The security token included in the request is expired"}`)
+		return
+	}
+	s.reply(w)
+}
+
+// verifyAWSSignature recomputes the signature of a received request and
+// returns the access key that signed it.
+func verifyAWSSignature(r *http.Request, body []byte, keys map[string]AWSCredentials, region, service string) (string, error) {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), awsSigningAlgorithm+" ")
+	parts := map[string]string{}
+	for _, field := range strings.Split(auth, ", ") {
+		name, value, _ := strings.Cut(field, "=")
+		parts[name] = value
+	}
+	credential := strings.SplitN(parts["Credential"], "/", 2)
+	if len(credential) != 2 {
+		return "", fmt.Errorf("malformed authorization %q", auth)
+	}
+	creds, ok := keys[credential[0]]
+	if !ok {
+		return "", fmt.Errorf("unknown access key %q", credential[0])
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	scope := awsCredentialScope(amzDate, region, service)
+	if credential[1] != scope {
+		return "", fmt.Errorf("scope %q, want %q", credential[1], scope)
+	}
+	if r.Header.Get("X-Amz-Security-Token") != creds.SessionToken {
+		return "", fmt.Errorf("unexpected session token")
+	}
+
+	headers := map[string]string{}
+	for _, name := range strings.Split(parts["SignedHeaders"], ";") {
+		if name == "host" {
+			headers[name] = r.Host
+			continue
+		}
+		headers[name] = strings.Join(r.Header.Values(name), ",")
+	}
+	canonical, _ := awsCanonicalRequest(r.Method, r.URL.EscapedPath(), r.URL.RawQuery, headers, sha256Hex(body))
+	want := awsSignature(creds.SecretAccessKey, amzDate, region, service, awsStringToSign(amzDate, scope, canonical))
+	if parts["Signature"] != want {
+		return "", fmt.Errorf("signature mismatch")
+	}
+	return credential[0], nil
+}
+
+func newBedrockStub(t *testing.T, cfg BedrockConfig, stub *bedrockStub) Model {
+	t.Helper()
+	stub.t = t
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	cfg.BaseURL = srv.URL
+	cfg.HTTPClient = srv.Client()
+	cfg.Region = "us-west-2"
+	cfg.MaxRetries = 1
+	mdl, err := NewBedrock(cfg)
+	require.NoError(t, err)
+	return mdl
+}
+
+func TestAWSSignatureMatchesReferenceVector(t *testing.T) {
+	// get-vanilla from the AWS SigV4 test suite.
+	amzDate := "20150830T123600Z"
+	canonical, signed := awsCanonicalRequest(http.MethodGet, "/", "", map[string]string{
+		"host":       "example.amazonaws.com",
+		"x-amz-date": amzDate,
+	}, sha256Hex(nil))
+	assert.Equal(t, "host;x-amz-date", signed)
+	scope := awsCredentialScope(amzDate, "us-east-1", "service")
+	sig := awsSignature("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", amzDate, "us-east-1", "service", awsStringToSign(amzDate, scope, canonical))
+	assert.Equal(t, "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", sig)
+
+	assert.Equal(t, "/model/anthropic.claude-v1%253A0/invoke", awsURIEncode("/model/anthropic.claude-v1%3A0/invoke", false))
+	assert.Equal(t, "a=1&b=x%2Fy&b=z", awsCanonicalQuery("b=z&a=1&b=x%2Fy"))
+}
+
+func TestBedrockCompleteSignsInvokeRequest(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "sk-ant-must-not-leak")
+	creds := AWSCredentials{AccessKeyID: "AKIDSTATIC", SecretAccessKey: "secret", SessionToken: "session"}
+	stub := &bedrockStub{
+		keys: map[string]AWSCredentials{creds.AccessKeyID: creds},
+		reply: func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, bedrockTestMessage)
+		},
+	}
+	mdl := newBedrockStub(t, BedrockConfig{Model: "claude-sonnet-4-5", InferenceProfile: "us", Credentials: creds}, stub)
+
+	resp, err := mdl.Complete(context.Background(), Request{SessionID: "sess-1", Messages: []Message{{Role: "user", Content: "hi"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "hello from bedrock", resp.Message.Content)
+	assert.Equal(t, 7, resp.Usage.InputTokens)
+
+	assert.Equal(t, "/model/us.anthropic.claude-sonnet-4-5-20250929-v1%3A0/invoke", stub.path)
+	assert.Equal(t, bedrockAnthropicVersion, stub.body["anthropic_version"])
+	assert.NotContains(t, stub.body, "model")
+	assert.NotContains(t, stub.body, "stream")
+	assert.NotContains(t, stub.body, "metadata", "Bedrock rejects metadata even with a session ID")
+	assert.Empty(t, stub.header.Get("X-Api-Key"))
+	assert.Equal(t, int32(1), stub.calls.Load())
+}
+
+func TestBedrockCompleteStreamDecodesEventStream(t *testing.T) {
+	creds := AWSCredentials{AccessKeyID: "AKIDSTREAM", SecretAccessKey: "secret"}
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5-20251001","content":[],"usage":{"input_tokens":5,"output_tokens":0}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"ping"}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"input_tokens":5,"output_tokens":2}}`,
+		`{"type":"message_stop"}`,
+	}
+	stub := &bedrockStub{
+		keys: map[string]AWSCredentials{creds.AccessKeyID: creds},
+		reply: func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", awsEventStreamContentType)
+			for _, event := range events {
+				_, _ = w.Write(bedrockChunkFrame(event))
+			}
+		},
+	}
+	mdl := newBedrockStub(t, BedrockConfig{Model: "claude-haiku-4-5", Credentials: creds}, stub)
+
+	var text strings.Builder
+	var final *Response
+	err := mdl.CompleteStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, func(sr StreamResult) error {
+		text.WriteString(sr.Delta)
+		if sr.Final {
+			final = sr.Response
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", text.String())
+	require.NotNil(t, final)
+	assert.Equal(t, "Hello", final.Message.Content)
+	assert.Equal(t, "end_turn", final.StopReason)
+	assert.Equal(t, 2, final.Usage.OutputTokens)
+	// The token pre-count is not sent to Bedrock; only the invoke call is.
+	assert.Equal(t, int32(1), stub.calls.Load())
+	assert.Equal(t, "/model/us.anthropic.claude-haiku-4-5-20251001-v1%3A0/invoke-with-response-stream", stub.path)
+}
+
+func TestBedrockStreamReportsException(t *testing.T) {
+	payload := []byte(`{"message":"Too many requests"}`)
+	frame := awsEventFrame(map[string]string{":message-type": "exception", ":exception-type": "throttlingException"}, payload)
+	dec := &awsEventStreamDecoder{rc: io.NopCloser(bytes.NewReader(append(bedrockChunkFrame(`{"type":"ping"}`), frame...)))}
+
+	require.True(t, dec.Next())
+	assert.Equal(t, "ping", dec.Event().Type)
+	assert.False(t, dec.Next())
+	assert.EqualError(t, dec.Err(), "bedrock: throttlingException: Too many requests")
+
+	corrupt := bedrockChunkFrame(`{"type":"ping"}`)
+	corrupt[len(corrupt)-1] ^= 0xff
+	dec = &awsEventStreamDecoder{rc: io.NopCloser(bytes.NewReader(corrupt))}
+	assert.False(t, dec.Next())
+	assert.ErrorContains(t, dec.Err(), "checksum mismatch")
+
+	// A prelude announcing a 4 GiB frame is rejected before allocating it.
+	var huge [12]byte
+	binary.BigEndian.PutUint32(huge[0:4], 0xffffffff)
+	binary.BigEndian.PutUint32(huge[8:12], crc32.ChecksumIEEE(huge[:8]))
+	dec = &awsEventStreamDecoder{rc: io.NopCloser(bytes.NewReader(huge[:]))}
+	assert.False(t, dec.Next())
+	assert.EqualError(t, dec.Err(), "bedrock: invalid event stream frame length 4294967295")
+}
+
+func TestBedrockRefreshesRejectedCredentials(t *testing.T) {
+	dir := t.TempDir()
+	writeCreds := func(name, keyID string) {
+		data := fmt.Sprintf(`{"Credentials":{"AccessKeyId":%q,"SecretAccessKey":"secret-%s","SessionToken":"token-%s"}}`, keyID, keyID, keyID)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600))
+	}
+	writeCreds("current.json", "AKIDOLD")
+	writeCreds("fresh.json", "AKIDNEW")
+	refreshLog := filepath.Join(dir, "refresh.log")
+
+	stub := &bedrockStub{
+		keys: map[string]AWSCredentials{
+			"AKIDOLD": {AccessKeyID: "AKIDOLD", SecretAccessKey: "secret-AKIDOLD", SessionToken: "token-AKIDOLD"},
+			"AKIDNEW": {AccessKeyID: "AKIDNEW", SecretAccessKey: "secret-AKIDNEW", SessionToken: "token-AKIDNEW"},
+		},
+		expired: map[string]bool{"AKIDOLD": true},
+		reply: func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, bedrockTestMessage)
+		},
+	}
+	mdl := newBedrockStub(t, BedrockConfig{
+		Model:            "anthropic.claude-sonnet-4-5-20250929-v1:0",
+		CredentialExport: fmt.Sprintf("cat %q", filepath.Join(dir, "current.json")),
+		AuthRefresh:      fmt.Sprintf("cp %q %q && echo refreshed >> %q", filepath.Join(dir, "fresh.json"), filepath.Join(dir, "current.json"), refreshLog),
+	}, stub)
+
+	resp, err := mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "hello from bedrock", resp.Message.Content)
+	assert.Equal(t, int32(2), stub.calls.Load())
+	logged, err := os.ReadFile(refreshLog)
+	require.NoError(t, err)
+	assert.Equal(t, "refreshed\n", string(logged))
+
+	// Credentials that stay rejected fail without looping.
+	stub.expired["AKIDNEW"] = true
+	stub.calls.Store(0)
+	_, err = mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	require.Error(t, err)
+	assert.Equal(t, int32(2), stub.calls.Load())
+}
+
+func TestAWSCredentialSourceRefreshesExpiredCredentials(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "current.json")
+	soon := time.Now().Add(30 * time.Second).UTC().Format(time.RFC3339)
+	require.NoError(t, os.WriteFile(current, []byte(`{"AccessKeyId":"AKIDOLD","SecretAccessKey":"s","Expiration":"`+soon+`"}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fresh.json"), []byte(`{"Version":1,"AccessKeyId":"AKIDNEW","SecretAccessKey":"s"}`), 0o600))
+
+	src := &awsCredentialSource{
+		export:  fmt.Sprintf("cat %q", current),
+		refresh: fmt.Sprintf("cp %q %q", filepath.Join(dir, "fresh.json"), current),
+	}
+	creds, err := src.retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "AKIDOLD", creds.AccessKeyID)
+
+	creds, err = src.retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "AKIDNEW", creds.AccessKeyID)
+	assert.True(t, creds.Expires.IsZero())
+
+	failing := &awsCredentialSource{export: "echo boom >&2; exit 3"}
+	_, err = failing.retrieve(context.Background())
+	assert.ErrorContains(t, err, "awsCredentialExport")
+	assert.ErrorContains(t, err, "boom")
+	assert.False(t, isRetryable(err))
+}
+
+func TestAWSCredentialSourceRefreshForwardsOutputAndRunsOnce(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "current.json")
+	runs := filepath.Join(dir, "runs")
+	require.NoError(t, os.WriteFile(current, []byte(`{"AccessKeyId":"AKIDOLD","SecretAccessKey":"s"}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fresh.json"), []byte(`{"AccessKeyId":"AKIDNEW","SecretAccessKey":"s"}`), 0o600))
+
+	var out bytes.Buffer
+	src := &awsCredentialSource{
+		export: fmt.Sprintf("cat %q", current),
+		refresh: fmt.Sprintf("echo run >> %q; echo 'Open https://device.sso.example/ and enter ABCD-EFGH'; echo waiting >&2; sleep 0.2; cp %q %q",
+			runs, filepath.Join(dir, "fresh.json"), current),
+		refreshOutput: &out,
+	}
+	creds, err := src.retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "AKIDOLD", creds.AccessKeyID)
+	require.True(t, src.invalidate())
+
+	// Concurrent callers share a single refresh.
+	var wg sync.WaitGroup
+	got := make([]string, 8)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			creds, err := src.retrieve(context.Background())
+			assert.NoError(t, err)
+			got[i] = creds.AccessKeyID
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range got {
+		assert.Equal(t, "AKIDNEW", id)
+	}
+	logged, err := os.ReadFile(runs)
+	require.NoError(t, err)
+	assert.Equal(t, "run\n", string(logged))
+	assert.Contains(t, out.String(), "Open https://device.sso.example/ and enter ABCD-EFGH")
+	assert.Contains(t, out.String(), "waiting")
+
+	// A caller whose context ends stops waiting for someone else's refresh.
+	require.True(t, src.invalidate())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = src.retrieve(context.Background())
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = src.retrieve(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	<-done
+}
+
+func TestAWSCredentialSourceReadsEnvironmentAndProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, os.WriteFile(path, []byte("[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = d\n\n# work account\n[work]\naws_access_key_id=AKIDWORK\naws_secret_access_key=w\naws_session_token=t\n"), 0o600))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "work")
+
+	creds, err := (&awsCredentialSource{}).retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, AWSCredentials{AccessKeyID: "AKIDWORK", SecretAccessKey: "w", SessionToken: "t"}, creds)
+
+	creds, err = (&awsCredentialSource{profile: "default"}).retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "AKIDDEFAULT", creds.AccessKeyID)
+
+	_, err = (&awsCredentialSource{profile: "missing"}).retrieve(context.Background())
+	assert.ErrorContains(t, err, `profile "missing"`)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "e")
+	creds, err = (&awsCredentialSource{}).retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "AKIDENV", creds.AccessKeyID)
+}
+
+func TestBedrockModelID(t *testing.T) {
+	cases := []struct{ name, region, profile, want string }{
+		{"claude-sonnet-4-5", "", "", "us.anthropic.claude-sonnet-4-5-20250929-v1:0"},
+		{"claude-sonnet-4-5", "eu-central-1", "", "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"},
+		{"claude-haiku-4-5", "ap-northeast-1", "", "apac.anthropic.claude-haiku-4-5-20251001-v1:0"},
+		{"claude-opus-4-1", "sa-east-1", "", "global.anthropic.claude-opus-4-1-20250805-v1:0"},
+		{"claude-opus-4-1-20250805", "eu-west-1", "us", "us.anthropic.claude-opus-4-1-20250805-v1:0"},
+		{"claude-3-5-sonnet-20241022", "eu-west-1", "", "anthropic.claude-3-5-sonnet-20241022-v2:0"},
+		{"claude-3-haiku-20240307", "us-east-1", "", "anthropic.claude-3-haiku-20240307-v1:0"},
+		{"claude-3-7-sonnet-latest", "us-east-1", "eu.", "eu.anthropic.claude-3-7-sonnet-20250219-v1:0"},
+		{"claude-3-7-sonnet-latest", "us-gov-west-1", "", "us-gov.anthropic.claude-3-7-sonnet-20250219-v1:0"},
+		{"global.anthropic.claude-haiku-4-5-20251001-v1:0", "", "us", "global.anthropic.claude-haiku-4-5-20251001-v1:0"},
+		{"arn:aws:bedrock:us-east-1:123456789012:application-inference-profile/abc", "", "us", "arn:aws:bedrock:us-east-1:123456789012:application-inference-profile/abc"},
+		{"meta.llama3-70b-instruct-v1:0", "", "", "meta.llama3-70b-instruct-v1:0"},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, BedrockModelID(tc.name, tc.region, tc.profile), tc.name+" "+tc.region)
+	}
+
+	_, err := NewBedrock(BedrockConfig{Credentials: AWSCredentials{AccessKeyID: "AKID"}})
+	assert.Error(t, err)
+}
+
+func bedrockChunkFrame(event string) []byte {
+	payload, _ := json.Marshal(map[string][]byte{"bytes": []byte(event)})
+	return awsEventFrame(map[string]string{":message-type": "event", ":event-type": "chunk", ":content-type": "application/json"}, payload)
+}
+
+// awsEventFrame encodes one event stream message with string headers.
+func awsEventFrame(headers map[string]string, payload []byte) []byte {
+	var hdr bytes.Buffer
+	for name, value := range headers {
+		hdr.WriteByte(byte(len(name)))
+		hdr.WriteString(name)
+		hdr.WriteByte(7)
+		_ = binary.Write(&hdr, binary.BigEndian, uint16(len(value)))
+		hdr.WriteString(value)
+	}
+	total := 12 + hdr.Len() + len(payload) + 4
+	frame := make([]byte, 0, total)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(total))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(hdr.Len()))
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+	frame = append(frame, hdr.Bytes()...)
+	frame = append(frame, payload...)
+	return binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+}
@@ -0,0 +1,485 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const cassetteVersion = 1
+
+// ErrCassetteExhausted is returned by a Replayer once every recorded
+// interaction has been served.
+var ErrCassetteExhausted = errors.New("model: cassette exhausted")
+
+// Cassette holds recorded model interactions.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded model call. Stream is set for CompleteStream
+// calls and holds every result passed to the handler, including the final
+// one. Error records a failed call.
+type Interaction struct {
+	Request  Request        `json:"request"`
+	Response *Response      `json:"response,omitempty"`
+	Stream   []StreamResult `json:"stream,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// LoadCassette reads a cassette file.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("model: read cassette: %w", err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("model: decode cassette %s: %w", path, err)
+	}
+	if c.Version != cassetteVersion {
+		return nil, fmt.Errorf("model: unsupported cassette version %d", c.Version)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette atomically.
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("model: encode cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("model: create cassette dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cassette-*")
+	if err != nil {
+		return fmt.Errorf("model: write cassette: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("model: write cassette: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("model: write cassette: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("model: write cassette: %w", err)
+	}
+	return nil
+}
+
+// Recorder wraps a Model and appends every call to a cassette file. The file
+// is rewritten after each call so a crashed session keeps what it recorded.
+type Recorder struct {
+	inner Model
+	path  string
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder returns a Recorder writing to path. An existing file is
+// replaced on the first call.
+func NewRecorder(inner Model, path string) *Recorder {
+	return &Recorder{inner: inner, path: path, cassette: Cassette{Version: cassetteVersion}}
+}
+
+// Complete implements Model.
+func (r *Recorder) Complete(ctx context.Context, req Request) (*Response, error) {
+	if r.inner == nil {
+		return nil, errors.New("model: recorder inner model is nil")
+	}
+	resp, err := r.inner.Complete(ctx, req)
+	interaction := Interaction{Request: req, Response: resp}
+	if err != nil {
+		interaction.Error = err.Error()
+	}
+	if saveErr := r.record(interaction); saveErr != nil && err == nil {
+		return resp, saveErr
+	}
+	return resp, err
+}
+
+// CompleteStream implements Model.
+func (r *Recorder) CompleteStream(ctx context.Context, req Request, cb StreamHandler) error {
+	if r.inner == nil {
+		return errors.New("model: recorder inner model is nil")
+	}
+	interaction := Interaction{Request: req}
+	err := r.inner.CompleteStream(ctx, req, func(sr StreamResult) error {
+		interaction.Stream = append(interaction.Stream, sr)
+		if sr.Final {
+			interaction.Response = sr.Response
+		}
+		if cb == nil {
+			return nil
+		}
+		return cb(sr)
+	})
+	if err != nil {
+		interaction.Error = err.Error()
+	}
+	if saveErr := r.record(interaction); saveErr != nil && err == nil {
+		return saveErr
+	}
+	return err
+}
+
+// Cassette returns a copy of what has been recorded so far.
+func (r *Recorder) Cassette() Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.cassette
+	out.Interactions = append([]Interaction(nil), r.cassette.Interactions...)
+	return out
+}
+
+func (r *Recorder) record(interaction Interaction) error {
+	// Round-trip through JSON so later mutations by the caller do not leak
+	// into the cassette.
+	interaction, err := cloneInteraction(interaction)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	return r.cassette.Save(r.path)
+}
+
+// MatchMode selects how a Replayer pairs live requests with recorded ones.
+type MatchMode int
+
+const (
+	// MatchStrict serves interactions in recorded order and requires the
+	// whole request, except SessionID, to be identical.
+	MatchStrict MatchMode = iota
+	// MatchLenient serves the first unused interaction whose conversation
+	// matches by role, whitespace-normalized text and tool calls (name and
+	// arguments). System prompt, tool definitions, request parameters, tool
+	// call IDs and tool results are ignored, so volatile tool output does not
+	// break the replay.
+	MatchLenient
+)
+
+// CassetteMismatchError reports a request that matches no recorded
+// interaction. Diff compares the closest recorded request ("-") with the
+// live one ("+") in the normalized form used by the match mode.
+type CassetteMismatchError struct {
+	Index int
+	Diff  string
+}
+
+func (e *CassetteMismatchError) Error() string {
+	return fmt.Sprintf("model: request does not match cassette interaction %d:\n%s", e.Index, e.Diff)
+}
+
+// Replayer is a Model that serves responses from a cassette.
+type Replayer struct {
+	cassette Cassette
+	mode     MatchMode
+
+	mu   sync.Mutex
+	used []bool
+	next int
+}
+
+// NewReplayer loads the cassette at path.
+func NewReplayer(path string, mode MatchMode) (*Replayer, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayerFromCassette(*c, mode), nil
+}
+
+// NewReplayerFromCassette replays an in-memory cassette.
+func NewReplayerFromCassette(c Cassette, mode MatchMode) *Replayer {
+	return &Replayer{cassette: c, mode: mode, used: make([]bool, len(c.Interactions))}
+}
+
+// Remaining reports how many recorded interactions have not been served.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, used := range r.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+// Complete implements Model.
+func (r *Replayer) Complete(_ context.Context, req Request) (*Response, error) {
+	interaction, err := r.match(req)
+	if err != nil {
+		return nil, err
+	}
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+	if interaction.Response == nil {
+		return nil, errors.New("model: cassette interaction has no response")
+	}
+	return interaction.Response, nil
+}
+
+// CompleteStream implements Model. Recorded stream results are replayed in
+// order; interactions recorded through Complete are served as a single final
+// result.
+func (r *Replayer) CompleteStream(_ context.Context, req Request, cb StreamHandler) error {
+	if cb == nil {
+		return errors.New("stream callback required")
+	}
+	interaction, err := r.match(req)
+	if err != nil {
+		return err
+	}
+	stream := interaction.Stream
+	if len(stream) == 0 && interaction.Response != nil {
+		stream = []StreamResult{{Final: true, Response: interaction.Response}}
+	}
+	for _, sr := range stream {
+		if err := cb(sr); err != nil {
+			return err
+		}
+	}
+	if interaction.Error != "" {
+		return errors.New(interaction.Error)
+	}
+	return nil
+}
+
+// match finds the interaction for req and returns a private copy of it.
+func (r *Replayer) match(req Request) (Interaction, error) {
+	live, err := normalizeRequest(req, r.mode)
+	if err != nil {
+		return Interaction{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	found := -1
+	switch r.mode {
+	case MatchLenient:
+		for i, interaction := range r.cassette.Interactions {
+			if r.used[i] {
+				continue
+			}
+			recorded, err := normalizeRequest(interaction.Request, r.mode)
+			if err != nil {
+				return Interaction{}, err
+			}
+			if recorded == live {
+				found = i
+				break
+			}
+		}
+	default:
+		if r.next >= len(r.cassette.Interactions) {
+			return Interaction{}, fmt.Errorf("%w after %d interactions", ErrCassetteExhausted, r.next)
+		}
+		recorded, err := normalizeRequest(r.cassette.Interactions[r.next].Request, r.mode)
+		if err != nil {
+			return Interaction{}, err
+		}
+		if recorded == live {
+			found = r.next
+		}
+	}
+	if found < 0 {
+		return Interaction{}, r.mismatch(live)
+	}
+	r.used[found] = true
+	if found >= r.next {
+		r.next = found + 1
+	}
+	return cloneInteraction(r.cassette.Interactions[found])
+}
+
+// mismatch builds the error for an unmatched request, diffing against the
+// next expected interaction in strict mode or the closest unused one in
+// lenient mode.
+func (r *Replayer) mismatch(live string) error {
+	best, bestDiff, bestScore := -1, "", -1
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || (r.mode == MatchStrict && i != r.next) {
+			continue
+		}
+		recorded, err := normalizeRequest(interaction.Request, r.mode)
+		if err != nil {
+			continue
+		}
+		diff, changed := lineDiff(recorded, live)
+		if bestScore < 0 || changed < bestScore {
+			best, bestDiff, bestScore = i, diff, changed
+		}
+	}
+	if best < 0 {
+		return fmt.Errorf("%w: no unused interactions left", ErrCassetteExhausted)
+	}
+	return &CassetteMismatchError{Index: best, Diff: bestDiff}
+}
+
+type lenientMessage struct {
+	Role      string           `json:"role"`
+	Text      string           `json:"text,omitempty"`
+	ToolCalls []lenientToolUse `json:"tool_calls,omitempty"`
+}
+
+type lenientToolUse struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+}
+
+// normalizeRequest renders req in the comparable form of mode as indented
+// JSON so mismatches diff line by line.
+func normalizeRequest(req Request, mode MatchMode) (string, error) {
+	var view any
+	if mode == MatchLenient {
+		msgs := make([]lenientMessage, 0, len(req.Messages))
+		for _, msg := range req.Messages {
+			lm := lenientMessage{Role: msg.Role, Text: strings.Join(strings.Fields(msg.TextContent()), " ")}
+			if msg.Role != "tool" {
+				for _, call := range msg.ToolCalls {
+					lm.ToolCalls = append(lm.ToolCalls, lenientToolUse{Name: call.Name, Arguments: call.Arguments})
+				}
+			}
+			msgs = append(msgs, lm)
+		}
+		view = msgs
+	} else {
+		req.SessionID = ""
+		view = req
+	}
+	data, err := json.MarshalIndent(view, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("model: normalize request: %w", err)
+	}
+	return string(data), nil
+}
+
+func cloneInteraction(in Interaction) (Interaction, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return Interaction{}, fmt.Errorf("model: encode interaction: %w", err)
+	}
+	var out Interaction
+	if err := json.Unmarshal(data, &out); err != nil {
+		return Interaction{}, fmt.Errorf("model: decode interaction: %w", err)
+	}
+	return out, nil
+}
+
+// maxDiffCells bounds the LCS table of lineDiff. Larger changed regions are
+// reported as removed and added wholesale instead of aligned.
+const maxDiffCells = 1 << 20
+
+// lineDiff returns a line diff of a and b with unchanged runs collapsed, and
+// the number of changed lines.
+func lineDiff(a, b string) (string, int) {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	type line struct {
+		op   byte
+		text string
+	}
+	var lines []line
+	// Common leading and trailing lines need no alignment.
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		lines = append(lines, line{' ', x[prefix]})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	tail := x[len(x)-suffix:]
+	x, y = x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]
+
+	// lcs[i][j] is the LCS length of x[i:] and y[j:].
+	var lcs [][]int
+	if (len(x)+1)*(len(y)+1) <= maxDiffCells {
+		lcs = make([][]int, len(x)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(y)+1)
+		}
+		for i := len(x) - 1; i >= 0; i-- {
+			for j := len(y) - 1; j >= 0; j-- {
+				if x[i] == y[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+	}
+
+	changed := 0
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case lcs == nil:
+			// Too large to align: every remaining line differs.
+			if i < len(x) {
+				lines = append(lines, line{'-', x[i]})
+				i++
+			} else {
+				lines = append(lines, line{'+', y[j]})
+				j++
+			}
+			changed++
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			lines = append(lines, line{' ', x[i]})
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] >= lcs[i+1][j]):
+			lines = append(lines, line{'+', y[j]})
+			changed++
+			j++
+		default:
+			lines = append(lines, line{'-', x[i]})
+			changed++
+			i++
+		}
+	}
+	for _, text := range tail {
+		lines = append(lines, line{' ', text})
+	}
+
+	const contextLines = 2
+	var sb strings.Builder
+	skipped := 0
+	for k, l := range lines {
+		near := false
+		for d := -contextLines; d <= contextLines && !near; d++ {
+			if n := k + d; n >= 0 && n < len(lines) && lines[n].op != ' ' {
+				near = true
+			}
+		}
+		if !near {
+			skipped++
+			continue
+		}
+		if skipped > 0 {
+			fmt.Fprintf(&sb, "  ... (%d unchanged lines)\n", skipped)
+			skipped = 0
+		}
+		fmt.Fprintf(&sb, "%c %s\n", l.op, l.text)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(&sb, "  ... (%d unchanged lines)\n", skipped)
+	}
+	return sb.String(), changed
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func cassetteRequest(toolResult string) Request {
+	return Request{
+		System:    "be brief",
+		SessionID: "live",
+		Messages: []Message{
+			{Role: "user", Content: "list files"},
+			{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Name: "glob", Arguments: map[string]any{"pattern": "*.go"}}}},
+			{Role: "tool", ToolCalls: []ToolCall{{ID: "call_1", Name: "glob", Result: toolResult}}},
+		},
+	}
+}
+
+func recordCassette(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "session.cassette.json")
+	inner := &failoverStub{name: "recorded", deltas: []string{"two ", "files"}}
+	rec := NewRecorder(inner, path)
+
+	var streamed []string
+	if err := rec.CompleteStream(context.Background(), cassetteRequest("a.go\nb.go"), func(sr StreamResult) error {
+		streamed = append(streamed, sr.Delta)
+		return nil
+	}); err != nil {
+		t.Fatalf("record stream: %v", err)
+	}
+	if _, err := rec.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "thanks"}}}); err != nil {
+		t.Fatalf("record complete: %v", err)
+	}
+	if got := len(rec.Cassette().Interactions); got != 2 {
+		t.Fatalf("expected 2 recorded interactions, got %d", got)
+	}
+	if strings.Join(streamed, "") != "two files" {
+		t.Fatalf("recorder must pass stream through, got %q", streamed)
+	}
+	return path
+}
+
+func TestReplayerStrictServesRecordedSession(t *testing.T) {
+	path := recordCassette(t)
+	rp, err := NewReplayer(path, MatchStrict)
+	if err != nil {
+		t.Fatalf("replayer: %v", err)
+	}
+
+	req := cassetteRequest("a.go\nb.go")
+	req.SessionID = "another-session"
+	var deltas []string
+	var final *Response
+	if err := rp.CompleteStream(context.Background(), req, func(sr StreamResult) error {
+		deltas = append(deltas, sr.Delta)
+		if sr.Final {
+			final = sr.Response
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("replay stream: %v", err)
+	}
+	if strings.Join(deltas, "") != "two files" || final == nil || final.Model != "recorded" {
+		t.Fatalf("unexpected replayed stream %q final=%+v", deltas, final)
+	}
+	resp, err := rp.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "thanks"}}})
+	if err != nil || resp.Message.Content != "recorded" {
+		t.Fatalf("unexpected replayed response %+v err=%v", resp, err)
+	}
+	if rp.Remaining() != 0 {
+		t.Fatalf("expected cassette fully consumed")
+	}
+	if _, err := rp.Complete(context.Background(), Request{}); !errors.Is(err, ErrCassetteExhausted) {
+		t.Fatalf("expected ErrCassetteExhausted, got %v", err)
+	}
+}
+
+func TestReplayerStrictMismatchShowsDiff(t *testing.T) {
+	rp, err := NewReplayer(recordCassette(t), MatchStrict)
+	if err != nil {
+		t.Fatalf("replayer: %v", err)
+	}
+	_, err = rp.Complete(context.Background(), cassetteRequest("a.go\nb.go\nc.go"))
+	var mismatch *CassetteMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected mismatch error, got %v", err)
+	}
+	if mismatch.Index != 0 {
+		t.Fatalf("expected diff against interaction 0, got %d", mismatch.Index)
+	}
+	if !strings.Contains(mismatch.Diff, `- `) || !strings.Contains(mismatch.Diff, `+ `) || !strings.Contains(mismatch.Diff, `c.go`) {
+		t.Fatalf("expected readable diff, got:\n%s", mismatch.Diff)
+	}
+	if !strings.Contains(mismatch.Diff, "unchanged lines") {
+		t.Fatalf("expected unchanged lines collapsed, got:\n%s", mismatch.Diff)
+	}
+}
+
+func TestReplayerLenientIgnoresVolatileContent(t *testing.T) {
+	rp, err := NewReplayer(recordCassette(t), MatchLenient)
+	if err != nil {
+		t.Fatalf("replayer: %v", err)
+	}
+	// Out of order, different tool output and whitespace, other system prompt.
+	if _, err := rp.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "  thanks "}}}); err != nil {
+		t.Fatalf("lenient complete: %v", err)
+	}
+	req := cassetteRequest("/tmp/xyz/a.go")
+	req.System = "different"
+	req.Messages[1].ToolCalls[0].ID = "call_other"
+	if _, err := rp.Complete(context.Background(), req); err != nil {
+		t.Fatalf("lenient complete: %v", err)
+	}
+
+	rp2, _ := NewReplayer(recordCassette(t), MatchLenient)
+	changed := cassetteRequest("a.go")
+	changed.Messages[0].Content = "list directories"
+	_, err = rp2.Complete(context.Background(), changed)
+	var mismatch *CassetteMismatchError
+	if !errors.As(err, &mismatch) || !strings.Contains(mismatch.Diff, "list directories") {
+		t.Fatalf("expected lenient mismatch with diff, got %v", err)
+	}
+}
+
+func TestLineDiffBoundsLargeInputs(t *testing.T) {
+	diff, changed := lineDiff("a\nold\nz", "a\nnew\nz")
+	if changed != 2 || !strings.Contains(diff, "- old") || !strings.Contains(diff, "+ new") {
+		t.Fatalf("unexpected small diff %d:\n%s", changed, diff)
+	}
+
+	// Past the table cap the differing middle is listed without alignment,
+	// while shared leading and trailing lines still collapse.
+	var x, y []string
+	for i := 0; i < 2000; i++ {
+		x = append(x, fmt.Sprintf("x%d", i))
+		y = append(y, fmt.Sprintf("y%d", i))
+	}
+	a := "head\n" + strings.Join(x, "\n") + "\ntail"
+	b := "head\n" + strings.Join(y, "\n") + "\ntail"
+	diff, changed = lineDiff(a, b)
+	if changed != 4000 {
+		t.Fatalf("expected every middle line changed, got %d", changed)
+	}
+	if !strings.HasPrefix(diff, "  head\n- x0\n") || !strings.HasSuffix(diff, "+ y1999\n  tail\n") {
+		t.Fatalf("unexpected large diff edges:\n%s...%s", diff[:40], diff[len(diff)-40:])
+	}
+}
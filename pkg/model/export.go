@@ -0,0 +1,39 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	anthropicsdk "github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+)
+
+// EncodeAnthropicMessages renders msgs as the system and messages fields of
+// an Anthropic Messages API request, exactly as the Anthropic adapter sends
+// them.
+func EncodeAnthropicMessages(msgs []Message) ([]byte, error) {
+	system, messages, err := convertMessages(msgs, false)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(struct {
+		System   []anthropicsdk.TextBlockParam `json:"system,omitempty"`
+		Messages []anthropicsdk.MessageParam   `json:"messages"`
+	}{System: system, Messages: messages})
+	if err != nil {
+		return nil, fmt.Errorf("encode anthropic messages: %w", err)
+	}
+	return data, nil
+}
+
+// EncodeOpenAIMessages renders msgs as the messages field of an OpenAI Chat
+// Completions request, exactly as the OpenAI adapter sends them.
+func EncodeOpenAIMessages(msgs []Message) ([]byte, error) {
+	data, err := json.Marshal(struct {
+		Messages []openai.ChatCompletionMessageParamUnion `json:"messages"`
+	}{Messages: convertMessagesToOpenAI(msgs)})
+	if err != nil {
+		return nil, fmt.Errorf("encode openai messages: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeProviderMessages(t *testing.T) {
+	msgs := []Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "list files"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "t1", Name: "bash", Arguments: map[string]any{"command": "ls"}}}},
+		{Role: "tool", ToolCalls: []ToolCall{{ID: "t1", Name: "bash", Result: "a.go"}}},
+	}
+
+	raw, err := EncodeAnthropicMessages(msgs)
+	if err != nil {
+		t.Fatalf("anthropic: %v", err)
+	}
+	var anthropic struct {
+		System []struct {
+			Text string `json:"text"`
+		} `json:"system"`
+		Messages []struct {
+			Role    string           `json:"role"`
+			Content []map[string]any `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(raw, &anthropic); err != nil {
+		t.Fatalf("decode anthropic: %v", err)
+	}
+	if len(anthropic.System) != 1 || anthropic.System[0].Text != "be brief" || len(anthropic.Messages) != 3 {
+		t.Fatalf("unexpected anthropic payload %s", raw)
+	}
+	if result := anthropic.Messages[2]; result.Role != "user" || result.Content[0]["type"] != "tool_result" || result.Content[0]["tool_use_id"] != "t1" {
+		t.Fatalf("expected tool result turn, got %+v", result)
+	}
+
+	raw, err = EncodeOpenAIMessages(msgs)
+	if err != nil {
+		t.Fatalf("openai: %v", err)
+	}
+	var openai struct {
+		Messages []map[string]any `json:"messages"`
+	}
+	if err := json.Unmarshal(raw, &openai); err != nil {
+		t.Fatalf("decode openai: %v", err)
+	}
+	if len(openai.Messages) != 4 || openai.Messages[0]["role"] != "system" || openai.Messages[3]["tool_call_id"] != "t1" {
+		t.Fatalf("unexpected openai payload %s", raw)
+	}
+}
@@ -0,0 +1,363 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	anthropicsdk "github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+)
+
+const (
+	defaultFailoverThreshold = 3
+	defaultFailoverCooldown  = 30 * time.Second
+	defaultFailoverSticky    = 5 * time.Minute
+)
+
+// FailoverSwitch describes a request served by a different model than the
+// previous one.
+type FailoverSwitch struct {
+	// From and To index the models passed to NewFailover; 0 is the primary.
+	From int
+	To   int
+	// FromModel and ToModel are the model names reported by the providers,
+	// when known.
+	FromModel string
+	ToModel   string
+	// Err is the failure that caused the switch; nil when returning to the
+	// primary once the sticky window expired or when From was skipped.
+	Err error
+	// CircuitOpen reports that the model a request would have started with,
+	// indexed by Skipped, was passed over because its circuit breaker was
+	// open.
+	CircuitOpen bool
+	Skipped     int
+}
+
+// Reason renders the switch cause for logs and events.
+func (s FailoverSwitch) Reason() string {
+	switch {
+	case s.Err != nil:
+		return fmt.Sprintf("failover from model %d: %v", s.From, s.Err)
+	case s.CircuitOpen:
+		return fmt.Sprintf("skipped model %d: circuit open", s.Skipped)
+	case s.To == 0:
+		return fmt.Sprintf("returned to model %d", s.To)
+	}
+	return fmt.Sprintf("switched to model %d", s.To)
+}
+
+type failoverObserverKey struct{}
+
+// WithFailoverObserver returns a context whose Failover calls report model
+// switches to fn.
+func WithFailoverObserver(ctx context.Context, fn func(FailoverSwitch)) context.Context {
+	if fn == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, failoverObserverKey{}, fn)
+}
+
+func failoverObserver(ctx context.Context) func(FailoverSwitch) {
+	if ctx == nil {
+		return nil
+	}
+	fn, _ := ctx.Value(failoverObserverKey{}).(func(FailoverSwitch))
+	return fn
+}
+
+// Failover is a Model that tries a primary model and falls back to the next
+// one on retryable errors. Every model has a circuit breaker that skips it
+// for Cooldown after FailureThreshold consecutive retryable failures, and a
+// request that succeeded on a fallback keeps using it for StickyWindow
+// before the primary is tried again.
+//
+// Streams switch models only while nothing has been emitted; an error after
+// the first delta is returned to the caller.
+//
+// Configure the exported fields before the first call.
+type Failover struct {
+	// Retryable decides which errors move on to the next model. nil uses
+	// IsFailoverRetryable.
+	Retryable func(error) bool
+	// FailureThreshold is the number of consecutive failures that open a
+	// model's breaker. Zero uses 3.
+	FailureThreshold int
+	// Cooldown is how long an open breaker skips its model. Zero uses 30s.
+	Cooldown time.Duration
+	// StickyWindow keeps routing to the fallback that last succeeded. Zero
+	// uses 5m; a negative value always starts from the primary.
+	StickyWindow time.Duration
+
+	models []failoverMember
+
+	mu          sync.Mutex
+	current     int
+	currentName string
+	stickyUntil time.Time
+	now         func() time.Time
+}
+
+type failoverMember struct {
+	model     Model
+	failures  int
+	openUntil time.Time
+}
+
+// NewFailover returns a Model that serves requests from primary and falls
+// back to fallbacks in order. nil models are ignored.
+func NewFailover(primary Model, fallbacks ...Model) *Failover {
+	f := &Failover{now: time.Now}
+	for _, m := range append([]Model{primary}, fallbacks...) {
+		if m != nil {
+			f.models = append(f.models, failoverMember{model: m})
+		}
+	}
+	return f
+}
+
+// Complete implements Model.
+func (f *Failover) Complete(ctx context.Context, req Request) (*Response, error) {
+	var resp *Response
+	err := f.do(ctx, func(m Model) (bool, error) {
+		var err error
+		resp, err = m.Complete(ctx, req)
+		return false, err
+	}, func() *Response { return resp })
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CompleteStream implements Model. Once a model has emitted a stream result
+// its errors are returned as is instead of falling back.
+func (f *Failover) CompleteStream(ctx context.Context, req Request, cb StreamHandler) error {
+	var final *Response
+	return f.do(ctx, func(m Model) (bool, error) {
+		emitted := false
+		var cbErr error
+		err := m.CompleteStream(ctx, req, func(sr StreamResult) error {
+			emitted = true
+			if sr.Final {
+				final = sr.Response
+			}
+			if cb == nil {
+				return nil
+			}
+			if err := cb(sr); err != nil {
+				cbErr = err
+				return err
+			}
+			return nil
+		})
+		if cbErr != nil {
+			return true, handlerError{cbErr}
+		}
+		return emitted, err
+	}, func() *Response { return final })
+}
+
+// do runs call against the candidate models. call reports whether the
+// attempt committed to its model, in which case its error is final.
+func (f *Failover) do(ctx context.Context, call func(Model) (bool, error), served func() *Response) error {
+	if len(f.models) == 0 {
+		return errors.New("failover: no models configured")
+	}
+	var (
+		errs      []error
+		lastIndex = -1
+		lastErr   error
+	)
+	order, skipped := f.candidates()
+	for _, idx := range order {
+		committed, err := call(f.models[idx].model)
+		if err == nil {
+			f.succeed(ctx, idx, served(), lastErr, lastIndex, skipped)
+			return nil
+		}
+		var herr handlerError
+		if errors.As(err, &herr) {
+			return herr.err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if !f.retryable(err) {
+			return err
+		}
+		f.fail(idx)
+		if committed {
+			return err
+		}
+		errs = append(errs, fmt.Errorf("model %d: %w", idx, err))
+		lastIndex, lastErr = idx, err
+	}
+	return fmt.Errorf("failover: all models failed: %w", errors.Join(errs...))
+}
+
+// handlerError marks an error returned by the caller's stream handler, which
+// is passed through without touching the breakers.
+type handlerError struct{ err error }
+
+func (e handlerError) Error() string { return e.err.Error() }
+
+// candidates orders the models for a request: the sticky model first, then
+// the rest in configuration order, skipping open breakers unless every
+// breaker is open. skipped is the first-choice model left out for its open
+// breaker, or -1.
+func (f *Failover) candidates() (order []int, skipped int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.clock()
+	start := 0
+	if f.current != 0 && now.Before(f.stickyUntil) {
+		start = f.current
+	}
+	order = make([]int, 0, len(f.models))
+	order = append(order, start)
+	for i := range f.models {
+		if i != start {
+			order = append(order, i)
+		}
+	}
+	closed := order[:0:0]
+	for _, idx := range order {
+		if !now.Before(f.models[idx].openUntil) {
+			closed = append(closed, idx)
+		}
+	}
+	switch {
+	case len(closed) == 0:
+		return order, -1
+	case closed[0] != start:
+		return closed, start
+	}
+	return closed, -1
+}
+
+func (f *Failover) fail(idx int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	member := &f.models[idx]
+	member.failures++
+	if member.failures >= f.threshold() {
+		member.openUntil = f.clock().Add(f.cooldown())
+	}
+}
+
+func (f *Failover) succeed(ctx context.Context, idx int, resp *Response, cause error, causeIndex, skipped int) {
+	f.mu.Lock()
+	member := &f.models[idx]
+	member.failures = 0
+	member.openUntil = time.Time{}
+
+	name := ""
+	if resp != nil {
+		name = resp.Model
+	}
+	var sw *FailoverSwitch
+	if idx != f.current {
+		sw = &FailoverSwitch{From: f.current, To: idx, FromModel: f.currentName, ToModel: name}
+		if causeIndex == f.current {
+			sw.Err = cause
+		} else if skipped >= 0 {
+			sw.CircuitOpen, sw.Skipped = true, skipped
+		}
+	}
+	f.current = idx
+	f.currentName = name
+	if idx != 0 && f.sticky() > 0 {
+		f.stickyUntil = f.clock().Add(f.sticky())
+	} else {
+		f.stickyUntil = time.Time{}
+	}
+	f.mu.Unlock()
+
+	if sw != nil {
+		if fn := failoverObserver(ctx); fn != nil {
+			fn(*sw)
+		}
+	}
+}
+
+func (f *Failover) clock() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+func (f *Failover) retryable(err error) bool {
+	if f.Retryable != nil {
+		return f.Retryable(err)
+	}
+	return IsFailoverRetryable(err)
+}
+
+func (f *Failover) threshold() int {
+	if f.FailureThreshold > 0 {
+		return f.FailureThreshold
+	}
+	return defaultFailoverThreshold
+}
+
+func (f *Failover) cooldown() time.Duration {
+	if f.Cooldown > 0 {
+		return f.Cooldown
+	}
+	return defaultFailoverCooldown
+}
+
+func (f *Failover) sticky() time.Duration {
+	if f.StickyWindow == 0 {
+		return defaultFailoverSticky
+	}
+	return f.StickyWindow
+}
+
+// IsFailoverRetryable reports whether err should move a Failover on to the
+// next model: rate limits (429), request timeouts (408), server errors (5xx,
+// including Anthropic's 529 overloaded), network timeouts and overloaded
+// stream errors. Cancellation and other client errors are not retryable.
+func IsFailoverRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if status, ok := httpStatus(err); ok {
+		return status == http.StatusTooManyRequests ||
+			status == http.StatusRequestTimeout ||
+			status >= http.StatusInternalServerError
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "overloaded") || strings.Contains(msg, "rate_limit")
+}
+
+func httpStatus(err error) (int, bool) {
+	var anthropicErr *anthropicsdk.Error
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode, true
+	}
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode, true
+	}
+	var geminiErr *GeminiError
+	if errors.As(err, &geminiErr) {
+		return geminiErr.StatusCode, true
+	}
+	return 0, false
+}
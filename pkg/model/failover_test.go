@@ -0,0 +1,264 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	anthropicsdk "github.com/anthropics/anthropic-sdk-go"
+)
+
+type failoverStub struct {
+	name    string
+	errs    []error
+	calls   int
+	deltas  []string
+	midFail error
+}
+
+func (s *failoverStub) next() error {
+	s.calls++
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func (s *failoverStub) Complete(context.Context, Request) (*Response, error) {
+	if err := s.next(); err != nil {
+		return nil, err
+	}
+	return &Response{Message: Message{Role: "assistant", Content: s.name}, Model: s.name}, nil
+}
+
+func (s *failoverStub) CompleteStream(_ context.Context, _ Request, cb StreamHandler) error {
+	if err := s.next(); err != nil {
+		return err
+	}
+	for _, d := range s.deltas {
+		if err := cb(StreamResult{Delta: d}); err != nil {
+			return err
+		}
+	}
+	if s.midFail != nil {
+		return s.midFail
+	}
+	return cb(StreamResult{Final: true, Response: &Response{Message: Message{Role: "assistant", Content: s.name}, Model: s.name}})
+}
+
+func overloaded() error {
+	return &anthropicsdk.Error{StatusCode: 529}
+}
+
+func TestFailoverFallsBackAndReportsSwitch(t *testing.T) {
+	primary := &failoverStub{name: "primary", errs: []error{overloaded()}}
+	backup := &failoverStub{name: "backup"}
+	f := NewFailover(primary, backup)
+
+	var switches []FailoverSwitch
+	ctx := WithFailoverObserver(context.Background(), func(sw FailoverSwitch) { switches = append(switches, sw) })
+
+	resp, err := f.Complete(ctx, Request{})
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if resp.Model != "backup" {
+		t.Fatalf("expected backup to serve, got %q", resp.Model)
+	}
+	if len(switches) != 1 || switches[0].From != 0 || switches[0].To != 1 || switches[0].ToModel != "backup" || switches[0].Err == nil {
+		t.Fatalf("unexpected switches %+v", switches)
+	}
+
+	// The sticky window keeps the backup without another switch event.
+	if _, err := f.Complete(ctx, Request{}); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if primary.calls != 1 || backup.calls != 2 || len(switches) != 1 {
+		t.Fatalf("expected sticky backup, primary=%d backup=%d switches=%d", primary.calls, backup.calls, len(switches))
+	}
+}
+
+func TestFailoverStickyWindowExpiresBackToPrimary(t *testing.T) {
+	now := time.Unix(0, 0)
+	primary := &failoverStub{name: "primary", errs: []error{overloaded()}}
+	backup := &failoverStub{name: "backup"}
+	f := NewFailover(primary, backup)
+	f.StickyWindow = time.Minute
+	f.now = func() time.Time { return now }
+
+	var switches []FailoverSwitch
+	ctx := WithFailoverObserver(context.Background(), func(sw FailoverSwitch) { switches = append(switches, sw) })
+	if _, err := f.Complete(ctx, Request{}); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	resp, err := f.Complete(ctx, Request{})
+	if err != nil || resp.Model != "primary" {
+		t.Fatalf("expected primary after sticky window, got %+v err=%v", resp, err)
+	}
+	if len(switches) != 2 || switches[1].To != 0 || switches[1].Err != nil || switches[1].Reason() != "returned to model 0" {
+		t.Fatalf("expected switch back to primary, got %+v", switches)
+	}
+}
+
+func TestFailoverSwitchReportsOpenCircuit(t *testing.T) {
+	now := time.Unix(0, 0)
+	primary := &failoverStub{name: "primary"}
+	backup := &failoverStub{name: "backup"}
+	f := NewFailover(primary, backup)
+	f.now = func() time.Time { return now }
+	// Another request opened the primary's breaker.
+	f.models[0].openUntil = now.Add(time.Minute)
+
+	var switches []FailoverSwitch
+	ctx := WithFailoverObserver(context.Background(), func(sw FailoverSwitch) { switches = append(switches, sw) })
+	if _, err := f.Complete(ctx, Request{}); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if primary.calls != 0 || len(switches) != 1 {
+		t.Fatalf("expected the primary skipped with one switch, calls=%d switches=%+v", primary.calls, switches)
+	}
+	sw := switches[0]
+	if sw.Err != nil || !sw.CircuitOpen || sw.Skipped != 0 || sw.To != 1 {
+		t.Fatalf("unexpected switch %+v", sw)
+	}
+	if got := sw.Reason(); got != "skipped model 0: circuit open" {
+		t.Fatalf("unexpected reason %q", got)
+	}
+}
+
+func TestFailoverCircuitBreakerSkipsOpenModel(t *testing.T) {
+	now := time.Unix(0, 0)
+	primary := &failoverStub{name: "primary", errs: []error{overloaded(), overloaded()}}
+	backup := &failoverStub{name: "backup"}
+	f := NewFailover(primary, backup)
+	f.FailureThreshold = 2
+	f.Cooldown = time.Minute
+	f.StickyWindow = -1
+	f.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := f.Complete(context.Background(), Request{}); err != nil {
+			t.Fatalf("complete %d: %v", i, err)
+		}
+	}
+	if primary.calls != 2 {
+		t.Fatalf("open breaker must skip the primary, got %d calls", primary.calls)
+	}
+	now = now.Add(2 * time.Minute)
+	resp, err := f.Complete(context.Background(), Request{})
+	if err != nil || resp.Model != "primary" || primary.calls != 3 {
+		t.Fatalf("expected primary retried after cooldown, got %+v err=%v calls=%d", resp, err, primary.calls)
+	}
+}
+
+func TestFailoverFallsBackOnGeminiUnavailable(t *testing.T) {
+	unavailable := func() error {
+		return fmt.Errorf("gemini complete: %w", &GeminiError{StatusCode: http.StatusServiceUnavailable, Status: "UNAVAILABLE"})
+	}
+	primary := &failoverStub{name: "gemini", errs: []error{unavailable(), unavailable()}}
+	backup := &failoverStub{name: "backup"}
+	f := NewFailover(primary, backup)
+	f.FailureThreshold = 2
+	f.StickyWindow = -1
+
+	for i := 0; i < 3; i++ {
+		resp, err := f.Complete(context.Background(), Request{})
+		if err != nil || resp.Model != "backup" {
+			t.Fatalf("complete %d: expected backup, got %+v err=%v", i, resp, err)
+		}
+	}
+	if primary.calls != 2 {
+		t.Fatalf("expected the breaker to open after two 503s, got %d primary calls", primary.calls)
+	}
+	if IsFailoverRetryable(&GeminiError{StatusCode: http.StatusBadRequest}) {
+		t.Fatalf("gemini client errors must not fail over")
+	}
+}
+
+func TestFailoverDoesNotRetryClientErrors(t *testing.T) {
+	primary := &failoverStub{name: "primary", errs: []error{&anthropicsdk.Error{StatusCode: http.StatusBadRequest}}}
+	backup := &failoverStub{name: "backup"}
+	f := NewFailover(primary, backup)
+	if _, err := f.Complete(context.Background(), Request{}); err == nil {
+		t.Fatalf("expected client error to be returned")
+	}
+	if backup.calls != 0 {
+		t.Fatalf("client errors must not fall back, got %d backup calls", backup.calls)
+	}
+
+	f.Retryable = func(error) bool { return true }
+	primary.errs = []error{errors.New("custom")}
+	if resp, err := f.Complete(context.Background(), Request{}); err != nil || resp.Model != "backup" {
+		t.Fatalf("custom retry rule should fall back, got %+v err=%v", resp, err)
+	}
+}
+
+func TestFailoverStreamNeverSwitchesAfterFirstDelta(t *testing.T) {
+	boom := &anthropicsdk.Error{StatusCode: http.StatusServiceUnavailable}
+	primary := &failoverStub{name: "primary", deltas: []string{"hel"}, midFail: boom}
+	backup := &failoverStub{name: "backup"}
+	f := NewFailover(primary, backup)
+
+	var deltas []string
+	err := f.CompleteStream(context.Background(), Request{}, func(sr StreamResult) error {
+		if sr.Delta != "" {
+			deltas = append(deltas, sr.Delta)
+		}
+		return nil
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected mid-stream error returned, got %v", err)
+	}
+	if backup.calls != 0 || len(deltas) != 1 {
+		t.Fatalf("stream must not switch after a delta, backup=%d deltas=%v", backup.calls, deltas)
+	}
+
+	// A failure before anything was emitted falls back.
+	primary.errs = []error{boom}
+	primary.deltas = nil
+	var final *Response
+	err = f.CompleteStream(context.Background(), Request{}, func(sr StreamResult) error {
+		if sr.Final {
+			final = sr.Response
+		}
+		return nil
+	})
+	if err != nil || final == nil || final.Model != "backup" {
+		t.Fatalf("expected stream served by backup, got %+v err=%v", final, err)
+	}
+}
+
+func TestFailoverAllModelsFail(t *testing.T) {
+	f := NewFailover(&failoverStub{errs: []error{overloaded()}}, &failoverStub{errs: []error{overloaded()}})
+	_, err := f.Complete(context.Background(), Request{})
+	var apiErr *anthropicsdk.Error
+	if err == nil || !errors.As(err, &apiErr) {
+		t.Fatalf("expected joined provider errors, got %v", err)
+	}
+}
+
+func TestIsFailoverRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&anthropicsdk.Error{StatusCode: http.StatusTooManyRequests}, true},
+		{&anthropicsdk.Error{StatusCode: 529}, true},
+		{&anthropicsdk.Error{StatusCode: http.StatusUnauthorized}, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, true},
+		{errors.New("stream error: overloaded_error"), true},
+		{errors.New("invalid request"), false},
+	}
+	for _, tc := range cases {
+		if got := IsFailoverRetryable(tc.err); got != tc.want {
+			t.Fatalf("IsFailoverRetryable(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,765 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GeminiConfig configures the Gemini-backed Model.
+type GeminiConfig struct {
+	APIKey      string
+	BaseURL     string // Optional: defaults to the public v1beta endpoint
+	Model       string // e.g., "gemini-2.5-flash", "gemini-2.5-pro"
+	MaxTokens   int
+	MaxRetries  int
+	System      string
+	Temperature *float64
+	HTTPClient  *http.Client
+
+	// APIKeyHelper, when set, supplies the key of every request in place
+	// of APIKey, which may then be empty.
+	APIKeyHelper *APIKeyHelper
+}
+
+type geminiModel struct {
+	client      *http.Client
+	baseURL     string
+	apiKey      string
+	model       string
+	maxTokens   int
+	maxRetries  int
+	system      string
+	temperature *float64
+}
+
+const (
+	defaultGeminiBaseURL    = "https://generativelanguage.googleapis.com/v1beta"
+	defaultGeminiModel      = "gemini-2.5-flash"
+	defaultGeminiMaxTokens  = 4096
+	defaultGeminiMaxRetries = 10
+)
+
+// GeminiError is a non-2xx reply of the Gemini API.
+type GeminiError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *GeminiError) Error() string {
+	if e.Status != "" {
+		return fmt.Sprintf("gemini: %d %s: %s", e.StatusCode, e.Status, e.Message)
+	}
+	return fmt.Sprintf("gemini: %d: %s", e.StatusCode, e.Message)
+}
+
+// NewGemini constructs a Model backed by the Gemini generateContent API.
+func NewGemini(cfg GeminiConfig) (Model, error) {
+	apiKey := strings.TrimSpace(cfg.APIKey)
+	if apiKey == "" && cfg.APIKeyHelper == nil {
+		return nil, errors.New("gemini: api key required")
+	}
+	baseURL := strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultGeminiBaseURL
+	}
+	client := withAPIKeyHelper(cfg.HTTPClient, cfg.APIKeyHelper, "gemini", func(h http.Header, key string) {
+		h.Set("x-goog-api-key", key)
+	})
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxTokens := cfg.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultGeminiMaxTokens
+	}
+	retries := cfg.MaxRetries
+	if retries <= 0 {
+		retries = defaultGeminiMaxRetries
+	}
+	modelName := strings.TrimSpace(cfg.Model)
+	if modelName == "" {
+		modelName = defaultGeminiModel
+	}
+	return &geminiModel{
+		client:      client,
+		baseURL:     baseURL,
+		apiKey:      apiKey,
+		model:       modelName,
+		maxTokens:   maxTokens,
+		maxRetries:  retries,
+		system:      strings.TrimSpace(cfg.System),
+		temperature: cfg.Temperature,
+	}, nil
+}
+
+// Complete issues a non-streaming generateContent call.
+func (m *geminiModel) Complete(ctx context.Context, req Request) (*Response, error) {
+	body, err := m.buildRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	recordModelRequest(ctx, req)
+	var resp *Response
+	err = m.doWithRetry(ctx, func(ctx context.Context) error {
+		httpResp, err := m.post(ctx, m.selectModel(req.Model), "generateContent", body)
+		if err != nil {
+			return err
+		}
+		defer httpResp.Body.Close()
+
+		var payload geminiResponse
+		if err := json.NewDecoder(httpResp.Body).Decode(&payload); err != nil {
+			return fmt.Errorf("gemini: decode response: %w", err)
+		}
+		acc := &geminiAccumulator{}
+		if err := acc.add(payload, nil); err != nil {
+			return err
+		}
+		resp = acc.response()
+		recordModelResponse(ctx, resp)
+		return nil
+	})
+	return resp, err
+}
+
+// CompleteStream issues a streamGenerateContent call, forwarding deltas to cb.
+func (m *geminiModel) CompleteStream(ctx context.Context, req Request, cb StreamHandler) error {
+	if cb == nil {
+		return errors.New("stream callback required")
+	}
+	body, err := m.buildRequest(req)
+	if err != nil {
+		return err
+	}
+
+	recordModelRequest(ctx, req)
+
+	return m.doWithRetry(ctx, func(ctx context.Context) error {
+		httpResp, err := m.post(ctx, m.selectModel(req.Model), "streamGenerateContent?alt=sse", body)
+		if err != nil {
+			return err
+		}
+		defer httpResp.Body.Close()
+
+		acc := &geminiAccumulator{}
+		scanner := bufio.NewScanner(httpResp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+			var chunk geminiResponse
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err != nil {
+				return fmt.Errorf("gemini: decode stream chunk: %w", err)
+			}
+			if err := acc.add(chunk, cb); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		resp := acc.response()
+		for i := range resp.Message.ToolCalls {
+			if err := cb(StreamResult{ToolCall: &resp.Message.ToolCalls[i]}); err != nil {
+				return err
+			}
+		}
+		recordModelResponse(ctx, resp)
+		return cb(StreamResult{Final: true, Response: resp})
+	})
+}
+
+// post calls method of modelName and returns the response of a 2xx reply.
+func (m *geminiModel) post(ctx context.Context, modelName, method string, body []byte) (*http.Response, error) {
+	endpoint := m.baseURL + "/models/" + url.PathEscape(strings.TrimPrefix(modelName, "models/")) + ":" + method
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-goog-api-key", m.apiKey)
+	httpResp, err := m.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode/100 != 2 {
+		defer httpResp.Body.Close()
+		return nil, decodeGeminiError(httpResp)
+	}
+	return httpResp, nil
+}
+
+func decodeGeminiError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024)) //nolint:errcheck // best-effort error body
+	apiErr := &GeminiError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(raw))}
+	var payload struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(raw, &payload) == nil && payload.Error.Message != "" {
+		apiErr.Message = payload.Error.Message
+		apiErr.Status = payload.Error.Status
+	}
+	return apiErr
+}
+
+func (m *geminiModel) doWithRetry(ctx context.Context, fn func(context.Context) error) error {
+	attempts := 0
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !isGeminiRetryable(err) || attempts >= m.maxRetries {
+			return err
+		}
+		attempts++
+		backoff := time.Duration(attempts*attempts) * 100 * time.Millisecond
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func isGeminiRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var credErr *credentialError
+	if errors.As(err, &credErr) {
+		return false
+	}
+	var apiErr *GeminiError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return true
+		}
+		//nolint:staticcheck // Temporary is deprecated but retained for transient errors
+		return netErr.Temporary()
+	}
+	return true
+}
+
+func (m *geminiModel) selectModel(override string) string {
+	if trimmed := strings.TrimSpace(override); trimmed != "" {
+		return trimmed
+	}
+	return m.model
+}
+
+// Wire types of the generateContent REST API.
+type (
+	geminiRequest struct {
+		Contents          []geminiContent        `json:"contents"`
+		SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+		Tools             []geminiTool           `json:"tools,omitempty"`
+		ToolConfig        *geminiToolConfig      `json:"toolConfig,omitempty"`
+		GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+	}
+	geminiContent struct {
+		Role  string       `json:"role,omitempty"`
+		Parts []geminiPart `json:"parts"`
+	}
+	geminiPart struct {
+		Text             string                  `json:"text,omitempty"`
+		Thought          bool                    `json:"thought,omitempty"`
+		ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
+		InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+		FileData         *geminiFileData         `json:"fileData,omitempty"`
+		FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+		FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+	}
+	geminiBlob struct {
+		MimeType string `json:"mimeType"`
+		Data     string `json:"data"`
+	}
+	geminiFileData struct {
+		MimeType string `json:"mimeType,omitempty"`
+		FileURI  string `json:"fileUri"`
+	}
+	geminiFunctionCall struct {
+		ID   string         `json:"id,omitempty"`
+		Name string         `json:"name"`
+		Args map[string]any `json:"args,omitempty"`
+	}
+	geminiFunctionResponse struct {
+		ID       string         `json:"id,omitempty"`
+		Name     string         `json:"name"`
+		Response map[string]any `json:"response"`
+	}
+	geminiTool struct {
+		FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+	}
+	geminiFunctionDeclaration struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Parameters  map[string]any `json:"parameters,omitempty"`
+	}
+	geminiToolConfig struct {
+		FunctionCallingConfig geminiFunctionCallingConfig `json:"functionCallingConfig"`
+	}
+	geminiFunctionCallingConfig struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	}
+	geminiGenerationConfig struct {
+		MaxOutputTokens int                   `json:"maxOutputTokens,omitempty"`
+		Temperature     *float64              `json:"temperature,omitempty"`
+		TopP            *float64              `json:"topP,omitempty"`
+		TopK            *int                  `json:"topK,omitempty"`
+		StopSequences   []string              `json:"stopSequences,omitempty"`
+		ThinkingConfig  *geminiThinkingConfig `json:"thinkingConfig,omitempty"`
+	}
+	geminiThinkingConfig struct {
+		IncludeThoughts bool `json:"includeThoughts,omitempty"`
+		ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+	}
+
+	geminiResponse struct {
+		Candidates     []geminiCandidate `json:"candidates"`
+		UsageMetadata  *geminiUsage      `json:"usageMetadata"`
+		ModelVersion   string            `json:"modelVersion"`
+		PromptFeedback *struct {
+			BlockReason string `json:"blockReason"`
+		} `json:"promptFeedback"`
+	}
+	geminiCandidate struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	}
+	geminiUsage struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+		TotalTokenCount         int `json:"totalTokenCount"`
+	}
+)
+
+func (m *geminiModel) buildRequest(req Request) ([]byte, error) {
+	if err := req.ToolChoice.Validate(); err != nil {
+		return nil, err
+	}
+	contents, err := convertMessagesToGemini(req.Messages)
+	if err != nil {
+		return nil, err
+	}
+	body := geminiRequest{Contents: contents}
+
+	if texts := req.systemTexts(m.system); len(texts) > 0 {
+		body.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: strings.Join(texts, "\n\n")}}}
+	}
+
+	if len(req.Tools) > 0 {
+		body.Tools = []geminiTool{{FunctionDeclarations: convertToolsToGemini(req.Tools)}}
+		if choice := req.ToolChoice; choice != nil {
+			// Gemini has no switch for parallel calls.
+			cfg := geminiFunctionCallingConfig{Mode: "AUTO"}
+			switch choice.Type {
+			case ToolChoiceAny:
+				cfg.Mode = "ANY"
+			case ToolChoiceNone:
+				cfg.Mode = "NONE"
+			case ToolChoiceTool:
+				cfg = geminiFunctionCallingConfig{Mode: "ANY", AllowedFunctionNames: []string{choice.Name}}
+			}
+			body.ToolConfig = &geminiToolConfig{FunctionCallingConfig: cfg}
+		}
+	}
+
+	gen := &body.GenerationConfig
+	gen.MaxOutputTokens = req.MaxTokens
+	if gen.MaxOutputTokens <= 0 {
+		gen.MaxOutputTokens = m.maxTokens
+	}
+	gen.Temperature = m.temperature
+	if req.Temperature != nil {
+		gen.Temperature = req.Temperature
+	}
+	gen.TopP = req.TopP
+	gen.TopK = req.TopK
+	gen.StopSequences = req.StopSequences
+	if t := req.Thinking; t != nil && t.Enabled {
+		gen.ThinkingConfig = &geminiThinkingConfig{IncludeThoughts: true}
+		if t.BudgetTokens > 0 {
+			budget := t.BudgetTokens
+			gen.ThinkingConfig.ThinkingBudget = &budget
+		}
+	}
+
+	return json.Marshal(body)
+}
+
+func convertMessagesToGemini(msgs []Message) ([]geminiContent, error) {
+	var contents []geminiContent
+	appendParts := func(role string, parts ...geminiPart) {
+		if len(parts) == 0 {
+			return
+		}
+		// Gemini expects alternating turns; merge consecutive ones.
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+			return
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: parts})
+	}
+
+	for _, msg := range msgs {
+		switch strings.ToLower(strings.TrimSpace(msg.Role)) {
+		case "system":
+			// Mid-conversation system notes travel as user text; the
+			// system instruction is fixed per request.
+			if text := strings.TrimSpace(msg.Content); text != "" {
+				appendParts("user", geminiPart{Text: text})
+			}
+		case "assistant":
+			appendParts("model", buildGeminiModelParts(msg)...)
+		case "tool":
+			appendParts("user", buildGeminiFunctionResponses(msg)...)
+		default:
+			parts, err := buildGeminiUserParts(msg)
+			if err != nil {
+				return nil, err
+			}
+			appendParts("user", parts...)
+		}
+	}
+	if len(contents) == 0 || contents[0].Role != "user" {
+		contents = append([]geminiContent{{Role: "user", Parts: []geminiPart{{Text: "."}}}}, contents...)
+	}
+	return contents, nil
+}
+
+func buildGeminiUserParts(msg Message) ([]geminiPart, error) {
+	var parts []geminiPart
+	if text := strings.TrimSpace(msg.Content); text != "" {
+		parts = append(parts, geminiPart{Text: text})
+	}
+	for _, block := range msg.ContentBlocks {
+		switch block.Type {
+		case ContentBlockText:
+			if text := strings.TrimSpace(block.Text); text != "" {
+				parts = append(parts, geminiPart{Text: text})
+			}
+		case ContentBlockImage, ContentBlockDocument:
+			part, err := geminiMediaPart(block)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, geminiPart{Text: "."})
+	}
+	return parts, nil
+}
+
+// geminiMediaPart sends base64 data inline and URLs as file references.
+func geminiMediaPart(block ContentBlock) (geminiPart, error) {
+	mediaType := strings.TrimSpace(block.MediaType)
+	if mediaType == "" {
+		mediaType = "image/jpeg"
+		if block.Type == ContentBlockDocument {
+			mediaType = "application/pdf"
+		}
+	}
+	if data := strings.TrimSpace(block.Data); data != "" {
+		return geminiPart{InlineData: &geminiBlob{MimeType: mediaType, Data: data}}, nil
+	}
+	if uri := strings.TrimSpace(block.URL); uri != "" {
+		return geminiPart{FileData: &geminiFileData{MimeType: mediaType, FileURI: uri}}, nil
+	}
+	return geminiPart{}, fmt.Errorf("gemini: %s block has neither data nor url", block.Type)
+}
+
+// buildGeminiModelParts replays an assistant turn. Thought summaries go back
+// with their signatures; signatures Gemini attached to other parts are kept
+// as ThinkingBlocks without text and return on the function calls, in
+// order, or on the text when there are none.
+func buildGeminiModelParts(msg Message) []geminiPart {
+	var parts []geminiPart
+	var signatures []string
+	for _, block := range msg.ThinkingBlocks {
+		switch {
+		case block.Text != "":
+			parts = append(parts, geminiPart{Text: block.Text, Thought: true, ThoughtSignature: block.Signature})
+		case block.Signature != "":
+			signatures = append(signatures, block.Signature)
+		}
+	}
+	nextSignature := func() string {
+		if len(signatures) == 0 {
+			return ""
+		}
+		sig := signatures[0]
+		signatures = signatures[1:]
+		return sig
+	}
+
+	text := strings.TrimSpace(msg.Content)
+	var textSignature string
+	if len(msg.ToolCalls) == 0 {
+		textSignature = nextSignature()
+	}
+	if text != "" {
+		parts = append(parts, geminiPart{Text: text, ThoughtSignature: textSignature})
+	}
+	for _, call := range msg.ToolCalls {
+		name := strings.TrimSpace(call.Name)
+		if name == "" {
+			continue
+		}
+		parts = append(parts, geminiPart{
+			FunctionCall:     &geminiFunctionCall{ID: call.ID, Name: name, Args: call.Arguments},
+			ThoughtSignature: nextSignature(),
+		})
+	}
+	if len(parts) == 0 {
+		parts = append(parts, geminiPart{Text: "."})
+	}
+	return parts
+}
+
+func buildGeminiFunctionResponses(msg Message) []geminiPart {
+	if len(msg.ToolCalls) == 0 {
+		return []geminiPart{{Text: msg.Content}}
+	}
+	parts := make([]geminiPart, 0, len(msg.ToolCalls))
+	for _, call := range msg.ToolCalls {
+		result := call.Result
+		if strings.TrimSpace(result) == "" {
+			result = msg.Content
+		}
+		parts = append(parts, geminiPart{FunctionResponse: &geminiFunctionResponse{
+			ID:       call.ID,
+			Name:     call.Name,
+			Response: geminiFunctionResult(result),
+		}})
+	}
+	return parts
+}
+
+// geminiFunctionResult wraps a tool result in the object Gemini requires.
+func geminiFunctionResult(result string) map[string]any {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(result), &obj); err == nil && obj != nil {
+		return obj
+	}
+	return map[string]any{"output": result}
+}
+
+func convertToolsToGemini(tools []ToolDefinition) []geminiFunctionDeclaration {
+	decls := make([]geminiFunctionDeclaration, 0, len(tools))
+	for _, def := range tools {
+		name := strings.TrimSpace(def.Name)
+		if name == "" {
+			continue
+		}
+		decl := geminiFunctionDeclaration{Name: name, Description: strings.TrimSpace(def.Description)}
+		if len(def.Parameters) > 0 {
+			decl.Parameters = geminiSchema(def.Parameters)
+		}
+		decls = append(decls, decl)
+	}
+	return decls
+}
+
+// geminiSchemaKeys is the subset of OpenAPI schema fields Gemini accepts in
+// function declarations; it rejects the rest, such as $schema and
+// additionalProperties.
+var geminiSchemaKeys = map[string]bool{
+	"type": true, "format": true, "title": true, "description": true, "nullable": true,
+	"enum": true, "items": true, "minItems": true, "maxItems": true, "properties": true,
+	"required": true, "minProperties": true, "maxProperties": true, "propertyOrdering": true,
+	"minLength": true, "maxLength": true, "pattern": true, "minimum": true, "maximum": true,
+	"anyOf": true, "default": true, "example": true,
+}
+
+// geminiSchema converts a JSON schema into the subset Gemini accepts. Type
+// lists such as ["string", "null"] become a nullable single type.
+func geminiSchema(schema map[string]any) map[string]any {
+	out := make(map[string]any, len(schema))
+	for key, value := range schema {
+		if !geminiSchemaKeys[key] {
+			continue
+		}
+		switch key {
+		case "type":
+			if list, ok := value.([]any); ok {
+				for _, item := range list {
+					if s, _ := item.(string); s == "null" {
+						out["nullable"] = true
+					} else if s != "" {
+						out["type"] = s
+					}
+				}
+				continue
+			}
+		case "properties":
+			if props, ok := value.(map[string]any); ok {
+				converted := make(map[string]any, len(props))
+				for name, prop := range props {
+					if sub, ok := prop.(map[string]any); ok {
+						converted[name] = geminiSchema(sub)
+					}
+				}
+				value = converted
+			}
+		case "items":
+			if sub, ok := value.(map[string]any); ok {
+				value = geminiSchema(sub)
+			}
+		case "anyOf":
+			if list, ok := value.([]any); ok {
+				converted := make([]any, 0, len(list))
+				for _, item := range list {
+					if sub, ok := item.(map[string]any); ok {
+						converted = append(converted, geminiSchema(sub))
+					}
+				}
+				value = converted
+			}
+		}
+		out[key] = value
+	}
+	if _, ok := out["type"]; !ok && out["properties"] != nil {
+		out["type"] = "object"
+	}
+	return out
+}
+
+// geminiAccumulator assembles a Response from one reply or the chunks of a
+// stream.
+type geminiAccumulator struct {
+	content   strings.Builder
+	reasoning strings.Builder
+	thinking  []ThinkingBlock
+	calls     []ToolCall
+	usage     Usage
+	stop      string
+	model     string
+}
+
+// add folds chunk into the response, forwarding text and thought deltas to
+// cb when it is not nil.
+func (a *geminiAccumulator) add(chunk geminiResponse, cb StreamHandler) error {
+	if chunk.ModelVersion != "" {
+		a.model = chunk.ModelVersion
+	}
+	if u := chunk.UsageMetadata; u != nil {
+		a.usage = Usage{
+			InputTokens:     u.PromptTokenCount - u.CachedContentTokenCount,
+			OutputTokens:    u.CandidatesTokenCount + u.ThoughtsTokenCount,
+			TotalTokens:     u.TotalTokenCount,
+			CacheReadTokens: u.CachedContentTokenCount,
+		}
+	}
+	if fb := chunk.PromptFeedback; fb != nil && fb.BlockReason != "" && len(chunk.Candidates) == 0 {
+		return fmt.Errorf("gemini: prompt blocked: %s", fb.BlockReason)
+	}
+	if len(chunk.Candidates) == 0 {
+		return nil
+	}
+	candidate := chunk.Candidates[0]
+	if candidate.FinishReason != "" {
+		a.stop = candidate.FinishReason
+	}
+	for _, part := range candidate.Content.Parts {
+		switch {
+		case part.Thought:
+			a.reasoning.WriteString(part.Text)
+			a.addThought(part.Text, part.ThoughtSignature)
+			if cb != nil && part.Text != "" {
+				if err := cb(StreamResult{Thinking: part.Text}); err != nil {
+					return err
+				}
+			}
+		case part.FunctionCall != nil:
+			id := part.FunctionCall.ID
+			if id == "" {
+				id = "call_" + uuid.NewString()
+			}
+			call := ToolCall{ID: id, Name: part.FunctionCall.Name, Arguments: part.FunctionCall.Args}
+			a.calls = append(a.calls, call)
+			if part.ThoughtSignature != "" {
+				a.thinking = append(a.thinking, ThinkingBlock{Signature: part.ThoughtSignature})
+			}
+			if cb != nil {
+				args, _ := json.Marshal(call.Arguments) //nolint:errcheck // decoded from JSON
+				// Tool calls follow the text block, so shift their
+				// indices past it.
+				if err := cb(StreamResult{ToolInputDelta: string(args), ToolCallID: call.ID, ToolCallName: call.Name, Index: len(a.calls)}); err != nil {
+					return err
+				}
+			}
+		default:
+			if part.ThoughtSignature != "" {
+				a.thinking = append(a.thinking, ThinkingBlock{Signature: part.ThoughtSignature})
+			}
+			if part.Text == "" {
+				continue
+			}
+			a.content.WriteString(part.Text)
+			if cb != nil {
+				if err := cb(StreamResult{Delta: part.Text}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// addThought extends the streamed thought in progress or starts a new one
+// once the previous one was signed.
+func (a *geminiAccumulator) addThought(text, signature string) {
+	if n := len(a.thinking); n > 0 && a.thinking[n-1].Text != "" && a.thinking[n-1].Signature == "" {
+		a.thinking[n-1].Text += text
+		a.thinking[n-1].Signature = signature
+		return
+	}
+	a.thinking = append(a.thinking, ThinkingBlock{Text: text, Signature: signature})
+}
+
+func (a *geminiAccumulator) response() *Response {
+	return &Response{
+		Message: Message{
+			Role:             "assistant",
+			Content:          a.content.String(),
+			ToolCalls:        a.calls,
+			ReasoningContent: a.reasoning.String(),
+			ThinkingBlocks:   a.thinking,
+		},
+		Usage:      a.usage,
+		StopReason: a.stop,
+		Model:      a.model,
+	}
+}
@@ -0,0 +1,288 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// geminiReplay serves a recorded payload for every request and keeps the
+// last request body.
+type geminiReplay struct {
+	t       *testing.T
+	status  int
+	fixture string
+	path    string
+	body    map[string]any
+	calls   atomic.Int32
+}
+
+func (r *geminiReplay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.calls.Add(1)
+	assert.Equal(r.t, "test-key", req.Header.Get("x-goog-api-key"))
+	r.path = req.URL.Path + "?" + req.URL.RawQuery
+	raw, err := io.ReadAll(req.Body)
+	require.NoError(r.t, err)
+	require.NoError(r.t, json.Unmarshal(raw, &r.body))
+
+	data, err := os.ReadFile(filepath.Join("testdata", "gemini", r.fixture))
+	require.NoError(r.t, err)
+	if strings.HasSuffix(r.fixture, ".sse") {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+	}
+	_, _ = w.Write(data)
+}
+
+func newGeminiReplay(t *testing.T, fixture string) (*geminiReplay, Model) {
+	t.Helper()
+	replay := &geminiReplay{t: t, fixture: fixture}
+	srv := httptest.NewServer(replay)
+	t.Cleanup(srv.Close)
+	mdl, err := NewGemini(GeminiConfig{APIKey: "test-key", BaseURL: srv.URL + "/v1beta/", MaxRetries: 1, System: "Be brief."})
+	require.NoError(t, err)
+	return replay, mdl
+}
+
+func TestGeminiCompleteToolCall(t *testing.T) {
+	replay, mdl := newGeminiReplay(t, "generate_tool_call.json")
+	temp, topK := 0.2, 20
+	resp, err := mdl.Complete(context.Background(), Request{
+		System:       "You are a weather bot.",
+		SystemBlocks: []SystemBlock{{Text: "Use metric units."}},
+		Messages: []Message{{
+			Role:          "user",
+			Content:       "Weather in Paris?",
+			ContentBlocks: []ContentBlock{{Type: ContentBlockImage, MediaType: "image/png", Data: "aGk="}, {Type: ContentBlockDocument, URL: "gs://bucket/report.pdf"}},
+		}},
+		Tools: []ToolDefinition{{
+			Name:        "get_weather",
+			Description: "Current weather",
+			Parameters: map[string]any{
+				"$schema":              "https://json-schema.org/draft/2020-12/schema",
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]any{
+					"city": map[string]any{"type": "string"},
+					"unit": map[string]any{"type": []any{"string", "null"}, "enum": []any{"celsius", "fahrenheit"}},
+				},
+				"required": []any{"city"},
+			},
+		}},
+		ToolChoice:    &ToolChoice{Type: ToolChoiceTool, Name: "get_weather"},
+		Temperature:   &temp,
+		TopK:          &topK,
+		StopSequences: []string{"END"},
+		Thinking:      &ThinkingConfig{Enabled: true, BudgetTokens: 1024},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "/v1beta/models/gemini-2.5-flash:generateContent?", replay.path)
+	assert.Equal(t, map[string]any{"parts": []any{map[string]any{"text": "Be brief.\n\nYou are a weather bot.\n\nUse metric units."}}}, replay.body["systemInstruction"])
+	assert.Equal(t, []any{map[string]any{"role": "user", "parts": []any{
+		map[string]any{"text": "Weather in Paris?"},
+		map[string]any{"inlineData": map[string]any{"mimeType": "image/png", "data": "aGk="}},
+		map[string]any{"fileData": map[string]any{"mimeType": "application/pdf", "fileUri": "gs://bucket/report.pdf"}},
+	}}}, replay.body["contents"])
+	assert.Equal(t, []any{map[string]any{"functionDeclarations": []any{map[string]any{
+		"name":        "get_weather",
+		"description": "Current weather",
+		"parameters": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"city": map[string]any{"type": "string"},
+				"unit": map[string]any{"type": "string", "nullable": true, "enum": []any{"celsius", "fahrenheit"}},
+			},
+			"required": []any{"city"},
+		},
+	}}}}, replay.body["tools"])
+	assert.Equal(t, map[string]any{"functionCallingConfig": map[string]any{"mode": "ANY", "allowedFunctionNames": []any{"get_weather"}}}, replay.body["toolConfig"])
+	assert.Equal(t, map[string]any{
+		"maxOutputTokens": float64(4096),
+		"temperature":     0.2,
+		"topK":            float64(20),
+		"stopSequences":   []any{"END"},
+		"thinkingConfig":  map[string]any{"includeThoughts": true, "thinkingBudget": float64(1024)},
+	}, replay.body["generationConfig"])
+
+	msg := resp.Message
+	assert.Equal(t, "Let me look that up.", msg.Content)
+	assert.Contains(t, msg.ReasoningContent, "Checking the weather")
+	require.Len(t, msg.ToolCalls, 1)
+	assert.Equal(t, "get_weather", msg.ToolCalls[0].Name)
+	assert.Equal(t, map[string]any{"city": "Paris", "unit": "celsius"}, msg.ToolCalls[0].Arguments)
+	assert.True(t, strings.HasPrefix(msg.ToolCalls[0].ID, "call_"))
+	assert.Equal(t, []ThinkingBlock{{Text: msg.ReasoningContent}, {Signature: "CiQBVKhc7vB0bOGSpXl1ZqSdQ8Zw"}}, msg.ThinkingBlocks)
+	assert.Equal(t, Usage{InputTokens: 118, OutputTokens: 99, TotalTokens: 281, CacheReadTokens: 64}, resp.Usage)
+	assert.Equal(t, "STOP", resp.StopReason)
+	assert.Equal(t, "gemini-2.5-flash", resp.Model)
+}
+
+func TestGeminiReplaysToolTurn(t *testing.T) {
+	replay, mdl := newGeminiReplay(t, "generate_tool_call.json")
+	_, err := mdl.Complete(context.Background(), Request{Messages: []Message{
+		{Role: "user", Content: "Weather?"},
+		{
+			Role:           "assistant",
+			Content:        "Checking.",
+			ToolCalls:      []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: map[string]any{"city": "Paris"}}},
+			ThinkingBlocks: []ThinkingBlock{{Text: "plan"}, {Signature: "sig-call"}},
+		},
+		{Role: "tool", ToolCalls: []ToolCall{{ID: "call_1", Name: "get_weather", Result: `{"temp":21}`}}},
+		{Role: "tool", ToolCalls: []ToolCall{{ID: "call_2", Name: "get_time", Result: "noon"}}},
+		{Role: "system", Content: "[2 earlier messages omitted]"},
+	}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []any{
+		map[string]any{"role": "user", "parts": []any{map[string]any{"text": "Weather?"}}},
+		map[string]any{"role": "model", "parts": []any{
+			map[string]any{"text": "plan", "thought": true},
+			map[string]any{"text": "Checking."},
+			map[string]any{"functionCall": map[string]any{"id": "call_1", "name": "get_weather", "args": map[string]any{"city": "Paris"}}, "thoughtSignature": "sig-call"},
+		}},
+		map[string]any{"role": "user", "parts": []any{
+			map[string]any{"functionResponse": map[string]any{"id": "call_1", "name": "get_weather", "response": map[string]any{"temp": float64(21)}}},
+			map[string]any{"functionResponse": map[string]any{"id": "call_2", "name": "get_time", "response": map[string]any{"output": "noon"}}},
+			map[string]any{"text": "[2 earlier messages omitted]"},
+		}},
+	}, replay.body["contents"])
+	assert.Nil(t, replay.body["systemInstruction"].(map[string]any)["role"])
+}
+
+func TestGeminiStreamText(t *testing.T) {
+	replay, mdl := newGeminiReplay(t, "stream_text.sse")
+	var deltas, thinking []string
+	var final *Response
+	err := mdl.CompleteStream(context.Background(), Request{
+		Model:    "gemini-2.5-pro",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+	}, func(sr StreamResult) error {
+		if sr.Delta != "" {
+			deltas = append(deltas, sr.Delta)
+		}
+		if sr.Thinking != "" {
+			thinking = append(thinking, sr.Thinking)
+		}
+		if sr.Final {
+			final = sr.Response
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse", replay.path)
+	assert.Equal(t, []string{"Hello", " there! How can I help?"}, deltas)
+	assert.Equal(t, []string{"The user greets me; reply briefly."}, thinking)
+	require.NotNil(t, final)
+	assert.Equal(t, "Hello there! How can I help?", final.Message.Content)
+	assert.Equal(t, []ThinkingBlock{{Text: "The user greets me; reply briefly."}, {Signature: "CiIBVKhc7tq1T2yYm3Q"}}, final.Message.ThinkingBlocks)
+	assert.Equal(t, Usage{InputTokens: 9, OutputTokens: 29, TotalTokens: 38}, final.Usage)
+	assert.Equal(t, "STOP", final.StopReason)
+}
+
+func TestGeminiStreamToolCalls(t *testing.T) {
+	_, mdl := newGeminiReplay(t, "stream_tool_call.sse")
+	var inputs []StreamResult
+	var calls []ToolCall
+	var final *Response
+	err := mdl.CompleteStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, func(sr StreamResult) error {
+		switch {
+		case sr.ToolInputDelta != "":
+			inputs = append(inputs, sr)
+		case sr.ToolCall != nil:
+			calls = append(calls, *sr.ToolCall)
+		case sr.Final:
+			final = sr.Response
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Len(t, inputs, 2)
+	assert.Equal(t, `{"city":"Paris"}`, inputs[0].ToolInputDelta)
+	assert.Equal(t, "get_time", inputs[1].ToolCallName)
+	assert.Equal(t, 2, inputs[1].Index)
+	require.Len(t, calls, 2)
+	assert.Equal(t, inputs[0].ToolCallID, calls[0].ID)
+	assert.NotEqual(t, calls[0].ID, calls[1].ID)
+	require.NotNil(t, final)
+	assert.Equal(t, []ThinkingBlock{{Signature: "CiQBVKhc7lW2"}}, final.Message.ThinkingBlocks)
+	assert.Equal(t, "gemini-2.5-pro", final.Model)
+
+	parts := buildGeminiModelParts(final.Message)
+	require.Len(t, parts, 2)
+	assert.Equal(t, "CiQBVKhc7lW2", parts[0].ThoughtSignature)
+	assert.Empty(t, parts[1].ThoughtSignature)
+}
+
+func TestGeminiErrors(t *testing.T) {
+	replay, mdl := newGeminiReplay(t, "error_invalid_argument.json")
+	replay.status = http.StatusBadRequest
+	_, err := mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	var apiErr *GeminiError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "INVALID_ARGUMENT", apiErr.Status)
+	assert.Contains(t, apiErr.Message, "additionalProperties")
+	assert.EqualValues(t, 1, replay.calls.Load(), "client errors are not retried")
+
+	replay.status = http.StatusServiceUnavailable
+	replay.calls.Store(0)
+	err = mdl.CompleteStream(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, func(StreamResult) error { return nil })
+	require.ErrorAs(t, err, &apiErr)
+	assert.EqualValues(t, 2, replay.calls.Load(), "server errors are retried")
+
+	_, err = mdl.Complete(context.Background(), Request{
+		Messages:   []Message{{Role: "user", Content: "hi"}},
+		Tools:      []ToolDefinition{{Name: "x"}},
+		ToolChoice: &ToolChoice{Type: ToolChoiceTool},
+	})
+	require.Error(t, err)
+	_, err = mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", ContentBlocks: []ContentBlock{{Type: ContentBlockImage}}}}})
+	require.ErrorContains(t, err, "neither data nor url")
+
+	_, err = NewGemini(GeminiConfig{})
+	require.Error(t, err)
+}
+
+func TestGeminiProvider(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "")
+	t.Setenv("GOOGLE_API_KEY", "google-key")
+	assert.Equal(t, "google-key", (&GeminiProvider{}).resolveAPIKey())
+	t.Setenv("GEMINI_API_KEY", "gemini-key")
+	assert.Equal(t, "gemini-key", (&GeminiProvider{}).resolveAPIKey())
+	assert.Equal(t, "explicit", (&GeminiProvider{APIKey: "explicit"}).resolveAPIKey())
+
+	p := &GeminiProvider{CacheTTL: time.Hour}
+	mdl1, err := p.Model(context.Background())
+	require.NoError(t, err)
+	mdl2, err := p.Model(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, mdl1, mdl2)
+
+	gm, ok := mdl1.(*geminiModel)
+	require.True(t, ok)
+	assert.Equal(t, defaultGeminiModel, gm.model)
+	assert.Equal(t, defaultGeminiBaseURL, gm.baseURL)
+
+	t.Setenv("GEMINI_API_KEY", "")
+	t.Setenv("GOOGLE_API_KEY", "")
+	_, err = (&GeminiProvider{}).Model(context.Background())
+	require.Error(t, err)
+}
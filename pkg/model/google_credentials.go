@@ -0,0 +1,268 @@
+package model
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	googleCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+	defaultGoogleTokenURL    = "https://oauth2.googleapis.com/token"
+	defaultGCEMetadataHost   = "169.254.169.254"
+)
+
+// googleCredentials is a Google credentials JSON file: a service account
+// key or the authorized_user file written by `gcloud auth
+// application-default login`.
+type googleCredentials struct {
+	Type           string `json:"type"`
+	ProjectID      string `json:"project_id"`
+	QuotaProjectID string `json:"quota_project_id"`
+	ClientEmail    string `json:"client_email"`
+	PrivateKeyID   string `json:"private_key_id"`
+	PrivateKey     string `json:"private_key"`
+	TokenURI       string `json:"token_uri"`
+	ClientID       string `json:"client_id"`
+	ClientSecret   string `json:"client_secret"`
+	RefreshToken   string `json:"refresh_token"`
+}
+
+// loadGoogleCredentials resolves Application Default Credentials: data, then
+// file, then GOOGLE_APPLICATION_CREDENTIALS, then the gcloud well-known file.
+// It returns nil when none exist, leaving the GCE metadata server.
+func loadGoogleCredentials(data []byte, file string) (*googleCredentials, error) {
+	if len(data) == 0 {
+		path := strings.TrimSpace(file)
+		if path == "" {
+			path = strings.TrimSpace(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+		}
+		if path == "" {
+			path = gcloudWellKnownFile()
+			if _, err := os.Stat(path); path == "" || err != nil {
+				return nil, nil
+			}
+		}
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("read google credentials: %w", err)
+		}
+	}
+	var creds googleCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("decode google credentials: %w", err)
+	}
+	switch creds.Type {
+	case "service_account":
+		if creds.ClientEmail == "" || creds.PrivateKey == "" {
+			return nil, errors.New("google service account requires client_email and private_key")
+		}
+	case "authorized_user":
+		if creds.RefreshToken == "" {
+			return nil, errors.New("google authorized user requires refresh_token")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported google credentials type %q", creds.Type)
+	}
+	return &creds, nil
+}
+
+func gcloudWellKnownFile() string {
+	if dir := strings.TrimSpace(os.Getenv("CLOUDSDK_CONFIG")); dir != "" {
+		return filepath.Join(dir, "application_default_credentials.json")
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud", "application_default_credentials.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// googleTokenSource exchanges credentials for OAuth access tokens and caches
+// them until shortly before they expire.
+type googleTokenSource struct {
+	creds  *googleCredentials // nil uses the GCE metadata server
+	client *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func (s *googleTokenSource) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.expiry.Add(-time.Minute)) {
+		return s.token, nil
+	}
+
+	var (
+		req *http.Request
+		err error
+	)
+	switch {
+	case s.creds == nil:
+		req, err = s.metadataRequest(ctx)
+	case s.creds.Type == "service_account":
+		req, err = s.serviceAccountRequest(ctx)
+	default:
+		req, err = s.refreshTokenRequest(ctx)
+	}
+	if err != nil {
+		return "", &credentialError{platform: "vertex", err: err}
+	}
+	token, expiry, err := s.exchange(req)
+	if err != nil {
+		return "", &credentialError{platform: "vertex", err: err}
+	}
+	s.token, s.expiry = token, expiry
+	return token, nil
+}
+
+// serviceAccountRequest builds an RS256 JWT bearer grant.
+// invalidate drops the cached token if it is still the one that was
+// rejected, so a token fetched meanwhile by another request survives.
+func (s *googleTokenSource) invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+func (s *googleTokenSource) serviceAccountRequest(ctx context.Context) (*http.Request, error) {
+	key, err := parseRSAPrivateKey(s.creds.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	tokenURL := s.tokenURL()
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.creds.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   s.creds.ClientEmail,
+		"scope": googleCloudPlatformScope,
+		"aud":   tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("sign jwt: %w", err)
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	}
+	return newFormRequest(ctx, tokenURL, form)
+}
+
+func (s *googleTokenSource) refreshTokenRequest(ctx context.Context) (*http.Request, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {s.creds.ClientID},
+		"client_secret": {s.creds.ClientSecret},
+		"refresh_token": {s.creds.RefreshToken},
+	}
+	return newFormRequest(ctx, s.tokenURL(), form)
+}
+
+func (s *googleTokenSource) metadataRequest(ctx context.Context) (*http.Request, error) {
+	host := strings.TrimSpace(os.Getenv("GCE_METADATA_HOST"))
+	if host == "" {
+		host = defaultGCEMetadataHost
+	}
+	endpoint := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token?scopes=" + url.QueryEscape(googleCloudPlatformScope)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return req, nil
+}
+
+func (s *googleTokenSource) tokenURL() string {
+	if uri := strings.TrimSpace(s.creds.TokenURI); uri != "" {
+		return uri
+	}
+	return defaultGoogleTokenURL
+}
+
+func (s *googleTokenSource) exchange(req *http.Request) (string, time.Time, error) {
+	client := s.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("read token response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", time.Time{}, fmt.Errorf("token request: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", time.Time{}, fmt.Errorf("decode token response: %w", err)
+	}
+	if out.AccessToken == "" {
+		return "", time.Time{}, errors.New("token response has no access_token")
+	}
+	return out.AccessToken, time.Now().Add(time.Duration(out.ExpiresIn) * time.Second), nil
+}
+
+func newFormRequest(ctx context.Context, endpoint string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+func parseRSAPrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("private_key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private_key is not an RSA key")
+		}
+		return rsaKey, nil
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private_key: %w", err)
+	}
+	return key, nil
+}
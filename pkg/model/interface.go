@@ -2,6 +2,8 @@ package model
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
 )
 
@@ -36,6 +38,17 @@ type Message struct {
 	ContentBlocks    []ContentBlock // Multimodal content; takes precedence over Content when non-empty
 	ToolCalls        []ToolCall
 	ReasoningContent string // For thinking models (e.g. DeepSeek, Kimi k2.5)
+	// ThinkingBlocks keep the provider's signed reasoning so it can be sent
+	// back verbatim; ReasoningContent holds the same text for display.
+	ThinkingBlocks []ThinkingBlock
+}
+
+// ThinkingBlock is one block of extended thinking as the provider returned
+// it. Redacted thinking carries only its encrypted data.
+type ThinkingBlock struct {
+	Text      string
+	Signature string
+	Redacted  string
 }
 
 // TextContent returns the text portion of the message. When ContentBlocks
@@ -72,16 +85,176 @@ type ToolDefinition struct {
 	Parameters  map[string]any
 }
 
+// SystemBlock is one part of the system prompt. Cacheable marks text that
+// stays the same from request to request, so providers with prompt caching
+// can end the cached prefix after the last block of the leading cacheable
+// run.
+type SystemBlock struct {
+	Text      string
+	Cacheable bool
+}
+
 // Request drives a single model completion.
 type Request struct {
-	Messages          []Message
-	Tools             []ToolDefinition
-	System            string
+	Messages []Message
+	Tools    []ToolDefinition
+	System   string
+	// SystemBlocks follow System in the system prompt.
+	SystemBlocks      []SystemBlock
 	Model             string
 	SessionID         string
 	MaxTokens         int
 	Temperature       *float64
 	EnablePromptCache bool // Enable prompt caching for system and recent messages
+	// Thinking turns on extended thinking or sets the reasoning effort.
+	Thinking *ThinkingConfig
+	// Cache places prompt cache breakpoints explicitly instead of the
+	// automatic placement of EnablePromptCache. Providers without prompt
+	// caching ignore it.
+	Cache *CachePolicy
+	// TopP and TopK restrict sampling to the most likely tokens. OpenAI
+	// has no TopK and ignores it.
+	TopP *float64
+	TopK *int
+	// StopSequences end the completion when the model produces one. The
+	// OpenAI Responses API has no stop sequences and ignores them.
+	StopSequences []string
+	// ToolChoice constrains tool use; nil leaves it to the model. It only
+	// applies when Tools is not empty.
+	ToolChoice *ToolChoice
+	// UserID identifies the end user to the provider for abuse monitoring.
+	// Empty uses SessionID.
+	UserID string
+}
+
+// ToolChoiceType selects how the model may use tools.
+type ToolChoiceType string
+
+const (
+	// ToolChoiceAuto lets the model decide whether to call tools.
+	ToolChoiceAuto ToolChoiceType = "auto"
+	// ToolChoiceAny requires a call to one of the tools.
+	ToolChoiceAny ToolChoiceType = "any"
+	// ToolChoiceNone forbids tool calls.
+	ToolChoiceNone ToolChoiceType = "none"
+	// ToolChoiceTool requires a call to the tool named by ToolChoice.Name.
+	ToolChoiceTool ToolChoiceType = "tool"
+)
+
+// ToolChoice constrains how the model uses the request's tools.
+type ToolChoice struct {
+	// Type defaults to ToolChoiceAuto.
+	Type ToolChoiceType
+	Name string
+	// DisableParallelToolUse limits the model to one tool call per turn.
+	DisableParallelToolUse bool
+}
+
+// Forces reports whether the choice requires a tool call.
+func (c *ToolChoice) Forces() bool {
+	return c != nil && (c.Type == ToolChoiceAny || c.Type == ToolChoiceTool)
+}
+
+// Validate checks that the choice is well formed.
+func (c *ToolChoice) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Type {
+	case "", ToolChoiceAuto, ToolChoiceAny, ToolChoiceNone:
+		return nil
+	case ToolChoiceTool:
+		if strings.TrimSpace(c.Name) == "" {
+			return errors.New("tool choice: tool name is required")
+		}
+		return nil
+	}
+	return fmt.Errorf("tool choice: unsupported type %q", c.Type)
+}
+
+// endUser returns the end-user identifier sent to providers.
+func (r Request) endUser() string {
+	if user := strings.TrimSpace(r.UserID); user != "" {
+		return user
+	}
+	return strings.TrimSpace(r.SessionID)
+}
+
+// ReasoningEffort is the reasoning effort of OpenAI reasoning models.
+type ReasoningEffort string
+
+const (
+	ReasoningEffortMinimal ReasoningEffort = "minimal"
+	ReasoningEffortLow     ReasoningEffort = "low"
+	ReasoningEffortMedium  ReasoningEffort = "medium"
+	ReasoningEffortHigh    ReasoningEffort = "high"
+)
+
+// ThinkingConfig controls model reasoning. Anthropic models think when
+// Enabled, spending up to BudgetTokens; OpenAI reasoning models use Effort
+// and, when Enabled, stream a summary of their reasoning.
+type ThinkingConfig struct {
+	Enabled bool
+	// BudgetTokens defaults to 4096 and must be at least 1024. MaxTokens is
+	// raised above it when needed.
+	BudgetTokens int
+	Effort       ReasoningEffort
+}
+
+// CacheTTL is how long a prompt cache entry lives.
+type CacheTTL string
+
+const (
+	CacheTTL5m CacheTTL = "5m"
+	CacheTTL1h CacheTTL = "1h"
+)
+
+// CachePolicy chooses where prompt cache breakpoints go. Each breakpoint
+// caches the request up to and including the marked part, in the order
+// tools, system prompt, messages. Anthropic accepts at most four.
+type CachePolicy struct {
+	// AfterTools caches the tool definitions.
+	AfterTools bool
+	// AfterSystem caches the tools and the system prompt.
+	AfterSystem bool
+	// AfterMessages caches everything up to these indexes of
+	// Request.Messages; negative indexes count from the end, so -1 is the
+	// last message. System messages cannot be marked.
+	AfterMessages []int
+	// TTL defaults to CacheTTL5m. A policy without breakpoints keeps the
+	// automatic placement of EnablePromptCache with this TTL.
+	TTL CacheTTL
+}
+
+func (p *CachePolicy) explicit() bool {
+	return p != nil && (p.AfterTools || p.AfterSystem || len(p.AfterMessages) > 0)
+}
+
+func (p *CachePolicy) ttl() CacheTTL {
+	if p == nil {
+		return ""
+	}
+	return p.TTL
+}
+
+// systemTexts lists the non-empty parts of the system prompt in order,
+// starting with the provider default.
+func (r Request) systemTexts(defaultSystem string) []string {
+	texts := make([]string, 0, len(r.SystemBlocks)+2)
+	for _, text := range append([]string{defaultSystem, r.System}, blockTexts(r.SystemBlocks)...) {
+		if trimmed := strings.TrimSpace(text); trimmed != "" {
+			texts = append(texts, trimmed)
+		}
+	}
+	return texts
+}
+
+func blockTexts(blocks []SystemBlock) []string {
+	out := make([]string, len(blocks))
+	for i, block := range blocks {
+		out[i] = block.Text
+	}
+	return out
 }
 
 // Usage reports token accounting for a completion.
@@ -98,14 +271,30 @@ type Response struct {
 	Message    Message
 	Usage      Usage
 	StopReason string
+	// Model names the model that served the request when the provider
+	// reports it.
+	Model string
 }
 
 // StreamResult delivers incremental updates during streaming calls.
+// Delta only ever carries assistant text so callers that concatenate it keep
+// working; reasoning and tool argument fragments use dedicated fields.
 type StreamResult struct {
 	Delta    string
 	ToolCall *ToolCall
 	Final    bool
 	Response *Response
+
+	// Thinking carries an incremental reasoning fragment.
+	Thinking string
+	// ToolInputDelta carries a partial JSON fragment of the arguments for the
+	// tool call identified by ToolCallID and ToolCallName.
+	ToolInputDelta string
+	ToolCallID     string
+	ToolCallName   string
+	// Index is the provider-assigned content block index of the fragment.
+	// Fragments sharing an index belong to the same block.
+	Index int
 }
 
 // StreamHandler consumes streaming updates in order.
@@ -116,3 +305,9 @@ type Model interface {
 	Complete(ctx context.Context, req Request) (*Response, error)
 	CompleteStream(ctx context.Context, req Request, cb StreamHandler) error
 }
+
+// TokenCounter is implemented by models whose provider can count the input
+// tokens of a request without running it.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, req Request) (int, error)
+}
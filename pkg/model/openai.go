@@ -27,6 +27,10 @@ type OpenAIConfig struct {
 	Temperature  *float64
 	HTTPClient   *http.Client
 	UseResponses bool // true = /responses API, false = /chat/completions
+
+	// APIKeyHelper, when set, supplies the key of every request in place
+	// of APIKey, which may then be empty.
+	APIKeyHelper *APIKeyHelper
 }
 
 type openaiChatCompletions interface {
@@ -52,7 +56,7 @@ const (
 // NewOpenAI constructs a production-ready OpenAI-backed Model.
 func NewOpenAI(cfg OpenAIConfig) (Model, error) {
 	apiKey := strings.TrimSpace(cfg.APIKey)
-	if apiKey == "" {
+	if apiKey == "" && cfg.APIKeyHelper == nil {
 		return nil, errors.New("openai: api key required")
 	}
 
@@ -62,8 +66,8 @@ func NewOpenAI(cfg OpenAIConfig) (Model, error) {
 	if cfg.BaseURL != "" {
 		opts = append(opts, option.WithBaseURL(cfg.BaseURL))
 	}
-	if cfg.HTTPClient != nil {
-		opts = append(opts, option.WithHTTPClient(cfg.HTTPClient))
+	if client := withAPIKeyHelper(cfg.HTTPClient, cfg.APIKeyHelper, "openai", setOpenAIAPIKey); client != nil {
+		opts = append(opts, option.WithHTTPClient(client))
 	}
 
 	client := openai.NewClient(opts...)
@@ -144,10 +148,14 @@ func (m *openaiModel) CompleteStream(ctx context.Context, req Request, cb Stream
 			accumulatedCalls     = make(map[int]*toolCallAccumulator)
 			finalUsage           Usage
 			finishReason         string
+			servedModel          string
 		)
 
 		for stream.Next() {
 			chunk := stream.Current()
+			if chunk.Model != "" {
+				servedModel = chunk.Model
+			}
 
 			// Capture usage from final chunk
 			if chunk.Usage.TotalTokens > 0 {
@@ -167,8 +175,11 @@ func (m *openaiModel) CompleteStream(ctx context.Context, req Request, cb Stream
 					if err := json.Unmarshal([]byte(raw), &dp); err == nil {
 						if rc, ok := dp["reasoning_content"]; ok {
 							var s string
-							if json.Unmarshal(rc, &s) == nil {
+							if json.Unmarshal(rc, &s) == nil && s != "" {
 								accumulatedReasoning.WriteString(s)
+								if err := cb(StreamResult{Thinking: s}); err != nil {
+									return err
+								}
 							}
 						}
 					}
@@ -198,6 +209,18 @@ func (m *openaiModel) CompleteStream(ctx context.Context, req Request, cb Stream
 						acc.name = tc.Function.Name
 					}
 					acc.arguments.WriteString(tc.Function.Arguments)
+					if tc.Function.Arguments != "" {
+						// Tool calls follow the text block, so shift their
+						// indices past it.
+						if err := cb(StreamResult{
+							ToolInputDelta: tc.Function.Arguments,
+							ToolCallID:     acc.id,
+							ToolCallName:   acc.name,
+							Index:          idx + 1,
+						}); err != nil {
+							return err
+						}
+					}
 				}
 			}
 		}
@@ -234,6 +257,7 @@ func (m *openaiModel) CompleteStream(ctx context.Context, req Request, cb Stream
 			},
 			Usage:      finalUsage,
 			StopReason: finishReason,
+			Model:      servedModel,
 		}
 		recordModelResponse(ctx, resp)
 		return cb(StreamResult{Final: true, Response: resp})
@@ -269,7 +293,7 @@ func parseJSONArgs(raw string) map[string]any {
 }
 
 func (m *openaiModel) buildParams(req Request) (openai.ChatCompletionNewParams, error) {
-	messages := convertMessagesToOpenAI(req.Messages, m.system, req.System)
+	messages := convertMessagesToOpenAI(req.Messages, req.systemTexts(m.system)...)
 
 	maxTokens := req.MaxTokens
 	if maxTokens <= 0 {
@@ -287,6 +311,15 @@ func (m *openaiModel) buildParams(req Request) (openai.ChatCompletionNewParams,
 	if len(req.Tools) > 0 {
 		tools := convertToolsToOpenAI(req.Tools)
 		params.Tools = tools
+		if choice := req.ToolChoice; choice != nil {
+			if err := choice.Validate(); err != nil {
+				return openai.ChatCompletionNewParams{}, err
+			}
+			params.ToolChoice = convertToolChoiceToOpenAI(choice)
+			if choice.DisableParallelToolUse {
+				params.ParallelToolCalls = openai.Bool(false)
+			}
+		}
 	}
 
 	if m.temperature != nil {
@@ -296,13 +329,40 @@ func (m *openaiModel) buildParams(req Request) (openai.ChatCompletionNewParams,
 		params.Temperature = openai.Float(*req.Temperature)
 	}
 
-	if sessionID := strings.TrimSpace(req.SessionID); sessionID != "" {
-		params.User = openai.String(sessionID)
+	if req.TopP != nil {
+		params.TopP = openai.Float(*req.TopP)
+	}
+	if len(req.StopSequences) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: append([]string(nil), req.StopSequences...)}
+	}
+
+	if req.Thinking != nil && req.Thinking.Effort != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(req.Thinking.Effort)
+	}
+
+	if user := req.endUser(); user != "" {
+		params.User = openai.String(user)
 	}
 
 	return params, nil
 }
 
+func convertToolChoiceToOpenAI(choice *ToolChoice) openai.ChatCompletionToolChoiceOptionUnionParam {
+	switch choice.Type {
+	case ToolChoiceAny:
+		return openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: openai.String("required")}
+	case ToolChoiceNone:
+		return openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: openai.String("none")}
+	case ToolChoiceTool:
+		return openai.ChatCompletionToolChoiceOptionUnionParam{
+			OfChatCompletionNamedToolChoice: &openai.ChatCompletionNamedToolChoiceParam{
+				Function: openai.ChatCompletionNamedToolChoiceFunctionParam{Name: choice.Name},
+			},
+		}
+	}
+	return openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: openai.String("auto")}
+}
+
 func (m *openaiModel) doWithRetry(ctx context.Context, fn func(context.Context) error) error {
 	attempts := 0
 	for {
@@ -330,6 +390,10 @@ func isOpenAIRetryable(err error) bool {
 	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 		return false
 	}
+	var credErr *credentialError
+	if errors.As(err, &credErr) {
+		return false
+	}
 	var apiErr *openai.Error
 	if errors.As(err, &apiErr) {
 		// Don't retry authentication errors
@@ -346,6 +410,10 @@ func isOpenAIRetryable(err error) bool {
 	return true
 }
 
+func setOpenAIAPIKey(h http.Header, key string) {
+	h.Set("Authorization", "Bearer "+key)
+}
+
 func (m *openaiModel) selectModel(override string) string {
 	if trimmed := strings.TrimSpace(override); trimmed != "" {
 		return trimmed
@@ -600,13 +668,18 @@ func convertOpenAIResponse(completion *openai.ChatCompletion) *Response {
 		},
 		Usage:      convertOpenAIUsage(completion.Usage),
 		StopReason: choice.FinishReason,
+		Model:      completion.Model,
 	}
 }
 
+// convertOpenAIUsage reports cached prompt tokens as CacheReadTokens and
+// leaves only the uncached remainder in InputTokens, as Anthropic does.
 func convertOpenAIUsage(usage openai.CompletionUsage) Usage {
+	cached := usage.PromptTokensDetails.CachedTokens
 	return Usage{
-		InputTokens:  int(usage.PromptTokens),
-		OutputTokens: int(usage.CompletionTokens),
-		TotalTokens:  int(usage.TotalTokens),
+		InputTokens:     int(usage.PromptTokens - cached),
+		OutputTokens:    int(usage.CompletionTokens),
+		TotalTokens:     int(usage.TotalTokens),
+		CacheReadTokens: int(cached),
 	}
 }
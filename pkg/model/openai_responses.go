@@ -32,7 +32,7 @@ type openaiResponsesService interface {
 // NewOpenAIResponses constructs an OpenAI model using the Responses API.
 func NewOpenAIResponses(cfg OpenAIConfig) (Model, error) {
 	apiKey := strings.TrimSpace(cfg.APIKey)
-	if apiKey == "" {
+	if apiKey == "" && cfg.APIKeyHelper == nil {
 		return nil, errors.New("openai: api key required")
 	}
 
@@ -42,8 +42,8 @@ func NewOpenAIResponses(cfg OpenAIConfig) (Model, error) {
 	if cfg.BaseURL != "" {
 		opts = append(opts, option.WithBaseURL(cfg.BaseURL))
 	}
-	if cfg.HTTPClient != nil {
-		opts = append(opts, option.WithHTTPClient(cfg.HTTPClient))
+	if client := withAPIKeyHelper(cfg.HTTPClient, cfg.APIKeyHelper, "openai", setOpenAIAPIKey); client != nil {
+		opts = append(opts, option.WithHTTPClient(client))
 	}
 
 	client := openai.NewClient(opts...)
@@ -73,6 +73,9 @@ func NewOpenAIResponses(cfg OpenAIConfig) (Model, error) {
 
 // Complete issues a non-streaming completion using Responses API.
 func (m *openaiResponsesModel) Complete(ctx context.Context, req Request) (*Response, error) {
+	if err := req.ToolChoice.Validate(); err != nil {
+		return nil, err
+	}
 	recordModelRequest(ctx, req)
 	var resp *Response
 	err := m.doWithRetry(ctx, func(ctx context.Context) error {
@@ -95,6 +98,9 @@ func (m *openaiResponsesModel) CompleteStream(ctx context.Context, req Request,
 	if cb == nil {
 		return errors.New("stream callback required")
 	}
+	if err := req.ToolChoice.Validate(); err != nil {
+		return err
+	}
 
 	recordModelRequest(ctx, req)
 
@@ -123,7 +129,14 @@ func (m *openaiResponsesModel) CompleteStream(ctx context.Context, req Request,
 				// Text delta - use Delta field
 				if delta := event.Delta.OfString; delta != "" {
 					accumulatedContent.WriteString(delta)
-					if err := cb(StreamResult{Delta: delta}); err != nil {
+					if err := cb(StreamResult{Delta: delta, Index: int(event.OutputIndex)}); err != nil {
+						return err
+					}
+				}
+
+			case "response.reasoning_summary_text.delta", "response.reasoning_text.delta":
+				if delta := event.Delta.OfString; delta != "" {
+					if err := cb(StreamResult{Thinking: delta, Index: int(event.OutputIndex)}); err != nil {
 						return err
 					}
 				}
@@ -136,7 +149,25 @@ func (m *openaiResponsesModel) CompleteStream(ctx context.Context, req Request,
 						acc = &responsesToolCallAccumulator{id: event.ItemID}
 						accumulatedCalls[event.ItemID] = acc
 					}
-					acc.arguments.WriteString(event.Arguments)
+					fragment := event.Delta.OfString
+					if fragment == "" {
+						fragment = event.Arguments
+					}
+					acc.arguments.WriteString(fragment)
+					if fragment != "" {
+						id := acc.callID
+						if id == "" {
+							id = acc.id
+						}
+						if err := cb(StreamResult{
+							ToolInputDelta: fragment,
+							ToolCallID:     id,
+							ToolCallName:   acc.name,
+							Index:          int(event.OutputIndex),
+						}); err != nil {
+							return err
+						}
+					}
 				}
 
 			case "response.function_call_arguments.done":
@@ -207,6 +238,9 @@ func (m *openaiResponsesModel) CompleteStream(ctx context.Context, req Request,
 			Usage:      finalUsage,
 			StopReason: stopReason,
 		}
+		if finalResponse != nil {
+			resp.Model = string(finalResponse.Model)
+		}
 		recordModelResponse(ctx, resp)
 		return cb(StreamResult{Final: true, Response: resp})
 	})
@@ -248,17 +282,25 @@ func (m *openaiResponsesModel) buildResponsesParams(req Request) responses.Respo
 		Input:           buildResponsesInput(req.Messages),
 	}
 
-	// Set system instructions
-	if sys := m.system; sys != "" {
-		params.Instructions = openai.String(sys)
+	// Set system instructions: the request system prompt replaces the
+	// default and system blocks follow either.
+	sys := m.system
+	if trimmed := strings.TrimSpace(req.System); trimmed != "" {
+		sys = trimmed
 	}
-	if sys := strings.TrimSpace(req.System); sys != "" {
-		params.Instructions = openai.String(sys)
+	if texts := (Request{SystemBlocks: req.SystemBlocks}).systemTexts(sys); len(texts) > 0 {
+		params.Instructions = openai.String(strings.Join(texts, "\n\n"))
 	}
 
 	// Add tools
 	if len(req.Tools) > 0 {
 		params.Tools = convertToolsToResponsesAPI(req.Tools)
+		if choice := req.ToolChoice; choice != nil {
+			params.ToolChoice = convertToolChoiceToResponses(choice)
+			if choice.DisableParallelToolUse {
+				params.ParallelToolCalls = openai.Bool(false)
+			}
+		}
 	}
 
 	// Set temperature
@@ -268,14 +310,39 @@ func (m *openaiResponsesModel) buildResponsesParams(req Request) responses.Respo
 	if req.Temperature != nil {
 		params.Temperature = openai.Float(*req.Temperature)
 	}
+	// The Responses API has no top_k or stop sequences.
+	if req.TopP != nil {
+		params.TopP = openai.Float(*req.TopP)
+	}
 
-	if sessionID := strings.TrimSpace(req.SessionID); sessionID != "" {
-		params.User = openai.String(sessionID)
+	if t := req.Thinking; t != nil && (t.Enabled || t.Effort != "") {
+		params.Reasoning = shared.ReasoningParam{Effort: shared.ReasoningEffort(t.Effort)}
+		if t.Enabled {
+			// Summaries are the only reasoning the Responses API streams.
+			params.Reasoning.Summary = shared.ReasoningSummaryAuto
+		}
+	}
+
+	if user := req.endUser(); user != "" {
+		params.User = openai.String(user)
 	}
 
 	return params
 }
 
+func convertToolChoiceToResponses(choice *ToolChoice) responses.ResponseNewParamsToolChoiceUnion {
+	mode := responses.ToolChoiceOptionsAuto
+	switch choice.Type {
+	case ToolChoiceAny:
+		mode = responses.ToolChoiceOptionsRequired
+	case ToolChoiceNone:
+		mode = responses.ToolChoiceOptionsNone
+	case ToolChoiceTool:
+		return responses.ResponseNewParamsToolChoiceUnion{OfFunctionTool: &responses.ToolChoiceFunctionParam{Name: choice.Name}}
+	}
+	return responses.ResponseNewParamsToolChoiceUnion{OfToolChoiceMode: param.NewOpt(mode)}
+}
+
 func (m *openaiResponsesModel) selectModel(override string) string {
 	if trimmed := strings.TrimSpace(override); trimmed != "" {
 		return trimmed
@@ -505,13 +572,17 @@ func convertResponsesAPIResponse(resp *responses.Response) *Response {
 		},
 		Usage:      convertResponsesUsage(resp.Usage),
 		StopReason: stopReason,
+		Model:      string(resp.Model),
 	}
 }
 
+// convertResponsesUsage splits cached input tokens out like convertOpenAIUsage.
 func convertResponsesUsage(usage responses.ResponseUsage) Usage {
+	cached := usage.InputTokensDetails.CachedTokens
 	return Usage{
-		InputTokens:  int(usage.InputTokens),
-		OutputTokens: int(usage.OutputTokens),
-		TotalTokens:  int(usage.TotalTokens),
+		InputTokens:     int(usage.InputTokens - cached),
+		OutputTokens:    int(usage.OutputTokens),
+		TotalTokens:     int(usage.TotalTokens),
+		CacheReadTokens: int(cached),
 	}
 }
@@ -667,6 +667,21 @@ func TestConvertResponsesUsage(t *testing.T) {
 				TotalTokens:  0,
 			},
 		},
+		{
+			name: "cached input",
+			usage: responses.ResponseUsage{
+				InputTokens:        100,
+				InputTokensDetails: responses.ResponseUsageInputTokensDetails{CachedTokens: 80},
+				OutputTokens:       50,
+				TotalTokens:        150,
+			},
+			want: Usage{
+				InputTokens:     20,
+				OutputTokens:    50,
+				TotalTokens:     150,
+				CacheReadTokens: 80,
+			},
+		},
 		{
 			name: "large values",
 			usage: responses.ResponseUsage{
@@ -688,6 +703,7 @@ func TestConvertResponsesUsage(t *testing.T) {
 			assert.Equal(t, tt.want.InputTokens, result.InputTokens)
 			assert.Equal(t, tt.want.OutputTokens, result.OutputTokens)
 			assert.Equal(t, tt.want.TotalTokens, result.TotalTokens)
+			assert.Equal(t, tt.want.CacheReadTokens, result.CacheReadTokens)
 		})
 	}
 }
@@ -947,6 +963,23 @@ func TestOpenAIResponsesModel_BuildResponsesParams(t *testing.T) {
 		assert.Equal(t, "Be concise", params.Instructions.Value)
 	})
 
+	t.Run("system blocks follow instructions", func(t *testing.T) {
+		mdl := &openaiResponsesModel{
+			model:     "gpt-4o",
+			maxTokens: 4096,
+			system:    "Be helpful",
+		}
+
+		req := Request{
+			Messages:     []Message{{Role: "user", Content: "Hello"}},
+			SystemBlocks: []SystemBlock{{Text: "Cached", Cacheable: true}, {Text: " "}, {Text: "Today"}},
+		}
+
+		params := mdl.buildResponsesParams(req)
+		require.True(t, params.Instructions.Valid())
+		assert.Equal(t, "Be helpful\n\nCached\n\nToday", params.Instructions.Value)
+	})
+
 	t.Run("with tools", func(t *testing.T) {
 		mdl := &openaiResponsesModel{
 			model:     "gpt-4o",
@@ -11,6 +11,8 @@ import (
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/packages/ssestream"
+	"github.com/openai/openai-go/responses"
+	"github.com/openai/openai-go/shared"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -487,6 +489,12 @@ func TestConvertOpenAIUsage(t *testing.T) {
 	assert.Equal(t, 100, result.InputTokens)
 	assert.Equal(t, 50, result.OutputTokens)
 	assert.Equal(t, 150, result.TotalTokens)
+
+	usage.PromptTokensDetails.CachedTokens = 60
+	result = convertOpenAIUsage(usage)
+	assert.Equal(t, 40, result.InputTokens, "cached tokens are not billed as uncached input")
+	assert.Equal(t, 60, result.CacheReadTokens)
+	assert.InDelta(t, (40*2.5+60*1.25+50*10)/1e6, NewPricingRegistry(nil).Cost("gpt-4o", result), 1e-12)
 }
 
 func TestParseJSONArgs(t *testing.T) {
@@ -779,6 +787,64 @@ func TestConvertToFunctionParameters(t *testing.T) {
 	})
 }
 
+func TestOpenAIModel_BuildParamsSystemBlocks(t *testing.T) {
+	mdl := &openaiModel{model: "gpt-4o", system: "default"}
+	params, err := mdl.buildParams(Request{
+		Messages:     []Message{{Role: "user", Content: "hi"}},
+		System:       "base",
+		SystemBlocks: []SystemBlock{{Text: "rules", Cacheable: true}, {Text: ""}, {Text: "environment"}},
+	})
+	require.NoError(t, err)
+	var system []string
+	for _, msg := range params.Messages {
+		if msg.OfSystem != nil {
+			system = append(system, msg.OfSystem.Content.OfString.Value)
+		}
+	}
+	assert.Equal(t, []string{"default", "base", "rules", "environment"}, system)
+}
+
+func TestOpenAIModel_BuildParamsIgnoresCachePolicy(t *testing.T) {
+	mdl := &openaiModel{model: "gpt-4o"}
+	req := Request{Messages: []Message{{Role: "user", Content: "hi"}}}
+	plain, err := mdl.buildParams(req)
+	require.NoError(t, err)
+	req.EnablePromptCache = true
+	req.Cache = &CachePolicy{AfterSystem: true, AfterMessages: []int{7}, TTL: "bogus"}
+	cached, err := mdl.buildParams(req)
+	require.NoError(t, err)
+	assert.Equal(t, plain, cached)
+
+	responses := &openaiResponsesModel{model: "gpt-4o", maxTokens: 16}
+	assert.Equal(t, responses.buildResponsesParams(Request{Messages: req.Messages}), responses.buildResponsesParams(req))
+}
+
+func TestOpenAIModel_BuildParamsReasoningEffort(t *testing.T) {
+	mdl := &openaiModel{model: "o3"}
+	req := Request{Messages: []Message{{Role: "user", Content: "hi"}}, Thinking: &ThinkingConfig{Enabled: true}}
+	params, err := mdl.buildParams(req)
+	require.NoError(t, err)
+	assert.Empty(t, params.ReasoningEffort)
+
+	req.Thinking.Effort = ReasoningEffortHigh
+	params, err = mdl.buildParams(req)
+	require.NoError(t, err)
+	assert.Equal(t, shared.ReasoningEffortHigh, params.ReasoningEffort)
+
+	responses := &openaiResponsesModel{model: "o3", maxTokens: 16}
+	rp := responses.buildResponsesParams(req)
+	assert.Equal(t, shared.ReasoningEffortHigh, rp.Reasoning.Effort)
+	assert.Equal(t, shared.ReasoningSummaryAuto, rp.Reasoning.Summary)
+
+	req.Thinking = &ThinkingConfig{Effort: ReasoningEffortLow}
+	rp = responses.buildResponsesParams(req)
+	assert.Equal(t, shared.ReasoningEffortLow, rp.Reasoning.Effort)
+	assert.Empty(t, rp.Reasoning.Summary)
+
+	req.Thinking = nil
+	assert.Empty(t, responses.buildResponsesParams(req).Reasoning.Effort)
+}
+
 func TestOpenAIModel_SelectModel(t *testing.T) {
 	mdl := &openaiModel{model: "gpt-4o"}
 
@@ -934,3 +1000,54 @@ func TestOpenAIModel_DoWithRetry(t *testing.T) {
 		assert.True(t, attempts <= 3) // Should stop early due to cancellation
 	})
 }
+
+func TestOpenAIModel_BuildParamsToolChoiceAndSampling(t *testing.T) {
+	topP, topK := 0.5, 10
+	req := Request{
+		Messages:      []Message{{Role: "user", Content: "hi"}},
+		Tools:         []ToolDefinition{{Name: "classify", Parameters: map[string]any{"type": "object"}}},
+		ToolChoice:    &ToolChoice{Type: ToolChoiceTool, Name: "classify", DisableParallelToolUse: true},
+		StopSequences: []string{"END"},
+		TopP:          &topP,
+		TopK:          &topK,
+		SessionID:     "sess",
+		UserID:        "user-1",
+	}
+
+	mdl := &openaiModel{model: "gpt-4o"}
+	params, err := mdl.buildParams(req)
+	require.NoError(t, err)
+	require.NotNil(t, params.ToolChoice.OfChatCompletionNamedToolChoice)
+	assert.Equal(t, "classify", params.ToolChoice.OfChatCompletionNamedToolChoice.Function.Name)
+	assert.False(t, params.ParallelToolCalls.Value)
+	assert.True(t, params.ParallelToolCalls.Valid())
+	assert.Equal(t, []string{"END"}, params.Stop.OfStringArray)
+	assert.Equal(t, 0.5, params.TopP.Value)
+	assert.Equal(t, "user-1", params.User.Value)
+
+	for typ, want := range map[ToolChoiceType]string{ToolChoiceAuto: "auto", ToolChoiceAny: "required", ToolChoiceNone: "none"} {
+		req.ToolChoice = &ToolChoice{Type: typ}
+		params, err = mdl.buildParams(req)
+		require.NoError(t, err)
+		assert.Equal(t, want, params.ToolChoice.OfAuto.Value)
+		assert.False(t, params.ParallelToolCalls.Valid())
+	}
+	req.ToolChoice = &ToolChoice{Type: ToolChoiceTool}
+	_, err = mdl.buildParams(req)
+	require.Error(t, err)
+
+	responsesModel := &openaiResponsesModel{model: "gpt-4o", maxTokens: 16}
+	req.ToolChoice = &ToolChoice{Type: ToolChoiceTool, Name: "classify", DisableParallelToolUse: true}
+	rp := responsesModel.buildResponsesParams(req)
+	require.NotNil(t, rp.ToolChoice.OfFunctionTool)
+	assert.Equal(t, "classify", rp.ToolChoice.OfFunctionTool.Name)
+	assert.False(t, rp.ParallelToolCalls.Value)
+	assert.Equal(t, 0.5, rp.TopP.Value)
+	assert.Equal(t, "user-1", rp.User.Value)
+
+	req.ToolChoice = &ToolChoice{Type: ToolChoiceAny}
+	assert.Equal(t, responses.ToolChoiceOptionsRequired, responsesModel.buildResponsesParams(req).ToolChoice.OfToolChoiceMode.Value)
+	req.ToolChoice = &ToolChoice{Type: ToolChoiceTool}
+	_, err = responsesModel.Complete(context.Background(), req)
+	require.Error(t, err)
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	anthropicsdk "github.com/anthropics/anthropic-sdk-go"
+)
+
+// Price lists the USD cost per million tokens of a model.
+type Price struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheRead  float64 `json:"cacheRead,omitempty"`
+	CacheWrite float64 `json:"cacheWrite,omitempty"`
+}
+
+// Cost returns the USD cost of usage at this price.
+func (p Price) Cost(usage Usage) float64 {
+	total := float64(usage.InputTokens)*p.Input +
+		float64(usage.OutputTokens)*p.Output +
+		float64(usage.CacheReadTokens)*p.CacheRead +
+		float64(usage.CacheCreationTokens)*p.CacheWrite
+	return total / 1_000_000
+}
+
+var (
+	priceOpus   = Price{Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75}
+	priceSonnet = Price{Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75}
+	priceHaiku4 = Price{Input: 1, Output: 5, CacheRead: 0.1, CacheWrite: 1.25}
+	priceHaiku3 = Price{Input: 0.8, Output: 4, CacheRead: 0.08, CacheWrite: 1}
+)
+
+// defaultPrices covers the Anthropic models accepted by mapModelName and the
+// common OpenAI and Gemini chat models. Dated snapshots resolve through prefix lookup.
+var defaultPrices = map[string]Price{
+	string(anthropicsdk.ModelClaude3_7SonnetLatest):    priceSonnet, //nolint:staticcheck // deprecated but still accepted
+	string(anthropicsdk.ModelClaude3_7Sonnet20250219):  priceSonnet, //nolint:staticcheck // deprecated but still accepted
+	string(anthropicsdk.ModelClaude3_5HaikuLatest):     priceHaiku3,
+	string(anthropicsdk.ModelClaude3_5Haiku20241022):   priceHaiku3,
+	string(anthropicsdk.ModelClaudeHaiku4_5):           priceHaiku4,
+	string(anthropicsdk.ModelClaudeHaiku4_5_20251001):  priceHaiku4,
+	string(anthropicsdk.ModelClaudeSonnet4_20250514):   priceSonnet,
+	string(anthropicsdk.ModelClaudeSonnet4_0):          priceSonnet,
+	string(anthropicsdk.ModelClaude4Sonnet20250514):    priceSonnet,
+	string(anthropicsdk.ModelClaudeSonnet4_5):          priceSonnet,
+	string(anthropicsdk.ModelClaudeSonnet4_5_20250929): priceSonnet,
+	string(anthropicsdk.ModelClaudeOpus4_0):            priceOpus,
+	string(anthropicsdk.ModelClaudeOpus4_20250514):     priceOpus,
+	string(anthropicsdk.ModelClaude4Opus20250514):      priceOpus,
+	string(anthropicsdk.ModelClaudeOpus4_1_20250805):   priceOpus,
+	string(anthropicsdk.ModelClaude3OpusLatest):        priceOpus, //nolint:staticcheck // deprecated but still accepted
+	string(anthropicsdk.ModelClaude_3_Opus_20240229):   priceOpus, //nolint:staticcheck // deprecated but still accepted
+	string(anthropicsdk.ModelClaude_3_Haiku_20240307):  {Input: 0.25, Output: 1.25, CacheRead: 0.03, CacheWrite: 0.3},
+	"gpt-4o":       {Input: 2.5, Output: 10, CacheRead: 1.25},
+	"gpt-4o-mini":  {Input: 0.15, Output: 0.6, CacheRead: 0.075},
+	"gpt-4-turbo":  {Input: 10, Output: 30},
+	"gpt-4.1":      {Input: 2, Output: 8, CacheRead: 0.5},
+	"gpt-4.1-mini": {Input: 0.4, Output: 1.6, CacheRead: 0.1},
+	"gpt-4.1-nano": {Input: 0.1, Output: 0.4, CacheRead: 0.025},
+	"o1":           {Input: 15, Output: 60, CacheRead: 7.5},
+	"o1-mini":      {Input: 1.1, Output: 4.4, CacheRead: 0.55},
+	"o3":           {Input: 2, Output: 8, CacheRead: 0.5},
+	"o3-mini":      {Input: 1.1, Output: 4.4, CacheRead: 0.55},
+	"o4-mini":      {Input: 1.1, Output: 4.4, CacheRead: 0.275},
+	// Gemini prices are the rates for prompts up to 200k tokens.
+	"gemini-2.5-pro":        {Input: 1.25, Output: 10, CacheRead: 0.31},
+	"gemini-2.5-flash":      {Input: 0.3, Output: 2.5, CacheRead: 0.075},
+	"gemini-2.5-flash-lite": {Input: 0.1, Output: 0.4, CacheRead: 0.025},
+}
+
+// PricingRegistry maps model names to prices. It is safe for concurrent use.
+type PricingRegistry struct {
+	mu     sync.RWMutex
+	prices map[string]Price
+}
+
+// NewPricingRegistry returns a registry seeded with the built-in prices.
+// Entries in overrides replace or extend the defaults.
+func NewPricingRegistry(overrides map[string]Price) *PricingRegistry {
+	r := &PricingRegistry{prices: make(map[string]Price, len(defaultPrices)+len(overrides))}
+	for name, price := range defaultPrices {
+		r.prices[name] = price
+	}
+	for name, price := range overrides {
+		r.Set(name, price)
+	}
+	return r
+}
+
+// Set registers or replaces the price of a model.
+func (r *PricingRegistry) Set(name string, price Price) {
+	name = normalizePricingName(name)
+	if r == nil || name == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prices[name] = price
+}
+
+// Lookup returns the price of a model. Names without an exact entry match the
+// longest registered name they extend with a "-" suffix, so dated snapshots
+// such as "gpt-4o-2024-08-06" inherit the "gpt-4o" price.
+func (r *PricingRegistry) Lookup(name string) (Price, bool) {
+	name = normalizePricingName(name)
+	if r == nil || name == "" {
+		return Price{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if price, ok := r.prices[name]; ok {
+		return price, true
+	}
+	best := ""
+	for candidate := range r.prices {
+		if len(candidate) > len(best) && strings.HasPrefix(name, candidate+"-") {
+			best = candidate
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return r.prices[best], true
+}
+
+// Cost returns the USD cost of usage for the named model, or zero when the
+// model is unknown.
+func (r *PricingRegistry) Cost(name string, usage Usage) float64 {
+	price, ok := r.Lookup(name)
+	if !ok {
+		return 0
+	}
+	return price.Cost(usage)
+}
+
+// Models lists the registered model names in sorted order.
+func (r *PricingRegistry) Models() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.prices))
+	for name := range r.prices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func normalizePricingName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDefaultPricingCoversSupportedAnthropicModels(t *testing.T) {
+	reg := NewPricingRegistry(nil)
+	for _, m := range supportedAnthropicModels {
+		if _, ok := reg.Lookup(string(m)); !ok {
+			t.Fatalf("missing default price for %s", m)
+		}
+	}
+	for _, name := range []string{defaultOpenAIModel, defaultGeminiModel} {
+		if _, ok := reg.Lookup(name); !ok {
+			t.Fatalf("missing default price for %s", name)
+		}
+	}
+}
+
+func TestPricingRegistryLookupAndCost(t *testing.T) {
+	reg := NewPricingRegistry(map[string]Price{
+		"Custom-Model": {Input: 1, Output: 2},
+		"gpt-4o":       {Input: 5, Output: 20},
+	})
+
+	price, ok := reg.Lookup("  custom-model ")
+	if !ok || price.Output != 2 {
+		t.Fatalf("expected normalized override, got %+v ok=%v", price, ok)
+	}
+	if price, ok := reg.Lookup("gpt-4o-2024-08-06"); !ok || price.Input != 5 {
+		t.Fatalf("expected dated snapshot to inherit overridden gpt-4o price, got %+v ok=%v", price, ok)
+	}
+	if price, ok := reg.Lookup("gpt-4o-mini-2024-07-18"); !ok || price.Input != 0.15 {
+		t.Fatalf("expected longest prefix match, got %+v ok=%v", price, ok)
+	}
+	if _, ok := reg.Lookup("gpt-4oo"); ok {
+		t.Fatalf("prefix match must stop at a dash boundary")
+	}
+
+	usage := Usage{InputTokens: 1000, OutputTokens: 500, CacheReadTokens: 2000, CacheCreationTokens: 100}
+	got := reg.Cost(string(supportedAnthropicModels[0]), usage)
+	want := (1000*3 + 500*15 + 2000*0.3 + 100*3.75) / 1e6
+	if math.Abs(got-want) > 1e-12 {
+		t.Fatalf("cost = %v, want %v", got, want)
+	}
+	if reg.Cost("unknown", usage) != 0 {
+		t.Fatalf("unknown model should cost nothing")
+	}
+	var nilReg *PricingRegistry
+	if nilReg.Cost("gpt-4o", usage) != 0 || nilReg.Models() != nil {
+		t.Fatalf("nil registry should be inert")
+	}
+}
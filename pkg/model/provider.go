@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -37,6 +38,10 @@ type AnthropicProvider struct {
 	Temperature *float64
 	CacheTTL    time.Duration
 
+	// APIKeyHelper, when set and APIKey is empty, supplies the key in place
+	// of the environment.
+	APIKeyHelper *APIKeyHelper
+
 	mu      sync.RWMutex
 	cached  Model
 	expires time.Time
@@ -58,14 +63,16 @@ func (p *AnthropicProvider) Model(ctx context.Context) (Model, error) {
 		return p.cached, nil
 	}
 
+	apiKey, helper := keyOrHelper(p.APIKey, p.resolveAPIKey(), p.APIKeyHelper)
 	mdl, err := NewAnthropic(AnthropicConfig{
-		APIKey:      p.resolveAPIKey(),
-		BaseURL:     strings.TrimSpace(p.BaseURL),
-		Model:       strings.TrimSpace(p.ModelName),
-		MaxTokens:   p.MaxTokens,
-		MaxRetries:  p.MaxRetries,
-		System:      p.System,
-		Temperature: p.Temperature,
+		APIKey:       apiKey,
+		APIKeyHelper: helper,
+		BaseURL:      strings.TrimSpace(p.BaseURL),
+		Model:        strings.TrimSpace(p.ModelName),
+		MaxTokens:    p.MaxTokens,
+		MaxRetries:   p.MaxRetries,
+		System:       p.System,
+		Temperature:  p.Temperature,
 	})
 	if err != nil {
 		return nil, err
@@ -92,6 +99,16 @@ func (p *AnthropicProvider) resolveAPIKey() string {
 	return ""
 }
 
+// UseAPIKeyHelper sets the helper unless one is already configured,
+// typically from the settings.json apiKeyHelper.
+func (p *AnthropicProvider) UseAPIKeyHelper(helper *APIKeyHelper) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.APIKeyHelper == nil {
+		p.APIKeyHelper = helper
+	}
+}
+
 func (p *AnthropicProvider) cachedModel() Model {
 	if p.CacheTTL <= 0 {
 		return nil
@@ -125,6 +142,10 @@ type OpenAIProvider struct {
 	Temperature *float64
 	CacheTTL    time.Duration
 
+	// APIKeyHelper, when set and APIKey is empty, supplies the key in place
+	// of the environment.
+	APIKeyHelper *APIKeyHelper
+
 	mu      sync.RWMutex
 	cached  Model
 	expires time.Time
@@ -146,14 +167,16 @@ func (p *OpenAIProvider) Model(ctx context.Context) (Model, error) {
 		return p.cached, nil
 	}
 
+	apiKey, helper := keyOrHelper(p.APIKey, p.resolveAPIKey(), p.APIKeyHelper)
 	mdl, err := NewOpenAI(OpenAIConfig{
-		APIKey:      p.resolveAPIKey(),
-		BaseURL:     strings.TrimSpace(p.BaseURL),
-		Model:       strings.TrimSpace(p.ModelName),
-		MaxTokens:   p.MaxTokens,
-		MaxRetries:  p.MaxRetries,
-		System:      p.System,
-		Temperature: p.Temperature,
+		APIKey:       apiKey,
+		APIKeyHelper: helper,
+		BaseURL:      strings.TrimSpace(p.BaseURL),
+		Model:        strings.TrimSpace(p.ModelName),
+		MaxTokens:    p.MaxTokens,
+		MaxRetries:   p.MaxRetries,
+		System:       p.System,
+		Temperature:  p.Temperature,
 	})
 	if err != nil {
 		return nil, err
@@ -177,6 +200,16 @@ func (p *OpenAIProvider) resolveAPIKey() string {
 	return ""
 }
 
+// UseAPIKeyHelper sets the helper unless one is already configured,
+// typically from the settings.json apiKeyHelper.
+func (p *OpenAIProvider) UseAPIKeyHelper(helper *APIKeyHelper) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.APIKeyHelper == nil {
+		p.APIKeyHelper = helper
+	}
+}
+
 func (p *OpenAIProvider) cachedModel() Model {
 	if p.CacheTTL <= 0 {
 		return nil
@@ -189,6 +222,254 @@ func (p *OpenAIProvider) cachedModel() Model {
 	return p.cached
 }
 
+// GeminiProvider caches Gemini clients with optional TTL.
+type GeminiProvider struct {
+	APIKey      string
+	BaseURL     string // Optional: for proxies
+	ModelName   string
+	MaxTokens   int
+	MaxRetries  int
+	System      string
+	Temperature *float64
+	CacheTTL    time.Duration
+
+	// APIKeyHelper, when set and APIKey is empty, supplies the key in place
+	// of the environment.
+	APIKeyHelper *APIKeyHelper
+
+	mu      sync.RWMutex
+	cached  Model
+	expires time.Time
+}
+
+// Model implements Provider with caching using double-checked locking.
+func (p *GeminiProvider) Model(ctx context.Context) (Model, error) {
+	if mdl := p.cachedModel(); mdl != nil {
+		return mdl, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != nil && (p.CacheTTL <= 0 || time.Now().Before(p.expires)) {
+		return p.cached, nil
+	}
+
+	apiKey, helper := keyOrHelper(p.APIKey, p.resolveAPIKey(), p.APIKeyHelper)
+	mdl, err := NewGemini(GeminiConfig{
+		APIKey:       apiKey,
+		APIKeyHelper: helper,
+		BaseURL:      strings.TrimSpace(p.BaseURL),
+		Model:        strings.TrimSpace(p.ModelName),
+		MaxTokens:    p.MaxTokens,
+		MaxRetries:   p.MaxRetries,
+		System:       p.System,
+		Temperature:  p.Temperature,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if p.CacheTTL > 0 {
+		p.cached = mdl
+		p.expires = time.Now().Add(p.CacheTTL)
+	}
+	return mdl, nil
+}
+
+func (p *GeminiProvider) resolveAPIKey() string {
+	if key := strings.TrimSpace(p.APIKey); key != "" {
+		return key
+	}
+	if key := strings.TrimSpace(os.Getenv("GEMINI_API_KEY")); key != "" {
+		return key
+	}
+	if key := strings.TrimSpace(os.Getenv("GOOGLE_API_KEY")); key != "" {
+		return key
+	}
+	return ""
+}
+
+// UseAPIKeyHelper sets the helper unless one is already configured,
+// typically from the settings.json apiKeyHelper.
+func (p *GeminiProvider) UseAPIKeyHelper(helper *APIKeyHelper) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.APIKeyHelper == nil {
+		p.APIKeyHelper = helper
+	}
+}
+
+func (p *GeminiProvider) cachedModel() Model {
+	if p.CacheTTL <= 0 {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.cached == nil || time.Now().After(p.expires) {
+		return nil
+	}
+	return p.cached
+}
+
+// BedrockProvider caches Bedrock-backed Anthropic clients with optional TTL.
+type BedrockProvider struct {
+	Region            string
+	BaseURL           string // Optional: for VPC endpoints or proxies
+	ModelName         string
+	InferenceProfile  string    // Optional: cross-region inference profile, e.g. "us"
+	Profile           string    // Optional: shared credentials profile
+	CredentialExport  string    // Optional: script printing JSON credentials
+	AuthRefresh       string    // Optional: script refreshing expired credentials
+	AuthRefreshOutput io.Writer // Optional: receives the refresh script's output, defaults to os.Stderr
+	MaxTokens         int
+	MaxRetries        int
+	System            string
+	Temperature       *float64
+	CacheTTL          time.Duration
+
+	mu      sync.RWMutex
+	cached  Model
+	expires time.Time
+}
+
+// Model implements Provider with caching using double-checked locking.
+func (p *BedrockProvider) Model(ctx context.Context) (Model, error) {
+	if mdl := p.cachedModel(); mdl != nil {
+		return mdl, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != nil && (p.CacheTTL <= 0 || time.Now().Before(p.expires)) {
+		return p.cached, nil
+	}
+
+	mdl, err := NewBedrock(BedrockConfig{
+		Region:            strings.TrimSpace(p.Region),
+		BaseURL:           strings.TrimSpace(p.BaseURL),
+		Model:             strings.TrimSpace(p.ModelName),
+		InferenceProfile:  p.InferenceProfile,
+		Profile:           p.Profile,
+		CredentialExport:  p.CredentialExport,
+		AuthRefresh:       p.AuthRefresh,
+		AuthRefreshOutput: p.AuthRefreshOutput,
+		MaxTokens:         p.MaxTokens,
+		MaxRetries:        p.MaxRetries,
+		System:            p.System,
+		Temperature:       p.Temperature,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if p.CacheTTL > 0 {
+		p.cached = mdl
+		p.expires = time.Now().Add(p.CacheTTL)
+	}
+	return mdl, nil
+}
+
+// UseCredentialScripts fills the credential scripts left empty, typically
+// from the awsCredentialExport and awsAuthRefresh settings.
+func (p *BedrockProvider) UseCredentialScripts(credentialExport, authRefresh string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if strings.TrimSpace(p.CredentialExport) == "" {
+		p.CredentialExport = credentialExport
+	}
+	if strings.TrimSpace(p.AuthRefresh) == "" {
+		p.AuthRefresh = authRefresh
+	}
+}
+
+func (p *BedrockProvider) cachedModel() Model {
+	if p.CacheTTL <= 0 {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.cached == nil || time.Now().After(p.expires) {
+		return nil
+	}
+	return p.cached
+}
+
+// VertexProvider caches Vertex AI-backed Anthropic clients with optional TTL.
+type VertexProvider struct {
+	ProjectID       string
+	Region          string
+	BaseURL         string // Optional: for private endpoints or proxies
+	ModelName       string
+	CredentialsFile string // Optional: defaults to Application Default Credentials
+	MaxTokens       int
+	MaxRetries      int
+	System          string
+	Temperature     *float64
+	CacheTTL        time.Duration
+
+	mu      sync.RWMutex
+	cached  Model
+	expires time.Time
+}
+
+// Model implements Provider with caching using double-checked locking.
+func (p *VertexProvider) Model(ctx context.Context) (Model, error) {
+	if mdl := p.cachedModel(); mdl != nil {
+		return mdl, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != nil && (p.CacheTTL <= 0 || time.Now().Before(p.expires)) {
+		return p.cached, nil
+	}
+
+	mdl, err := NewVertex(VertexConfig{
+		ProjectID:       strings.TrimSpace(p.ProjectID),
+		Region:          strings.TrimSpace(p.Region),
+		BaseURL:         strings.TrimSpace(p.BaseURL),
+		Model:           strings.TrimSpace(p.ModelName),
+		CredentialsFile: strings.TrimSpace(p.CredentialsFile),
+		MaxTokens:       p.MaxTokens,
+		MaxRetries:      p.MaxRetries,
+		System:          p.System,
+		Temperature:     p.Temperature,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if p.CacheTTL > 0 {
+		p.cached = mdl
+		p.expires = time.Now().Add(p.CacheTTL)
+	}
+	return mdl, nil
+}
+
+func (p *VertexProvider) cachedModel() Model {
+	if p.CacheTTL <= 0 {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.cached == nil || time.Now().After(p.expires) {
+		return nil
+	}
+	return p.cached
+}
+
+// keyOrHelper prefers an explicit key, then the helper, then the key
+// resolved from the environment.
+func keyOrHelper(explicit, resolved string, helper *APIKeyHelper) (string, *APIKeyHelper) {
+	if strings.TrimSpace(explicit) != "" || helper == nil {
+		return resolved, nil
+	}
+	return "", helper
+}
+
 // MustProvider materialises a model immediately and panics on failure.
 func MustProvider(p Provider) Model {
 	if p == nil {
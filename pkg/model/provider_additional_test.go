@@ -65,6 +65,31 @@ func TestAnthropicProviderResolveAPIKeyPriority(t *testing.T) {
 	}
 }
 
+func TestProviderAPIKeyHelperPrecedence(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "envkey")
+	helper := &APIKeyHelper{Command: "echo helper-key"}
+
+	if key, got := keyOrHelper("explicit", "explicit", helper); key != "explicit" || got != nil {
+		t.Fatalf("explicit key should win, got %q %v", key, got)
+	}
+	if key, got := keyOrHelper("", "envkey", helper); key != "" || got != helper {
+		t.Fatalf("helper should win over env, got %q %v", key, got)
+	}
+	if key, got := keyOrHelper("", "envkey", nil); key != "envkey" || got != nil {
+		t.Fatalf("expected env key without helper, got %q %v", key, got)
+	}
+
+	p := &AnthropicProvider{}
+	p.UseAPIKeyHelper(helper)
+	p.UseAPIKeyHelper(&APIKeyHelper{Command: "echo other"})
+	if p.APIKeyHelper != helper {
+		t.Fatalf("expected first helper to be kept")
+	}
+	if _, err := p.Model(context.Background()); err != nil {
+		t.Fatalf("model with helper: %v", err)
+	}
+}
+
 func TestMustProvider(t *testing.T) {
 	if _, err := func() (Model, error) {
 		defer func() {
@@ -121,3 +146,29 @@ func TestMustProviderError(t *testing.T) {
 	}()
 	_ = MustProvider(stubProvider{err: errors.New("boom")})
 }
+
+func TestCloudAnthropicProvidersCaching(t *testing.T) {
+	bedrock := &BedrockProvider{Region: "us-west-2", CacheTTL: time.Minute}
+	bedrock.UseCredentialScripts("export-creds", "login")
+	bedrock.UseCredentialScripts("other", "other")
+	if bedrock.CredentialExport != "export-creds" || bedrock.AuthRefresh != "login" {
+		t.Fatalf("expected first scripts to stick, got %q %q", bedrock.CredentialExport, bedrock.AuthRefresh)
+	}
+	vertex := &VertexProvider{ProjectID: "proj", CacheTTL: time.Minute}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("CLOUDSDK_CONFIG", t.TempDir())
+
+	for _, p := range []Provider{bedrock, vertex} {
+		m1, err := p.Model(context.Background())
+		if err != nil {
+			t.Fatalf("model: %v", err)
+		}
+		m2, err := p.Model(context.Background())
+		if err != nil {
+			t.Fatalf("model: %v", err)
+		}
+		if m1 != m2 {
+			t.Fatalf("expected cached model for %T", p)
+		}
+	}
+}
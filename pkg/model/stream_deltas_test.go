@@ -0,0 +1,154 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func collectStream(t *testing.T, m Model) []StreamResult {
+	t.Helper()
+	var out []StreamResult
+	err := m.CompleteStream(context.Background(), Request{
+		Messages: []Message{{Role: "user", Content: "hi"}},
+	}, func(sr StreamResult) error {
+		out = append(out, sr)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	return out
+}
+
+func sseServer(t *testing.T, frames []string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, frame := range frames {
+			fmt.Fprintf(w, "%s\n\n", frame)
+		}
+	}))
+}
+
+func TestAnthropicStreamForwardsThinkingAndToolInputDeltas(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hmm"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"ok"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"calc","input":{}}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"a\":"}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"1}"}}`,
+		`{"type":"content_block_stop","index":2}`,
+		`{"type":"message_stop"}`,
+	}
+	m := &anthropicModel{
+		msgs:             &fakeMessages{stream: buildStream(t, events), countErr: fmt.Errorf("skip")},
+		model:            mapModelName(""),
+		maxTokens:        16,
+		configuredAPIKey: "key",
+	}
+
+	var thinking, text, input []string
+	for _, sr := range collectStream(t, m) {
+		switch {
+		case sr.Thinking != "":
+			if sr.Index != 0 || sr.Delta != "" {
+				t.Fatalf("unexpected thinking fragment %+v", sr)
+			}
+			thinking = append(thinking, sr.Thinking)
+		case sr.Delta != "":
+			if sr.Index != 1 {
+				t.Fatalf("unexpected text fragment %+v", sr)
+			}
+			text = append(text, sr.Delta)
+		case sr.ToolInputDelta != "":
+			if sr.Index != 2 || sr.ToolCallID != "toolu_1" || sr.ToolCallName != "calc" {
+				t.Fatalf("unexpected tool fragment %+v", sr)
+			}
+			input = append(input, sr.ToolInputDelta)
+		}
+	}
+	if strings.Join(thinking, "") != "hmm" || strings.Join(text, "") != "ok" || strings.Join(input, "") != `{"a":1}` {
+		t.Fatalf("unexpected fragments thinking=%v text=%v input=%v", thinking, text, input)
+	}
+}
+
+func TestOpenAIStreamForwardsReasoningAndToolInputDeltas(t *testing.T) {
+	srv := sseServer(t, []string{
+		`data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"think"}}]}`,
+		`data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"hi"}}]}`,
+		`data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"calc","arguments":"{\"a\""}}]}}]}`,
+		`data: {"id":"c1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":":1}"}}]},"finish_reason":"tool_calls"}]}`,
+		`data: [DONE]`,
+	})
+	defer srv.Close()
+
+	m, err := NewOpenAI(OpenAIConfig{APIKey: "k", BaseURL: srv.URL, Model: "gpt-4o", MaxRetries: 1})
+	if err != nil {
+		t.Fatalf("new openai: %v", err)
+	}
+
+	var thinking, text, input []string
+	var final *Response
+	for _, sr := range collectStream(t, m) {
+		switch {
+		case sr.Final:
+			final = sr.Response
+		case sr.Thinking != "":
+			thinking = append(thinking, sr.Thinking)
+		case sr.Delta != "":
+			text = append(text, sr.Delta)
+		case sr.ToolInputDelta != "":
+			if sr.ToolCallID != "call_1" || sr.ToolCallName != "calc" || sr.Index != 1 {
+				t.Fatalf("unexpected tool fragment %+v", sr)
+			}
+			input = append(input, sr.ToolInputDelta)
+		}
+	}
+	if strings.Join(thinking, "") != "think" || strings.Join(text, "") != "hi" || strings.Join(input, "") != `{"a":1}` {
+		t.Fatalf("unexpected fragments thinking=%v text=%v input=%v", thinking, text, input)
+	}
+	if final == nil || len(final.Message.ToolCalls) != 1 || final.Message.ToolCalls[0].Arguments["a"] != float64(1) {
+		t.Fatalf("unexpected final response %+v", final)
+	}
+}
+
+func TestOpenAIResponsesStreamForwardsToolInputDeltas(t *testing.T) {
+	srv := sseServer(t, []string{
+		"event: response.output_text.delta\n" + `data: {"type":"response.output_text.delta","item_id":"msg_1","output_index":0,"content_index":0,"delta":"hi","sequence_number":1}`,
+		"event: response.output_item.added\n" + `data: {"type":"response.output_item.added","output_index":1,"item":{"type":"function_call","id":"fc_1","call_id":"call_1","name":"calc","arguments":""},"sequence_number":2}`,
+		"event: response.function_call_arguments.delta\n" + `data: {"type":"response.function_call_arguments.delta","item_id":"fc_1","output_index":1,"delta":"{\"a\":1}","sequence_number":3}`,
+		"event: response.function_call_arguments.done\n" + `data: {"type":"response.function_call_arguments.done","item_id":"fc_1","output_index":1,"arguments":"{\"a\":1}","sequence_number":4}`,
+	})
+	defer srv.Close()
+
+	m, err := NewOpenAIResponses(OpenAIConfig{APIKey: "k", BaseURL: srv.URL, Model: "gpt-4o", MaxRetries: 1})
+	if err != nil {
+		t.Fatalf("new responses: %v", err)
+	}
+
+	var text, input []string
+	for _, sr := range collectStream(t, m) {
+		switch {
+		case sr.Delta != "":
+			text = append(text, sr.Delta)
+		case sr.ToolInputDelta != "":
+			if sr.ToolCallID != "call_1" || sr.ToolCallName != "calc" || sr.Index != 1 {
+				t.Fatalf("unexpected tool fragment %+v", sr)
+			}
+			input = append(input, sr.ToolInputDelta)
+		}
+	}
+	if strings.Join(text, "") != "hi" || strings.Join(input, "") != `{"a":1}` {
+		t.Fatalf("unexpected fragments text=%v input=%v", text, input)
+	}
+}
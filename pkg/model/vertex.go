@@ -0,0 +1,195 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	anthropicsdk "github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// VertexConfig runs the Anthropic adapter against Claude on Google Cloud
+// Vertex AI, authenticated with Application Default Credentials.
+type VertexConfig struct {
+	ProjectID string // Optional: defaults to ANTHROPIC_VERTEX_PROJECT_ID, GOOGLE_CLOUD_PROJECT, then the credentials' project
+	Region    string // Optional: defaults to CLOUD_ML_REGION, then us-east5
+	BaseURL   string // Optional: defaults to the regional aiplatform endpoint
+	// Model is an Anthropic model name ("claude-sonnet-4-5") or a Vertex
+	// model ID ("claude-sonnet-4-5@20250929").
+	Model       string
+	MaxTokens   int
+	MaxRetries  int
+	System      string
+	Temperature *float64
+	HTTPClient  *http.Client
+
+	// CredentialsFile is a service account key or authorized_user JSON file;
+	// CredentialsJSON is its content. When both are empty, credentials come
+	// from GOOGLE_APPLICATION_CREDENTIALS, the gcloud default credentials
+	// file, then the GCE metadata server.
+	CredentialsFile string
+	CredentialsJSON []byte
+}
+
+const (
+	defaultVertexRegion    = "us-east5"
+	vertexAnthropicVersion = "vertex-2023-10-16"
+)
+
+// vertexModelIDs lists the models whose Vertex ID is not
+// "<family>@<date>".
+var vertexModelIDs = map[string]string{
+	"claude-3-5-sonnet-20241022": "claude-3-5-sonnet-v2@20241022",
+}
+
+// NewVertex constructs an Anthropic-backed Model served by Vertex AI.
+func NewVertex(cfg VertexConfig) (Model, error) {
+	creds, err := loadGoogleCredentials(cfg.CredentialsJSON, cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("vertex: %w", err)
+	}
+
+	project := strings.TrimSpace(cfg.ProjectID)
+	for _, env := range []string{"ANTHROPIC_VERTEX_PROJECT_ID", "GOOGLE_CLOUD_PROJECT"} {
+		if project == "" {
+			project = strings.TrimSpace(os.Getenv(env))
+		}
+	}
+	if project == "" && creds != nil {
+		project = creds.ProjectID
+		if project == "" {
+			project = creds.QuotaProjectID
+		}
+	}
+	if project == "" {
+		return nil, errors.New("vertex: project id required")
+	}
+
+	region := strings.TrimSpace(cfg.Region)
+	if region == "" {
+		region = strings.TrimSpace(os.Getenv("CLOUD_ML_REGION"))
+	}
+	if region == "" {
+		region = defaultVertexRegion
+	}
+	baseURL := strings.TrimSpace(cfg.BaseURL)
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s-aiplatform.googleapis.com", region)
+		if region == "global" {
+			baseURL = "https://aiplatform.googleapis.com"
+		}
+	}
+
+	tokens := &googleTokenSource{creds: creds, client: cfg.HTTPClient}
+	quotaProject := ""
+	if creds != nil && creds.Type == "authorized_user" {
+		quotaProject = creds.QuotaProjectID
+	}
+	opts := []option.RequestOption{
+		option.WithBaseURL(baseURL),
+		option.WithMiddleware(vertexMiddleware(project, region, quotaProject, tokens)),
+		// doWithRetry owns retries, matching the Bedrock adapter.
+		option.WithMaxRetries(0),
+	}
+	if cfg.HTTPClient != nil {
+		opts = append(opts, option.WithHTTPClient(cfg.HTTPClient))
+	}
+
+	client := anthropicsdk.NewClient(opts...)
+	return newAnthropicModel(&client.Messages, cfg.Model, cfg.MaxTokens, cfg.MaxRetries, cfg.System, cfg.Temperature), nil
+}
+
+// VertexModelID maps an Anthropic model name or alias to its Vertex model
+// ID, e.g. "claude-sonnet-4-5" to "claude-sonnet-4-5@20250929". Vertex IDs
+// and non-Claude names pass through unchanged.
+func VertexModelID(name string) string {
+	name = strings.TrimSpace(name)
+	if strings.Contains(name, "@") {
+		return name
+	}
+	dated := datedAnthropicModel(name)
+	if id, ok := vertexModelIDs[dated]; ok {
+		return id
+	}
+	if family, date, ok := splitModelDate(dated); ok && strings.HasPrefix(dated, "claude-") {
+		return family + "@" + date
+	}
+	return name
+}
+
+// vertexMiddleware rewrites Messages API calls into Vertex rawPredict calls
+// and authorizes them with an OAuth access token.
+func vertexMiddleware(project, region, quotaProject string, tokens *googleTokenSource) option.Middleware {
+	return func(r *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		if r.Method != http.MethodPost {
+			return nil, fmt.Errorf("vertex: %s %s is not supported", r.Method, r.URL.Path)
+		}
+		fields, err := readJSONBody(r)
+		if err != nil {
+			return nil, fmt.Errorf("vertex: %w", err)
+		}
+		modelID := VertexModelID(jsonString(fields["model"]))
+		if modelID == "" {
+			return nil, errors.New("vertex: model is required")
+		}
+
+		var prefix, specifier string
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/messages"):
+			prefix = strings.TrimSuffix(r.URL.Path, "/v1/messages")
+			specifier = modelID + ":rawPredict"
+			if jsonBool(fields["stream"]) {
+				specifier = modelID + ":streamRawPredict"
+			}
+			delete(fields, "model")
+		case strings.HasSuffix(r.URL.Path, "/v1/messages/count_tokens"):
+			prefix = strings.TrimSuffix(r.URL.Path, "/v1/messages/count_tokens")
+			specifier = "count-tokens:rawPredict"
+			raw, _ := json.Marshal(modelID)
+			fields["model"] = raw
+		default:
+			return nil, fmt.Errorf("vertex: %s %s is not supported", r.Method, r.URL.Path)
+		}
+		if _, ok := fields["anthropic_version"]; !ok {
+			fields["anthropic_version"] = json.RawMessage(`"` + vertexAnthropicVersion + `"`)
+		}
+		body, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("vertex: encode request body: %w", err)
+		}
+
+		r.URL.Path = fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/anthropic/models/%s", prefix, project, region, specifier)
+		r.URL.RawPath = ""
+		r.Header.Del("X-Api-Key")
+		if quotaProject != "" {
+			r.Header.Set("X-Goog-User-Project", quotaProject)
+		}
+
+		send := func() (*http.Response, string, error) {
+			token, err := tokens.accessToken(r.Context())
+			if err != nil {
+				return nil, "", err
+			}
+			req := r.Clone(r.Context())
+			req.Header.Set("Authorization", "Bearer "+token)
+			setRequestBody(req, body)
+			resp, err := next(req)
+			return resp, token, err
+		}
+		resp, token, err := send()
+		if err == nil && resp.StatusCode == http.StatusUnauthorized {
+			// The cached token was revoked or rotated early: fetch a new one
+			// and try once more.
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			tokens.invalidate(token)
+			resp, _, err = send()
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,243 @@
+package model
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// vertexStub serves the OAuth token endpoint and the Vertex rawPredict
+// endpoints.
+type vertexStub struct {
+	t   *testing.T
+	key *rsa.PublicKey
+
+	tokenCalls atomic.Int32
+	grant      string
+	path       string
+	body       map[string]any
+	header     http.Header
+}
+
+func (s *vertexStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		s.tokenCalls.Add(1)
+		require.NoError(s.t, r.ParseForm())
+		s.grant = r.PostForm.Get("grant_type")
+		switch s.grant {
+		case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+			claims := verifyJWT(s.t, r.PostForm.Get("assertion"), s.key)
+			assert.Equal(s.t, "agent@proj-1.iam.gserviceaccount.com", claims["iss"])
+			assert.Equal(s.t, "http://"+r.Host+"/token", claims["aud"])
+			assert.Equal(s.t, googleCloudPlatformScope, claims["scope"])
+		case "refresh_token":
+			assert.Equal(s.t, "refresh-1", r.PostForm.Get("refresh_token"))
+			assert.Equal(s.t, "client-1", r.PostForm.Get("client_id"))
+		default:
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"access_token":"ya29.test","expires_in":3600,"token_type":"Bearer"}`)
+		return
+	}
+
+	s.path, s.header = r.URL.Path, r.Header.Clone()
+	raw, err := io.ReadAll(r.Body)
+	require.NoError(s.t, err)
+	s.body = nil
+	require.NoError(s.t, json.Unmarshal(raw, &s.body))
+	if r.Header.Get("Authorization") != "Bearer ya29.test" {
+		http.Error(w, `{"error":{"code":401,"message":"unauthenticated"}}`, http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if strings.HasSuffix(r.URL.Path, "count-tokens:rawPredict") {
+		_, _ = io.WriteString(w, `{"input_tokens":11}`)
+		return
+	}
+	_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-5-20250929","content":[{"type":"text","text":"hello from vertex"}],"stop_reason":"end_turn","usage":{"input_tokens":4,"output_tokens":2}}`)
+}
+
+func verifyJWT(t *testing.T, token string, key *rsa.PublicKey) map[string]any {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig))
+
+	var header map[string]string
+	raw, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	require.NoError(t, json.Unmarshal(raw, &header))
+	assert.Equal(t, "RS256", header["alg"])
+	assert.Equal(t, "key-1", header["kid"])
+
+	var claims map[string]any
+	raw, _ = base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, json.Unmarshal(raw, &claims))
+	return claims
+}
+
+func TestVertexServiceAccountTokenExchange(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	stub := &vertexStub{t: t, key: &key.PublicKey}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	t.Setenv("ANTHROPIC_API_KEY", "sk-ant-must-not-leak")
+	t.Setenv("ANTHROPIC_VERTEX_PROJECT_ID", "")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+
+	account, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "proj-1",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "agent@proj-1.iam.gserviceaccount.com",
+		"token_uri":      srv.URL + "/token",
+	})
+	require.NoError(t, err)
+	mdl, err := NewVertex(VertexConfig{
+		Region:          "us-east5",
+		BaseURL:         srv.URL,
+		Model:           "claude-sonnet-4-5",
+		CredentialsJSON: account,
+		HTTPClient:      srv.Client(),
+	})
+	require.NoError(t, err)
+
+	for range 2 {
+		resp, err := mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+		require.NoError(t, err)
+		assert.Equal(t, "hello from vertex", resp.Message.Content)
+	}
+	assert.Equal(t, int32(1), stub.tokenCalls.Load(), "access token should be cached")
+	assert.Equal(t, "/v1/projects/proj-1/locations/us-east5/publishers/anthropic/models/claude-sonnet-4-5@20250929:rawPredict", stub.path)
+	assert.Equal(t, vertexAnthropicVersion, stub.body["anthropic_version"])
+	assert.NotContains(t, stub.body, "model")
+	assert.Empty(t, stub.header.Get("X-Api-Key"))
+
+	counter, ok := mdl.(TokenCounter)
+	require.True(t, ok)
+	count, err := counter.CountTokens(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	require.NoError(t, err)
+	assert.Equal(t, 11, count)
+	assert.Equal(t, "/v1/projects/proj-1/locations/us-east5/publishers/anthropic/models/count-tokens:rawPredict", stub.path)
+	assert.Equal(t, "claude-sonnet-4-5@20250929", stub.body["model"])
+}
+
+func TestVertexAuthorizedUserCredentials(t *testing.T) {
+	stub := &vertexStub{t: t}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	t.Setenv("ANTHROPIC_VERTEX_PROJECT_ID", "")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+
+	user := `{"type":"authorized_user","client_id":"client-1","client_secret":"shh","refresh_token":"refresh-1","quota_project_id":"billing-1","token_uri":"` + srv.URL + `/token"}`
+	mdl, err := NewVertex(VertexConfig{Region: "global", BaseURL: srv.URL, Model: "claude-3-5-sonnet-20241022", CredentialsJSON: []byte(user)})
+	require.NoError(t, err)
+
+	_, err = mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "refresh_token", stub.grant)
+	assert.Equal(t, "/v1/projects/billing-1/locations/global/publishers/anthropic/models/claude-3-5-sonnet-v2@20241022:rawPredict", stub.path)
+	assert.Equal(t, "billing-1", stub.header.Get("X-Goog-User-Project"))
+}
+
+func TestVertexRefreshesRevokedToken(t *testing.T) {
+	t.Setenv("ANTHROPIC_VERTEX_PROJECT_ID", "")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+	var tokenCalls, predictCalls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			token := "ya29.revoked"
+			if tokenCalls.Add(1) > 1 {
+				token = "ya29.test"
+			}
+			_, _ = io.WriteString(w, `{"access_token":"`+token+`","expires_in":3600}`)
+			return
+		}
+		predictCalls.Add(1)
+		raw, _ := io.ReadAll(r.Body)
+		assert.Contains(t, string(raw), vertexAnthropicVersion, "the retried request must carry the body again")
+		if r.Header.Get("Authorization") != "Bearer ya29.test" {
+			http.Error(w, `{"error":{"code":401,"message":"unauthenticated"}}`, http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-5-20250929","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	user := `{"type":"authorized_user","client_id":"c","refresh_token":"r","token_uri":"` + srv.URL + `/token"}`
+	mdl, err := NewVertex(VertexConfig{ProjectID: "p", BaseURL: srv.URL, MaxRetries: 1, CredentialsJSON: []byte(user)})
+	require.NoError(t, err)
+
+	resp, err := mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp.Message.Content)
+	assert.Equal(t, int32(2), tokenCalls.Load())
+	assert.Equal(t, int32(2), predictCalls.Load())
+
+	_, err = mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), tokenCalls.Load(), "the refreshed token should be cached")
+}
+
+func TestVertexCredentialErrors(t *testing.T) {
+	t.Setenv("ANTHROPIC_VERTEX_PROJECT_ID", "")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("CLOUDSDK_CONFIG", t.TempDir())
+
+	_, err := NewVertex(VertexConfig{})
+	assert.EqualError(t, err, "vertex: project id required")
+	_, err = NewVertex(VertexConfig{ProjectID: "p", CredentialsJSON: []byte(`{"type":"external_account"}`)})
+	assert.ErrorContains(t, err, `unsupported google credentials type "external_account"`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+	}))
+	t.Cleanup(srv.Close)
+	user := `{"type":"authorized_user","refresh_token":"r","token_uri":"` + srv.URL + `/token"}`
+	mdl, err := NewVertex(VertexConfig{ProjectID: "p", BaseURL: srv.URL, CredentialsJSON: []byte(user)})
+	require.NoError(t, err)
+	_, err = mdl.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	assert.ErrorContains(t, err, "invalid_grant")
+	assert.False(t, isRetryable(err))
+}
+
+func TestVertexModelID(t *testing.T) {
+	cases := map[string]string{
+		"claude-sonnet-4-5":         "claude-sonnet-4-5@20250929",
+		"claude-opus-4-1-20250805":  "claude-opus-4-1@20250805",
+		"claude-3-5-sonnet-latest":  "claude-3-5-sonnet-v2@20241022",
+		"claude-haiku-4-5@20251001": "claude-haiku-4-5@20251001",
+		"claude-custom":             "claude-custom",
+		"gemini-2.5-pro":            "gemini-2.5-pro",
+		" claude-3-haiku-20240307 ": "claude-3-haiku@20240307",
+	}
+	for name, want := range cases {
+		assert.Equal(t, want, VertexModelID(name), name)
+	}
+}
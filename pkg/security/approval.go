@@ -171,6 +171,18 @@ func (q *ApprovalQueue) Deny(id, approver, reason string) (*ApprovalRecord, erro
 	return cloneRecord(rec), nil
 }
 
+// Get returns a copy of the record with the given id.
+func (q *ApprovalQueue) Get(id string) (*ApprovalRecord, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, ok := q.records[id]
+	if !ok {
+		return nil, false
+	}
+	return cloneRecord(rec), true
+}
+
 // ListPending returns outstanding approvals for review.
 func (q *ApprovalQueue) ListPending() []*ApprovalRecord {
 	q.mu.Lock()
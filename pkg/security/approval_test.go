@@ -431,3 +431,23 @@ func TestNewApprovalIDProducesUniqueValues(t *testing.T) {
 		t.Fatalf("expected unique ids, got %s", first)
 	}
 }
+
+func TestApprovalQueueGetReturnsCopy(t *testing.T) {
+	q, _ := newTestQueue(t)
+	rec, err := q.Request("sess", "rm -rf /tmp/x", nil)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+
+	got, ok := q.Get(rec.ID)
+	if !ok || got.State != ApprovalPending || got.Command != "rm -rf /tmp/x" {
+		t.Fatalf("unexpected record: %#v", got)
+	}
+	got.State = ApprovalApproved
+	if again, _ := q.Get(rec.ID); again.State != ApprovalPending {
+		t.Fatalf("Get must return a copy, state leaked: %#v", again)
+	}
+	if _, ok := q.Get("missing"); ok {
+		t.Fatalf("expected missing record")
+	}
+}
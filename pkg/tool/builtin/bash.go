@@ -180,6 +180,9 @@ const (
 	`
 )
 
+// bashWaitDelay caps how long a killed command may hold its output pipes.
+const bashWaitDelay = 2 * time.Second
+
 var bashSchema = &tool.JSONSchema{
 	Type: "object",
 	Properties: map[string]interface{}{
@@ -344,6 +347,7 @@ func (b *BashTool) Execute(ctx context.Context, params map[string]interface{}) (
 	cmd := exec.CommandContext(execCtx, "bash", "-c", command)
 	cmd.Env = os.Environ()
 	cmd.Dir = workdir
+	killProcessTreeOnCancel(cmd)
 
 	spool := newBashOutputSpool(ctx, b.effectiveOutputThresholdBytes())
 	cmd.Stdout = spool.StdoutWriter()
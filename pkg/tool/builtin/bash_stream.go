@@ -51,6 +51,7 @@ func (b *BashTool) StreamExecute(ctx context.Context, params map[string]interfac
 	cmd := exec.CommandContext(execCtx, "bash", "-c", command)
 	cmd.Env = os.Environ()
 	cmd.Dir = workdir
+	killProcessTreeOnCancel(cmd)
 
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
@@ -2,8 +2,26 @@
 
 package toolbuiltin
 
-import "path/filepath"
+import (
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
 
 func bashOutputBaseDir() string {
 	return filepath.Join(string(filepath.Separator), "tmp", "agentsdk", "bash-output")
 }
+
+// killProcessTreeOnCancel runs cmd in its own process group and kills the
+// whole group when the command context ends, so background children and
+// pipelines do not outlive a cancelled run.
+func killProcessTreeOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		if cmd.Process == nil {
+			return nil
+		}
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = bashWaitDelay
+}
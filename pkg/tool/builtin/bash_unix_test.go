@@ -0,0 +1,57 @@
+//go:build !windows
+
+package toolbuiltin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBashToolCancelKillsChildProcesses(t *testing.T) {
+	dir := cleanTempDir(t)
+	pidFile := filepath.Join(dir, "child.pid")
+	script := writeScript(t, dir, "children.sh", "#!/bin/sh\nsleep 30 &\necho $! > "+pidFile+"\nwait\n")
+
+	tool := NewBashToolWithRoot(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	if _, err := tool.Execute(ctx, map[string]interface{}{"command": "./" + filepath.Base(script)}); err == nil {
+		t.Fatalf("expected cancelled command to fail")
+	}
+	raw, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("read child pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		t.Fatalf("parse child pid: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("child process %d survived the cancelled command", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// processAlive reports whether pid runs; zombies awaiting their reaper count
+// as dead where /proc exposes the process state.
+func processAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
@@ -4,9 +4,16 @@ package toolbuiltin
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
 )
 
 func bashOutputBaseDir() string {
 	return filepath.Join(os.TempDir(), "agentsdk", "bash-output")
 }
+
+// killProcessTreeOnCancel bounds how long a cancelled command may keep its
+// output pipes open; the process itself is killed by exec.CommandContext.
+func killProcessTreeOnCancel(cmd *exec.Cmd) {
+	cmd.WaitDelay = bashWaitDelay
+}
@@ -57,6 +57,9 @@ func NewBashOutputTool(store *ShellStore) *BashOutputTool {
 
 func (b *BashOutputTool) Name() string { return "BashOutput" }
 
+// IsConcurrencySafe implements tool.ConcurrencySafeTool.
+func (b *BashOutputTool) IsConcurrencySafe() bool { return true }
+
 func (b *BashOutputTool) Description() string { return bashOutputDescription }
 
 func (b *BashOutputTool) Schema() *tool.JSONSchema { return bashOutputSchema }
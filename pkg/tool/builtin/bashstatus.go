@@ -31,6 +31,9 @@ func NewBashStatusTool() *BashStatusTool { return &BashStatusTool{} }
 
 func (b *BashStatusTool) Name() string { return "BashStatus" }
 
+// IsConcurrencySafe implements tool.ConcurrencySafeTool.
+func (b *BashStatusTool) IsConcurrencySafe() bool { return true }
+
 func (b *BashStatusTool) Description() string { return bashStatusDescription }
 
 func (b *BashStatusTool) Schema() *tool.JSONSchema { return bashStatusSchema }
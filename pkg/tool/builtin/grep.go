@@ -158,6 +158,9 @@ func (g *GrepTool) SetRespectGitignore(respect bool) {
 
 func (g *GrepTool) Name() string { return "Grep" }
 
+// IsConcurrencySafe implements tool.ConcurrencySafeTool.
+func (g *GrepTool) IsConcurrencySafe() bool { return true }
+
 func (g *GrepTool) Description() string { return grepToolDesc }
 
 func (g *GrepTool) Schema() *tool.JSONSchema { return grepSchema }
@@ -83,6 +83,9 @@ func NewReadToolWithSandbox(root string, sandbox *security.Sandbox) *ReadTool {
 
 func (r *ReadTool) Name() string { return "Read" }
 
+// IsConcurrencySafe implements tool.ConcurrencySafeTool.
+func (r *ReadTool) IsConcurrencySafe() bool { return true }
+
 func (r *ReadTool) Description() string { return readDescription }
 
 func (r *ReadTool) Schema() *tool.JSONSchema { return readSchema }
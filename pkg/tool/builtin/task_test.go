@@ -33,6 +33,11 @@ func TestTaskToolMetadata(t *testing.T) {
 			t.Fatalf("missing property %s", key)
 		}
 	}
+	// Subagents and skills act on shared session state, so they never run
+	// alongside other tool calls.
+	if tool.IsConcurrencySafe(task) || tool.IsConcurrencySafe(NewSkillTool(nil, nil)) {
+		t.Fatal("Task and Skill must not be concurrency safe")
+	}
 }
 
 func TestTaskToolExecuteSuccess(t *testing.T) {
@@ -33,6 +33,9 @@ func NewTaskGetTool(store tasks.Store) *TaskGetTool {
 
 func (t *TaskGetTool) Name() string { return "TaskGet" }
 
+// IsConcurrencySafe implements tool.ConcurrencySafeTool.
+func (t *TaskGetTool) IsConcurrencySafe() bool { return true }
+
 func (t *TaskGetTool) Description() string { return taskGetDescription }
 
 func (t *TaskGetTool) Schema() *tool.JSONSchema { return taskGetSchema }
@@ -43,6 +43,9 @@ func NewTaskListTool(store tasks.Store) *TaskListTool {
 
 func (t *TaskListTool) Name() string { return "TaskList" }
 
+// IsConcurrencySafe implements tool.ConcurrencySafeTool.
+func (t *TaskListTool) IsConcurrencySafe() bool { return true }
+
 func (t *TaskListTool) Description() string { return taskListDescription }
 
 func (t *TaskListTool) Schema() *tool.JSONSchema { return taskListSchema }
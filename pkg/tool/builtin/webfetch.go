@@ -126,6 +126,9 @@ func NewWebFetchTool(opts *WebFetchOptions) *WebFetchTool {
 
 func (w *WebFetchTool) Name() string { return "WebFetch" }
 
+// IsConcurrencySafe implements tool.ConcurrencySafeTool.
+func (w *WebFetchTool) IsConcurrencySafe() bool { return true }
+
 func (w *WebFetchTool) Description() string { return webFetchDescription }
 
 func (w *WebFetchTool) Schema() *tool.JSONSchema { return webFetchSchema }
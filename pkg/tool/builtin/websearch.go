@@ -109,6 +109,9 @@ func NewWebSearchTool(opts *WebSearchOptions) *WebSearchTool {
 
 func (w *WebSearchTool) Name() string { return "WebSearch" }
 
+// IsConcurrencySafe implements tool.ConcurrencySafeTool.
+func (w *WebSearchTool) IsConcurrencySafe() bool { return true }
+
 func (w *WebSearchTool) Description() string { return webSearchDescription }
 
 func (w *WebSearchTool) Schema() *tool.JSONSchema { return webSearchSchema }
@@ -16,3 +16,24 @@ type Tool interface {
 	// Execute runs the tool with validated parameters.
 	Execute(ctx context.Context, params map[string]interface{}) (*ToolResult, error)
 }
+
+// ConcurrencySafeTool is optionally implemented by tools that can run in
+// parallel with other calls emitted in the same model turn. Read-only tools
+// typically qualify; tools that mutate files or spawn processes should not.
+type ConcurrencySafeTool interface {
+	Tool
+
+	// IsConcurrencySafe reports whether overlapping executions are safe.
+	IsConcurrencySafe() bool
+}
+
+// IsConcurrencySafe reports whether t declared itself safe for parallel
+// execution. Tools that do not implement ConcurrencySafeTool are treated as
+// unsafe.
+func IsConcurrencySafe(t Tool) bool {
+	if t == nil {
+		return false
+	}
+	safe, ok := t.(ConcurrencySafeTool)
+	return ok && safe.IsConcurrencySafe()
+}
@@ -0,0 +1,27 @@
+package tool
+
+import "testing"
+
+type concurrencyStubTool struct {
+	stubTool
+	safe bool
+}
+
+func (c *concurrencyStubTool) IsConcurrencySafe() bool { return c.safe }
+
+func TestIsConcurrencySafe(t *testing.T) {
+	t.Parallel()
+
+	if IsConcurrencySafe(nil) {
+		t.Fatal("nil tool must not be concurrency safe")
+	}
+	if IsConcurrencySafe(&stubTool{name: "plain"}) {
+		t.Fatal("tools without declaration default to unsafe")
+	}
+	if IsConcurrencySafe(&concurrencyStubTool{safe: false}) {
+		t.Fatal("explicit false should be honoured")
+	}
+	if !IsConcurrencySafe(&concurrencyStubTool{safe: true}) {
+		t.Fatal("explicit true should be honoured")
+	}
+}